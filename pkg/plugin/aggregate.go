@@ -0,0 +1,251 @@
+package plugin
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// computeAggregate evaluates an aggregate function over the documents of a group.
+// Integer fields stay int64 for COUNT, SUM, MIN and MAX so values above 2^53 keep
+// their precision; floats are only used when the data or the function (AVG) needs them.
+func computeAggregate(aggField AggregateInfo, groupDocs []map[string]interface{}) interface{} {
+	if aggField.Window != nil {
+		// Computed over the groups by applyWindowFunctions
+		return nil
+	}
+	if aggField.Function == "COUNT" {
+		return countValues(aggField.Field, groupDocs)
+	}
+	if aggField.Function == "STRING_AGG" {
+		return stringAggValue(aggField, groupDocs)
+	}
+
+	var values []interface{}
+	for _, doc := range groupDocs {
+		if val, ok := aggregatedValue(doc, aggField.Field); ok {
+			values = append(values, val)
+		}
+	}
+
+	switch aggField.Function {
+	case "SUM":
+		return sumValues(values)
+	case "AVG":
+		if len(values) == 0 {
+			return 0.0
+		}
+		sum := 0.0
+		for _, val := range values {
+			numVal, _ := convertToFloat(val)
+			sum += numVal
+		}
+		return sum / float64(len(values))
+	case "MIN":
+		return extremeValue(values, false)
+	case "MAX":
+		return extremeValue(values, true)
+	default:
+		return 0.0
+	}
+}
+
+// countValues counts the documents of a group: all of them for COUNT(*), those where the
+// field exists and isn't null for COUNT(field)
+func countValues(field string, groupDocs []map[string]interface{}) int64 {
+	if field == "*" {
+		return int64(len(groupDocs))
+	}
+	var count int64
+	for _, doc := range groupDocs {
+		if hasValue(doc, field) {
+			count++
+		}
+	}
+	return count
+}
+
+// hasValue reports whether a document has a non-null value for a field. A path through an
+// array such as items[].price needs a non-null value in one of the elements.
+func hasValue(doc map[string]interface{}, field string) bool {
+	arrayPath, elementPath, isArray := strings.Cut(field, "[]")
+	if !isArray {
+		return selectFieldValue(doc, field) != nil
+	}
+
+	elements, _ := selectFieldValue(doc, arrayPath).([]interface{})
+	elementPath = strings.TrimPrefix(elementPath, ".")
+	for _, element := range elements {
+		if elementPath == "" {
+			if element != nil {
+				return true
+			}
+			continue
+		}
+		if elementDoc, isMap := element.(map[string]interface{}); isMap && hasValue(elementDoc, elementPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregatedValue returns the number a document contributes to an aggregate. A path through
+// an array such as items[].price sums the elements of the document first.
+func aggregatedValue(doc map[string]interface{}, field string) (interface{}, bool) {
+	arrayPath, elementPath, isArray := strings.Cut(field, "[]")
+	if !isArray {
+		return numericValue(selectFieldValue(doc, field))
+	}
+
+	elements, _ := selectFieldValue(doc, arrayPath).([]interface{})
+	elementPath = strings.TrimPrefix(elementPath, ".")
+	var values []interface{}
+	for _, element := range elements {
+		var val interface{}
+		var ok bool
+		if elementPath == "" {
+			val, ok = numericValue(element)
+		} else if elementDoc, isMap := element.(map[string]interface{}); isMap {
+			val, ok = aggregatedValue(elementDoc, elementPath)
+		}
+		if ok {
+			values = append(values, val)
+		}
+	}
+	if len(values) == 0 {
+		return nil, false
+	}
+	return sumValues(values), true
+}
+
+// numericValue returns the number an aggregated value holds: int64 for integers and strings
+// of integers, float64 for other finite numbers and numeric strings. Other values are skipped.
+func numericValue(val interface{}) (interface{}, bool) {
+	if n, ok := toInt64(val); ok {
+		return n, true
+	}
+	if str, ok := val.(string); ok {
+		str = strings.TrimSpace(str)
+		if n, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return n, true
+		}
+		val = str
+	}
+	f, err := convertToFloat(val)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, false
+	}
+	return f, true
+}
+
+// sumValues adds numeric values, keeping an exact int64 sum while every value is an
+// integer and the sum doesn't overflow
+func sumValues(values []interface{}) interface{} {
+	var intSum int64
+	for i, val := range values {
+		n, ok := toInt64(val)
+		if !ok || (n > 0 && intSum > math.MaxInt64-n) || (n < 0 && intSum < math.MinInt64-n) {
+			sum := float64(intSum)
+			for _, rest := range values[i:] {
+				numVal, _ := convertToFloat(rest)
+				sum += numVal
+			}
+			return sum
+		}
+		intSum += n
+	}
+	return intSum
+}
+
+// extremeValue returns the minimum or maximum of the values, as int64 when it is an
+// integer, or 0 when there are no values. Integers are compared exactly.
+func extremeValue(values []interface{}, max bool) interface{} {
+	var best interface{}
+	for _, val := range values {
+		if best == nil {
+			best = val
+			continue
+		}
+		bestInt, bestIsInt := toInt64(best)
+		valInt, valIsInt := toInt64(val)
+		var isBetter bool
+		if bestIsInt && valIsInt {
+			isBetter = (max && valInt > bestInt) || (!max && valInt < bestInt)
+		} else {
+			bestFloat, _ := convertToFloat(best)
+			valFloat, _ := convertToFloat(val)
+			isBetter = (max && valFloat > bestFloat) || (!max && valFloat < bestFloat)
+		}
+		if isBetter {
+			best = val
+		}
+	}
+	if best == nil {
+		return 0.0
+	}
+	if n, ok := toInt64(best); ok {
+		return n
+	}
+	bestFloat, _ := convertToFloat(best)
+	return bestFloat
+}
+
+// newAggregateField builds the column of an aggregate: int64 when every value is an
+// integer, float64 otherwise. The column is nullable when a value is missing, as in empty
+// time buckets or windows without values.
+func newAggregateField(name string, values []interface{}) *data.Field {
+	allInts, hasNulls := true, false
+	for _, val := range values {
+		if val == nil {
+			hasNulls = true
+			continue
+		}
+		if _, ok := toInt64(val); !ok {
+			allInts = false
+		}
+	}
+	if hasNulls {
+		return newNullableAggregateField(name, values, allInts)
+	}
+	if allInts {
+		out := make([]int64, len(values))
+		for i, val := range values {
+			out[i], _ = toInt64(val)
+		}
+		return data.NewField(name, nil, out)
+	}
+
+	out := make([]float64, len(values))
+	for i, val := range values {
+		if numVal, err := convertToFloat(val); err == nil {
+			out[i] = numVal
+		}
+	}
+	return data.NewField(name, nil, out)
+}
+
+// newNullableAggregateField builds the column of an aggregate with missing values
+func newNullableAggregateField(name string, values []interface{}, allInts bool) *data.Field {
+	if allInts {
+		out := make([]*int64, len(values))
+		for i, val := range values {
+			if n, ok := toInt64(val); ok {
+				out[i] = &n
+			}
+		}
+		return data.NewField(name, nil, out)
+	}
+
+	out := make([]*float64, len(values))
+	for i, val := range values {
+		if val == nil {
+			continue
+		}
+		if numVal, err := convertToFloat(val); err == nil {
+			out[i] = &numVal
+		}
+	}
+	return data.NewField(name, nil, out)
+}
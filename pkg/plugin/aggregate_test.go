@@ -0,0 +1,230 @@
+package plugin
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeAggregate(t *testing.T) {
+	const big = int64(1) << 60
+	docs := []map[string]interface{}{
+		{"value": big + 1},
+		{"value": big + 3},
+		{"value": nil},
+	}
+
+	tests := []struct {
+		function string
+		expected interface{}
+	}{
+		{"COUNT", int64(2)},
+		{"SUM", 2*big + 4},
+		{"MIN", big + 1},
+		{"MAX", big + 3},
+		{"AVG", float64(big + 2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.function, func(t *testing.T) {
+			require.Equal(t, tt.expected, computeAggregate(AggregateInfo{Function: tt.function, Field: "value"}, docs))
+		})
+	}
+}
+
+func TestComputeCount(t *testing.T) {
+	docs := []map[string]interface{}{
+		{"email": "a@example.com", "items": []interface{}{map[string]interface{}{"price": int64(3)}}},
+		{"email": "", "items": []interface{}{map[string]interface{}{"price": nil}}},
+		{"email": nil, "items": []interface{}{}},
+		{"user": map[string]interface{}{"email": "b@example.com"}},
+	}
+
+	tests := []struct {
+		field    string
+		expected int64
+	}{
+		{"*", 4},
+		{"email", 2},
+		{"user.email", 1},
+		{"items", 3},
+		{"items[].price", 1},
+		{"missing", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			require.Equal(t, tt.expected, computeAggregate(AggregateInfo{Function: "COUNT", Field: tt.field}, docs))
+		})
+	}
+
+	info, err := parseSQLQueryWithVariables("SELECT COUNT(1) AS n, COUNT(email) AS emails FROM users")
+	require.NoError(t, err)
+	require.Equal(t, "*", info.AggregateFields[0].Field)
+	require.Equal(t, "email", info.AggregateFields[1].Field)
+}
+
+func TestComputeAggregateMixedNumbers(t *testing.T) {
+	docs := []map[string]interface{}{{"value": int64(2)}, {"value": 0.5}}
+
+	require.Equal(t, 2.5, computeAggregate(AggregateInfo{Function: "SUM", Field: "value"}, docs))
+	require.Equal(t, 0.5, computeAggregate(AggregateInfo{Function: "MIN", Field: "value"}, docs))
+	require.Equal(t, int64(2), computeAggregate(AggregateInfo{Function: "MAX", Field: "value"}, docs))
+}
+
+func TestSumValuesOverflow(t *testing.T) {
+	sum := sumValues([]interface{}{int64(math.MaxInt64), int64(1)})
+	require.Equal(t, float64(math.MaxInt64)+1, sum)
+}
+
+func TestNewAggregateField(t *testing.T) {
+	field := newAggregateField("total", []interface{}{int64(1) << 60, int64(2)})
+	require.Equal(t, data.FieldTypeInt64, field.Type())
+	require.Equal(t, int64(1)<<60, field.At(0))
+
+	field = newAggregateField("avg", []interface{}{int64(1), 2.5})
+	require.Equal(t, data.FieldTypeFloat64, field.Type())
+}
+
+func TestComputeAggregateNestedFields(t *testing.T) {
+	docs := []map[string]interface{}{
+		{"metrics": map[string]interface{}{"bytesSent": int64(100)}},
+		{"metrics": map[string]interface{}{"bytesSent": int32(20)}},
+		{"metrics": map[string]interface{}{"bytesSent": " 3 "}},
+		{"metrics": map[string]interface{}{"bytesSent": "n/a"}},
+		{"metrics": map[string]interface{}{}},
+		{"metrics.bytesSent": int64(7)},
+	}
+	aggregate := func(function string) interface{} {
+		return computeAggregate(AggregateInfo{Function: function, Field: "metrics.bytesSent"}, docs)
+	}
+
+	require.Equal(t, int64(130), aggregate("SUM"))
+	require.Equal(t, 32.5, aggregate("AVG"))
+	require.Equal(t, int64(3), aggregate("MIN"))
+	require.Equal(t, int64(100), aggregate("MAX"))
+
+	docs = append(docs, map[string]interface{}{"metrics": map[string]interface{}{"bytesSent": "0.5"}})
+	require.Equal(t, 130.5, aggregate("SUM"))
+}
+
+func TestNumericValue(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		numeric interface{}
+		ok      bool
+	}{
+		{int64(5), int64(5), true},
+		{int32(5), int64(5), true},
+		{2.5, 2.5, true},
+		{"42", int64(42), true},
+		{" 1.5 ", 1.5, true},
+		{"NaN", nil, false},
+		{math.Inf(1), nil, false},
+		{"n/a", nil, false},
+		{true, nil, false},
+		{nil, nil, false},
+	}
+
+	for _, tt := range tests {
+		numeric, ok := numericValue(tt.value)
+		require.Equal(t, tt.ok, ok, "%v", tt.value)
+		require.Equal(t, tt.numeric, numeric, "%v", tt.value)
+	}
+}
+
+func TestParseNestedAggregateField(t *testing.T) {
+	queryInfo, err := parseSQLQueryWithVariables("SELECT SUM(`metrics`.`bytesSent`) AS sent FROM sessions")
+	require.NoError(t, err)
+	require.Equal(t, []AggregateInfo{{Function: "SUM", Field: "metrics.bytesSent", Alias: "sent"}}, queryInfo.AggregateFields)
+}
+
+func TestComputeAggregateArrayElements(t *testing.T) {
+	docs := []map[string]interface{}{
+		{"items": []interface{}{
+			map[string]interface{}{"price": int64(10)},
+			map[string]interface{}{"price": "2.5"},
+			map[string]interface{}{"sku": "free"},
+		}},
+		{"items": []interface{}{map[string]interface{}{"price": int64(4)}}},
+		{"items": []interface{}{}},
+		{"items": "none"},
+		{"scores": []interface{}{int64(1), int64(2)}},
+	}
+	aggregate := func(function, field string) interface{} {
+		return computeAggregate(AggregateInfo{Function: function, Field: field}, docs)
+	}
+
+	require.Equal(t, 16.5, aggregate("SUM", "items[].price"))
+	require.Equal(t, 8.25, aggregate("AVG", "items[].price"))
+	require.Equal(t, int64(4), aggregate("MIN", "items[].price"))
+	require.Equal(t, 12.5, aggregate("MAX", "items[].price"))
+	require.Equal(t, int64(3), aggregate("SUM", "scores[]"))
+}
+
+func TestAggregatedValueNestedArrays(t *testing.T) {
+	doc := map[string]interface{}{
+		"orders": []interface{}{
+			map[string]interface{}{"lines": []interface{}{
+				map[string]interface{}{"qty": int64(1)},
+				map[string]interface{}{"qty": int64(2)},
+			}},
+			map[string]interface{}{"lines": []interface{}{map[string]interface{}{"qty": int64(3)}}},
+		},
+	}
+
+	value, ok := aggregatedValue(doc, "orders[].lines[].qty")
+	require.True(t, ok)
+	require.Equal(t, int64(6), value)
+
+	_, ok = aggregatedValue(doc, "orders[].missing")
+	require.False(t, ok)
+}
+
+func TestParseArrayAggregateField(t *testing.T) {
+	queryInfo, err := parseSQLQueryWithVariables("SELECT SUM(items[].price) AS total FROM orders")
+	require.NoError(t, err)
+	require.Equal(t, []AggregateInfo{{Function: "SUM", Field: "items[].price", Alias: "total"}}, queryInfo.AggregateFields)
+	require.Equal(t, []string{"items"}, projectionFields(queryInfo, FirestoreQuery{}))
+}
+
+func TestGroupByWithoutAggregates(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"brand": "yoigo", "tier": int64(1)},
+		{"brand": "orange", "tier": int64(2)},
+		{"brand": "yoigo", "tier": int64(1)},
+		{"tier": int64(3)},
+	}
+
+	// A row per distinct brand, the missing brand is a null
+	info, err := parseSQLQueryWithVariables("SELECT brand FROM sessions GROUP BY brand")
+	require.NoError(t, err)
+	response := (&Datasource{}).aggregateRows(context.Background(), rows, info, FirestoreQuery{})
+	require.NoError(t, response.Error)
+	frame := response.Frames[0]
+	require.Len(t, frame.Fields, 1)
+	require.Equal(t, 3, frame.Rows())
+	var brands []interface{}
+	for i := 0; i < frame.Rows(); i++ {
+		if brand, ok := frame.Fields[0].ConcreteAt(i); ok {
+			brands = append(brands, brand)
+			continue
+		}
+		brands = append(brands, nil)
+	}
+	require.ElementsMatch(t, []interface{}{"yoigo", "orange", nil}, brands)
+
+	// Grouped numbers stay numbers
+	info, err = parseSQLQueryWithVariables("SELECT brand, tier FROM sessions GROUP BY brand, tier ORDER BY tier DESC")
+	require.NoError(t, err)
+	response = (&Datasource{}).aggregateRows(context.Background(), rows, info, FirestoreQuery{Format: formatTimeSeries})
+	require.NoError(t, response.Error)
+	frame = response.Frames[0]
+	require.Equal(t, 3, frame.Rows())
+	require.Equal(t, data.FieldTypeNullableInt64, frame.Fields[1].Type())
+	tier, _ := frame.Fields[1].ConcreteAt(0)
+	require.Equal(t, int64(3), tier)
+}
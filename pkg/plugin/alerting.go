@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// isLabelType reports whether the columns of a field type become the labels of a series
+func isLabelType(fieldType data.FieldType) bool {
+	switch fieldType {
+	case data.FieldTypeString, data.FieldTypeNullableString, data.FieldTypeBool, data.FieldTypeNullableBool:
+		return true
+	default:
+		return false
+	}
+}
+
+// alertingResponse shapes the frames of a query with the alerting option the way Grafana
+// alerting and server-side expressions evaluate them. A frame with a time field becomes a
+// wide time series, its string columns the labels of its numeric columns; a frame without
+// one is a numeric table with a row per label set. Columns alerting can't evaluate fail the
+// query, naming the column, instead of failing the alert rule later.
+func alertingResponse(response backend.DataResponse) backend.DataResponse {
+	if response.Error != nil {
+		return response
+	}
+	for i, frame := range response.Frames {
+		converted, err := alertingFrame(frame)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, "alerting: "+err.Error())
+		}
+		response.Frames[i] = converted
+	}
+	return response
+}
+
+// alertingFrame converts a frame to a wide time series or a numeric long frame
+func alertingFrame(frame *data.Frame) (*data.Frame, error) {
+	var timeFields []string
+	numeric := 0
+	for _, field := range frame.Fields {
+		switch {
+		case field.Type().Time():
+			timeFields = append(timeFields, field.Name)
+		case field.Type().Numeric():
+			numeric++
+		case isLabelType(field.Type()):
+		default:
+			return nil, fmt.Errorf("column %s holds %s values, alert rules evaluate numbers labelled by strings, remove it from the SELECT",
+				field.Name, field.Type().ItemTypeString())
+		}
+	}
+	switch {
+	case len(timeFields) > 1:
+		return nil, fmt.Errorf("columns %s are times, alert rules need a single time column", strings.Join(timeFields, ", "))
+	case numeric == 0 && frame.Rows() > 0:
+		return nil, fmt.Errorf("the query has no numeric column for alert rules to evaluate")
+	case len(timeFields) == 0:
+		return numericLongFrame(frame)
+	}
+
+	wide, err := longToWide(frame, wideFillMissing(""))
+	if err != nil {
+		return nil, err
+	}
+	if wide.Meta == nil {
+		wide.Meta = &data.FrameMeta{}
+	}
+	wide.Meta.Type = data.FrameTypeTimeSeriesWide
+	return wide, nil
+}
+
+// numericLongFrame checks that every row of a frame without time is a distinct label set,
+// since alert rules evaluate one number per label set and series
+func numericLongFrame(frame *data.Frame) (*data.Frame, error) {
+	seen := make(map[string]int)
+	for row := 0; row < frame.Rows(); row++ {
+		labels := data.Labels{}
+		for _, field := range frame.Fields {
+			if !isLabelType(field.Type()) {
+				continue
+			}
+			if value, ok := field.ConcreteAt(row); ok {
+				labels[field.Name] = fmt.Sprint(value)
+			}
+		}
+		if previous, ok := seen[labels.String()]; ok {
+			return nil, fmt.Errorf("rows %d and %d have the same labels %s, alert rules need one row per label set, GROUP BY the string columns",
+				previous+1, row+1, labels.String())
+		}
+		seen[labels.String()] = row
+	}
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	frame.Meta.Type = data.FrameTypeNumericLong
+	return frame, nil
+}
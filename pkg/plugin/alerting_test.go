@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertingResponse(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	payload := json.RawMessage(`{}`)
+
+	tests := []struct {
+		name      string
+		frame     *data.Frame
+		frameType data.FrameType
+		err       string
+	}{
+		{
+			"long time series",
+			data.NewFrame("response",
+				data.NewField("ts", nil, []*time.Time{ptr(start.Add(time.Minute)), ptr(start), nil}),
+				data.NewField("host", nil, []string{"a", "b", "c"}),
+				data.NewField("cpu", nil, []float64{1, 2, 3}),
+			),
+			data.FrameTypeTimeSeriesWide, "",
+		},
+		{
+			"wide time series",
+			data.NewFrame("response",
+				data.NewField("ts", nil, []time.Time{start}),
+				data.NewField("cpu", nil, []int64{1}),
+			),
+			data.FrameTypeTimeSeriesWide, "",
+		},
+		{
+			"numbers",
+			data.NewFrame("response",
+				data.NewField("brand", nil, []string{"a", "b"}),
+				data.NewField("count", nil, []int64{1, 2}),
+			),
+			data.FrameTypeNumericLong, "",
+		},
+		{
+			"duplicate labels",
+			data.NewFrame("response",
+				data.NewField("brand", nil, []string{"a", "a"}),
+				data.NewField("count", nil, []int64{1, 2}),
+			),
+			"", "alerting: rows 1 and 2 have the same labels",
+		},
+		{
+			"json column",
+			data.NewFrame("response",
+				data.NewField("ts", nil, []time.Time{start}),
+				data.NewField("payload", nil, []json.RawMessage{payload}),
+			),
+			"", "alerting: column payload holds json.RawMessage values",
+		},
+		{
+			"two time columns",
+			data.NewFrame("response",
+				data.NewField("createdAt", nil, []time.Time{start}),
+				data.NewField("updatedAt", nil, []time.Time{start}),
+				data.NewField("count", nil, []int64{1}),
+			),
+			"", "alerting: columns createdAt, updatedAt are times",
+		},
+		{
+			"no numbers",
+			data.NewFrame("response", data.NewField("brand", nil, []string{"a"})),
+			"", "alerting: the query has no numeric column",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := alertingResponse(backend.DataResponse{Frames: data.Frames{tt.frame}})
+			if tt.err != "" {
+				require.ErrorContains(t, response.Error, tt.err)
+				return
+			}
+			require.NoError(t, response.Error)
+			require.Equal(t, tt.frameType, response.Frames[0].Meta.Type)
+		})
+	}
+}
+
+func TestAlertingLongToWide(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	frame := data.NewFrame("response",
+		data.NewField("ts", nil, []time.Time{start.Add(time.Minute), start, start}),
+		data.NewField("host", nil, []string{"a", "a", "b"}),
+		data.NewField("cpu", nil, []float64{1, 2, 3}),
+	)
+
+	response := alertingResponse(backend.DataResponse{Frames: data.Frames{frame}})
+	require.NoError(t, response.Error)
+	wide := response.Frames[0]
+	require.Len(t, wide.Fields, 3)
+	require.Equal(t, 2, wide.Rows())
+	require.Equal(t, start, wide.Fields[0].At(0))
+	require.Equal(t, data.Labels{"host": "a"}, wide.Fields[1].Labels)
+	require.Equal(t, data.Labels{"host": "b"}, wide.Fields[2].Labels)
+}
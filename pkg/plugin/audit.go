@@ -0,0 +1,133 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// auditRecord is one executed query in the audit log
+type auditRecord struct {
+	Time          time.Time `json:"time"`
+	User          string    `json:"user,omitempty"`
+	Email         string    `json:"email,omitempty"`
+	OrgID         int64     `json:"orgId"`
+	Datasource    string    `json:"datasourceUid,omitempty"`
+	RefID         string    `json:"refId"`
+	Collection    string    `json:"collection,omitempty"`
+	Query         string    `json:"query"`
+	DocumentsRead int       `json:"documentsRead"`
+	DurationMs    int64     `json:"durationMs"`
+	Status        int       `json:"status"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// auditLogger records the queries of a datasource for compliance review, in the plugin
+// logs or as JSON lines appended to a file
+type auditLogger struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// newAuditLogger returns the audit logger of the auditLog settings, nil when auditing is off
+func newAuditLogger(settings *FirestoreSettings) (*auditLogger, error) {
+	if !settings.AuditLog {
+		return nil, nil
+	}
+	if settings.AuditLogPath == "" {
+		return &auditLogger{}, nil
+	}
+	file, err := os.OpenFile(settings.AuditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("audit log: %v", err)
+	}
+	return &auditLogger{file: file}, nil
+}
+
+// newAuditRecord describes a query executed for the user of pCtx
+func newAuditRecord(pCtx backend.PluginContext, query backend.DataQuery, response backend.DataResponse, duration time.Duration) auditRecord {
+	var qm FirestoreQuery
+	_ = json.Unmarshal(query.JSON, &qm)
+
+	record := auditRecord{
+		Time:          time.Now().UTC(),
+		OrgID:         pCtx.OrgID,
+		RefID:         query.RefID,
+		Collection:    extractCollectionName(qm.Query),
+		Query:         qm.Query,
+		DocumentsRead: responseDocumentsRead(response),
+		DurationMs:    duration.Milliseconds(),
+		Status:        int(response.Status),
+	}
+	if qm.Builder != nil {
+		record.Collection = qm.Builder.Collection
+		record.Query = qm.Builder.describe()
+	}
+	if record.Status == 0 {
+		record.Status = int(backend.StatusOK)
+	}
+	if pCtx.User != nil {
+		record.User = pCtx.User.Login
+		record.Email = pCtx.User.Email
+	}
+	if pCtx.DataSourceInstanceSettings != nil {
+		record.Datasource = pCtx.DataSourceInstanceSettings.UID
+	}
+	if response.Error != nil {
+		record.Error = response.Error.Error()
+	}
+	return record
+}
+
+// responseDocumentsRead returns the documentsRead reported in the frame meta of a response
+func responseDocumentsRead(response backend.DataResponse) int {
+	for _, frame := range response.Frames {
+		if frame.Meta == nil {
+			continue
+		}
+		if custom, ok := frame.Meta.Custom.(map[string]interface{}); ok {
+			if count, ok := custom["documentsRead"].(int); ok {
+				return count
+			}
+		}
+	}
+	return 0
+}
+
+// record writes a record to the audit log. Audit records are written whatever the logLevel.
+func (a *auditLogger) record(ctx context.Context, record auditRecord) {
+	if a == nil {
+		return
+	}
+	if a.file == nil {
+		// Written unredacted: reviewing what was queried is the purpose of the audit log
+		log.DefaultLogger.FromContext(ctx).Info("Query audit",
+			"user", record.User, "email", record.Email, "orgId", record.OrgID, "datasourceUid", record.Datasource,
+			"refId", record.RefID, "collection", record.Collection, "query", record.Query,
+			"documentsRead", record.DocumentsRead, "durationMs", record.DurationMs, "status", record.Status, "error", record.Error)
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.DefaultLogger.FromContext(ctx).Error("Failed to encode audit record", "error", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.DefaultLogger.FromContext(ctx).Error("Failed to write audit record", "error", err)
+	}
+}
+
+func (a *auditLogger) close() {
+	if a != nil && a.file != nil {
+		_ = a.file.Close()
+	}
+}
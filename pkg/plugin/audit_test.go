@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAuditRecord(t *testing.T) {
+	pCtx := backend.PluginContext{
+		OrgID:                      2,
+		User:                       &backend.User{Login: "jdoe", Email: "jdoe@example.com"},
+		DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "firestore"},
+	}
+	query := backend.DataQuery{RefID: "A", JSON: []byte(`{"query":"SELECT * FROM customers WHERE msisdn = '600000000'"}`)}
+
+	meta := &queryMeta{}
+	meta.addDocumentsRead(routeNative, 42)
+	response := meta.apply(backend.DataResponse{Frames: data.Frames{data.NewFrame("response")}})
+
+	record := newAuditRecord(pCtx, query, response, 1500*time.Millisecond)
+	require.Equal(t, "jdoe", record.User)
+	require.Equal(t, int64(2), record.OrgID)
+	require.Equal(t, "firestore", record.Datasource)
+	require.Equal(t, "customers", record.Collection)
+	require.Equal(t, 42, record.DocumentsRead)
+	require.Equal(t, int64(1500), record.DurationMs)
+	require.Equal(t, 200, record.Status)
+
+	failed := newAuditRecord(backend.PluginContext{}, query, backend.ErrDataResponse(backend.StatusForbidden, "denied"), 0)
+	require.Empty(t, failed.User)
+	require.Equal(t, 403, failed.Status)
+	require.Equal(t, "denied", failed.Error)
+}
+
+func TestAuditLoggerFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := newAuditLogger(&FirestoreSettings{AuditLog: true, AuditLogPath: path})
+	require.NoError(t, err)
+
+	audit.record(context.Background(), auditRecord{User: "jdoe", Query: "SELECT * FROM users", DocumentsRead: 3})
+	audit.close()
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var record auditRecord
+	require.NoError(t, json.Unmarshal(content, &record))
+	require.Equal(t, "jdoe", record.User)
+	require.Equal(t, 3, record.DocumentsRead)
+
+	disabled, err := newAuditLogger(&FirestoreSettings{AuditLogPath: path})
+	require.NoError(t, err)
+	require.Nil(t, disabled)
+	disabled.record(context.Background(), record)
+}
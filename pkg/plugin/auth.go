@@ -0,0 +1,161 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	vkit "cloud.google.com/go/firestore/apiv1"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/pgollangi/fireql"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Authentication modes of the authType setting
+const (
+	// authTypeServiceAccount authenticates with the pasted service account JSON key
+	authTypeServiceAccount = "serviceAccount"
+	// authTypeADC authenticates with Application Default Credentials, e.g. the GCE/GKE
+	// metadata server when Grafana runs with Workload Identity
+	authTypeADC = "adc"
+	// authTypeOAuth authenticates with the Google OAuth token Grafana forwards for the
+	// signed-in user, so Firestore IAM and security rules apply per user
+	authTypeOAuth = "oauthPassThru"
+)
+
+// validateAuthType checks the authType setting, an empty value is the service account mode
+func validateAuthType(authType string) error {
+	switch authType {
+	case "", authTypeServiceAccount, authTypeADC, authTypeOAuth:
+		return nil
+	default:
+		return fmt.Errorf("invalid authType %q, expected %s, %s or %s", authType, authTypeServiceAccount, authTypeADC, authTypeOAuth)
+	}
+}
+
+// serviceAccountKey returns the service account JSON used by the datasource, empty in
+// the other modes so a key left over from an earlier configuration is not used
+func serviceAccountKey(settings *FirestoreSettings, secure map[string]string) string {
+	if settings.AuthType == authTypeADC || settings.AuthType == authTypeOAuth {
+		return ""
+	}
+	return secure["serviceAccount"]
+}
+
+// clientOptions returns the connection options and credentials of the Firestore client
+func clientOptions(ctx context.Context, settings *FirestoreSettings, secure map[string]string) ([]option.ClientOption, error) {
+	if err := validateAuthType(settings.AuthType); err != nil {
+		return nil, err
+	}
+
+	if settings.EmulatorHost != "" {
+		return emulatorOptions(settings.EmulatorHost)
+	}
+
+	options, err := credentialOptions(ctx, settings, secure)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Endpoint != "" {
+		options = append(options, option.WithEndpoint(settings.Endpoint))
+	}
+	return options, nil
+}
+
+// credentialOptions returns the credentials of the auth mode. Without a service account
+// key no option is returned and the clients look up Application Default Credentials.
+func credentialOptions(ctx context.Context, settings *FirestoreSettings, secure map[string]string) ([]option.ClientOption, error) {
+	if settings.AuthType == authTypeOAuth {
+		token := forwardedToken(ctx)
+		if token == "" {
+			return nil, errors.New("no OAuth token was forwarded for the signed-in user, sign in to Grafana with Google OAuth")
+		}
+		return []option.ClientOption{option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))}, nil
+	}
+
+	serviceAccount := serviceAccountKey(settings, secure)
+	if len(serviceAccount) == 0 {
+		return nil, nil
+	}
+
+	if !json.Valid([]byte(serviceAccount)) {
+		return nil, errors.New("invalid service account, it is expected to be a JSON")
+	}
+	creds, err := google.CredentialsFromJSON(ctx, []byte(serviceAccount),
+		vkit.DefaultAuthScopes()...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ServiceAccount: %v", err)
+	}
+	return []option.ClientOption{option.WithCredentials(creds)}, nil
+}
+
+// emulatorOptions connects the client to a Firestore emulator: plaintext gRPC and the
+// admin credentials the emulator accepts, as the SDK does for FIRESTORE_EMULATOR_HOST
+func emulatorOptions(host string) ([]option.ClientOption, error) {
+	conn, err := grpc.NewClient(host,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(emulatorCredentials{}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("emulator host %q: %v", host, err)
+	}
+	return []option.ClientOption{option.WithGRPCConn(conn)}, nil
+}
+
+// emulatorCredentials authenticate every call as the emulator's admin
+type emulatorCredentials struct{}
+
+func (emulatorCredentials) GetRequestMetadata(_ context.Context, _ ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer owner"}, nil
+}
+
+func (emulatorCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// fireqlSupported reports whether FireQL can honour the connection settings. It builds its
+// own client from the project and service account key, so forwarded identities, endpoint
+// overrides and emulators configured on the datasource need the native SDK.
+func fireqlSupported(settings *FirestoreSettings) bool {
+	return settings.AuthType != authTypeOAuth && settings.Endpoint == "" && settings.EmulatorHost == ""
+}
+
+// fireqlOptions returns the credentials of a FireQL query, which uses Application Default
+// Credentials when no service account is passed
+func fireqlOptions(settings *FirestoreSettings, secure map[string]string) ([]fireql.Option, error) {
+	if err := validateAuthType(settings.AuthType); err != nil {
+		return nil, err
+	}
+	var options []fireql.Option
+	if serviceAccount := serviceAccountKey(settings, secure); serviceAccount != "" {
+		options = append(options, fireql.OptionServiceAccount(serviceAccount))
+	}
+	return options, nil
+}
+
+// forwardedTokenKey is the context key of the OAuth access token forwarded by Grafana
+type forwardedTokenKey struct{}
+
+// withForwardedIdentity keeps the OAuth access token Grafana forwarded with a request in ctx,
+// where newFirestoreClient picks it up in OAuth pass-through mode
+func withForwardedIdentity(ctx context.Context, req backend.ForwardHTTPHeaders) context.Context {
+	header := req.GetHTTPHeader(backend.OAuthIdentityTokenHeaderName)
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, forwardedTokenKey{}, token)
+}
+
+// forwardedToken returns the OAuth access token of the request, empty when none was forwarded
+func forwardedToken(ctx context.Context) string {
+	token, _ := ctx.Value(forwardedTokenKey{}).(string)
+	return token
+}
@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		authType string
+		secure   map[string]string
+		options  int
+		valid    bool
+	}{
+		{"no key falls back to ADC", "", nil, 0, true},
+		{"invalid key", authTypeServiceAccount, map[string]string{"serviceAccount": "test"}, 0, false},
+		{"ADC ignores a stored key", authTypeADC, map[string]string{"serviceAccount": "test"}, 0, true},
+		{"OAuth without a forwarded token", authTypeOAuth, map[string]string{"serviceAccount": "test"}, 0, false},
+		{"unknown mode", "apiKey", nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		settings := FirestoreSettings{AuthType: tt.authType}
+		options, err := clientOptions(context.Background(), &settings, tt.secure)
+		require.Equal(t, tt.valid, err == nil, tt.name)
+		require.Len(t, options, tt.options, tt.name)
+	}
+}
+
+func TestClientOptionsEndpoint(t *testing.T) {
+	settings := FirestoreSettings{AuthType: authTypeADC, Endpoint: "firestore-psc.p.googleapis.com:443"}
+	options, err := clientOptions(context.Background(), &settings, nil)
+	require.NoError(t, err)
+	require.Len(t, options, 1)
+	require.False(t, fireqlSupported(&settings))
+
+	require.True(t, fireqlSupported(&FirestoreSettings{}))
+	require.False(t, fireqlSupported(&FirestoreSettings{AuthType: authTypeOAuth}))
+}
+
+func TestClientOptionsEmulator(t *testing.T) {
+	settings := FirestoreSettings{AuthType: authTypeOAuth, EmulatorHost: "localhost:8080"}
+	options, err := clientOptions(context.Background(), &settings, nil)
+	require.NoError(t, err)
+	require.Len(t, options, 1)
+	require.False(t, fireqlSupported(&settings))
+}
+
+func TestFireqlOptions(t *testing.T) {
+	secure := map[string]string{"serviceAccount": `{"type":"service_account"}`}
+
+	options, err := fireqlOptions(&FirestoreSettings{}, secure)
+	require.NoError(t, err)
+	require.Len(t, options, 1)
+
+	options, err = fireqlOptions(&FirestoreSettings{AuthType: authTypeADC}, secure)
+	require.NoError(t, err)
+	require.Empty(t, options)
+
+	_, err = fireqlOptions(&FirestoreSettings{AuthType: "apiKey"}, secure)
+	require.Error(t, err)
+}
+
+func TestForwardedIdentity(t *testing.T) {
+	req := &backend.QueryDataRequest{}
+	require.Empty(t, forwardedToken(withForwardedIdentity(context.Background(), req)))
+
+	req.SetHTTPHeader(backend.OAuthIdentityTokenHeaderName, "Bearer user-token")
+	ctx := withForwardedIdentity(context.Background(), req)
+	require.Equal(t, "user-token", forwardedToken(ctx))
+
+	options, err := clientOptions(ctx, &FirestoreSettings{AuthType: authTypeOAuth}, nil)
+	require.NoError(t, err)
+	require.Len(t, options, 1)
+
+	fqlOptions, err := fireqlOptions(&FirestoreSettings{AuthType: authTypeOAuth}, map[string]string{"serviceAccount": "{}"})
+	require.NoError(t, err)
+	require.Empty(t, fqlOptions)
+}
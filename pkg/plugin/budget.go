@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"google.golang.org/api/iterator"
+)
+
+// defaultMemoryBudgetMB is the per-query memory budget used when the setting is not set
+const defaultMemoryBudgetMB = 256
+
+// memoryBudget tracks the approximate bytes a query accumulates while its frames are
+// built, so an oversized result fails the query instead of exhausting the plugin
+// process shared by every datasource instance. A nil budget is unlimited.
+type memoryBudget struct {
+	limit int64
+	used  int64
+}
+
+// errBudgetExceeded is the error of a query that read more than its memory budget
+var errBudgetExceeded = errors.New("result too large")
+
+// newMemoryBudget creates a budget of the given size, or the default when limitMB isn't positive
+func newMemoryBudget(limitMB int) *memoryBudget {
+	if limitMB <= 0 {
+		limitMB = defaultMemoryBudgetMB
+	}
+	return &memoryBudget{limit: int64(limitMB) << 20}
+}
+
+// add accounts for a value read by the query and fails once the budget is exceeded
+func (b *memoryBudget) add(value interface{}) error {
+	if b == nil {
+		return nil
+	}
+	b.used += estimateSize(value)
+	if b.used > b.limit {
+		return fmt.Errorf("%w: the query read more than %d MB, add LIMIT or narrow the time range", errBudgetExceeded, b.limit>>20)
+	}
+	return nil
+}
+
+// readDocuments reads the documents of a query, decoding each one as the iterator returns it
+// and charging its fields to the budget, so an oversized result stops the read instead of
+// being loaded whole first. Documents that can't be decoded are skipped like
+// skipUndecodable does. A retried read is charged from where the budget was before it.
+func (d *Datasource) readDocuments(ctx context.Context, operation string, query firestore.Query, budget *memoryBudget, meta *queryMeta) ([]*firestore.DocumentSnapshot, decodedDocuments, error) {
+	var (
+		docs        []*firestore.DocumentSnapshot
+		decoded     decodedDocuments
+		undecodable []error
+	)
+	var used int64
+	if budget != nil {
+		used = budget.used
+	}
+	err := d.withRetries(ctx, operation, func() error {
+		docs, decoded, undecodable = nil, decodedDocuments{}, nil
+		if budget != nil {
+			budget.used = used
+		}
+		it := query.Documents(ctx)
+		defer it.Stop()
+		for i := 0; ; i++ {
+			if err := checkCanceled(ctx, i); err != nil {
+				return err
+			}
+			doc, err := it.Next()
+			if errors.Is(err, iterator.Done) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			docData, err := documentData(doc)
+			if err != nil {
+				d.logger(ctx).Warn("Skipping a document that can't be decoded", "document", doc.Ref.ID, "error", err)
+				undecodable = append(undecodable, err)
+				continue
+			}
+			if err := budget.add(docData); err != nil {
+				return err
+			}
+			docs = append(docs, doc)
+			decoded[doc] = docData
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, err := range undecodable {
+		meta.addUndecodable(err)
+	}
+	return docs, decoded, nil
+}
+
+// budgetExceededResponse is the response of a query that exceeded its memory budget
+func budgetExceededResponse(err error) backend.DataResponse {
+	return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+}
+
+// estimateSize approximates the memory held by a Firestore value, including the
+// overhead of interfaces, strings and maps
+func estimateSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 16
+	case string:
+		return 32 + int64(len(v))
+	case []byte:
+		return 40 + int64(len(v))
+	case time.Time:
+		return 40
+	case map[string]interface{}:
+		size := int64(48)
+		for key, item := range v {
+			size += 32 + int64(len(key)) + estimateSize(item)
+		}
+		return size
+	case []interface{}:
+		size := int64(40)
+		for _, item := range v {
+			size += estimateSize(item)
+		}
+		return size
+	case []map[string]interface{}:
+		size := int64(40)
+		for _, item := range v {
+			size += estimateSize(item)
+		}
+		return size
+	default:
+		return 24
+	}
+}
@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	budget := newMemoryBudget(1)
+	row := map[string]interface{}{"payload": strings.Repeat("x", 300<<10)}
+
+	require.NoError(t, budget.add(row))
+	require.NoError(t, budget.add(row))
+	require.NoError(t, budget.add(row))
+	err := budget.add(row)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "more than 1 MB")
+
+	var unlimited *memoryBudget
+	require.NoError(t, unlimited.add(row))
+
+	require.Equal(t, int64(defaultMemoryBudgetMB)<<20, newMemoryBudget(0).limit)
+}
+
+func TestReadDocuments(t *testing.T) {
+	payload := &firestorepb.Value{ValueType: &firestorepb.Value_StringValue{StringValue: strings.Repeat("x", 300<<10)}}
+	client := fakeFirestoreClient(t,
+		eventDocument("a", map[string]*firestorepb.Value{"payload": payload}),
+		corruptDocument("b"),
+		eventDocument("c", map[string]*firestorepb.Value{"payload": payload}),
+		eventDocument("d", map[string]*firestorepb.Value{"payload": payload}),
+		eventDocument("e", map[string]*firestorepb.Value{"payload": payload}),
+	)
+	query := client.Collection("events").Query
+	ctx := context.Background()
+
+	// Documents are charged as they are read, the read stops at the one exceeding the budget
+	meta := &queryMeta{}
+	budget := newMemoryBudget(1)
+	docs, decoded, err := (&Datasource{}).readDocuments(ctx, "test", query, budget, meta)
+	require.True(t, errors.Is(err, errBudgetExceeded))
+	require.Contains(t, err.Error(), "more than 1 MB")
+	require.Nil(t, docs)
+	require.Nil(t, decoded)
+	require.Less(t, budget.used, 5*int64(300<<10))
+
+	// A read within the budget keeps the decoded fields and skips undecodable documents
+	budget = newMemoryBudget(2)
+	docs, decoded, err = (&Datasource{}).readDocuments(ctx, "test", query, budget, meta)
+	require.NoError(t, err)
+	require.Len(t, docs, 4)
+	require.Len(t, decoded, 4)
+	require.Equal(t, 1, meta.undecodable)
+	require.Greater(t, budget.used, 4*int64(300<<10))
+}
+
+func TestEstimateSize(t *testing.T) {
+	small := estimateSize(map[string]interface{}{"a": "b"})
+	nested := estimateSize(map[string]interface{}{"a": "b", "c": []interface{}{int64(1), map[string]interface{}{"d": "eeee"}}})
+	require.Greater(t, small, int64(0))
+	require.Greater(t, nested, small)
+	require.Equal(t, estimateSize("abc")+3, estimateSize("abcdef"))
+}
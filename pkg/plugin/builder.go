@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// BuilderQuery is the structured query model of the visual query builder. It maps directly
+// to a native Firestore query, without parsing SQL.
+type BuilderQuery struct {
+	Collection   string               `json:"collection"`
+	Fields       []string             `json:"fields,omitempty"`
+	Filters      []BuilderFilter      `json:"filters,omitempty"`
+	OrderBy      []BuilderOrder       `json:"orderBy,omitempty"`
+	Limit        int                  `json:"limit,omitempty"`
+	GroupBy      []string             `json:"groupBy,omitempty"`
+	Aggregations []BuilderAggregation `json:"aggregations,omitempty"`
+}
+
+// BuilderFilter is a field filter, Operator is a Firestore operator such as "==" or "in"
+type BuilderFilter struct {
+	Field    string      `json:"field"`
+	Operator string      `json:"operator"`
+	Value    interface{} `json:"value"`
+}
+
+// BuilderOrder is a sort key
+type BuilderOrder struct {
+	Field      string `json:"field"`
+	Descending bool   `json:"descending,omitempty"`
+}
+
+// BuilderAggregation is an aggregate of a field, Field is empty or "*" for COUNT
+type BuilderAggregation struct {
+	Function string `json:"function"`
+	Field    string `json:"field,omitempty"`
+	Alias    string `json:"alias,omitempty"`
+}
+
+// builderOperators are the Firestore filter operators the builder accepts
+var builderOperators = map[string]bool{
+	"==":                 true,
+	"!=":                 true,
+	"<":                  true,
+	"<=":                 true,
+	">":                  true,
+	">=":                 true,
+	"in":                 true,
+	"not-in":             true,
+	"array-contains":     true,
+	"array-contains-any": true,
+}
+
+// builderFunctions are the aggregate functions the builder accepts
+var builderFunctions = map[string]bool{"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true}
+
+// queryInfo validates the builder query and returns its query model. The time range of the
+// panel filters timeField when both are set.
+func (b *BuilderQuery) queryInfo(timeField string, timeRange backend.TimeRange) (*QueryInfo, error) {
+	if b.Collection == "" {
+		return nil, errors.New("builder query: collection is required")
+	}
+	if b.Limit < 0 {
+		return nil, fmt.Errorf("builder query: invalid limit %d", b.Limit)
+	}
+
+	info := &QueryInfo{
+		Collection:    b.Collection,
+		Fields:        b.Fields,
+		Limit:         b.Limit,
+		GroupByFields: b.GroupBy,
+	}
+	if !timeRange.From.IsZero() && !timeRange.To.IsZero() {
+		info.TimeField = timeField
+	}
+
+	for _, filter := range b.Filters {
+		if filter.Field == "" || !builderOperators[filter.Operator] {
+			return nil, fmt.Errorf("builder query: invalid filter %q %q", filter.Field, filter.Operator)
+		}
+		info.AdditionalFilters = append(info.AdditionalFilters, FilterInfo{
+			Field:    filter.Field,
+			Operator: filter.Operator,
+			Value:    filter.Value,
+		})
+	}
+
+	for _, order := range b.OrderBy {
+		if order.Field == "" {
+			return nil, errors.New("builder query: orderBy field is required")
+		}
+		info.OrderBy = append(info.OrderBy, OrderKey{Field: order.Field, Descending: order.Descending})
+	}
+
+	for _, aggregation := range b.Aggregations {
+		function := strings.ToUpper(aggregation.Function)
+		field := aggregation.Field
+		if function == "COUNT" && field == "" {
+			field = "*"
+		}
+		if !builderFunctions[function] || field == "" {
+			return nil, fmt.Errorf("builder query: invalid aggregation %s(%s)", aggregation.Function, aggregation.Field)
+		}
+		alias := aggregation.Alias
+		if alias == "" {
+			// The default alias of the SQL parser, named after the function
+			alias = fmt.Sprintf("%s(%s)", function, field)
+		}
+		info.AggregateFields = append(info.AggregateFields, AggregateInfo{Function: function, Field: field, Alias: alias})
+	}
+
+	if len(info.Fields) == 0 && len(info.AggregateFields) == 0 {
+		info.Fields = []string{"*"}
+	}
+	return info, nil
+}
+
+// describe returns a readable form of the builder query for the audit log
+func (b *BuilderQuery) describe() string {
+	parts := []string{"collection(" + b.Collection + ")"}
+	if len(b.Fields) > 0 {
+		parts = append(parts, "fields("+strings.Join(b.Fields, ", ")+")")
+	}
+	for _, filter := range b.Filters {
+		parts = append(parts, fmt.Sprintf("where(%s %s %v)", filter.Field, filter.Operator, filter.Value))
+	}
+	if len(b.GroupBy) > 0 {
+		parts = append(parts, "groupBy("+strings.Join(b.GroupBy, ", ")+")")
+	}
+	for _, aggregation := range b.Aggregations {
+		field := aggregation.Field
+		if field == "" {
+			field = "*"
+		}
+		parts = append(parts, fmt.Sprintf("%s(%s)", strings.ToUpper(aggregation.Function), field))
+	}
+	for _, order := range b.OrderBy {
+		parts = append(parts, fmt.Sprintf("orderBy(%s %s)", order.Field, OrderKey{Descending: order.Descending}.direction()))
+	}
+	if b.Limit > 0 {
+		parts = append(parts, fmt.Sprintf("limit(%d)", b.Limit))
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,52 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilderQueryInfo(t *testing.T) {
+	timeRange := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+	builder := BuilderQuery{
+		Collection:   "orders",
+		Filters:      []BuilderFilter{{Field: "status", Operator: "in", Value: []interface{}{"open", "paid"}}},
+		OrderBy:      []BuilderOrder{{Field: "total", Descending: true}},
+		Limit:        10,
+		GroupBy:      []string{"brand"},
+		Aggregations: []BuilderAggregation{{Function: "count"}, {Function: "SUM", Field: "total", Alias: "revenue"}},
+	}
+
+	info, err := builder.queryInfo("createdAt", timeRange)
+	require.NoError(t, err)
+	require.Equal(t, "orders", info.Collection)
+	require.Equal(t, "createdAt", info.TimeField)
+	require.Equal(t, []FilterInfo{{Field: "status", Operator: "in", Value: []interface{}{"open", "paid"}}}, info.AdditionalFilters)
+	require.Equal(t, []OrderKey{{Field: "total", Descending: true}}, info.OrderBy)
+	require.Equal(t, []AggregateInfo{
+		{Function: "COUNT", Field: "*", Alias: "COUNT(*)"},
+		{Function: "SUM", Field: "total", Alias: "revenue"},
+	}, info.AggregateFields)
+	require.Equal(t, "count", aggregateFieldName(info.AggregateFields[0]))
+	require.Equal(t, "collection(orders).where(status in [open paid]).groupBy(brand).COUNT(*).SUM(total).orderBy(total DESC).limit(10)", builder.describe())
+
+	info, err = (&BuilderQuery{Collection: "orders"}).queryInfo("createdAt", backend.TimeRange{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"*"}, info.Fields)
+	require.Empty(t, info.TimeField)
+
+	invalid := []BuilderQuery{
+		{},
+		{Collection: "orders", Limit: -1},
+		{Collection: "orders", Filters: []BuilderFilter{{Field: "status", Operator: "LIKE"}}},
+		{Collection: "orders", OrderBy: []BuilderOrder{{}}},
+		{Collection: "orders", Aggregations: []BuilderAggregation{{Function: "MEDIAN", Field: "total"}}},
+		{Collection: "orders", Aggregations: []BuilderAggregation{{Function: "SUM"}}},
+	}
+	for _, builder := range invalid {
+		_, err := builder.queryInfo("", timeRange)
+		require.Error(t, err, "%+v", builder)
+	}
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"google.golang.org/api/iterator"
+)
+
+// cancelCheckInterval is how many documents or rows a loop processes between two checks of
+// the query context
+const cancelCheckInterval = 256
+
+// statusClientClosedRequest is the status Grafana reports for a request its client abandoned
+const statusClientClosedRequest backend.Status = 499
+
+// checkCanceled returns an error once the query context is done, checked every
+// cancelCheckInterval iterations so reading, filtering and aggregating large results stops
+// soon after the panel is closed or the query times out
+func checkCanceled(ctx context.Context, iteration int) error {
+	if iteration%cancelCheckInterval != 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("query stopped: %w", err)
+	}
+	return nil
+}
+
+// isCanceled reports whether an error is the query context being done
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// collectDocuments reads the documents of a query until the query context is done
+func collectDocuments(ctx context.Context, it *firestore.DocumentIterator) ([]*firestore.DocumentSnapshot, error) {
+	defer it.Stop()
+	var docs []*firestore.DocumentSnapshot
+	for i := 0; ; i++ {
+		if err := checkCanceled(ctx, i); err != nil {
+			return nil, err
+		}
+		doc, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+}
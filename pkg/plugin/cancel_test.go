@@ -0,0 +1,34 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, checkCanceled(ctx, 0))
+
+	cancel()
+	require.ErrorIs(t, checkCanceled(ctx, 0), context.Canceled)
+	require.ErrorIs(t, checkCanceled(ctx, cancelCheckInterval), context.Canceled)
+	// Between two checks the loop goes on
+	require.NoError(t, checkCanceled(ctx, 1))
+
+	require.True(t, isCanceled(checkCanceled(ctx, 0)))
+	require.False(t, isCanceled(errors.New("memory budget exceeded")))
+}
+
+func TestAggregateRowsCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rows := []map[string]interface{}{{"status": "open"}, {"status": "closed"}}
+	info := &QueryInfo{GroupByFields: []string{"status"}, AggregateFields: []AggregateInfo{{Function: "COUNT", Field: "*"}}}
+	response := (&Datasource{}).aggregateRows(ctx, rows, info, FirestoreQuery{})
+	require.ErrorContains(t, response.Error, "query stopped: context canceled")
+	require.Equal(t, statusClientClosedRequest, response.Status)
+}
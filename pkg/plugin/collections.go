@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// collectionsQueryPattern matches a collection listing: SHOW COLLECTIONS [IN <document path>]
+var collectionsQueryPattern = regexp.MustCompile(`(?is)^\s*SHOW\s+COLLECTIONS(?:\s+IN\s+(.+?))?\s*;?\s*$`)
+
+// parseCollectionsQuery returns the document whose subcollections a SHOW COLLECTIONS query
+// lists, empty for the top-level collections. isCollections is false for other queries.
+func parseCollectionsQuery(query string) (parent string, isCollections bool, err error) {
+	match := collectionsQueryPattern.FindStringSubmatch(query)
+	if match == nil {
+		return "", false, nil
+	}
+	if match[1] == "" {
+		return "", true, nil
+	}
+	parent = strings.Trim(strings.TrimSpace(match[1]), "`'\"/")
+	if len(strings.Split(parent, "/"))%2 != 0 || strings.Contains(parent, "//") || parent == "" {
+		return "", true, fmt.Errorf("invalid document path %q, expected collection/document[/collection/document...]", parent)
+	}
+	return parent, true, nil
+}
+
+// listCollections returns the top-level collections, or the subcollections of a document,
+// as a table of collection IDs and paths
+func (d *Datasource) listCollections(ctx context.Context, pCtx backend.PluginContext, parent string) backend.DataResponse {
+	client, err := newFirestoreClient(ctx, pCtx)
+	if err != nil {
+		d.logger(ctx).Error("Failed to create Firestore client", "error", err)
+		return backend.ErrDataResponse(backend.StatusBadRequest, "Firestore client: "+err.Error())
+	}
+	defer client.Close()
+
+	var collections []*firestore.CollectionRef
+	err = d.withRetries(ctx, "list collections", func() (err error) {
+		if parent == "" {
+			collections, err = client.Collections(ctx).GetAll()
+		} else {
+			collections, err = client.Doc(parent).Collections(ctx).GetAll()
+		}
+		return err
+	})
+	if err != nil {
+		d.logger(ctx).Error("Listing collections failed", "parent", parent, "error", err)
+		return firestoreErrorResponse("List collections: ", err)
+	}
+
+	names := make([]string, len(collections))
+	paths := make([]string, len(collections))
+	for i, collection := range collections {
+		names[i] = collection.ID
+		paths[i] = collection.Path
+	}
+	frame := data.NewFrame("response",
+		data.NewField(docNameColumn, nil, names),
+		data.NewField(docPathColumn, nil, paths),
+	)
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
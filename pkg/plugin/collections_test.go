@@ -0,0 +1,28 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCollectionsQuery(t *testing.T) {
+	tests := []struct {
+		query         string
+		parent        string
+		isCollections bool
+		valid         bool
+	}{
+		{"SHOW COLLECTIONS", "", true, true},
+		{"show collections in `customers/ACME`;", "customers/ACME", true, true},
+		{"SHOW COLLECTIONS IN customers", "", true, false},
+		{"SELECT * FROM collections", "", false, true},
+	}
+
+	for _, tt := range tests {
+		parent, isCollections, err := parseCollectionsQuery(tt.query)
+		require.Equal(t, tt.isCollections, isCollections, tt.query)
+		require.Equal(t, tt.valid, err == nil, tt.query)
+		require.Equal(t, tt.parent, parent, tt.query)
+	}
+}
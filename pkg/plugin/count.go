@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// countAlias is the alias of the count in server-side aggregation queries
+const countAlias = "count"
+
+// isCountOnly reports whether a query is a bare COUNT(*) that a server-side count
+// aggregation answers without downloading the documents
+func isCountOnly(queryInfo *QueryInfo, qm FirestoreQuery) bool {
+	if len(queryInfo.GroupByFields) > 0 || len(queryInfo.AggregateFields) != 1 {
+		return false
+	}
+	if aggField := queryInfo.AggregateFields[0]; aggField.Function != "COUNT" || aggField.Field != "*" {
+		return false
+	}
+	for _, filter := range queryInfo.AdditionalFilters {
+		if queryInfo.filteredInMemory(filter) {
+			return false
+		}
+	}
+	// Aggregation queries read the latest data and can't be profiled
+	return qm.ReadTime == "" && !qm.Explain && qm.Format != formatLogs
+}
+
+// countDocuments runs a server-side count aggregation of the query and returns the count as
+// a single numeric frame, named like the in-memory COUNT(*) column
+func (d *Datasource) countDocuments(ctx context.Context, query firestore.Query, aggField AggregateInfo, meta *queryMeta) (backend.DataResponse, error) {
+	count, err := d.countMatching(ctx, query, meta)
+	if err != nil {
+		return backend.DataResponse{}, err
+	}
+	frame := data.NewFrame("response", newAggregateField(aggregateFieldName(aggField), []interface{}{count}))
+	return backend.DataResponse{Frames: data.Frames{frame}}, nil
+}
+
+// countMatching counts the documents matching a query with a server-side count aggregation
+func (d *Datasource) countMatching(ctx context.Context, query firestore.Query, meta *queryMeta) (int64, error) {
+	defer meta.addFetch(time.Now(), 0)
+	var result firestore.AggregationResult
+	err := d.withRetries(ctx, "count aggregation", func() (err error) {
+		result, err = query.NewAggregationQuery().WithCount(countAlias).Get(ctx)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	count, ok := aggregationValue(result[countAlias]).(int64)
+	if !ok {
+		return 0, errors.New("count aggregation returned no count")
+	}
+
+	// Firestore bills a read per batch of up to 1000 index entries counted
+	meta.addDocumentsRead(routeNative, int(max(1, (count+999)/1000)))
+	return count, nil
+}
+
+// totalCountUnsupported returns why the documents matching a query can't be counted with the
+// totalCount option, empty when a count aggregation of its Firestore filters counts them
+func totalCountUnsupported(qm FirestoreQuery, info *QueryInfo) string {
+	switch {
+	case info.Join != nil:
+		return "joins can't be counted"
+	case info.Subquery != nil:
+		return "subqueries can't be counted"
+	case len(info.GroupByFields) > 0 || len(info.AggregateFields) > 0:
+		return "GROUP BY and aggregates return groups, not documents"
+	case isCollectionPattern(info.Collection):
+		return "wildcard collections can't be counted"
+	case distributionFormat(qm.Format):
+		return fmt.Sprintf("the %s format already counts every document", qm.Format)
+	case qm.ReadTime != "" || qm.Explain:
+		// Aggregation queries read the latest data and can't be profiled
+		return "readTime and explain queries can't be counted"
+	}
+	for _, filter := range info.AdditionalFilters {
+		if info.filteredInMemory(filter) {
+			return fmt.Sprintf("WHERE %s is filtered in memory", filter.Field)
+		}
+	}
+	return ""
+}
+
+// reportTotalCount records the number of documents matching the filters of a query in the
+// frame meta, so tables can show how many rows a LIMIT, a page or maxRows left out. A result
+// nothing cut is counted as read, otherwise a count aggregation counts the documents.
+func (d *Datasource) reportTotalCount(ctx context.Context, query firestore.Query, limited bool, read int, meta *queryMeta) {
+	total := int64(read)
+	if limited {
+		count, err := d.countMatching(ctx, query, meta)
+		if err != nil {
+			d.logger(ctx).Warn("Total count failed", "error", err)
+			meta.addNotice(data.NoticeSeverityWarning, "The total count of matching documents failed: "+err.Error())
+			return
+		}
+		total = count
+	}
+	meta.setCustom("totalCount", total)
+}
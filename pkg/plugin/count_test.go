@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsCountOnly(t *testing.T) {
+	tests := []struct {
+		query string
+		qm    FirestoreQuery
+		count bool
+	}{
+		{"SELECT COUNT(*) FROM orders WHERE ts >= $__from AND ts <= $__to", FirestoreQuery{}, true},
+		{"SELECT COUNT(*) AS total FROM orders WHERE status = 'open'", FirestoreQuery{}, true},
+		{"SELECT COUNT(*) FROM orders", FirestoreQuery{Format: formatTimeSeries}, true},
+		{"SELECT COUNT(*), SUM(amount) FROM orders", FirestoreQuery{}, false},
+		{"SELECT COUNT(amount) FROM orders", FirestoreQuery{}, false},
+		{"SELECT brand, COUNT(*) FROM orders GROUP BY brand", FirestoreQuery{}, false},
+		{"SELECT COUNT(*) FROM orders WHERE __name__ = 'a'", FirestoreQuery{}, false},
+		{"SELECT COUNT(*) FROM orders", FirestoreQuery{ReadTime: "2024-05-10T11:00:00Z"}, false},
+		{"SELECT COUNT(*) FROM orders", FirestoreQuery{Explain: true}, false},
+		{"SELECT * FROM orders", FirestoreQuery{}, false},
+	}
+
+	for _, tt := range tests {
+		queryInfo, err := parseSQLQueryWithVariables(tt.query)
+		require.NoError(t, err)
+		require.Equal(t, tt.count, isCountOnly(queryInfo, tt.qm), tt.query)
+	}
+}
+
+func TestTotalCountUnsupported(t *testing.T) {
+	tests := []struct {
+		name     string
+		qm       FirestoreQuery
+		expected string
+	}{
+		{name: "filters and limit", qm: FirestoreQuery{Query: "SELECT * FROM orders WHERE status = 'open' ORDER BY ts DESC LIMIT 100"}},
+		{name: "ranks", qm: FirestoreQuery{Query: "SELECT id, ROW_NUMBER() OVER (ORDER BY ts) AS n FROM orders LIMIT 10"}},
+		{name: "aggregates", qm: FirestoreQuery{Query: "SELECT brand, COUNT(*) FROM orders GROUP BY brand"}, expected: "GROUP BY and aggregates return groups, not documents"},
+		{name: "heatmap", qm: FirestoreQuery{Query: "SELECT * FROM orders", Format: formatHeatmap}, expected: "the heatmap format already counts every document"},
+		{name: "read time", qm: FirestoreQuery{Query: "SELECT * FROM orders", ReadTime: "2024-05-10T11:00:00Z"}, expected: "readTime and explain queries can't be counted"},
+		{name: "metadata filter", qm: FirestoreQuery{Query: "SELECT * FROM orders WHERE __name__ = 'a'"}, expected: "WHERE __name__ is filtered in memory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := nativeQueryInfo(tt.qm, backend.TimeRange{})
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, totalCountUnsupported(tt.qm, info))
+		})
+	}
+}
+
+func TestReportTotalCountOfCompleteResult(t *testing.T) {
+	// Nothing cut the result, its documents are all the matching ones
+	meta := &queryMeta{}
+	(&Datasource{}).reportTotalCount(context.Background(), firestore.Query{}, false, 42, meta)
+	require.Equal(t, int64(42), meta.custom["totalCount"])
+	require.Zero(t, meta.documentsRead)
+}
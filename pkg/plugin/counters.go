@@ -0,0 +1,148 @@
+package plugin
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// counterFunctions compute per-interval differences of cumulative counter fields
+var counterFunctions = map[string]bool{"RATE": true, "DELTA": true}
+
+// errCounterTimeField is returned when RATE or DELTA can't order the counter readings
+var errCounterTimeField = errors.New("RATE and DELTA need a time field to order the counter readings, group by date_trunc or set the time field")
+
+// counterSample holds the readings of a counter in one group: the first and the last, and
+// the increase between them where a decrease is a counter reset
+type counterSample struct {
+	first, last         float64
+	firstTime, lastTime time.Time
+	increase            float64
+}
+
+// counterTimeField returns the field that orders counter readings, the time bucket or the
+// time field of the query
+func counterTimeField(queryInfo *QueryInfo) string {
+	if queryInfo.TimeBucketField != "" {
+		return queryInfo.TimeBucketField
+	}
+	return queryInfo.TimeField
+}
+
+// hasCounterFunctions reports whether the query selects RATE or DELTA
+func hasCounterFunctions(queryInfo *QueryInfo) bool {
+	for _, aggField := range queryInfo.AggregateFields {
+		if counterFunctions[aggField.Function] {
+			return true
+		}
+	}
+	return false
+}
+
+// newCounterSample reads the counter field of the documents of a group in time order, nil
+// when none has both a number and a time
+func newCounterSample(field string, docs []map[string]interface{}, queryInfo *QueryInfo) *counterSample {
+	type reading struct {
+		ts    time.Time
+		value float64
+	}
+	timeField := counterTimeField(queryInfo)
+	var readings []reading
+	for _, doc := range docs {
+		val, ok := numericValue(selectFieldValue(doc, field))
+		if !ok {
+			continue
+		}
+		ts, ok := toTime(selectFieldValue(doc, timeField), queryInfo.timeFormatOf(timeField), queryInfo.Location)
+		if !ok {
+			continue
+		}
+		value, _ := convertToFloat(val)
+		readings = append(readings, reading{ts: ts, value: value})
+	}
+	if len(readings) == 0 {
+		return nil
+	}
+	sort.SliceStable(readings, func(i, j int) bool { return readings[i].ts.Before(readings[j].ts) })
+
+	sample := &counterSample{
+		first:     readings[0].value,
+		firstTime: readings[0].ts,
+	}
+	for i := 1; i < len(readings); i++ {
+		sample.increase += counterIncrease(readings[i-1].value, readings[i].value)
+	}
+	sample.last = readings[len(readings)-1].value
+	sample.lastTime = readings[len(readings)-1].ts
+	return sample
+}
+
+// counterIncrease returns how much a counter grew between two readings. After a reset the
+// counter restarted from zero, so the whole new reading is the increase.
+func counterIncrease(previous, current float64) float64 {
+	if current < previous {
+		return current
+	}
+	return current - previous
+}
+
+// applyCounterFunctions turns the counter samples of the GROUP BY results into RATE and
+// DELTA values. The increase of an interval includes the growth since the last reading of
+// the previous interval of its series; RATE divides it by the interval in seconds. Without a
+// time bucket every group is a series of its own, such as each host of GROUP BY host.
+func applyCounterFunctions(results []AggregatedResult, queryInfo *QueryInfo) {
+	if !hasCounterFunctions(queryInfo) {
+		return
+	}
+
+	series, timeIdx := resultSeries(results, queryInfo)
+	for aggIdx, aggField := range queryInfo.AggregateFields {
+		if !counterFunctions[aggField.Function] {
+			continue
+		}
+		for _, rows := range series {
+			var previous *counterSample
+			for _, row := range rows {
+				sample, _ := results[row].AggregateValues[aggIdx].(*counterSample)
+				results[row].AggregateValues[aggIdx] = counterValue(aggField.Function, sample, previous, bucketLength(results[row], timeIdx, queryInfo))
+				if sample != nil && timeIdx != -1 {
+					previous = sample
+				}
+			}
+		}
+	}
+}
+
+// bucketLength returns the length of the time bucket of a result, 0 without one
+func bucketLength(result AggregatedResult, timeIdx int, queryInfo *QueryInfo) time.Duration {
+	start, ok := resultTime(result, timeIdx)
+	if !ok || queryInfo.TimeBucket <= 0 {
+		return 0
+	}
+	return nextBucket(start, queryInfo).Sub(start)
+}
+
+// counterValue returns the RATE or DELTA of an interval, nil when it has no readings
+func counterValue(function string, sample, previous *counterSample, bucket time.Duration) interface{} {
+	if sample == nil {
+		return nil
+	}
+	delta := sample.increase
+	start := sample.firstTime
+	if previous != nil {
+		delta += counterIncrease(previous.last, sample.first)
+		start = previous.lastTime
+	}
+	if function == "DELTA" {
+		return delta
+	}
+
+	seconds := bucket.Seconds()
+	if bucket == 0 {
+		seconds = sample.lastTime.Sub(start).Seconds()
+	}
+	if seconds <= 0 {
+		return nil
+	}
+	return delta / seconds
+}
@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCounterSample(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	queryInfo := &QueryInfo{TimeField: "ts"}
+	docs := []map[string]interface{}{
+		{"ts": t0.Add(2 * time.Minute), "bytes": int64(5)},
+		{"ts": t0, "bytes": int64(100)},
+		{"ts": t0.Add(time.Minute), "bytes": int64(130)},
+		{"ts": t0.Add(3 * time.Minute)},
+		{"bytes": int64(1)},
+	}
+
+	sample := newCounterSample("bytes", docs, queryInfo)
+	require.Equal(t, &counterSample{
+		first:     100,
+		last:      5,
+		firstTime: t0,
+		lastTime:  t0.Add(2 * time.Minute),
+		increase:  35, // 30, then a reset to 5
+	}, sample)
+
+	require.Nil(t, newCounterSample("missing", docs, queryInfo))
+}
+
+func TestApplyCounterFunctions(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	minute := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Minute) }
+	queryInfo := &QueryInfo{
+		GroupByFields: []string{"ts", "host"},
+		AggregateFields: []AggregateInfo{
+			{Function: "DELTA", Field: "bytes", Alias: "delta"},
+			{Function: "RATE", Field: "bytes", Alias: "rate"},
+		},
+		TimeBucketField: "ts",
+		TimeBucket:      10 * time.Minute,
+	}
+	sample := func(first, last, increase float64, from, to int) *counterSample {
+		return &counterSample{first: first, last: last, firstTime: minute(from), lastTime: minute(to), increase: increase}
+	}
+	results := []AggregatedResult{
+		{GroupValues: []interface{}{minute(10), "a"}, AggregateValues: []interface{}{sample(700, 1300, 600, 11, 19), sample(700, 1300, 600, 11, 19)}},
+		{GroupValues: []interface{}{minute(0), "a"}, AggregateValues: []interface{}{sample(100, 400, 300, 1, 9), sample(100, 400, 300, 1, 9)}},
+		{GroupValues: []interface{}{minute(20), "a"}, AggregateValues: []interface{}{sample(50, 50, 0, 21, 21), sample(50, 50, 0, 21, 21)}},
+		{GroupValues: []interface{}{minute(0), "b"}, AggregateValues: []interface{}{nil, nil}},
+	}
+
+	applyCounterFunctions(results, queryInfo)
+
+	require.Equal(t, []interface{}{900.0, 1.5}, results[0].AggregateValues) // 300 across the boundary
+	require.Equal(t, []interface{}{300.0, 0.5}, results[1].AggregateValues)
+	require.Equal(t, []interface{}{50.0, 50.0 / 600}, results[2].AggregateValues) // reset
+	require.Equal(t, []interface{}{nil, nil}, results[3].AggregateValues)
+}
+
+func TestApplyCounterFunctionsPerGroup(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	queryInfo, err := parseSQLQueryWithVariables("SELECT host, DELTA(bytes) AS delta FROM hosts GROUP BY host")
+	require.NoError(t, err)
+	queryInfo.TimeField = "ts"
+	rows := []map[string]interface{}{
+		{"host": "a", "ts": t0, "bytes": int64(1000)},
+		{"host": "a", "ts": t0.Add(time.Minute), "bytes": int64(4000)},
+		{"host": "b", "ts": t0, "bytes": int64(10)},
+		{"host": "b", "ts": t0.Add(time.Minute), "bytes": int64(30)},
+	}
+
+	response := (&Datasource{}).aggregateRows(context.Background(), rows, queryInfo, FirestoreQuery{})
+	require.NoError(t, response.Error)
+	frame := response.Frames[0]
+	require.Equal(t, "a", frame.Fields[0].At(0))
+	require.Equal(t, 3000.0, frame.Fields[1].At(0))
+	require.Equal(t, "b", frame.Fields[0].At(1))
+	require.Equal(t, 20.0, frame.Fields[1].At(1))
+}
+
+func TestCounterValueWithoutTimeBucket(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	sample := &counterSample{first: 10, last: 70, firstTime: t0, lastTime: t0.Add(time.Minute), increase: 60}
+
+	require.Equal(t, 1.0, counterValue("RATE", sample, nil, 0))
+	require.Equal(t, 60.0, counterValue("DELTA", sample, nil, 0))
+	require.Nil(t, counterValue("RATE", &counterSample{firstTime: t0, lastTime: t0}, nil, 0))
+}
+
+func TestParseCounterFunctions(t *testing.T) {
+	queryInfo, err := parseSQLQueryWithVariables("SELECT date_trunc('minute', ts), RATE(stats.bytes) AS throughput, DELTA(requests) FROM hosts GROUP BY date_trunc('minute', ts)")
+	require.NoError(t, err)
+	require.Equal(t, []AggregateInfo{
+		{Function: "RATE", Field: "stats.bytes", Alias: "throughput"},
+		{Function: "DELTA", Field: "requests", Alias: "DELTA(requests)"},
+	}, queryInfo.AggregateFields)
+}
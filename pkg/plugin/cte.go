@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ctePattern matches the start of a query with common table expressions
+var ctePattern = regexp.MustCompile(`(?i)^\s*WITH\s`)
+
+// cteDefinitionPattern matches the name of a common table expression up to its parenthesis
+var cteDefinitionPattern = regexp.MustCompile(`(?i)^\s*([\p{L}_][\p{L}\p{N}_]*)\s+AS\s*\(`)
+
+// cteReferencePattern matches a table a query reads, FROM or JOIN name
+var cteReferencePattern = regexp.MustCompile(`(?i)\b(FROM|JOIN)\s+([\p{L}_][\p{L}\p{N}_]*)\b`)
+
+// expandCTEs inlines the common table expressions of a WITH query as subqueries of the
+// queries reading them, so WITH paid AS (SELECT ...) SELECT ... FROM paid runs as
+// SELECT ... FROM (SELECT ...) paid. An expression may read the ones defined before it.
+// Queries without WITH are returned as they are.
+func expandCTEs(query string) (string, error) {
+	if !ctePattern.MatchString(query) {
+		return query, nil
+	}
+	rest := ctePattern.ReplaceAllString(query, "")
+	if fields := strings.Fields(rest); len(fields) > 0 && strings.EqualFold(fields[0], "RECURSIVE") {
+		return "", fmt.Errorf("WITH RECURSIVE is not supported")
+	}
+
+	ctes := make(map[string]string)
+	for {
+		loc := cteDefinitionPattern.FindStringSubmatchIndex(rest)
+		if loc == nil {
+			return "", fmt.Errorf("WITH: expected name AS (SELECT ...)")
+		}
+		name := rest[loc[2]:loc[3]]
+		end := closingParen(rest, loc[1]-1)
+		if end == -1 {
+			return "", fmt.Errorf("WITH %s: missing closing parenthesis", name)
+		}
+		if _, exists := ctes[strings.ToLower(name)]; exists {
+			return "", fmt.Errorf("WITH %s is defined twice", name)
+		}
+
+		body, err := inlineCTEs(strings.TrimSpace(rest[loc[1]:end]), ctes)
+		if err != nil {
+			return "", err
+		}
+		if fields := strings.Fields(body); len(fields) == 0 || !strings.EqualFold(fields[0], "SELECT") {
+			return "", fmt.Errorf("WITH %s must hold a SELECT query", name)
+		}
+		ctes[strings.ToLower(name)] = body
+
+		rest = strings.TrimSpace(rest[end+1:])
+		if !strings.HasPrefix(rest, ",") {
+			break
+		}
+		rest = rest[1:]
+	}
+
+	if fields := strings.Fields(rest); len(fields) == 0 || !strings.EqualFold(fields[0], "SELECT") {
+		return "", fmt.Errorf("WITH must be followed by a SELECT query")
+	}
+	return inlineCTEs(rest, ctes)
+}
+
+// inlineCTEs replaces the common table expressions a query reads FROM with their query in
+// parentheses, aliased with their name unless the query aliases them
+func inlineCTEs(query string, ctes map[string]string) (string, error) {
+	var b strings.Builder
+	last := 0
+	for _, loc := range cteReferencePattern.FindAllStringSubmatchIndex(query, -1) {
+		keyword, name := query[loc[2]:loc[3]], query[loc[4]:loc[5]]
+		body, ok := ctes[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(keyword, "JOIN") {
+			return "", fmt.Errorf("WITH %s can't be joined, only read FROM", name)
+		}
+		b.WriteString(query[last:loc[0]])
+		b.WriteString(keyword + " (" + body + ")")
+		if match := subqueryAliasPattern.FindStringSubmatch(query[loc[1]:]); match == nil || (match[1] == "" && subqueryClauses[strings.ToUpper(match[2])]) {
+			b.WriteString(" " + name)
+		}
+		last = loc[1]
+	}
+	b.WriteString(query[last:])
+	return b.String(), nil
+}
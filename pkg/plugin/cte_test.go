@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandCTEs(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expanded string
+	}{
+		{
+			"no with",
+			"SELECT * FROM orders",
+			"SELECT * FROM orders",
+		},
+		{
+			"single",
+			"WITH totals AS (SELECT brand, customerId, SUM(total) AS total FROM orders GROUP BY brand, customerId) SELECT brand, AVG(total) AS avgTotal FROM totals GROUP BY brand",
+			"SELECT brand, AVG(total) AS avgTotal FROM (SELECT brand, customerId, SUM(total) AS total FROM orders GROUP BY brand, customerId) totals GROUP BY brand",
+		},
+		{
+			"chained",
+			"with paid as (select * from orders where status = 'paid'),\n  big as (select * from paid where total > 100)\nselect count(*) as n from big b",
+			"select count(*) as n from (select * from (select * from orders where status = 'paid') paid where total > 100) b",
+		},
+		{
+			"clause after the reference",
+			"WITH paid AS (SELECT * FROM orders WHERE status = 'paid') SELECT * FROM paid WHERE total > 10",
+			"SELECT * FROM (SELECT * FROM orders WHERE status = 'paid') paid WHERE total > 10",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expanded, err := expandCTEs(tt.query)
+			require.NoError(t, err)
+			require.Equal(t, tt.expanded, expanded)
+		})
+	}
+
+	for _, query := range []string{
+		"WITH RECURSIVE r AS (SELECT * FROM orders) SELECT * FROM r",
+		"WITH paid AS (SELECT * FROM orders SELECT * FROM paid",
+		"WITH paid AS (SELECT * FROM orders), paid AS (SELECT * FROM orders) SELECT * FROM paid",
+		"WITH paid AS (orders) SELECT * FROM paid",
+		"WITH paid AS (SELECT * FROM orders)",
+		"WITH paid (id) AS (SELECT id FROM orders) SELECT * FROM paid",
+		"WITH paid AS (SELECT * FROM orders) SELECT * FROM customers c JOIN paid p ON c.__name__ = p.customerId",
+	} {
+		_, err := expandCTEs(query)
+		require.Error(t, err, query)
+	}
+}
+
+func TestParseCTEQuery(t *testing.T) {
+	info, err := parseSQLQueryWithVariables("WITH totals AS (SELECT customerId, SUM(total) AS total FROM orders GROUP BY customerId) SELECT COUNT(*) AS customers FROM totals WHERE total > 100")
+	require.NoError(t, err)
+	require.Equal(t, "totals", info.Collection)
+	require.Equal(t, "orders", info.Subquery.Collection)
+	require.Equal(t, []string{"customerId"}, info.Subquery.GroupByFields)
+	require.Len(t, info.AdditionalFilters, 1)
+}
@@ -5,20 +5,25 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
-	vkit "cloud.google.com/go/firestore/apiv1"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/pgollangi/fireql"
-	"golang.org/x/oauth2/google"
+	"github.com/pgollangi/fireql/pkg/util"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 	"google.golang.org/api/iterator"
-	"google.golang.org/api/option"
 )
 
 // Make sure Datasource implements required interfaces. This is important to do
@@ -29,23 +34,137 @@ import (
 var (
 	_ backend.QueryDataHandler      = (*Datasource)(nil)
 	_ backend.CheckHealthHandler    = (*Datasource)(nil)
+	_ backend.CallResourceHandler   = (*Datasource)(nil)
+	_ backend.StreamHandler         = (*Datasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*Datasource)(nil)
 )
 
 // NewDatasource creates a new datasource instance.
 func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	return &Datasource{}, nil
+	var firestoreSettings FirestoreSettings
+	if err := json.Unmarshal(settings.JSONData, &firestoreSettings); err != nil {
+		// Invalid settings are reported by each query
+		defaultLogger().Warn("Error parsing settings", "error", err)
+	}
+	d := &Datasource{
+		settings:             settings,
+		debug:                firestoreSettings.Debug,
+		maxConcurrentQueries: firestoreSettings.MaxConcurrentQueries,
+		maxRetries:           defaultMaxRetries,
+	}
+	d.querySlots, d.maxActiveQueries = newQuerySlots(firestoreSettings.MaxActiveQueries)
+	if firestoreSettings.MaxRetries != nil && *firestoreSettings.MaxRetries >= 0 {
+		d.maxRetries = *firestoreSettings.MaxRetries
+	}
+	level, err := parseLogLevel(firestoreSettings.LogLevel)
+	if err != nil {
+		defaultLogger().FromContext(ctx).Warn("Invalid log level setting", "error", err)
+	}
+	d.logLevel = level
+	d.forwardOAuth = firestoreSettings.AuthType == authTypeOAuth
+	if templates := firestoreSettings.TemplatesCollection; templates != "" {
+		if collectionPathPattern.MatchString(templates) && !isCollectionPattern(templates) {
+			d.templates = templates
+		} else {
+			defaultLogger().FromContext(ctx).Warn("Invalid templates collection setting", "collection", templates)
+		}
+	}
+	d.audit, err = newAuditLogger(&firestoreSettings)
+	if err != nil {
+		// Audit records still go to the plugin logs rather than being dropped
+		defaultLogger().FromContext(ctx).Error("Failed to open the audit log", "error", err)
+		d.audit = &auditLogger{}
+	}
+	// A schema sampled with one user's identity must not be served to another
+	if !d.forwardOAuth {
+		d.schemas = newSchemaCache(d.loadSchema, schemaRefreshInterval)
+		d.lookups = newLookupCache()
+		d.streams = newStreamHub(d.listenStream)
+	}
+	d.resourceHandler = newResourceHandler(d)
+	return d, nil
 }
 
 // Datasource is an example datasource which can respond to data queries, reports
 // its health and has streaming skills.
-type Datasource struct{}
+type Datasource struct {
+	settings backend.DataSourceInstanceSettings
+
+	// debug enables diagnostic logs and notices, set with the debug datasource setting
+	debug bool
+
+	// maxConcurrentQueries limits the queries of one QueryData request run at the same time
+	maxConcurrentQueries int
+
+	// querySlots limits the queries of every request run at the same time to
+	// maxActiveQueries, nil when the datasource wasn't created by NewDatasource
+	querySlots       *semaphore.Weighted
+	maxActiveQueries int
+
+	// maxRetries is how often transient Firestore errors are retried
+	maxRetries int
+
+	// logLevel is the minimum level of the instance's logs, set with the logLevel setting
+	logLevel log.Level
+
+	// audit records the executed queries, nil when the auditLog setting is off
+	audit *auditLogger
+
+	// forwardOAuth runs queries with the OAuth identity of the signed-in user
+	forwardOAuth bool
+
+	// schemas caches the inferred collection schemas, nil when the datasource wasn't created by
+	// NewDatasource or forwards the OAuth identity of its users
+	schemas *schemaCache
+
+	// lookups caches the lookup collections, nil when the datasource wasn't created by
+	// NewDatasource or forwards the OAuth identity of its users
+	lookups *lookupCache
+
+	// templates is the collection of the shared query templates, empty when they are disabled
+	templates string
+
+	// streams shares the listeners of the streaming queries, nil when the datasource wasn't
+	// created by NewDatasource or forwards the OAuth identity of its users
+	streams *streamHub
+
+	resourceHandler backend.CallResourceHandler
+}
+
+// debugLog logs query diagnostics when the debug setting is enabled
+func (d *Datasource) debugLog(ctx context.Context, msg string, args ...interface{}) {
+	if d.debug {
+		d.logger(ctx).Info(msg, args...)
+	}
+}
+
+// queryConcurrency returns how many queries of a request run at the same time
+func (d *Datasource) queryConcurrency() int {
+	if d.maxConcurrentQueries > 0 {
+		return d.maxConcurrentQueries
+	}
+	return defaultMaxConcurrentQueries
+}
+
+// debugNotice adds a diagnostic notice to the query frames when the debug setting is enabled
+func (d *Datasource) debugNotice(meta *queryMeta, text string) {
+	if d.debug {
+		meta.addNotice(data.NoticeSeverityInfo, text)
+	}
+}
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
 // created. As soon as datasource settings change detected by SDK old datasource instance will
 // be disposed and a new one will be created using NewSampleDatasource factory function.
 func (d *Datasource) Dispose() {
 	// Clean up datasource instance resources.
+	if d.schemas != nil {
+		d.schemas.close()
+	}
+	if d.streams != nil {
+		d.streams.close()
+	}
+	d.audit.close()
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -55,38 +174,250 @@ func (d *Datasource) Dispose() {
 func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	// when logging at a non-Debug level, make sure you don't include sensitive information in the message
 	// (like the *backend.QueryDataRequest)
-	log.DefaultLogger.Debug("QueryData called", "numQueries", len(req.Queries))
+	d.debugLog(ctx, "QueryData called", "numQueries", len(req.Queries))
+
+	ctx = withForwardedIdentity(ctx, req)
 
 	// create response struct
 	response := backend.NewQueryDataResponse()
 
-	// loop over queries and execute them individually.
-	for _, q := range req.Queries {
-		res := d.query(ctx, req.PluginContext, q)
+	// execute the queries concurrently, up to the configured limit.
+	// query recovers from panics and reports failures in its response, so no goroutine returns an error.
+	results := make([]backend.DataResponse, len(req.Queries))
+	var g errgroup.Group
+	g.SetLimit(d.queryConcurrency())
+	for i, q := range req.Queries {
+		// Hidden queries aren't run, so they don't read and bill documents
+		if isHiddenQuery(req, q) {
+			continue
+		}
+		g.Go(func() error {
+			results[i] = d.query(ctx, req.PluginContext, q)
+			return nil
+		})
+	}
+	_ = g.Wait()
 
-		// save the response in a hashmap
-		// based on with RefID as identifier
-		response.Responses[q.RefID] = res
+	// save the responses in a hashmap
+	// based on with RefID as identifier
+	for i, q := range req.Queries {
+		response.Responses[q.RefID] = results[i]
 	}
 
 	return response, nil
 }
 
 type FirestoreQuery struct {
-	Query         string `json:"query"`
-	TimeField     string `json:"timeField,omitempty"`
+	Query          string `json:"query"`
+	TimeField      string `json:"timeField,omitempty"`
+	TimeFormat     string `json:"timeFormat,omitempty"`
+	Format         string `json:"format,omitempty"`
+	Flatten        bool   `json:"flatten,omitempty"`
+	GeoFormat      string `json:"geoFormat,omitempty"`
+	RefFormat      string `json:"refFormat,omitempty"`
+	IntervalMs     int64  `json:"intervalMs,omitempty"`
+	MaxRows        int    `json:"maxRows,omitempty"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
+
+	// ReadTime reads the database as of an RFC3339 timestamp within the PITR window
+	ReadTime string `json:"readTime,omitempty"`
+
+	// Explain profiles the query and returns its plan and execution stats instead of the results
+	Explain bool `json:"explain,omitempty"`
+
+	// Fill is the policy of the empty time buckets of a GROUP BY: null, zero or previous
+	Fill string `json:"fill,omitempty"`
+
+	// MaxDataPoints is the number of points the panel shows, a time series with more points is
+	// downsampled by the Downsample aggregator: avg, last or none
+	MaxDataPoints int64  `json:"maxDataPoints,omitempty"`
+	Downsample    string `json:"downsample,omitempty"`
+
+	// ConvertToWide pivots long time series, a row per time and label set, into a series per
+	// label set, with the missing points filled by Fill
+	ConvertToWide bool `json:"convertToWide,omitempty"`
+
+	// FieldConfig sets the display name, unit and decimals of result columns
+	FieldConfig map[string]FieldOptions `json:"fieldConfig,omitempty"`
+
+	// Decimals rounds the float columns the query computes, like averages and ratios, and
+	// writes the decimals into their field config. The decimals of a column in FieldConfig win.
+	Decimals *uint16 `json:"decimals,omitempty"`
+
+	// Alerting shapes the results as alert rules and expressions evaluate them, failing the
+	// query when a column can't be evaluated
+	Alerting bool `json:"alerting,omitempty"`
+
+	// Stream pushes the changes of the results to the panel over Grafana Live
+	Stream bool `json:"stream,omitempty"`
+
+	// Tail streams the documents written after the end of the time range, newest first by
+	// the time field, for watching incoming events. It implies Stream.
+	Tail bool `json:"tail,omitempty"`
+
+	// PageSize reads the results a page at a time, PageToken is the nextPageToken of the
+	// frame meta of the previous page, empty for the first page
+	PageSize  int    `json:"pageSize,omitempty"`
+	PageToken string `json:"pageToken,omitempty"`
+
+	// TotalCount reports the number of documents matching the filters in the frame meta,
+	// counted server-side when a LIMIT, a page or maxRows cuts the results
+	TotalCount bool `json:"totalCount,omitempty"`
+
+	// GroupValues lists the values of the GROUP BY field, comma separated, so each group is
+	// aggregated server-side
+	GroupValues string `json:"groupValues,omitempty"`
+
+	// Builder is the structured query of the visual query builder, used instead of Query
+	Builder *BuilderQuery `json:"builder,omitempty"`
+
+	// Lookup enriches the results with fields of the documents of another collection
+	Lookup *LookupOptions `json:"lookup,omitempty"`
+
+	// Params are the values of the :name parameters of the query, bound as typed values
+	Params map[string]interface{} `json:"params,omitempty"`
+
+	// Logs format options
+	LogMessageField string   `json:"logMessageField,omitempty"`
+	LogLevelField   string   `json:"logLevelField,omitempty"`
+	LogLabelFields  []string `json:"logLabelFields,omitempty"`
+
+	// Histogram and heatmap format options, the bucket width or else the bucket count
+	HistogramField       string  `json:"histogramField,omitempty"`
+	HistogramBucketWidth float64 `json:"histogramBucketWidth,omitempty"`
+	HistogramBuckets     int     `json:"histogramBuckets,omitempty"`
 }
 
+// Supported values for FirestoreQuery.Format
+const (
+	formatTable      = "table"
+	formatTimeSeries = "time_series"
+	formatLogs       = "logs"
+	formatHistogram  = "histogram"
+	formatHeatmap    = "heatmap"
+)
+
 type FirestoreSettings struct {
-	ProjectId string
+	ProjectId  string
+	TimeFormat string `json:"timeFormat,omitempty"`
+	Timezone   string `json:"timezone,omitempty"`
+	Debug      bool   `json:"debug,omitempty"`
+
+	// MaxRows caps the rows returned by a query, queries can lower or raise it
+	MaxRows int `json:"maxRows,omitempty"`
+
+	// DefaultLimit is the LIMIT of queries without one, so a query can't read a whole
+	// collection by accident. 0 leaves them capped by MaxRows only.
+	DefaultLimit int `json:"defaultLimit,omitempty"`
+
+	// Downsample is the aggregator of the time series with more points than the panel shows:
+	// avg (default), last or none, queries can override it
+	Downsample string `json:"downsample,omitempty"`
+
+	// MaxConcurrentQueries limits the queries of one request executed at the same time
+	MaxConcurrentQueries int `json:"maxConcurrentQueries,omitempty"`
+
+	// MaxActiveQueries limits the queries of every request executed at the same time, the
+	// others wait for a free slot within their timeout
+	MaxActiveQueries int `json:"maxActiveQueries,omitempty"`
+
+	// TimeoutSeconds bounds the execution time of a query, queries can lower or raise it
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+
+	// MaxRetries is how often transient Firestore errors are retried, 0 disables retries
+	MaxRetries *int `json:"maxRetries,omitempty"`
+
+	// MemoryBudgetMB caps the approximate memory a query may accumulate while building frames
+	MemoryBudgetMB int `json:"memoryBudgetMB,omitempty"`
+
+	// StreamMaxUpdatesPerSecond caps the updates sent per second on the channel of a streaming
+	// query, 10 by default, and StreamBatchWindowMs is how long a change waits for more
+	// changes to send them together
+	StreamMaxUpdatesPerSecond float64 `json:"streamMaxUpdatesPerSecond,omitempty"`
+	StreamBatchWindowMs       int     `json:"streamBatchWindowMs,omitempty"`
+
+	// LogLevel is the minimum level logged for the datasource: debug, info, warn or error
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// AuthType selects the credentials: serviceAccount (default) or adc for Application
+	// Default Credentials, e.g. Workload Identity on GKE or the GCE metadata server
+	AuthType string `json:"authType,omitempty"`
+
+	// Endpoint overrides firestore.googleapis.com:443, e.g. with a Private Service Connect
+	// or restricted VIP endpoint
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// EmulatorHost (host:port) connects to a Firestore emulator without credentials
+	EmulatorHost string `json:"emulatorHost,omitempty"`
+
+	// HealthCheckCollection is read by the health check to verify read permission
+	HealthCheckCollection string `json:"healthCheckCollection,omitempty"`
+
+	// CollectionTimeFields maps collection paths or IDs to the time field that filters their
+	// queries by the panel time range when the query doesn't filter one with $__from and $__to
+	CollectionTimeFields map[string]string `json:"collectionTimeFields,omitempty"`
+
+	// FieldTypes overrides the type the values of a field are read as, keyed by collection:field
+	// or by a field path of every collection, for fields whose type changed over time. The types are
+	// string, number, boolean, json, seconds, milliseconds and the time formats.
+	FieldTypes map[string]string `json:"fieldTypes,omitempty"`
+
+	// AuditLog records every executed query with the Grafana user, in the plugin logs or,
+	// with AuditLogPath, as JSON lines appended to that file
+	AuditLog     bool   `json:"auditLog,omitempty"`
+	AuditLogPath string `json:"auditLogPath,omitempty"`
+
+	// DataplaneLogs names the fields of log frames after the dataplane log lines, timestamp,
+	// body and severity, instead of time, body and level, and types them as log lines. Off
+	// by default, so panels transforming the time and level fields keep working.
+	DataplaneLogs bool `json:"dataplaneLogs,omitempty"`
+
+	// TemplatesCollection stores the shared query templates, which are disabled when it is empty.
+	// Saving templates needs write permission on this collection only.
+	TemplatesCollection string `json:"templatesCollection,omitempty"`
+}
+
+const (
+	// defaultMaxConcurrentQueries is the query concurrency used when the setting is not set
+	defaultMaxConcurrentQueries = 10
+	// defaultMaxRows is the row limit used when neither the query nor the settings set maxRows
+	defaultMaxRows = 10000
+	// defaultQueryTimeout is used when neither the query nor the settings set timeoutSeconds
+	defaultQueryTimeout = 30 * time.Second
+)
+
+// queryTimeout returns the execution time limit of a query
+func queryTimeout(qm FirestoreQuery, settings *FirestoreSettings) time.Duration {
+	if qm.TimeoutSeconds > 0 {
+		return time.Duration(qm.TimeoutSeconds) * time.Second
+	}
+	if settings.TimeoutSeconds > 0 {
+		return time.Duration(settings.TimeoutSeconds) * time.Second
+	}
+	return defaultQueryTimeout
+}
+
+// location returns the timezone used to interpret date strings stored without an offset
+func (s *FirestoreSettings) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %v", s.Timezone, err)
+	}
+	return loc, nil
 }
 
 func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery) (response backend.DataResponse) {
+	start := time.Now()
 	defer func() {
 		if err := recover(); err != nil {
-			log.DefaultLogger.Error("panic occurred ", err)
+			d.logger(ctx).Error("Panic while executing query", "refId", query.RefID, "panic", err)
 			response = backend.ErrDataResponse(backend.StatusInternal, "internal server error")
 		}
+		observeQuery(start, response)
+		d.audit.record(ctx, newAuditRecord(pCtx, query, response, time.Since(start)))
 	}()
 	response = d.queryInternal(ctx, pCtx, query)
 	return response
@@ -102,12 +433,20 @@ func (d *Datasource) queryInternal(ctx context.Context, pCtx backend.PluginConte
 	if err != nil {
 		return backend.ErrDataResponse(backend.StatusBadRequest, "json unmarshal: "+err.Error())
 	}
-	log.DefaultLogger.Debug("FirestoreQuery: ", qm)
+	d.debugLog(ctx, "Executing query", "refId", query.RefID, "format", qm.Format)
+	qm.Query = expandTimeFilterMacro(qm.Query)
+
+	if qm.IntervalMs == 0 {
+		qm.IntervalMs = query.Interval.Milliseconds()
+	}
+	if qm.MaxDataPoints == 0 {
+		qm.MaxDataPoints = query.MaxDataPoints
+	}
 
 	var settings FirestoreSettings
 	err = json.Unmarshal(pCtx.DataSourceInstanceSettings.JSONData, &settings)
 	if err != nil {
-		log.DefaultLogger.Error("Error parsing settings ", err)
+		d.logger(ctx).Error("Error parsing settings", "error", err)
 		return backend.ErrDataResponse(backend.StatusBadRequest, "ProjectID: "+err.Error())
 	}
 
@@ -115,9 +454,85 @@ func (d *Datasource) queryInternal(ctx context.Context, pCtx backend.PluginConte
 		return backend.ErrDataResponse(backend.StatusBadRequest, "ProjectID is required")
 	}
 
-	var options []fireql.Option
-	if pCtx.DataSourceInstanceSettings.DecryptedSecureJSONData["serviceAccount"] != "" {
-		options = append(options, fireql.OptionServiceAccount(pCtx.DataSourceInstanceSettings.DecryptedSecureJSONData["serviceAccount"]))
+	if qm.TimeFormat == "" {
+		qm.TimeFormat = settings.TimeFormat
+	}
+	if qm.MaxRows <= 0 {
+		qm.MaxRows = settings.MaxRows
+	}
+	if qm.Downsample == "" {
+		qm.Downsample = settings.Downsample
+	}
+	if qm.Tail {
+		qm.Stream = true
+	}
+	if qm.MaxRows <= 0 {
+		qm.MaxRows = defaultMaxRows
+	}
+	if err := validateTimeFormat(qm.TimeFormat); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateCollectionTimeFields(settings.CollectionTimeFields); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateFieldTypes(settings.FieldTypes); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+
+	// A slow collection scan fails the query instead of holding the whole dashboard refresh
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout(qm, &settings))
+	defer cancel()
+
+	if _, err := qm.readTime(time.Now()); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateGeoFormat(qm.GeoFormat); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateRefFormat(qm.RefFormat); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateFill(qm.Fill); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateDownsample(qm.Downsample); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateHistogram(qm); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateLookup(qm.Lookup); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateParams(qm.Params); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validateFieldConfig(qm); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if err := validatePaging(qm); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+
+	// Queries of every dashboard share the slots of the datasource
+	release, err := d.acquireQuerySlot(ctx)
+	if err != nil {
+		return firestoreErrorResponse("", err)
+	}
+	defer release()
+
+	options, err := fireqlOptions(&settings, pCtx.DataSourceInstanceSettings.DecryptedSecureJSONData)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+
+	// Without a LIMIT FireQL reads the default limit, or one record past maxRows so
+	// truncation can be reported
+	fireqlLimit := defaultLimitFor(nil, qm, &settings)
+	if fireqlLimit > 0 {
+		options = append(options, fireql.OptionDefaultLimit(fireqlLimit))
+	} else {
+		options = append(options, fireql.OptionDefaultLimit(qm.MaxRows+1))
 	}
 
 	fQuery, err := fireql.New(settings.ProjectId, options...)
@@ -125,153 +540,266 @@ func (d *Datasource) queryInternal(ctx context.Context, pCtx backend.PluginConte
 		return backend.ErrDataResponse(backend.StatusBadRequest, "fireql.NewFireQL: "+err.Error())
 	}
 
-	log.DefaultLogger.Info("Created fireql.NewFireQLWithServiceAccountJSON")
+	d.debugLog(ctx, "Created fireql.NewFireQLWithServiceAccountJSON")
+
+	// Builder queries map directly to a native Firestore query, without SQL parsing
+	if qm.Builder != nil {
+		plan, err := planQuery(qm, &settings, query.TimeRange)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, "Query parsing: "+err.Error())
+		}
+		if qm.Stream {
+			return d.queryStream(ctx, pCtx, &settings, qm, query.TimeRange, plan)
+		}
+		return d.executeNativePlan(ctx, pCtx, &settings, qm, query.TimeRange, plan)
+	}
 
 	if len(qm.Query) > 0 {
-		// Start with the original query
-		finalQuery := qm.Query
+		parent, isCollections, err := parseCollectionsQuery(qm.Query)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+		if isCollections {
+			queriesTotal.WithLabelValues(routeNative).Inc()
+			meta := &queryMeta{executedQuery: qm.Query, stats: queryStats{Engine: routeNative}}
+			return meta.apply(setFrameTypes(d.listCollections(ctx, pCtx, parent), formatTable, ""))
+		}
 
-		// Check if query contains Grafana global variables OR GROUP BY - if so, use native SDK
-		hasGrafanaVars := containsGrafanaVariables(qm.Query)
-		hasGroupBy := containsGroupBy(qm.Query)
+		paths, isDoc, err := parseDocQuery(qm.Query)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+		if isDoc {
+			queriesTotal.WithLabelValues(routeNative).Inc()
+			meta := &queryMeta{executedQuery: qm.Query, stats: queryStats{Engine: routeNative}}
+			response := applyFieldConfig(d.fetchDocuments(ctx, pCtx, &settings, qm, paths, meta), qm, nil)
+			return meta.apply(setFrameTypes(response, formatTable, ""))
+		}
 
-		// TEMPORARY DEBUG: Add route info to response if it's a test
-		routeInfo := fmt.Sprintf("hasGrafanaVars=%v,hasGroupBy=%v", hasGrafanaVars, hasGroupBy)
-		log.DefaultLogger.Info("DEBUG-ROUTE", "routeInfo", routeInfo)
+		if err := validateReadOnly(qm.Query); err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
 
-		if (hasGrafanaVars && !query.TimeRange.From.IsZero() && !query.TimeRange.To.IsZero()) || hasGroupBy {
-			log.DefaultLogger.Info("ROUTING TO NATIVE SDK", "query", qm.Query, "hasGrafanaVars", hasGrafanaVars, "hasGroupBy", hasGroupBy, "timeFrom", query.TimeRange.From, "timeTo", query.TimeRange.To)
-			return d.executeWithNativeSDKForVariables(ctx, pCtx, qm, query.TimeRange)
+		plan, err := planQuery(qm, &settings, query.TimeRange)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, "Query parsing: "+err.Error())
+		}
+		if qm.Stream {
+			if plan.route != routeNative {
+				return backend.ErrDataResponse(backend.StatusBadRequest, "stream: "+plan.reason)
+			}
+			return d.queryStream(ctx, pCtx, &settings, qm, query.TimeRange, plan)
+		}
+		if qm.PageSize > 0 && plan.route != routeNative {
+			return backend.ErrDataResponse(backend.StatusBadRequest, "pageSize: "+plan.reason)
 		}
+		if qm.TotalCount && plan.route != routeNative {
+			return backend.ErrDataResponse(backend.StatusBadRequest, "totalCount: "+plan.reason)
+		}
+		if plan.route == routeNative {
+			d.debugLog(ctx, "ROUTING TO NATIVE SDK", "query", qm.Query, "reason", plan.reason)
+			return d.executeNativePlan(ctx, pCtx, &settings, qm, query.TimeRange, plan)
+		}
+
+		// Start with the original query
+		finalQuery := qm.Query
 
-		log.DefaultLogger.Info("ROUTING TO FIREQL", "query", qm.Query, "hasGrafanaVars", hasGrafanaVars, "hasGroupBy", hasGroupBy)
+		d.debugLog(ctx, "ROUTING TO FIREQL", "query", qm.Query, "reason", plan.reason)
+		queriesTotal.WithLabelValues(routeFireQL).Inc()
 
-		// For queries without variables, continue with FireQL
-		finalQuery = qm.Query
+		// The native planner can't express the query, continue with FireQL. It has no
+		// parameters, so they are rendered as escaped literals
+		finalQuery, err = bindParams(qm.Query, qm.Params, sqlLiteral)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
 
 		// Time filtering is now manual using $__from and $__to variables in the query
 		// No automatic filtering to avoid index requirements for complex queries
 
-		// No automatic limit - user must specify LIMIT in query if needed
-
-		log.DefaultLogger.Info("Executing query", finalQuery)
+		d.debugLog(ctx, "Executing query", "query", finalQuery)
 
-		// Execute query directly
-		result, err := fQuery.Execute(finalQuery)
+		var result *util.QueryResult
+		started := time.Now()
+		err = d.withRetries(ctx, "fireql", func() (err error) {
+			result, err = executeWithTimeout(ctx, fQuery, finalQuery)
+			return err
+		})
 		if err != nil {
-			log.DefaultLogger.Error("Query execution failed", "error", err.Error(), "query", finalQuery)
-			return backend.ErrDataResponse(backend.StatusBadRequest, "fireql.Execute: "+err.Error())
+			d.logger(ctx).Error("FireQL query failed", "refId", query.RefID, "error", err)
+			return firestoreErrorResponse("fireql.Execute: ", err)
 		}
 
 		// Safely log query results
 		if result == nil {
-			log.DefaultLogger.Error("Query returned nil result")
+			d.logger(ctx).Error("FireQL query returned nil result", "refId", query.RefID)
 			return backend.ErrDataResponse(backend.StatusInternal, "Query returned nil result")
 		}
 
-		log.DefaultLogger.Info("Query executed successfully", "columns", len(result.Columns), "records", len(result.Records))
+		d.debugLog(ctx, "Query executed successfully", "columns", len(result.Columns), "records", len(result.Records))
 		if len(result.Records) == 0 {
-			log.DefaultLogger.Warn("No records returned - check timestamp format compatibility")
+			d.debugLog(ctx, "No records returned - check timestamp format compatibility", "refId", query.RefID)
 		}
 
-		// Protect against excessive memory usage
-		if len(result.Records) > 10000 {
-			log.DefaultLogger.Warn("Large result set detected, truncating to prevent memory issues", "originalSize", len(result.Records), "truncatedTo", 10000)
-			result.Records = result.Records[:10000]
+		meta := &queryMeta{executedQuery: finalQuery, stats: queryStats{Engine: routeFireQL, ParseMs: milliseconds(plan.parseTime)}}
+		meta.addFetch(started, len(result.Records))
+		meta.addDocumentsRead(routeFireQL, len(result.Records))
+		d.debugNotice(meta, "Executed with FireQL: "+plan.reason)
+
+		// FireQL lists the columns of SELECT * in map order, sort them so tables don't reorder between refreshes
+		if selectsAllFields(finalQuery) {
+			result.Columns, result.Records = sortRecordColumns(result.Columns, result.Records)
+		}
+
+		if qm.Flatten {
+			result.Columns, result.Records = flattenRecords(result.Columns, result.Records)
+		}
+		result.Columns, result.Records = expandGeoPointRecords(result.Columns, result.Records, qm.GeoFormat)
+		for _, record := range result.Records {
+			for idx, value := range record {
+				record[idx] = convertDocumentRefs(value, qm.RefFormat)
+			}
 		}
 
-		fieldValues := make(map[string]interface{})
+		// Protect against excessive memory usage
+		result.Records = result.Records[:meta.applyMaxRows(len(result.Records), qm.MaxRows)]
+		if fireqlLimit > 0 && !hasLimitClause(finalQuery) {
+			meta.reportDefaultLimit(len(result.Records), fireqlLimit)
+		}
 
-		for idx, column := range result.Columns {
-			var values interface{}
-			if len(result.Records) > 0 {
-				for recordIdx, record := range result.Records {
-					if record == nil {
-						log.DefaultLogger.Warn("Skipping nil record", "recordIndex", recordIdx)
-						continue
-					}
-					if idx >= len(record) {
-						log.DefaultLogger.Warn("Column index out of bounds for record", "columnIndex", idx, "recordLength", len(record), "recordIndex", recordIdx)
-						continue
-					}
-					val := record[idx]
-					if val == nil {
-						continue // Skip nil values
-					}
-					switch val.(type) {
-					case bool:
-						if values == nil {
-							values = []bool{}
-						}
-						values = append(values.([]bool), val.(bool))
-						break
-					case int:
-						if values == nil {
-							values = []int32{}
-						}
-						values = append(values.([]int32), int32(val.(int)))
-						break
-					case int32:
-						if values == nil {
-							values = []int32{}
-						}
-						values = append(values.([]int32), val.(int32))
-						break
-					case int64:
-						if values == nil {
-							values = []int64{}
-						}
-						values = append(values.([]int64), val.(int64))
-						break
-					case float64:
-						if values == nil {
-							values = []float64{}
-						}
-						values = append(values.([]float64), val.(float64))
-						break
-					case time.Time:
-						if values == nil {
-							values = []time.Time{}
-						}
-						values = append(values.([]time.Time), val.(time.Time))
-						break
-					case map[string]interface{}, []map[string]interface{}, []interface{}:
-						if values == nil {
-							values = []json.RawMessage{}
-						}
-						jsonVal, err := json.Marshal(val)
-						if err != nil {
-							return backend.ErrDataResponse(backend.StatusBadRequest, "json.Marshal : "+column+err.Error())
-						} else {
-							values = append(values.([]json.RawMessage), json.RawMessage(jsonVal))
-						}
-						break
-					default:
-						if values == nil {
-							values = []string{}
-						}
-						values = append(values.([]string), fmt.Sprintf("%v", val))
-					}
-				}
-			} else {
-				values = []string{}
+		// Drop empty records so every column stays aligned with the remaining rows
+		budget := newMemoryBudget(settings.MemoryBudgetMB)
+		records := make([][]interface{}, 0, len(result.Records))
+		skippedRecords := 0
+		for recordIdx, record := range result.Records {
+			if record == nil {
+				d.logger(ctx).Warn("Skipping nil record", "recordIndex", recordIdx)
+				skippedRecords++
+				continue
+			}
+			if err := budget.add(record); err != nil {
+				return budgetExceededResponse(err)
 			}
-			fieldValues[column] = values
+			records = append(records, record)
 		}
 
 		// create data frame response.
 		frame := data.NewFrame("response")
-		for _, column := range result.Columns {
-			// Add debug info to show this is using FireQL path
-			debugColumn := column + "_USING_FIREQL"
-			frame.Fields = append(frame.Fields,
-				data.NewField(debugColumn, nil, fieldValues[column]),
-			)
+		fieldTypes := settings.collectionFieldTypes(extractCollectionName(finalQuery))
+		location, _ := settings.location()
+		for idx, column := range result.Columns {
+			values := make([]interface{}, len(records))
+			for recordIdx, record := range records {
+				if idx < len(record) {
+					values[recordIdx] = record[idx]
+				}
+			}
+			if fieldType, ok := fieldTypes[column]; ok {
+				frame.Fields = append(frame.Fields, overrideField(column, values, fieldType, location))
+				continue
+			}
+			if notice, ok := coercionNotice(column, values); ok {
+				meta.addNotice(data.NoticeSeverityInfo, notice)
+			}
+			frame.Fields = append(frame.Fields, newTypedField(column, values))
 		}
 		// add the frames to the response.
 		response.Frames = append(response.Frames, frame)
+
+		if skippedRecords > 0 {
+			meta.addNotice(data.NoticeSeverityWarning, fmt.Sprintf("%d empty records were skipped", skippedRecords))
+		}
+		if qm.Lookup != nil {
+			response = d.applyLookup(ctx, pCtx, qm, response, meta)
+		}
+		response = downsampleResponse(response, qm, meta)
+		if qm.ConvertToWide {
+			response = wideResponse(response, qm)
+		}
+		if qm.Alerting {
+			response = alertingResponse(response)
+		}
+		// The aggregates FireQL computed are named as the query parses them
+		computed, _ := parseSQLQueryWithVariables(finalQuery)
+		response = applyFieldConfig(response, qm, computed)
+		response = setFrameTypes(response, qm.Format, qm.TimeField)
+		response = meta.apply(response)
+	}
+
+	return response
+}
+
+// queryMeta collects frame metadata while a query executes. It is applied to every
+// frame of the response once the query completes.
+type queryMeta struct {
+	executedQuery string
+	notices       []data.Notice
+	custom        map[string]interface{}
+	documentsRead int
+	stats         queryStats
+
+	// undecodable counts the documents skipped because they can't be decoded
+	undecodable      int
+	firstUndecodable error
+}
+
+// setCustom records a plugin specific value in the frame meta
+func (m *queryMeta) setCustom(key string, value interface{}) {
+	if m.custom == nil {
+		m.custom = make(map[string]interface{})
+	}
+	m.custom[key] = value
+}
+
+// addDocumentsRead counts documents read from Firestore, which Firestore bills, in the
+// frame meta and the plugin metrics
+func (m *queryMeta) addDocumentsRead(route string, count int) {
+	documentsFetchedTotal.WithLabelValues(route).Add(float64(count))
+	m.documentsRead += count
+	m.setCustom("documentsRead", m.documentsRead)
+}
+
+// applyMaxRows records the row limit in the frame meta and returns how many of the
+// rows to keep, adding a notice when rows are dropped
+func (m *queryMeta) applyMaxRows(rows, maxRows int) int {
+	if maxRows <= 0 {
+		return rows
 	}
+	m.setCustom("maxRows", maxRows)
+	if rows <= maxRows {
+		return rows
+	}
+	defaultLogger().Warn("Large result set detected, truncating to prevent memory issues", "rows", rows, "maxRows", maxRows)
+	m.setCustom("truncated", true)
+	m.addNotice(data.NoticeSeverityWarning, fmt.Sprintf("Results truncated to the first %d records. Add a LIMIT, narrow the time range or raise maxRows.", maxRows))
+	return maxRows
+}
 
+// addNotice records a notice so dashboard authors see it on the panel, not only in the logs
+func (m *queryMeta) addNotice(severity data.NoticeSeverity, text string) {
+	m.notices = append(m.notices, data.Notice{Severity: severity, Text: text})
+}
+
+// apply copies the collected metadata into the frames of the response
+func (m *queryMeta) apply(response backend.DataResponse) backend.DataResponse {
+	if m.stats.Engine != "" {
+		m.setCustom("stats", m.stats.finish())
+	}
+	notices := m.notices
+	if notice, ok := m.undecodableNotice(); ok {
+		notices = append(notices[:len(notices):len(notices)], notice)
+		m.setCustom("skippedDocuments", m.undecodable)
+	}
+	for _, frame := range response.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.ExecutedQueryString = m.executedQuery
+		frame.AppendNotices(notices...)
+		if len(m.custom) > 0 {
+			frame.Meta.Custom = m.custom
+		}
+	}
 	return response
 }
 
@@ -279,7 +807,7 @@ func newFirestoreClient(ctx context.Context, pCtx backend.PluginContext) (*fires
 	var settings FirestoreSettings
 	err := json.Unmarshal(pCtx.DataSourceInstanceSettings.JSONData, &settings)
 	if err != nil {
-		log.DefaultLogger.Error("Error parsing settings ", err)
+		defaultLogger().FromContext(ctx).Error("Error parsing settings", "error", err)
 		return nil, fmt.Errorf("ProjectID: %v", err)
 	}
 
@@ -287,25 +815,14 @@ func newFirestoreClient(ctx context.Context, pCtx backend.PluginContext) (*fires
 		return nil, errors.New("project Id is required")
 	}
 
-	var options []option.ClientOption
-	serviceAccount := pCtx.DataSourceInstanceSettings.DecryptedSecureJSONData["serviceAccount"]
-
-	if len(serviceAccount) > 0 {
-		if !json.Valid([]byte(serviceAccount)) {
-			return nil, errors.New("invalid service account, it is expected to be a JSON")
-		}
-		creds, err := google.CredentialsFromJSON(ctx, []byte(serviceAccount),
-			vkit.DefaultAuthScopes()...,
-		)
-		if err != nil {
-			log.DefaultLogger.Error("google.CredentialsFromJSON ", err)
-			return nil, fmt.Errorf("ServiceAccount: %v", err)
-		}
-		options = append(options, option.WithCredentials(creds))
+	options, err := clientOptions(ctx, &settings, pCtx.DataSourceInstanceSettings.DecryptedSecureJSONData)
+	if err != nil {
+		defaultLogger().FromContext(ctx).Error("Invalid datasource credentials", "error", err)
+		return nil, err
 	}
 	client, err := firestore.NewClient(ctx, settings.ProjectId, options...)
 	if err != nil {
-		log.DefaultLogger.Error("firestore.NewClient ", err)
+		defaultLogger().FromContext(ctx).Error("Failed to create Firestore client", "error", err)
 		return nil, fmt.Errorf("firestore.NewClient: %v", err)
 	}
 	return client, nil
@@ -321,36 +838,23 @@ func containsGroupBy(query string) bool {
 	return strings.Contains(strings.ToLower(query), "group by")
 }
 
+// selectsAllFields checks if the query selects only * so its columns come from the documents
+func selectsAllFields(query string) bool {
+	fields := strings.Fields(query)
+	return len(fields) >= 2 && strings.EqualFold(fields[0], "select") && fields[1] == "*"
+}
+
 // replaceGrafanaVariables replaces Grafana global variables with actual timestamp values
 func replaceGrafanaVariables(query string, timeRange backend.TimeRange) string {
 	// Based on testing, we discovered that Firestore/FireQL has issues with timestamp comparisons
-	// Let's try multiple formats and log them for debugging
-
-	// Format 1: Unix milliseconds (original approach)
 	fromMillis := timeRange.From.UnixMilli()
 	toMillis := timeRange.To.UnixMilli()
 
-	// Format 2: RFC3339 timestamps
-	fromRFC := timeRange.From.Format(time.RFC3339)
-	toRFC := timeRange.To.Format(time.RFC3339)
-
-	// Format 3: Firestore timestamp format (ISO 8601 with nanoseconds)
-	fromISO := timeRange.From.Format("2006-01-02T15:04:05.999999999Z")
-	toISO := timeRange.To.Format("2006-01-02T15:04:05.999999999Z")
-
 	// Based on testing, direct numeric comparison with Unix milliseconds should work
 	// The data inspect showed timestamps as Unix milliseconds: 1757789690410, 1758187471102, etc.
 	result := strings.ReplaceAll(query, "$__from", fmt.Sprintf("%d", fromMillis))
 	result = strings.ReplaceAll(result, "$__to", fmt.Sprintf("%d", toMillis))
 
-	log.DefaultLogger.Info("Replaced Grafana variables with Unix milliseconds",
-		"fromMillis", fromMillis,
-		"toMillis", toMillis,
-		"fromRFC", fromRFC,
-		"toRFC", toRFC,
-		"fromISO", fromISO,
-		"toISO", toISO,
-		"finalQuery", result)
 
 	return result
 }
@@ -362,22 +866,12 @@ func addTimeRangeFilter(query, timeField string, timeRange backend.TimeRange) st
 	fromMillis := timeRange.From.UnixMilli()
 	toMillis := timeRange.To.UnixMilli()
 
-	log.DefaultLogger.Info("Time filter values",
-		"field", timeField,
-		"fromMillis", fromMillis,
-		"toMillis", toMillis)
 
 	// Use numeric comparison matching the inspect data format (1758183895512)
 	// Firestore timestamps are stored as Unix milliseconds
 	timeFilter := fmt.Sprintf("%s >= %d and %s <= %d", timeField, fromMillis, timeField, toMillis)
 
-	log.DefaultLogger.Info("Using numeric Unix milliseconds for timestamp filtering",
-		"timeField", timeField,
-		"fromMillis", fromMillis,
-		"toMillis", toMillis,
-		"generatedFilter", timeFilter)
 
-	log.DefaultLogger.Info("Using Unix milliseconds for Firestore timestamp field", "filter", timeFilter)
 
 	// Check if the query already has a WHERE clause
 	queryLower := strings.ToLower(query)
@@ -408,28 +902,78 @@ func addTimeRangeFilter(query, timeField string, timeRange backend.TimeRange) st
 func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	// when logging at a non-Debug level, make sure you don't include sensitive information in the message
 	// (like the *backend.QueryDataRequest)
-	log.DefaultLogger.Debug("CheckHealth called")
+	d.debugLog(ctx, "CheckHealth called")
+	ctx = withForwardedIdentity(ctx, req)
 
 	var status = backend.HealthStatusOk
 	var message = "Data source is working"
 
 	client, healthErr := newFirestoreClient(ctx, req.PluginContext)
-
 	if healthErr == nil {
 		defer client.Close()
+	}
+
+	var settings FirestoreSettings
+	_ = json.Unmarshal(req.PluginContext.DataSourceInstanceSettings.JSONData, &settings)
+
+	// Name the missing permissions instead of failing on the first denied call below
+	var permissionsNote string
+	if healthErr == nil && verifiesPermissions(&settings) {
+		options, err := credentialOptions(ctx, &settings, req.PluginContext.DataSourceInstanceSettings.DecryptedSecureJSONData)
+		var missing []string
+		if err == nil {
+			missing, err = missingPermissions(ctx, settings.ProjectId, options)
+		}
+		switch {
+		case err != nil:
+			d.logger(ctx).Warn("Health check could not verify IAM permissions", "error", err)
+			permissionsNote = ". IAM permissions were not verified: " + err.Error()
+		case len(missing) > 0:
+			healthErr = fmt.Errorf("the credentials lack the IAM permissions %s on project %s, grant roles/datastore.viewer", strings.Join(missing, ", "), settings.ProjectId)
+		}
+	}
+
+	if healthErr == nil {
 		collections := client.Collections(ctx)
 		collection, err := collections.Next()
 		if err == nil || errors.Is(err, iterator.Done) {
-			log.DefaultLogger.Debug("First collections: ", collection.ID)
+			if collection != nil {
+				d.debugLog(ctx, "Health check listed collections", "first", collection.ID)
+			}
 		} else {
-			log.DefaultLogger.Error("client.Collections ", err)
+			d.logger(ctx).Error("Health check failed to list collections", "error", err)
 			healthErr = fmt.Errorf("firestore.Collections: %v", err)
+			if isDatastoreMode(err) {
+				healthErr = errDatastoreMode
+			}
+		}
+	}
+
+	// Listing collections can succeed without read permission on the documents, so a
+	// configured collection is read as well
+	if healthErr == nil && settings.HealthCheckCollection != "" {
+		timeField := settings.collectionTimeField(settings.HealthCheckCollection)
+		timeFormat := settings.TimeFormat
+		if fieldType := settings.collectionFieldTypes(settings.HealthCheckCollection)[timeField]; isTimeFieldType(fieldType) {
+			timeFormat = fieldType
+		}
+		latency, latest, err := readHealthCheckCollection(ctx, client, settings.HealthCheckCollection, timeField, timeFormat)
+		if err != nil {
+			d.logger(ctx).Error("Health check failed to read collection", "collection", settings.HealthCheckCollection, "error", err)
+			healthErr = fmt.Errorf("reading collection %s: %v", settings.HealthCheckCollection, err)
+		} else {
+			message = fmt.Sprintf("Data source is working, read collection %s in %d ms", settings.HealthCheckCollection, latency.Milliseconds())
+			if !latest.IsZero() {
+				message += fmt.Sprintf(", latest %s %s", timeField, latest.UTC().Format(time.RFC3339))
+			}
 		}
 	}
 
 	if healthErr != nil {
 		status = backend.HealthStatusError
 		message = healthErr.Error()
+	} else {
+		message += permissionsNote
 	}
 
 	return &backend.CheckHealthResult{
@@ -439,15 +983,33 @@ func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRe
 }
 
 
-// executeWithTimeout executes a query with timeout protection
-func executeWithTimeout(ctx context.Context, fQuery *fireql.FireQL, query string) (interface{}, error) {
-	resultChan := make(chan interface{}, 1)
+// readHealthCheckCollection reads a document of a collection and returns the read latency.
+// With a time field it reads the latest document and returns its time as well.
+func readHealthCheckCollection(ctx context.Context, client *firestore.Client, collection, timeField, timeFormat string) (time.Duration, time.Time, error) {
+	query := client.Collection(collection).Limit(1)
+	if timeField != "" {
+		query = query.OrderBy(timeField, firestore.Desc)
+	}
+	start := time.Now()
+	docs, err := query.Documents(ctx).GetAll()
+	latency := time.Since(start)
+	if err != nil || timeField == "" || len(docs) == 0 {
+		return latency, time.Time{}, err
+	}
+	latest, _ := toTime(selectFieldValue(docs[0].Data(), timeField), timeFormat, nil)
+	return latency, latest, nil
+}
+
+// executeWithTimeout executes a FireQL query until the context is done. FireQL doesn't take
+// a context, so a query that times out keeps running in the background until it returns.
+func executeWithTimeout(ctx context.Context, fQuery *fireql.FireQL, query string) (*util.QueryResult, error) {
+	resultChan := make(chan *util.QueryResult, 1)
 	errorChan := make(chan error, 1)
 
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				log.DefaultLogger.Error("Panic in query execution", "panic", r)
+				defaultLogger().FromContext(ctx).Error("Panic in query execution", "panic", r)
 				errorChan <- fmt.Errorf("query execution panic: %v", r)
 			}
 		}()
@@ -466,7 +1028,7 @@ func executeWithTimeout(ctx context.Context, fQuery *fireql.FireQL, query string
 	case err := <-errorChan:
 		return nil, err
 	case <-ctx.Done():
-		return nil, fmt.Errorf("query execution timeout after 30 seconds")
+		return nil, fmt.Errorf("query execution stopped: %w", ctx.Err())
 	}
 }
 
@@ -486,12 +1048,12 @@ func isSimpleQuery(query string) bool {
 
 // executeWithNativeSDK executes simple queries using native Firestore SDK with timestamp filtering
 func (d *Datasource) executeWithNativeSDK(ctx context.Context, pCtx backend.PluginContext, qm FirestoreQuery, timeRange backend.TimeRange) backend.DataResponse {
-	log.DefaultLogger.Info("Executing with native Firestore SDK", "query", qm.Query, "timeField", qm.TimeField)
+	d.debugLog(ctx, "Executing with native Firestore SDK", "query", qm.Query, "timeField", qm.TimeField)
 
 	// Create Firestore client
 	client, err := newFirestoreClient(ctx, pCtx)
 	if err != nil {
-		log.DefaultLogger.Error("Failed to create Firestore client", "error", err)
+		d.logger(ctx).Error("Failed to create Firestore client", "error", err)
 		return backend.ErrDataResponse(backend.StatusBadRequest, "Firestore client: "+err.Error())
 	}
 	defer client.Close()
@@ -499,29 +1061,29 @@ func (d *Datasource) executeWithNativeSDK(ctx context.Context, pCtx backend.Plug
 	// Parse collection name from query
 	collectionName := extractCollectionName(qm.Query)
 	if collectionName == "" {
-		log.DefaultLogger.Error("Could not extract collection name from query", "query", qm.Query)
+		d.logger(ctx).Error("Could not extract collection name from query")
 		return backend.ErrDataResponse(backend.StatusBadRequest, "Could not parse collection name")
 	}
 
-	log.DefaultLogger.Info("Using native SDK for collection", "collection", collectionName, "timeField", qm.TimeField)
+	d.debugLog(ctx, "Using native SDK for collection", "collection", collectionName, "timeField", qm.TimeField)
 
 	// Build native Firestore query with timestamp filtering
 	firestoreQuery := client.Collection(collectionName).
-		Where(qm.TimeField, ">=", timeRange.From).
-		Where(qm.TimeField, "<=", timeRange.To).
+		Where(qm.TimeField, ">=", timeFilterValue(timeRange.From, qm.TimeFormat)).
+		Where(qm.TimeField, "<=", timeFilterValue(timeRange.To, qm.TimeFormat)).
 		OrderBy(qm.TimeField, firestore.Desc)
 
 	// Execute query
-	docs, err := firestoreQuery.Documents(ctx).GetAll()
+	docs, err := d.getAllDocuments(ctx, "native query", firestoreQuery)
 	if err != nil {
-		log.DefaultLogger.Error("Native Firestore query failed", "error", err)
-		return backend.ErrDataResponse(backend.StatusBadRequest, "Native query: "+err.Error())
+		d.logger(ctx).Error("Native Firestore query failed", "error", err)
+		return firestoreErrorResponse("Native query: ", err)
 	}
 
-	log.DefaultLogger.Info("Native query executed successfully", "documents", len(docs))
+	d.debugLog(ctx, "Native query executed successfully", "documents", len(docs))
 
 	// Convert results to Grafana format
-	return d.convertFirestoreDocsToResponse(docs, qm)
+	return d.convertFirestoreDocsToResponse(ctx, docs, qm)
 }
 
 // extractCollectionName extracts collection name from SQL-like query
@@ -547,7 +1109,7 @@ func extractCollectionName(query string) string {
 }
 
 // convertFirestoreDocsToResponse converts Firestore documents to Grafana response format
-func (d *Datasource) convertFirestoreDocsToResponse(docs []*firestore.DocumentSnapshot, qm FirestoreQuery) backend.DataResponse {
+func (d *Datasource) convertFirestoreDocsToResponse(ctx context.Context, docs []*firestore.DocumentSnapshot, qm FirestoreQuery) backend.DataResponse {
 	var response backend.DataResponse
 
 	if len(docs) == 0 {
@@ -558,44 +1120,26 @@ func (d *Datasource) convertFirestoreDocsToResponse(docs []*firestore.DocumentSn
 		return response
 	}
 
-	// Extract all unique field names from documents
-	fieldMap := make(map[string][]interface{})
-
+	rows := make([]map[string]interface{}, 0, len(docs))
 	for _, doc := range docs {
-		docData := doc.Data()
-		for fieldName, value := range docData {
-			if fieldMap[fieldName] == nil {
-				fieldMap[fieldName] = make([]interface{}, 0, len(docs))
-			}
-
-			// Convert timestamp to time.Time for Grafana
-			if fieldName == qm.TimeField {
-				if ts, ok := value.(time.Time); ok {
-					fieldMap[fieldName] = append(fieldMap[fieldName], ts)
-				} else {
-					fieldMap[fieldName] = append(fieldMap[fieldName], value)
-				}
-			} else {
-				fieldMap[fieldName] = append(fieldMap[fieldName], value)
-			}
+		if doc != nil {
+			rows = append(rows, doc.Data())
 		}
 	}
+	fieldNames, fieldMap := alignColumns(rows)
 
 	// Create data frame
 	frame := data.NewFrame("response")
 
-	// Add fields to frame
-	for fieldName, values := range fieldMap {
-		// Ensure all fields have the same length by padding with nil
-		for len(values) < len(docs) {
-			values = append(values, nil)
-		}
+	// Add fields to frame in a stable order
+	for _, fieldName := range fieldNames {
+		values := fieldMap[fieldName]
 
 		if fieldName == qm.TimeField {
 			// Time field
 			timeValues := make([]time.Time, 0, len(values))
 			for _, v := range values {
-				if ts, ok := v.(time.Time); ok {
+				if ts, ok := toTime(v, qm.TimeFormat, nil); ok {
 					timeValues = append(timeValues, ts)
 				} else {
 					timeValues = append(timeValues, time.Time{})
@@ -603,16 +1147,8 @@ func (d *Datasource) convertFirestoreDocsToResponse(docs []*firestore.DocumentSn
 			}
 			frame.Fields = append(frame.Fields, data.NewField(fieldName, nil, timeValues))
 		} else {
-			// Other fields - convert to strings for simplicity
-			stringValues := make([]string, 0, len(values))
-			for _, v := range values {
-				if v != nil {
-					stringValues = append(stringValues, fmt.Sprintf("%v", v))
-				} else {
-					stringValues = append(stringValues, "")
-				}
-			}
-			frame.Fields = append(frame.Fields, data.NewField(fieldName, nil, stringValues))
+			// Other fields - keep the Firestore types
+			frame.Fields = append(frame.Fields, newTypedField(fieldName, values))
 		}
 	}
 
@@ -620,109 +1156,547 @@ func (d *Datasource) convertFirestoreDocsToResponse(docs []*firestore.DocumentSn
 	return response
 }
 
-// executeWithNativeSDKForVariables handles queries with $__from/$__to variables using native Firestore SDK
-func (d *Datasource) executeWithNativeSDKForVariables(ctx context.Context, pCtx backend.PluginContext, qm FirestoreQuery, timeRange backend.TimeRange) backend.DataResponse {
-	log.DefaultLogger.Info("Executing query with Grafana variables using native SDK", "query", qm.Query)
+// executeWithNativeSDKForVariables runs a query planned for the native Firestore SDK
+func (d *Datasource) executeWithNativeSDKForVariables(ctx context.Context, pCtx backend.PluginContext, settings *FirestoreSettings, qm FirestoreQuery, queryInfo *QueryInfo, timeRange backend.TimeRange, meta *queryMeta) backend.DataResponse {
+	d.debugLog(ctx, "Executing query with Grafana variables using native SDK", "query", qm.Query)
 
 	// Create Firestore client
 	client, err := newFirestoreClient(ctx, pCtx)
 	if err != nil {
-		log.DefaultLogger.Error("Failed to create Firestore client", "error", err)
+		d.logger(ctx).Error("Failed to create Firestore client", "error", err)
 		return backend.ErrDataResponse(backend.StatusBadRequest, "Firestore client: "+err.Error())
 	}
 	defer client.Close()
 
-	// Parse the SQL query to extract collection, fields, and additional filters
-	queryInfo, err := parseSQLQueryWithVariables(qm.Query)
+	if err := applyReadTime(client, &qm, meta); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	return d.executeNativeSDKQuery(ctx, client, settings, qm, queryInfo, timeRange, meta)
+}
+
+// executeNativeSDKQuery runs a native query with the client. The collections a wildcard FROM
+// matched, queryInfo.Collections, are each read with the query and their documents merged
+// before they are ordered, limited, grouped and converted, as if they were one collection.
+func (d *Datasource) executeNativeSDKQuery(ctx context.Context, client *firestore.Client, settings *FirestoreSettings, qm FirestoreQuery, queryInfo *QueryInfo, timeRange backend.TimeRange, meta *queryMeta) backend.DataResponse {
+	location, err := settings.location()
 	if err != nil {
-		log.DefaultLogger.Error("Failed to parse SQL query", "error", err, "query", qm.Query)
-		return backend.ErrDataResponse(backend.StatusBadRequest, "Query parsing: "+err.Error())
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
 	}
 
-	log.DefaultLogger.Info("Query parsed successfully", "collection", queryInfo.Collection, "groupByFields", queryInfo.GroupByFields, "aggregateFields", queryInfo.AggregateFields)
-	log.DefaultLogger.Info("Parsed query info", "collection", queryInfo.Collection, "timeField", queryInfo.TimeField, "fields", queryInfo.Fields, "additionalFilters", queryInfo.AdditionalFilters)
+	d.debugLog(ctx, "Query parsed successfully", "collection", queryInfo.Collection, "groupByFields", queryInfo.GroupByFields, "aggregateFields", queryInfo.AggregateFields)
+	for _, condition := range queryInfo.IgnoredConditions {
+		meta.addNotice(data.NoticeSeverityWarning, fmt.Sprintf("WHERE condition %q is not supported and was ignored, results may include unfiltered documents", condition))
+	}
+	d.debugLog(ctx, "Parsed query info", "collection", queryInfo.Collection, "timeField", queryInfo.TimeField, "fields", queryInfo.Fields, "additionalFilters", queryInfo.AdditionalFilters)
 
-	// Build native Firestore query
-	var firestoreQuery firestore.Query = client.Collection(queryInfo.Collection).Query
+	queryInfo.TimeFormat = qm.TimeFormat
+	queryInfo.Location = location
+	queryInfo.Flatten = qm.Flatten
+	queryInfo.GeoFormat = qm.GeoFormat
+	queryInfo.RefFormat = qm.RefFormat
+	queryInfo.MemoryBudget = newMemoryBudget(settings.MemoryBudgetMB)
+	queryInfo.FieldTypes = settings.collectionFieldTypes(queryInfo.Collection)
+	for _, collection := range queryInfo.Collections {
+		maps.Copy(queryInfo.FieldTypes, settings.collectionFieldTypes(collection))
+	}
+	queryInfo.DataplaneLogs = settings.DataplaneLogs
+	if queryInfo.Join != nil {
+		return d.executeJoin(ctx, client, qm, queryInfo, timeRange, meta)
+	}
+	var page *queryPage
+	if qm.PageSize > 0 {
+		page, err = d.queryPageOf(ctx, client, queryInfo, qm, meta)
+		if err != nil {
+			return firestoreErrorResponse("Page token: ", err)
+		}
+	}
 
-	// Add time range filter using the detected time field
+	// Build native Firestore queries, keeping a readable trace of what is pushed down. A
+	// wildcard FROM reads each collection it matched with the same query.
+	collections := []string{queryInfo.Collection}
+	merged := len(queryInfo.Collections) > 0
+	if merged {
+		collections = queryInfo.Collections
+	}
+	collectionQuery := func(collection string) (firestore.Query, []string) {
+		firestoreQuery := client.Collection(collection).Query
+		pushdown := []string{fmt.Sprintf("collection(%s)", collection)}
+		if queryInfo.TimeField != "" {
+			// The time range is compared with the time field as it is stored
+			fromValue, toValue := timeRangeBounds(timeRange, queryInfo.timeFormatOf(queryInfo.TimeField))
+			firestoreQuery = firestoreQuery.Where(queryInfo.TimeField, ">=", fromValue)
+			firestoreQuery = firestoreQuery.Where(queryInfo.TimeField, "<=", toValue)
+			pushdown = append(pushdown,
+				fmt.Sprintf("where(%s >= %s)", queryInfo.TimeField, describeTimeValue(fromValue)),
+				fmt.Sprintf("where(%s <= %s)", queryInfo.TimeField, describeTimeValue(toValue)))
+		}
+		return firestoreQuery, pushdown
+	}
 	if queryInfo.TimeField != "" {
-		firestoreQuery = firestoreQuery.Where(queryInfo.TimeField, ">=", timeRange.From)
-		firestoreQuery = firestoreQuery.Where(queryInfo.TimeField, "<=", timeRange.To)
-		log.DefaultLogger.Info("Added time range filter", "field", queryInfo.TimeField, "from", timeRange.From, "to", timeRange.To)
+		queryInfo.TimeRange = timeRange
+		d.debugLog(ctx, "Added time range filter", "field", queryInfo.TimeField, "from", timeRange.From, "to", timeRange.To)
 	}
 
-	// Add additional WHERE filters (non-time filters)
-	// Skip ALL Firestore WHERE filters to avoid index requirements - we'll filter manually in GROUP BY processing
+	// Filters are pushed to Firestore, metadata pseudo-columns and literals compared with a
+	// field of unknown type are filtered in memory
+	var serverFilters, memoryFilters []FilterInfo
 	for _, filter := range queryInfo.AdditionalFilters {
-		// Apply all filters manually to avoid index requirements
-		log.DefaultLogger.Info("Skipping Firestore filter (will apply manually to avoid index requirements)", "field", filter.Field, "operator", filter.Operator, "value", filter.Value)
+		if queryInfo.filteredInMemory(filter) {
+			memoryFilters = append(memoryFilters, filter)
+		} else {
+			serverFilters = append(serverFilters, filter)
+		}
 	}
+	baseQuery, basePushdown := collectionQuery(collections[0])
+
+	// buildQuery finishes the query of a collection with the server-side filters, or with
+	// every filter applied in memory when Firestore lacks the composite index they need
+	grouped := len(queryInfo.GroupByFields) > 0 || len(queryInfo.AggregateFields) > 0
+	ranked := len(queryInfo.Ranks) > 0
+	orderInMemory, limitInMemory := false, false
+	buildQuery := func(collection string, filtersInMemory bool) (firestore.Query, []string, []string) {
+		firestoreQuery, pushdown := collectionQuery(collection)
+		var inMemory []string
+
+		for _, filter := range queryInfo.AdditionalFilters {
+			if filtersInMemory || queryInfo.filteredInMemory(filter) {
+				inMemory = append(inMemory, fmt.Sprintf("where(%s %s %v)", filter.Field, filter.Operator, filter.Value))
+				continue
+			}
+			firestoreQuery = firestoreQuery.Where(filter.Field, filter.Operator, filter.Value)
+			pushdown = append(pushdown, fmt.Sprintf("where(%s %s %v)", filter.Field, filter.Operator, filter.Value))
+		}
 
-	// Add ordering if specified (but not for GROUP BY queries - ordering is handled post-aggregation)
-	if queryInfo.OrderField != "" && len(queryInfo.GroupByFields) == 0 && len(queryInfo.AggregateFields) == 0 {
-		direction := firestore.Asc
-		if queryInfo.OrderDirection == "DESC" {
-			direction = firestore.Desc
+		// Rows are numbered before they are ordered and limited
+		for _, rank := range queryInfo.Ranks {
+			inMemory = append(inMemory, rank.String())
+		}
+		for _, expression := range queryInfo.Expressions {
+			inMemory = append(inMemory, expression.String())
 		}
-		firestoreQuery = firestoreQuery.OrderBy(queryInfo.OrderField, direction)
-		log.DefaultLogger.Info("Added ordering", "field", queryInfo.OrderField, "direction", queryInfo.OrderDirection)
-	} else if queryInfo.OrderField != "" && (len(queryInfo.GroupByFields) > 0 || len(queryInfo.AggregateFields) > 0) {
-		log.DefaultLogger.Info("Skipping Firestore ORDER BY for GROUP BY query - will be handled post-aggregation", "field", queryInfo.OrderField)
-	}
 
-	// Add limit
-	if queryInfo.Limit > 0 {
-		firestoreQuery = firestoreQuery.Limit(queryInfo.Limit)
-	}
+		// Add ordering if specified (but not for GROUP BY queries - ordering is handled post-aggregation)
+		orderInMemory = ranked
+		if len(queryInfo.OrderBy) > 0 && !grouped {
+			for _, key := range queryInfo.OrderBy {
+				if key.Field == docCreateTimeColumn || key.Field == docUpdateTimeColumn || key.Field == docCollectionColumn {
+					// Firestore can't order by snapshot times or collections, sort the fetched documents instead
+					orderInMemory = true
+				}
+			}
+			for _, key := range queryInfo.OrderBy {
+				trace := fmt.Sprintf("orderBy(%s %s)", key.Field, key.direction())
+				if orderInMemory {
+					inMemory = append(inMemory, trace)
+					continue
+				}
+				direction := firestore.Asc
+				if key.Descending {
+					direction = firestore.Desc
+				}
+				if key.Field == docNameColumn {
+					firestoreQuery = firestoreQuery.OrderBy(firestore.DocumentID, direction)
+				} else {
+					firestoreQuery = firestoreQuery.OrderBy(key.Field, direction)
+				}
+				pushdown = append(pushdown, trace)
+			}
+			d.debugLog(ctx, "Added ordering", "keys", queryInfo.OrderBy)
+		} else if len(queryInfo.OrderBy) > 0 {
+			d.debugLog(ctx, "Skipping Firestore ORDER BY for GROUP BY query - will be handled post-aggregation", "keys", queryInfo.OrderBy)
+		}
 
-	// Execute query
-	docs, err := firestoreQuery.Documents(ctx).GetAll()
-	if err != nil {
-		log.DefaultLogger.Error("Native Firestore query with variables failed", "error", err)
-		return backend.ErrDataResponse(backend.StatusBadRequest, "Native query: "+err.Error())
-	}
+		if grouped {
+			inMemory = append(inMemory, fmt.Sprintf("groupBy(%s)", strings.Join(queryInfo.GroupByFields, ", ")))
+			for _, key := range queryInfo.OrderBy {
+				inMemory = append(inMemory, fmt.Sprintf("orderBy(%s %s)", key.Field, key.direction()))
+			}
+		}
 
-	log.DefaultLogger.Info("Native query with variables executed successfully", "documents", len(docs))
+		if distributionFormat(qm.Format) && !grouped {
+			// Every matching document is counted into the histogram
+			inMemory = append(inMemory, fmt.Sprintf("%s(%s)", qm.Format, qm.HistogramField))
+		}
 
-	// Apply manual filtering for additional WHERE conditions (both GROUP BY and simple queries)
-	if len(queryInfo.AdditionalFilters) > 0 {
-		log.DefaultLogger.Info("APPLYING MANUAL FILTERING FOR ADDITIONAL WHERE CONDITIONS", "totalDocs", len(docs), "additionalFilters", len(queryInfo.AdditionalFilters))
-		docs = d.applyManualFiltering(docs, queryInfo.AdditionalFilters)
-		log.DefaultLogger.Info("MANUAL FILTERING COMPLETE", "remainingDocs", len(docs))
-	}
+		// Add limit, applied after sorting, filtering and grouping when those are done in memory
+		limitInMemory = orderInMemory || grouped || ranked || len(memoryFilters) > 0 || (filtersInMemory && len(serverFilters) > 0)
+		if page != nil {
+			// Pages are only planned for queries Firestore limits
+			firestoreQuery, pushdown = page.apply(firestoreQuery, pushdown)
+		} else if queryInfo.Limit > 0 && limitInMemory {
+			inMemory = append(inMemory, fmt.Sprintf("limit(%d)", queryInfo.Limit))
+		} else if queryInfo.Limit > 0 {
+			firestoreQuery = firestoreQuery.Limit(queryInfo.Limit)
+			pushdown = append(pushdown, fmt.Sprintf("limit(%d)", queryInfo.Limit))
+		} else if len(inMemory) == 0 {
+			// Read one document past maxRows so truncation can be reported
+			firestoreQuery = firestoreQuery.Limit(qm.MaxRows + 1)
+			pushdown = append(pushdown, fmt.Sprintf("limit(%d)", qm.MaxRows+1))
+		}
 
-	// Check if this is a GROUP BY query that needs in-memory aggregation
-	if len(queryInfo.GroupByFields) > 0 || len(queryInfo.AggregateFields) > 0 {
-		log.DefaultLogger.Info("PROCESSING GROUP BY WITH NEW FUNCTION", "groupFields", queryInfo.GroupByFields, "aggregateFields", queryInfo.AggregateFields, "docs", len(docs))
-		for i, field := range queryInfo.AggregateFields {
-			log.DefaultLogger.Info("Aggregate field details", "index", i, "function", field.Function, "field", field.Field, "alias", field.Alias)
+		// Only ship the fields the query reads
+		if fields := projectionFields(queryInfo, qm); fields != nil {
+			firestoreQuery = firestoreQuery.Select(fields...)
+			pushdown = append(pushdown, fmt.Sprintf("select(%s)", strings.Join(fields, ", ")))
+		}
+		return firestoreQuery, pushdown, inMemory
+	}
+
+	// A bare COUNT(*) is counted server-side without downloading the documents, unless
+	// Firestore lacks the index its filters need
+	filteredQuery, filteredTrace := baseQuery, basePushdown
+	for _, filter := range serverFilters {
+		filteredQuery = filteredQuery.Where(filter.Field, filter.Operator, filter.Value)
+		filteredTrace = append(filteredTrace, fmt.Sprintf("where(%s %s %v)", filter.Field, filter.Operator, filter.Value))
+	}
+	if isCountOnly(queryInfo, qm) && !merged {
+		response, err := d.countDocuments(ctx, filteredQuery, queryInfo.AggregateFields[0], meta)
+		if _, missing := missingIndexURL(err); !missing {
+			meta.executedQuery = describeNativeQuery(qm.Query, timeRange, append(filteredTrace, "count(*)"), nil)
+			if err != nil {
+				d.logger(ctx).Error("Count aggregation failed", "collection", queryInfo.Collection, "error", err)
+				return firestoreErrorResponse("Count aggregation: ", err)
+			}
+			return response
+		}
+		d.debugLog(ctx, "Missing composite index for the count, counting in memory", "error", err)
+	}
+
+	// A GROUP BY on a field with known values is aggregated server-side, one query per value
+	if fanOutEligible(queryInfo, qm) && !merged {
+		if groupValues := d.fanOutGroupValues(ctx, queryInfo, qm); len(groupValues) > 0 {
+			response, complete, err := d.fanOutAggregation(ctx, filteredQuery, queryInfo, qm, groupValues, meta)
+			if err == nil && complete {
+				meta.stats.Groups += responseRows(response)
+				trace := append(filteredTrace, fmt.Sprintf("aggregate per %s in (%d values)", queryInfo.GroupByFields[0], len(groupValues)))
+				meta.executedQuery = describeNativeQuery(qm.Query, timeRange, trace, nil)
+				return response
+			}
+			d.debugLog(ctx, "Server-side group aggregation incomplete, aggregating in memory", "groups", len(groupValues), "error", err)
 		}
-		return d.processGroupByQueryWithOrdering(docs, queryInfo)
 	}
 
-	// Convert results to Grafana format
-	return d.convertFirestoreDocsToResponseWithFields(docs, queryInfo)
-}
+	// Execute query, reading the documents of every collection into one result
+	var (
+		docs      []*firestore.DocumentSnapshot
+		traces    []string
+		explained data.Frames
+	)
+	decoded := decodedDocuments{}
+	started := time.Now()
+	for _, collection := range collections {
+		firestoreQuery, pushdown, inMemory := buildQuery(collection, false)
+		if qm.Explain {
+			traces = append(traces, describeNativeQuery(qm.Query, timeRange, pushdown, inMemory))
+			response := d.explainQuery(ctx, firestoreQuery, pushdown, inMemory, meta)
+			if response.Error != nil {
+				return response
+			}
+			explained = append(explained, response.Frames...)
+			continue
+		}
+
+		var collectionDocs []*firestore.DocumentSnapshot
+		var collectionDecoded decodedDocuments
+		collectionDocs, collectionDecoded, err = d.readDocuments(ctx, "native query", firestoreQuery, queryInfo.MemoryBudget, meta)
+		if indexURL, missing := missingIndexURL(err); missing && len(serverFilters) > 0 && page == nil {
+			d.debugLog(ctx, "Missing composite index, filtering in memory", "collection", collection, "error", err)
+			meta.addNotice(data.NoticeSeverityWarning, missingIndexNotice(indexURL))
+			firestoreQuery, pushdown, inMemory = buildQuery(collection, true)
+			memoryFilters = queryInfo.AdditionalFilters
+			collectionDocs, collectionDecoded, err = d.readDocuments(ctx, "native query", firestoreQuery, queryInfo.MemoryBudget, meta)
+		}
+		traces = append(traces, describeNativeQuery(qm.Query, timeRange, pushdown, inMemory))
+		if err != nil {
+			d.logger(ctx).Error("Native Firestore query failed", "collection", collection, "error", err)
+			break
+		}
+		docs = append(docs, collectionDocs...)
+		maps.Copy(decoded, collectionDecoded)
+	}
+	meta.executedQuery = strings.Join(traces, "\n")
+	if merged {
+		meta.executedQuery += "\n-- merged in memory: " + strings.Join(mergeTrace(queryInfo, collections), ".")
+	}
+	if qm.Explain {
+		return backend.DataResponse{Frames: explained}
+	}
+	if errors.Is(err, errBudgetExceeded) {
+		return budgetExceededResponse(err)
+	}
+	if err != nil {
+		return firestoreErrorResponse("Native query: ", err)
+	}
+
+	d.debugLog(ctx, "Native query with variables executed successfully", "documents", len(docs))
+	meta.addFetch(started, len(docs)+meta.undecodable)
+	meta.addDocumentsRead(routeNative, len(docs)+meta.undecodable)
+	queryInfo.Decoded = decoded
+
+	// Apply manual filtering for the WHERE conditions Firestore didn't evaluate
+	if len(memoryFilters) > 0 {
+		d.debugLog(ctx, "APPLYING MANUAL FILTERING FOR ADDITIONAL WHERE CONDITIONS", "totalDocs", len(docs), "additionalFilters", len(memoryFilters))
+		docs, err = d.applyManualFiltering(ctx, docs, memoryFilters)
+		if err != nil {
+			return firestoreErrorResponse("Native query: ", err)
+		}
+		d.debugLog(ctx, "MANUAL FILTERING COMPLETE", "remainingDocs", len(docs))
+	}
+	meta.addFiltered(len(docs))
+	if qm.TotalCount {
+		limited := queryInfo.Limit > 0 || page != nil || len(docs) > qm.MaxRows
+		d.reportTotalCount(ctx, filteredQuery, limited, len(docs), meta)
+	}
+
+	if ranked {
+		return d.rankDocuments(ctx, docs, queryInfo, qm, meta)
+	}
+	// The documents of several collections are ordered and limited again once merged
+	if orderInMemory || (merged && !grouped && len(queryInfo.OrderBy) > 0) {
+		sortDocuments(docs, queryInfo.OrderBy)
+	}
+	if (limitInMemory || merged) && !grouped && queryInfo.Limit > 0 && len(docs) > queryInfo.Limit {
+		docs = docs[:queryInfo.Limit]
+	}
+
+	// Check if this is a GROUP BY query that needs in-memory aggregation
+	if grouped {
+		setPanelTimeBucket(queryInfo, qm)
+		d.debugLog(ctx, "PROCESSING GROUP BY WITH NEW FUNCTION", "groupFields", queryInfo.GroupByFields, "aggregateFields", queryInfo.AggregateFields, "docs", len(docs))
+		for i, field := range queryInfo.AggregateFields {
+			d.debugLog(ctx, "Aggregate field details", "index", i, "function", field.Function, "field", field.Field, "alias", field.Alias)
+		}
+		response := d.processGroupByQueryWithOrdering(ctx, docs, queryInfo, qm)
+		meta.stats.Groups += responseRows(response)
+		return response
+	}
+
+	switch qm.Format {
+	case formatHistogram:
+		return convertFirestoreDocsToHistogramResponse(docs, qm)
+	case formatHeatmap:
+		return convertFirestoreDocsToHeatmapResponse(docs, queryInfo, qm)
+	}
+
+	if page != nil {
+		docs = page.trim(docs, meta)
+	}
+	docs = docs[:meta.applyMaxRows(len(docs), qm.MaxRows)]
+
+	if qm.Format == formatLogs {
+		return d.convertFirestoreDocsToLogsResponse(ctx, docs, queryInfo, qm)
+	}
+
+	// Convert results to Grafana format
+	var schema *collectionSchema
+	if len(docs) == 0 && !merged {
+		schema = d.collectionSchema(ctx, queryInfo.Collection)
+	}
+	return d.convertFirestoreDocsToResponseWithFields(ctx, docs, schema, queryInfo)
+}
+
+// setPanelTimeBucket buckets the time field of a grouped query by the panel interval for
+// time series output, unless the query truncates it with date_trunc. The buckets follow
+// the wall clock of the datasource timezone, so daily buckets start at local midnight.
+func setPanelTimeBucket(queryInfo *QueryInfo, qm FirestoreQuery) {
+	if qm.Format == formatTimeSeries && qm.IntervalMs > 0 && queryInfo.TimeBucketField == "" {
+		timeField := queryInfo.TimeField
+		if timeField == "" {
+			timeField = qm.TimeField
+		}
+		queryInfo.TimeBucketField = timeField
+		queryInfo.TimeBucket = time.Duration(qm.IntervalMs) * time.Millisecond
+		if queryInfo.Location != nil && queryInfo.Location != time.UTC {
+			queryInfo.TimeBucketLocation = queryInfo.Location
+		}
+	}
+}
+
+// projectionFields returns the document fields read by the query, or nil when it needs
+// whole documents (SELECT *). Besides the selected fields this includes the fields used
+// by the in-memory filters, ordering, grouping and the logs format.
+func projectionFields(queryInfo *QueryInfo, qm FirestoreQuery) []string {
+	var fields []string
+	seen := make(map[string]bool)
+	add := func(field string) {
+		if field != "" && field != "*" && !isMetadataColumn(field) && !queryInfo.isComputed(field) && !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+
+	if len(queryInfo.Fields) == 0 && len(queryInfo.AggregateFields) == 0 {
+		return nil
+	}
+	for _, field := range queryInfo.Fields {
+		if field == "*" {
+			return nil
+		}
+		add(field)
+	}
+	add(queryInfo.TimeField)
+	add(qm.TimeField)
+	if distributionFormat(qm.Format) {
+		add(qm.HistogramField)
+	}
+	if qm.Format == formatLogs {
+		if qm.LogMessageField != "" {
+			add(qm.LogMessageField)
+		} else {
+			add(defaultLogMessageField)
+		}
+		add(qm.LogLevelField)
+		for _, field := range qm.LogLabelFields {
+			add(field)
+		}
+	}
+	for _, field := range queryInfo.GroupByFields {
+		add(field)
+	}
+	for _, aggField := range queryInfo.AggregateFields {
+		if aggField.Window != nil {
+			continue
+		}
+		// Aggregates through arrays read the whole array
+		arrayPath, _, _ := strings.Cut(aggField.Field, "[]")
+		add(arrayPath)
+	}
+	for _, filter := range queryInfo.AdditionalFilters {
+		add(filter.Field)
+	}
+	for _, rank := range queryInfo.Ranks {
+		for _, field := range rank.fields() {
+			add(field)
+		}
+	}
+	for _, expression := range queryInfo.Expressions {
+		add(expression.Condition.Field)
+	}
+	// Grouped queries order by their output columns, not document fields
+	if len(queryInfo.GroupByFields) == 0 && len(queryInfo.AggregateFields) == 0 {
+		for _, key := range queryInfo.OrderBy {
+			add(key.Field)
+		}
+	}
+
+	// Paths Firestore can't parse as dot-separated field paths are read in full
+	for _, field := range fields {
+		if strings.ContainsAny(field, "~*/[]") {
+			return nil
+		}
+	}
+	return fields
+}
+
+// describeNativeQuery renders the query as executed by the native SDK: the SQL with the
+// time variables expanded, followed by the operations pushed to Firestore and those done in memory
+func describeNativeQuery(query string, timeRange backend.TimeRange, pushdown, inMemory []string) string {
+	expanded := strings.NewReplacer(
+		"$__from", "'"+timeRange.From.UTC().Format(time.RFC3339Nano)+"'",
+		"$__to", "'"+timeRange.To.UTC().Format(time.RFC3339Nano)+"'",
+	).Replace(query)
+
+	lines := []string{expanded, "-- native SDK: " + strings.Join(pushdown, ".")}
+	if len(inMemory) > 0 {
+		lines = append(lines, "-- in memory: "+strings.Join(inMemory, "."))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// describeTimeValue formats a time filter value for ExecutedQueryString
+func describeTimeValue(value interface{}) string {
+	if ts, ok := value.(time.Time); ok {
+		return ts.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%v", value)
+}
 
 // QueryInfo holds parsed SQL query information
 type QueryInfo struct {
 	Collection        string
+
+	// Collections are the collections a wildcard FROM matched, read as one collection
+	Collections []string
+
 	Fields           []string
 	TimeField        string
+	TimeFormat       string
+
+	// TimeFieldFormat is how TimeField is stored when it differs from the query's time
+	// format, such as a detected field of date strings
+	TimeFieldFormat string
+	Location         *time.Location
 	AdditionalFilters []FilterInfo
-	OrderField       string
-	OrderDirection   string
+	OrderBy          []OrderKey
 	Limit            int
 	GroupByFields    []string
 	AggregateFields  []AggregateInfo
+
+	// TimeBucketField is the GROUP BY field truncated to TimeBucket intervals
+	TimeBucketField  string
+	TimeBucket       time.Duration
+
+	// TimeBucketUnit is the date_trunc unit, buckets of the calendar units week, month,
+	// quarter and year vary in length
+	TimeBucketUnit string
+
+	// TimeBucketLocation is the timezone whose wall clock the time buckets follow, nil is UTC
+	TimeBucketLocation *time.Location
+
+	// TimeRange is the panel time range filtering TimeField, zero when the query isn't filtered
+	TimeRange backend.TimeRange
+
+	// IgnoredConditions are WHERE conditions that couldn't be parsed into filters
+	IgnoredConditions []string
+
+	// Flatten expands nested maps into dot-notation columns
+	Flatten bool
+
+	// GeoFormat is the representation of GeoPoint values
+	GeoFormat string
+
+	// RefFormat is the representation of DocumentRef values
+	RefFormat string
+
+	// FieldTypes are the type overrides of the columns of the collection from the fieldTypes setting
+	FieldTypes map[string]string
+
+	// DataplaneLogs names and types log frames as dataplane log lines, from the dataplaneLogs setting
+	DataplaneLogs bool
+
+	// MemoryBudget tracks the memory accumulated while building frames, nil is unlimited
+	MemoryBudget *memoryBudget
+
+	// FieldKinds are the types the schema of the collection found its fields with, for the
+	// comparisons with number and boolean literals Firestore can evaluate
+	FieldKinds map[string]valueKind
+
+	// Decoded holds the fields of the documents read, decoded once for the rows of the frame
+	Decoded decodedDocuments
+
+	// Join is the collection joined to Collection, nil without a JOIN
+	Join *joinSpec
+
+	// Subquery is the query in parentheses the query reads FROM, nil when it reads a collection
+	Subquery *QueryInfo
+
+	// Ranks are the ROW_NUMBER and RANK columns of the query, also listed in Fields
+	Ranks []rankFunction
+
+	// Expressions are the boolean columns computed from a comparison, like amount > 0 AS
+	// has_amount, also listed in Fields
+	Expressions []selectExpression
 }
 
 // AggregateInfo holds information about aggregate functions
 type AggregateInfo struct {
-	Function string // COUNT, SUM, AVG, etc.
-	Field    string // field to aggregate on, "*" for COUNT(*)
-	Alias    string // alias name (e.g., "total" in COUNT(*) as total)
+	Function string       // COUNT, SUM, AVG, etc.
+	Field    string       // field to aggregate on, "*" for COUNT(*)
+	Alias    string       // alias name (e.g., "total" in COUNT(*) as total)
+	Window   *windowFrame // window of a window function such as MOVING_AVG, Field names its source aggregate
+
+	Distinct  bool   // STRING_AGG(DISTINCT field) joins each value once
+	Separator string // separator of the values joined by STRING_AGG
 }
 
 // FilterInfo holds WHERE clause filter information
@@ -732,12 +1706,48 @@ type FilterInfo struct {
 	Value    interface{}
 }
 
+// nativeQueryInfo returns the query model of a builder query, or parses the SQL query with
+// its parameters bound. The time range filters the time field only when the panel has one.
+func nativeQueryInfo(qm FirestoreQuery, timeRange backend.TimeRange) (*QueryInfo, error) {
+	if qm.Builder != nil {
+		return qm.Builder.queryInfo(qm.TimeField, timeRange)
+	}
+	query, err := bindParams(qm.Query, qm.Params, nativeParam)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseSQLQueryWithVariables(query)
+	if err == nil {
+		bindFilterParams(info, qm.Params)
+	}
+	if err == nil && (timeRange.From.IsZero() || timeRange.To.IsZero()) {
+		for query := info; query != nil; query = query.Subquery {
+			query.TimeField = ""
+		}
+	}
+	return info, err
+}
+
 // parseSQLQueryWithVariables parses SQL queries that contain $__from/$__to variables
 func parseSQLQueryWithVariables(query string) (*QueryInfo, error) {
+	query, err := expandCTEs(query)
+	if err != nil {
+		return nil, err
+	}
+	subquery, query, err := parseSubquery(query)
+	if err != nil {
+		return nil, err
+	}
+	join, query, err := parseJoin(query)
+	if err != nil {
+		return nil, err
+	}
+	if subquery != nil && join != nil {
+		return nil, fmt.Errorf("a subquery can't be joined")
+	}
 	queryLower := strings.ToLower(strings.TrimSpace(query))
 	queryOriginal := strings.TrimSpace(query)
 
-	log.DefaultLogger.Error("STARTING PARSE", "query", query)
 
 	info := &QueryInfo{
 		Fields: []string{},
@@ -756,18 +1766,20 @@ func parseSQLQueryWithVariables(query string) (*QueryInfo, error) {
 
 	// Parse fields using the new aggregate parser
 	fieldsStr := strings.TrimSpace(queryOriginal[selectIdx+7 : fromIdx])
-	log.DefaultLogger.Error("ABOUT TO PARSE FIELDS", "fieldsStr", fieldsStr)
-	parseAggregateFields(fieldsStr, info)
-	log.DefaultLogger.Error("AFTER PARSING FIELDS", "regularFields", info.Fields, "aggregateFields", info.AggregateFields)
+	if err := parseAggregateFields(fieldsStr, info); err != nil {
+		return nil, err
+	}
 
 	// Extract collection name
 	whereIdx := strings.Index(queryLower, " where ")
 	groupIdx := findGroupByIndex(queryLower)
-	orderIdx := strings.Index(queryLower, " order by ")
+	orderIdx := strings.Index(queryLower[fromIdx:], " order by ")
+	if orderIdx != -1 {
+		// An ORDER BY before FROM belongs to a window function
+		orderIdx += fromIdx
+	}
 	limitIdx := findLimitIndex(queryLower)
 
-	log.DefaultLogger.Info("SQL PARSING INDEXES", "whereIdx", whereIdx, "groupIdx", groupIdx, "orderIdx", orderIdx, "limitIdx", limitIdx)
-	log.DefaultLogger.Info("QUERY FOR PARSING", "originalQuery", queryOriginal)
 
 	endIdx := len(queryOriginal)
 	if whereIdx != -1 {
@@ -800,12 +1812,7 @@ func parseSQLQueryWithVariables(query string) (*QueryInfo, error) {
 		}
 
 		whereClause := strings.TrimSpace(queryOriginal[whereIdx+7 : whereEndIdx])
-		log.DefaultLogger.Info("PARSING WHERE CLAUSE", "whereClause", whereClause)
 		parseWhereClause(whereClause, info)
-		log.DefaultLogger.Info("PARSED FILTERS", "additionalFilters", len(info.AdditionalFilters), "timeField", info.TimeField)
-		for i, filter := range info.AdditionalFilters {
-			log.DefaultLogger.Info("FILTER DETAILS", "index", i, "field", filter.Field, "operator", filter.Operator, "value", filter.Value)
-		}
 	}
 
 	// Parse GROUP BY
@@ -821,10 +1828,10 @@ func parseSQLQueryWithVariables(query string) (*QueryInfo, error) {
 			groupEndIdx = limitIdx
 		}
 
-		log.DefaultLogger.Info("GROUP BY PARSING", "groupIdx", groupIdx, "groupStartIdx", groupStartIdx, "groupEndIdx", groupEndIdx, "orderIdx", orderIdx, "limitIdx", limitIdx)
 		groupClause := strings.TrimSpace(queryOriginal[groupStartIdx : groupEndIdx])
-		log.DefaultLogger.Info("GROUP BY CLAUSE EXTRACTED", "groupClause", groupClause)
-		parseGroupBy(groupClause, info)
+		if err := parseGroupBy(groupClause, info); err != nil {
+			return nil, err
+		}
 	}
 
 	// Parse ORDER BY
@@ -845,7 +1852,23 @@ func parseSQLQueryWithVariables(query string) (*QueryInfo, error) {
 		}
 	}
 
-	log.DefaultLogger.Info("PARSE COMPLETE", "groupByFields", info.GroupByFields, "aggregateFields", info.AggregateFields, "regularFields", info.Fields)
+	if err := validateWindowFunctions(info); err != nil {
+		return nil, err
+	}
+	if err := validateRanks(info); err != nil {
+		return nil, err
+	}
+	if err := validateExpressions(info); err != nil {
+		return nil, err
+	}
+	if join != nil {
+		info.Join = join
+		if err := validateJoinColumns(info); err != nil {
+			return nil, err
+		}
+	}
+	info.Subquery = subquery
+
 	return info, nil
 }
 
@@ -865,106 +1888,237 @@ func parseWhereClause(whereClause string, info *QueryInfo) {
 		}
 	}
 
-	// Parse other WHERE conditions (non-time filters)
-	// Simple parsing for equality conditions like: field = 'value' or field == "value"
-	conditions := strings.Split(whereClause, " AND ")
-	log.DefaultLogger.Info("PARSING WHERE CONDITIONS", "whereClause", whereClause, "splitConditions", conditions)
-	for i, condition := range conditions {
+	// Parse other WHERE conditions (non-time filters) like "msisdn = '633525465'",
+	// "clientData.BrandCliente == \"yoigo\"" or "amount>=10"
+	conditions := splitConditions(whereClause)
+	for _, condition := range conditions {
 		condition = strings.TrimSpace(condition)
-		log.DefaultLogger.Info("PROCESSING CONDITION", "index", i, "condition", condition)
-		if !strings.Contains(condition, "$__from") && !strings.Contains(condition, "$__to") {
-			// Parse condition like "msisdn = '633525465'" or "clientData.BrandCliente == \"yoigo\"" or "msisdn==\"681021597\""
-			if strings.Contains(condition, "==") {
-				// Handle both "field == value" and "field==\"value\""
-				var parts []string
-				if strings.Contains(condition, " == ") {
-					parts = strings.SplitN(condition, " == ", 2)
-				} else {
-					parts = strings.SplitN(condition, "==", 2)
-				}
-				log.DefaultLogger.Info("FOUND == OPERATOR", "parts", parts)
-				if len(parts) == 2 {
-					field := strings.TrimSpace(parts[0])
-					value := strings.Trim(strings.TrimSpace(parts[1]), "'\"")
-					log.DefaultLogger.Info("ADDING FILTER WITH ==", "field", field, "value", value)
-					info.AdditionalFilters = append(info.AdditionalFilters, FilterInfo{
-						Field:    field,
-						Operator: "==",
-						Value:    value,
-					})
-				}
-			} else if strings.Contains(condition, "=") {
-				// Handle both "field = value" and "field=value"
-				var parts []string
-				if strings.Contains(condition, " = ") {
-					parts = strings.SplitN(condition, " = ", 2)
-				} else {
-					parts = strings.SplitN(condition, "=", 2)
-				}
-				log.DefaultLogger.Info("FOUND = OPERATOR", "parts", parts)
-				if len(parts) == 2 {
-					field := strings.TrimSpace(parts[0])
-					value := strings.Trim(strings.TrimSpace(parts[1]), "'\"")
-					log.DefaultLogger.Info("ADDING FILTER WITH =", "field", field, "value", value)
-					info.AdditionalFilters = append(info.AdditionalFilters, FilterInfo{
-						Field:    field,
-						Operator: "==",
-						Value:    value,
-					})
-				}
-			} else {
-				log.DefaultLogger.Info("NO OPERATOR FOUND IN CONDITION", "condition", condition)
-			}
-		} else {
-			log.DefaultLogger.Info("SKIPPING TIME CONDITION", "condition", condition)
+		if strings.Contains(condition, "$__from") || strings.Contains(condition, "$__to") {
+			continue
 		}
+		filter, ok := parseCondition(condition)
+		if !ok {
+			info.IgnoredConditions = append(info.IgnoredConditions, condition)
+			continue
+		}
+		info.AdditionalFilters = append(info.AdditionalFilters, filter)
 	}
 }
 
-// parseGroupBy parses GROUP BY clause
-func parseGroupBy(groupClause string, info *QueryInfo) {
-	fields := strings.Split(groupClause, ",")
+// conditionPattern matches a comparison of a field with a literal
+var conditionPattern = regexp.MustCompile(`^(.+?)\s*(==|!=|<>|<=|>=|=|<|>)\s*(.+)$`)
+
+// parseCondition parses a WHERE comparison of a field path with a literal into a filter
+func parseCondition(condition string) (FilterInfo, bool) {
+	match := conditionPattern.FindStringSubmatch(condition)
+	if match == nil {
+		return FilterInfo{}, false
+	}
+	field := cleanBackticks(match[1])
+	if !fieldPathPattern.MatchString(field) {
+		return FilterInfo{}, false
+	}
+	operator := match[2]
+	switch operator {
+	case "=":
+		operator = "=="
+	case "<>":
+		operator = "!="
+	}
+	return FilterInfo{Field: field, Operator: operator, Value: filterLiteral(match[3])}, true
+}
+
+// filterLiteral converts a WHERE literal to the value compared against documents: quoted
+// literals are strings, unquoted ones are parsed as booleans and numbers when possible
+func filterLiteral(literal string) interface{} {
+	literal = strings.TrimSpace(literal)
+	if len(literal) >= 2 && (literal[0] == '\'' || literal[0] == '"') && literal[len(literal)-1] == literal[0] {
+		return literal[1 : len(literal)-1]
+	}
+	literal = strings.Trim(literal, "'\"")
+	if literal == "true" || literal == "false" {
+		return literal == "true"
+	}
+	if n, err := strconv.ParseInt(literal, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(literal, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return f
+	}
+	return literal
+}
+
+// parseGroupBy parses GROUP BY clause. A date_trunc of a field groups on the field
+// truncated to the time bucket.
+func parseGroupBy(groupClause string, info *QueryInfo) error {
+	fields := splitSQLList(groupClause)
 	for _, field := range fields {
 		field = strings.TrimSpace(field)
-		if field != "" {
-			// Clean backticks from field names
-			cleanField := cleanBackticks(field)
-			info.GroupByFields = append(info.GroupByFields, cleanField)
+		if field == "" {
+			continue
+		}
+		trunc, isBucket, err := parseDateTrunc(field)
+		if err != nil {
+			return err
+		}
+		if isBucket {
+			if info.TimeBucketField != "" {
+				return fmt.Errorf("GROUP BY supports a single date_trunc, got %s", field)
+			}
+			info.TimeBucketField = trunc.Field
+			info.TimeBucket = trunc.Bucket
+			info.TimeBucketUnit = trunc.Unit
+			info.TimeBucketLocation = trunc.Location
+			info.GroupByFields = append(info.GroupByFields, trunc.Field)
+			continue
+		}
+		// Clean backticks from field names
+		cleanField := cleanBackticks(field)
+		info.GroupByFields = append(info.GroupByFields, cleanField)
+	}
+	return nil
+}
+
+// splitSQLList splits a comma-separated SQL list, leaving the commas inside parentheses
+// and string literals, like those of date_trunc('hour', ts)
+func splitSQLList(list string) []string {
+	var parts []string
+	depth, start := 0, 0
+	var quote rune
+	for i, r := range list {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, list[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, list[start:])
+}
+
+// splitConditions splits a WHERE clause on its AND operators, in any case, leaving the ANDs
+// inside quoted literals and parentheses in their condition
+func splitConditions(clause string) []string {
+	var conditions []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(clause); i++ {
+		c := clause[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && i > 0 && i+4 < len(clause) && isSpace(clause[i-1]) && strings.EqualFold(clause[i:i+3], "AND") && isSpace(clause[i+3]):
+			conditions = append(conditions, clause[start:i])
+			start = i + 3
+			i += 2
 		}
 	}
+	return append(conditions, clause[start:])
+}
+
+// isSpace reports whether a byte of a query is whitespace
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
 }
 
-// cleanBackticks removes backticks from field names
+// cleanBackticks removes backticks from field names, including quoted path
+// segments like `clientData`.`BrandCliente`
 func cleanBackticks(field string) string {
-	return strings.Trim(strings.TrimSpace(field), "`")
+	return strings.ReplaceAll(strings.TrimSpace(field), "`", "")
+}
+
+// selectFieldValue resolves a selected field in the document data. A top-level key
+// matching the whole name wins; otherwise dotted names are resolved as nested paths.
+func selectFieldValue(docData map[string]interface{}, fieldName string) interface{} {
+	if value, exists := docData[fieldName]; exists {
+		return value
+	}
+	if strings.Contains(fieldName, ".") {
+		return getNestedFieldValue(docData, fieldName)
+	}
+	return nil
 }
 
 // parseAggregateFields parses SELECT fields to identify aggregate functions
-func parseAggregateFields(fieldsStr string, info *QueryInfo) {
-	fields := strings.Split(fieldsStr, ",")
+func parseAggregateFields(fieldsStr string, info *QueryInfo) error {
+	fields := splitSQLList(fieldsStr)
 	info.Fields = []string{}
 	info.AggregateFields = []AggregateInfo{}
 
-	log.DefaultLogger.Error("PARSING FIELDS", "fieldsStr", fieldsStr, "splitFields", fields)
 
 	for _, field := range fields {
 		field = strings.TrimSpace(field)
-		log.DefaultLogger.Info("PROCESSING FIELD", "field", field)
 
 		if field == "*" {
 			info.Fields = append(info.Fields, "*")
 			continue
 		}
 
+		// A selected date_trunc is the time bucket column of the GROUP BY
+		if _, isBucket, _ := parseDateTrunc(stripAlias(field)); isBucket {
+			continue
+		}
+
+		// Rows numbered per partition, like ROW_NUMBER() OVER (PARTITION BY msisdn ORDER BY ts DESC)
+		if rank, isRank, err := parseRankFunction(field); isRank {
+			if err != nil {
+				return err
+			}
+			info.Ranks = append(info.Ranks, rank)
+			info.Fields = append(info.Fields, rank.Alias)
+			continue
+		}
+
+		// Values of a field joined into one string, like STRING_AGG(DISTINCT code, ', ')
+		if stringAgg, isStringAgg, err := parseStringAgg(field); isStringAgg {
+			if err != nil {
+				return err
+			}
+			info.AggregateFields = append(info.AggregateFields, stringAgg)
+			continue
+		}
+
+		// Boolean columns computed from a comparison, like amount > 0 AS has_amount
+		if expression, isExpression := parseSelectExpression(field); isExpression {
+			info.Expressions = append(info.Expressions, expression)
+			info.Fields = append(info.Fields, expression.Alias)
+			continue
+		}
+
+		// Window functions over the aggregates, like MOVING_AVG(total, 5)
+		if window, isWindow, err := parseWindowFunction(field); isWindow {
+			if err != nil {
+				return err
+			}
+			info.AggregateFields = append(info.AggregateFields, window)
+			continue
+		}
+
 		// Check for aggregate functions like COUNT(*), SUM(field), AVG(field)
 		upperField := strings.ToUpper(field)
-		log.DefaultLogger.Info("CHECKING AGGREGATE", "field", field, "upperField", upperField)
 
 		if strings.Contains(upperField, "COUNT(") || strings.Contains(upperField, "SUM(") ||
 		   strings.Contains(upperField, "AVG(") || strings.Contains(upperField, "MIN(") ||
-		   strings.Contains(upperField, "MAX(") {
+		   strings.Contains(upperField, "MAX(") || strings.HasPrefix(upperField, "RATE(") ||
+		   strings.HasPrefix(upperField, "DELTA(") {
 
-			log.DefaultLogger.Info("DETECTED AGGREGATE FUNCTION", "field", field)
 
 			// Parse aggregate function
 			var funcName, fieldName, alias string
@@ -980,13 +2134,21 @@ func parseAggregateFields(fieldsStr string, info *QueryInfo) {
 				funcName = "MIN"
 			} else if strings.HasPrefix(upperField, "MAX(") {
 				funcName = "MAX"
+			} else if strings.HasPrefix(upperField, "RATE(") {
+				funcName = "RATE"
+			} else if strings.HasPrefix(upperField, "DELTA(") {
+				funcName = "DELTA"
 			}
 
 			// Extract field name from function
 			start := strings.Index(field, "(")
 			end := strings.Index(field, ")")
 			if start != -1 && end != -1 && end > start {
-				fieldName = strings.TrimSpace(field[start+1:end])
+				fieldName = cleanBackticks(field[start+1 : end])
+			}
+			// COUNT(1) counts every document like COUNT(*)
+			if _, err := strconv.Atoi(fieldName); err == nil && funcName == "COUNT" {
+				fieldName = "*"
 			}
 
 			// Check for alias (AS keyword) - case insensitive search but preserve original case
@@ -1012,22 +2174,15 @@ func parseAggregateFields(fieldsStr string, info *QueryInfo) {
 		} else {
 			// Regular field (non-aggregate) - clean backticks
 			cleanField := cleanBackticks(field)
-			log.DefaultLogger.Info("REGULAR FIELD", "field", field, "cleanField", cleanField)
 			info.Fields = append(info.Fields, cleanField)
 		}
 	}
+	return nil
 }
 
 // parseOrderBy parses ORDER BY clause
 func parseOrderBy(orderClause string, info *QueryInfo) {
-	parts := strings.Fields(orderClause)
-	if len(parts) >= 1 {
-		info.OrderField = parts[0]
-		info.OrderDirection = "ASC"
-		if len(parts) >= 2 && strings.ToUpper(parts[1]) == "DESC" {
-			info.OrderDirection = "DESC"
-		}
-	}
+	info.OrderBy = parseOrderKeys(orderClause)
 }
 
 // parseLimit parses LIMIT clause
@@ -1039,31 +2194,93 @@ func parseLimit(limitStr string) (int, error) {
 	return 0, fmt.Errorf("invalid limit")
 }
 
-// convertFirestoreDocsToResponseWithFields converts docs to Grafana format with specific fields
-func (d *Datasource) convertFirestoreDocsToResponseWithFields(docs []*firestore.DocumentSnapshot, queryInfo *QueryInfo) backend.DataResponse {
+// convertFirestoreDocsToResponseWithFields converts docs to Grafana format with specific fields.
+// When no documents match, the frame keeps the requested columns with the types of the
+// collection schema so panels keep their layout between refreshes.
+func (d *Datasource) convertFirestoreDocsToResponseWithFields(ctx context.Context, docs []*firestore.DocumentSnapshot, schema *collectionSchema, queryInfo *QueryInfo) backend.DataResponse {
 	var response backend.DataResponse
 
+	// * alongside other columns, as in SELECT __collection__, *, stands for the fields not
+	// selected explicitly
+	selectAlso := len(queryInfo.Fields) > 1 && slices.Contains(queryInfo.Fields, "*")
 	if len(docs) == 0 {
-		// Return empty frame with requested fields using proper data types
-		frame := data.NewFrame("response")
-		for _, field := range queryInfo.Fields {
-			if field == "*" {
-				frame.Fields = append(frame.Fields, data.NewField("no_data", nil, []string{}))
-				break
-			}
-			// Create properly typed empty arrays based on field type
-			if field == queryInfo.TimeField {
-				// Time field - use empty time.Time array
-				frame.Fields = append(frame.Fields, data.NewField(field, nil, []time.Time{}))
-			} else {
-				// Other fields - use empty string array
-				frame.Fields = append(frame.Fields, data.NewField(field, nil, []string{}))
-			}
+		if selectAlso {
+			queryInfo.Fields = expandStar(queryInfo.Fields, schema.topLevelFields())
 		}
-		response.Frames = append(response.Frames, frame)
+		response.Frames = append(response.Frames, emptySchemaFrame(queryInfo, schema))
 		return response
 	}
 
+	rows, hasGeoPoints, err := d.documentRows(ctx, docs, queryInfo)
+	if isCanceled(err) {
+		return firestoreErrorResponse("", err)
+	}
+	if err != nil {
+		return budgetExceededResponse(err)
+	}
+	if selectAlso {
+		queryInfo.Fields = expandStar(queryInfo.Fields, documentColumns(rows))
+	}
+	return rowsResponse(rows, queryInfo, hasGeoPoints)
+}
+
+// documentRows reads the document data into rows, flattening nested maps into dot-notation
+// leaf paths when requested, with the metadata pseudo-columns the query reads. It reports
+// whether the documents held GeoPoints.
+func (d *Datasource) documentRows(ctx context.Context, docs []*firestore.DocumentSnapshot, queryInfo *QueryInfo) ([]map[string]interface{}, bool, error) {
+	metadata := append([]string{}, queryInfo.Fields...)
+	for _, rank := range queryInfo.Ranks {
+		metadata = append(metadata, rank.fields()...)
+	}
+
+	rows := make([]map[string]interface{}, 0, len(docs))
+	hasGeoPoints := false
+	for i, doc := range docs {
+		if err := checkCanceled(ctx, i); err != nil {
+			return nil, false, err
+		}
+		if doc == nil {
+			d.logger(ctx).Warn("documentRows: Skipping nil document", "index", i)
+			continue
+		}
+
+		// Streams convert their snapshots here, a document that can't be decoded is skipped.
+		// The documents of a query were charged to the budget as they were read.
+		_, charged := queryInfo.Decoded[doc]
+		docData, err := queryInfo.Decoded.take(doc)
+		if err != nil {
+			d.logger(ctx).Warn("documentRows: Skipping a document that can't be decoded", "document", doc.Ref.ID, "error", err)
+			continue
+		}
+		if docData == nil {
+			d.logger(ctx).Warn("documentRows: Skipping document with nil data", "index", i)
+			continue
+		}
+
+		if queryInfo.Flatten {
+			docData = flattenMap(docData)
+		}
+		convertDocumentRefs(docData, queryInfo.RefFormat)
+		if expandGeoPoints(docData, queryInfo.GeoFormat) {
+			hasGeoPoints = true
+		}
+		addDocumentMetadata(docData, doc, metadata)
+		applyExpressions(docData, queryInfo.Expressions)
+		if !charged {
+			if err := queryInfo.MemoryBudget.add(docData); err != nil {
+				return nil, false, err
+			}
+		}
+		rows = append(rows, docData)
+	}
+	return rows, hasGeoPoints, nil
+}
+
+// rowsResponse builds the frame of the selected fields of document rows. Selected maps are
+// expanded into their leaf columns when the rows were flattened or hold GeoPoints.
+func rowsResponse(rows []map[string]interface{}, queryInfo *QueryInfo, expand bool) backend.DataResponse {
+	var response backend.DataResponse
+
 	// Collect data for requested fields
 	fieldData := make(map[string][]interface{})
 
@@ -1071,8 +2288,8 @@ func (d *Datasource) convertFirestoreDocsToResponseWithFields(docs []*firestore.
 	if len(queryInfo.Fields) == 1 && queryInfo.Fields[0] == "*" {
 		// Get all unique field names
 		allFields := make(map[string]bool)
-		for _, doc := range docs {
-			for fieldName := range doc.Data() {
+		for _, docData := range rows {
+			for fieldName := range docData {
 				allFields[fieldName] = true
 			}
 		}
@@ -1080,33 +2297,19 @@ func (d *Datasource) convertFirestoreDocsToResponseWithFields(docs []*firestore.
 		for fieldName := range allFields {
 			queryInfo.Fields = append(queryInfo.Fields, fieldName)
 		}
+		sort.Strings(queryInfo.Fields)
+	} else if queryInfo.Flatten || expand {
+		// Selected maps and GeoPoints expand into their leaf columns
+		queryInfo.Fields = expandFlattenedFields(queryInfo.Fields, rows)
 	}
 
-	// Initialize field data arrays
+	// Extract the values of each column into a slice of the size of the result
 	for _, fieldName := range queryInfo.Fields {
-		fieldData[fieldName] = make([]interface{}, 0, len(docs))
-	}
-
-	// Extract data from documents
-	for i, doc := range docs {
-		if doc == nil {
-			log.DefaultLogger.Warn("convertFirestoreDocsToResponseWithFields: Skipping nil document", "index", i)
-			continue
-		}
-
-		docData := doc.Data()
-		if docData == nil {
-			log.DefaultLogger.Warn("convertFirestoreDocsToResponseWithFields: Skipping document with nil data", "index", i)
-			continue
-		}
-
-		for _, fieldName := range queryInfo.Fields {
-			if value, exists := docData[fieldName]; exists {
-				fieldData[fieldName] = append(fieldData[fieldName], value)
-			} else {
-				fieldData[fieldName] = append(fieldData[fieldName], nil)
-			}
+		values := make([]interface{}, len(rows))
+		for i, docData := range rows {
+			values[i] = selectFieldValue(docData, fieldName)
 		}
+		fieldData[fieldName] = values
 	}
 
 	// Create data frame
@@ -1118,26 +2321,21 @@ func (d *Datasource) convertFirestoreDocsToResponseWithFields(docs []*firestore.
 		// Handle different data types
 		if fieldName == queryInfo.TimeField {
 			// Time field - ensure it's time.Time
-			timeValues := make([]time.Time, 0, len(values))
-			for _, v := range values {
-				if ts, ok := v.(time.Time); ok {
-					timeValues = append(timeValues, ts)
-				} else {
-					timeValues = append(timeValues, time.Time{})
+			timeValues := make([]time.Time, len(values))
+			for i, v := range values {
+				if ts, ok := toTime(v, queryInfo.timeFormatOf(fieldName), queryInfo.Location); ok {
+					timeValues[i] = ts
 				}
 			}
 			frame.Fields = append(frame.Fields, data.NewField(fieldName, nil, timeValues))
+		} else if fieldType, ok := queryInfo.FieldTypes[fieldName]; ok {
+			frame.Fields = append(frame.Fields, overrideField(fieldName, values, fieldType, queryInfo.Location))
 		} else {
-			// Other fields - convert to strings for simplicity
-			stringValues := make([]string, 0, len(values))
-			for _, v := range values {
-				if v != nil {
-					stringValues = append(stringValues, fmt.Sprintf("%v", v))
-				} else {
-					stringValues = append(stringValues, "")
-				}
+			// Other fields - keep the Firestore types
+			if notice, ok := coercionNotice(fieldName, values); ok {
+				frame.AppendNotices(data.Notice{Severity: data.NoticeSeverityInfo, Text: notice})
 			}
-			frame.Fields = append(frame.Fields, data.NewField(fieldName, nil, stringValues))
+			frame.Fields = append(frame.Fields, newTypedField(fieldName, values))
 		}
 	}
 
@@ -1145,33 +2343,86 @@ func (d *Datasource) convertFirestoreDocsToResponseWithFields(docs []*firestore.
 	return response
 }
 // processGroupByQueryWithOrdering handles GROUP BY queries with in-memory aggregation and ORDER BY support
-func (d *Datasource) processGroupByQueryWithOrdering(docs []*firestore.DocumentSnapshot, queryInfo *QueryInfo) backend.DataResponse {
-	var response backend.DataResponse
-
+func (d *Datasource) processGroupByQueryWithOrdering(ctx context.Context, docs []*firestore.DocumentSnapshot, queryInfo *QueryInfo, qm FirestoreQuery) backend.DataResponse {
 	if len(docs) == 0 {
-		// Return empty frame with group fields and aggregate fields
-		frame := data.NewFrame("response")
-		for _, field := range queryInfo.GroupByFields {
-			frame.Fields = append(frame.Fields, data.NewField(field, nil, []string{}))
+		return emptyGroupByResponse(queryInfo)
+	}
+
+	// Step 1: Apply manual filtering, the documents are then grouped as rows
+	filteredDocs, err := d.applyManualFiltering(ctx, docs, queryInfo.AdditionalFilters)
+	if err != nil {
+		return firestoreErrorResponse("", err)
+	}
+	rows := make([]map[string]interface{}, 0, len(filteredDocs))
+	for i, doc := range filteredDocs {
+		if err := checkCanceled(ctx, i); err != nil {
+			return firestoreErrorResponse("", err)
 		}
-		for _, aggField := range queryInfo.AggregateFields {
-			frame.Fields = append(frame.Fields, data.NewField(aggField.Alias, nil, []float64{}))
+		_, charged := queryInfo.Decoded[doc]
+		docData, err := queryInfo.Decoded.take(doc)
+		if err != nil {
+			d.logger(ctx).Warn("Skipping a document that can't be decoded", "document", doc.Ref.ID, "error", err)
+			continue
 		}
-		response.Frames = append(response.Frames, frame)
-		return response
+		if !charged {
+			if err := queryInfo.MemoryBudget.add(docData); err != nil {
+				return budgetExceededResponse(err)
+			}
+		}
+		addDocumentMetadata(docData, doc, queryInfo.GroupByFields)
+		rows = append(rows, docData)
 	}
+	d.debugLog(ctx, "FILTERING COMPLETE", "totalDocs", len(docs), "filteredDocs", len(filteredDocs))
+	return d.aggregateRows(ctx, rows, queryInfo, qm)
+}
 
-	// Step 1: Apply manual filtering and group documents by group fields
-	filteredDocs := d.applyManualFiltering(docs, queryInfo.AdditionalFilters)
-	groups := make(map[string][]map[string]interface{})
+// emptyGroupByResponse returns the frame of a GROUP BY without documents, its group and
+// aggregate columns without rows
+func emptyGroupByResponse(queryInfo *QueryInfo) backend.DataResponse {
+	var response backend.DataResponse
+	frame := data.NewFrame("response")
+	for _, field := range queryInfo.GroupByFields {
+		if field == queryInfo.TimeBucketField {
+			frame.Fields = append(frame.Fields, data.NewField(field, nil, []*time.Time{}))
+			continue
+		}
+		frame.Fields = append(frame.Fields, data.NewField(field, nil, []string{}))
+	}
+	for _, aggField := range queryInfo.AggregateFields {
+		if aggField.Function == "COUNT" {
+			frame.Fields = append(frame.Fields, data.NewField(aggregateFieldName(aggField), nil, []int64{}))
+			continue
+		}
+		if aggField.Function == "STRING_AGG" {
+			frame.Fields = append(frame.Fields, data.NewField(aggregateFieldName(aggField), nil, []*string{}))
+			continue
+		}
+		frame.Fields = append(frame.Fields, data.NewField(aggregateFieldName(aggField), nil, []float64{}))
+	}
+	response.Frames = append(response.Frames, frame)
+	return response
+}
 
-	for _, doc := range filteredDocs {
-		docData := doc.Data()
+// aggregateRows groups filtered rows by the GROUP BY fields and computes the aggregates of
+// each group
+func (d *Datasource) aggregateRows(ctx context.Context, rows []map[string]interface{}, queryInfo *QueryInfo, qm FirestoreQuery) backend.DataResponse {
+	if hasCounterFunctions(queryInfo) && counterTimeField(queryInfo) == "" {
+		return backend.ErrDataResponse(backend.StatusBadRequest, errCounterTimeField.Error())
+	}
+
+	groups := make(map[string][]map[string]interface{})
+	typedFields := typedGroupFields(queryInfo)
+	for i, docData := range rows {
+		if err := checkCanceled(ctx, i); err != nil {
+			return firestoreErrorResponse("", err)
+		}
+		applyExpressions(docData, queryInfo.Expressions)
+		applyFieldTypes(docData, typedFields, queryInfo)
 
 		// Build group key from group fields
 		var keyParts []string
 		for _, groupField := range queryInfo.GroupByFields {
-			value := getNestedFieldValue(docData, groupField)
+			value := groupFieldValue(docData, groupField, queryInfo)
 			keyParts = append(keyParts, fmt.Sprintf("%v", value))
 		}
 		groupKey := strings.Join(keyParts, "|")
@@ -1182,25 +2433,29 @@ func (d *Datasource) processGroupByQueryWithOrdering(docs []*firestore.DocumentS
 		groups[groupKey] = append(groups[groupKey], docData)
 	}
 
-	log.DefaultLogger.Info("GROUPING COMPLETE", "totalDocs", len(docs), "filteredDocs", len(filteredDocs), "totalGroups", len(groups))
+	d.debugLog(ctx, "GROUPING COMPLETE", "rows", len(rows), "totalGroups", len(groups))
 
 	// Step 2: Calculate aggregations for each group
-	type AggregatedResult struct {
-		GroupValues     []interface{}
-		AggregateValues []interface{}
-		SortValue       float64 // Used for ORDER BY
-	}
-
 	var results []AggregatedResult
 
-	for _, groupDocs := range groups {
+	// Visit groups in key order so results that tie on the ORDER BY keys keep a stable order
+	groupKeys := make([]string, 0, len(groups))
+	for groupKey := range groups {
+		groupKeys = append(groupKeys, groupKey)
+	}
+	sort.Strings(groupKeys)
+
+	for i, groupKey := range groupKeys {
+		if err := checkCanceled(ctx, i); err != nil {
+			return firestoreErrorResponse("", err)
+		}
+		groupDocs := groups[groupKey]
 		result := AggregatedResult{}
 
 		// Extract group field values from the first document in the group
 		if len(groupDocs) > 0 {
 			for _, groupField := range queryInfo.GroupByFields {
-				value := getNestedFieldValue(groupDocs[0], groupField)
-				log.DefaultLogger.Info("Group field extraction", "field", groupField, "value", value, "docData", groupDocs[0])
+				value := groupFieldValue(groupDocs[0], groupField, queryInfo)
 				result.GroupValues = append(result.GroupValues, value)
 			}
 		}
@@ -1208,190 +2463,73 @@ func (d *Datasource) processGroupByQueryWithOrdering(docs []*firestore.DocumentS
 		// Calculate aggregates
 		for _, aggField := range queryInfo.AggregateFields {
 			var aggregateValue interface{}
-
-			switch aggField.Function {
-			case "COUNT":
-				aggregateValue = float64(len(groupDocs))
-			case "SUM":
-				sum := 0.0
-				for _, doc := range groupDocs {
-					if val := getNestedFieldValue(doc, aggField.Field); val != nil {
-						if numVal, err := convertToFloat(val); err == nil {
-							sum += numVal
-						}
-					}
-				}
-				aggregateValue = sum
-			case "AVG":
-				sum := 0.0
-				count := 0
-				for _, doc := range groupDocs {
-					if val := getNestedFieldValue(doc, aggField.Field); val != nil {
-						if numVal, err := convertToFloat(val); err == nil {
-							sum += numVal
-							count++
-						}
-					}
-				}
-				if count > 0 {
-					aggregateValue = sum / float64(count)
-				} else {
-					aggregateValue = 0.0
-				}
-			case "MIN":
-				var min *float64
-				for _, doc := range groupDocs {
-					if val := getNestedFieldValue(doc, aggField.Field); val != nil {
-						if numVal, err := convertToFloat(val); err == nil {
-							if min == nil || numVal < *min {
-								min = &numVal
-							}
-						}
-					}
-				}
-				if min != nil {
-					aggregateValue = *min
-				} else {
-					aggregateValue = 0.0
-				}
-			case "MAX":
-				var max *float64
-				for _, doc := range groupDocs {
-					if val := getNestedFieldValue(doc, aggField.Field); val != nil {
-						if numVal, err := convertToFloat(val); err == nil {
-							if max == nil || numVal > *max {
-								max = &numVal
-							}
-						}
-					}
-				}
-				if max != nil {
-					aggregateValue = *max
-				} else {
-					aggregateValue = 0.0
-				}
-			default:
-				aggregateValue = 0.0
+			if counterFunctions[aggField.Function] {
+				// Turned into a RATE or DELTA by applyCounterFunctions
+				aggregateValue = newCounterSample(aggField.Field, groupDocs, queryInfo)
+			} else {
+				aggregateValue = computeAggregate(aggField, groupDocs)
 			}
 
 			result.AggregateValues = append(result.AggregateValues, aggregateValue)
-
-			// Set sort value for ORDER BY (check multiple possible matches)
-			if queryInfo.OrderField != "" {
-				isMatch := false
-
-				// Check direct alias match
-				if queryInfo.OrderField == aggField.Alias {
-					isMatch = true
-				}
-
-				// Check if ORDER BY matches the cleaned field name
-				cleanedAlias := aggField.Alias
-				if strings.Contains(cleanedAlias, "(") && strings.Contains(cleanedAlias, ")") {
-					if strings.Contains(strings.ToUpper(cleanedAlias), " AS ") {
-						parts := strings.Split(cleanedAlias, " ")
-						for i, part := range parts {
-							if strings.ToUpper(part) == "AS" && i+1 < len(parts) {
-								cleanedAlias = parts[i+1]
-								break
-							}
-						}
-					} else {
-						cleanedAlias = strings.ToLower(aggField.Function)
-					}
-				}
-
-				if queryInfo.OrderField == cleanedAlias {
-					isMatch = true
-				}
-
-				// Check function name match
-				if queryInfo.OrderField == strings.ToLower(aggField.Function) {
-					isMatch = true
-				}
-
-				if isMatch {
-					if sortVal, err := convertToFloat(aggregateValue); err == nil {
-						result.SortValue = sortVal
-						log.DefaultLogger.Info("Set sort value during aggregation", "orderField", queryInfo.OrderField, "alias", aggField.Alias, "cleanedAlias", cleanedAlias, "value", sortVal)
-					}
-				}
-			}
-		}
-
-		// If ORDER BY is on a group field, set sort value
-		if queryInfo.OrderField != "" {
-			for i, groupField := range queryInfo.GroupByFields {
-				if queryInfo.OrderField == groupField && i < len(result.GroupValues) {
-					if sortVal, err := convertToFloat(result.GroupValues[i]); err == nil {
-						result.SortValue = sortVal
-					}
-				}
-			}
 		}
 
 		results = append(results, result)
 	}
 
-	log.DefaultLogger.Info("Aggregated results", "totalResults", len(results))
-
-	// Step 3: Apply ORDER BY if specified
-	if queryInfo.OrderField != "" {
-		log.DefaultLogger.Info("Applying ORDER BY", "field", queryInfo.OrderField, "direction", queryInfo.OrderDirection)
-
-		// Validate that we have sort values set for all results
-		validSortValues := true
-		for i, result := range results {
-			log.DefaultLogger.Debug("Result sort value", "index", i, "sortValue", result.SortValue, "groupValues", result.GroupValues, "aggregateValues", result.AggregateValues)
-			if result.SortValue == 0 {
-				// Try to match ORDER BY field with aggregate fields
-				for j, aggField := range queryInfo.AggregateFields {
-					if queryInfo.OrderField == aggField.Alias || queryInfo.OrderField == strings.ToLower(aggField.Function) {
-						if j < len(result.AggregateValues) {
-							if sortVal, err := convertToFloat(result.AggregateValues[j]); err == nil {
-								results[i].SortValue = sortVal
-								log.DefaultLogger.Info("Set sort value from aggregate", "index", i, "value", sortVal, "field", aggField.Alias)
-							}
-						}
-					}
-				}
-			}
-		}
+	d.debugLog(ctx, "Aggregated results", "totalResults", len(results))
+	return d.aggregatedResultsResponse(ctx, results, queryInfo, qm)
+}
 
-		if validSortValues {
-			// Sort results based on ORDER BY using bubble sort
-			for i := 0; i < len(results)-1; i++ {
-				for j := i + 1; j < len(results); j++ {
-					shouldSwap := false
+// aggregatedResultsResponse orders and limits the GROUP BY results and builds their frame
+func (d *Datasource) aggregatedResultsResponse(ctx context.Context, results []AggregatedResult, queryInfo *QueryInfo, qm FirestoreQuery) backend.DataResponse {
+	var response backend.DataResponse
 
-					if queryInfo.OrderDirection == "DESC" {
-						shouldSwap = results[i].SortValue < results[j].SortValue
-					} else {
-						shouldSwap = results[i].SortValue > results[j].SortValue
-					}
+	// Gaps are filled and counter and window functions run over the complete series, before
+	// ordering and limiting
+	results = fillTimeBuckets(results, queryInfo, qm.Fill)
+	applyCounterFunctions(results, queryInfo)
+	applyWindowFunctions(results, queryInfo)
 
-					if shouldSwap {
-						results[i], results[j] = results[j], results[i]
-					}
-				}
-			}
-			log.DefaultLogger.Info("Sorting completed", "direction", queryInfo.OrderDirection)
-		} else {
-			log.DefaultLogger.Warn("Could not apply ORDER BY - invalid sort values")
-		}
+	// Step 3: Apply ORDER BY if specified
+	if len(queryInfo.OrderBy) > 0 {
+		d.debugLog(ctx, "Applying ORDER BY", "keys", queryInfo.OrderBy)
+		sortAggregatedResults(results, queryInfo)
 	}
 
 	// Step 4: Apply LIMIT if specified
 	if queryInfo.Limit > 0 && queryInfo.Limit < len(results) {
-		log.DefaultLogger.Info("Applying LIMIT to GROUP BY results", "originalCount", len(results), "limitTo", queryInfo.Limit)
+		d.debugLog(ctx, "Applying LIMIT to GROUP BY results", "originalCount", len(results), "limitTo", queryInfo.Limit)
 		results = results[:queryInfo.Limit]
 	}
 
-	// Step 5: Create data frame with grouped and aggregated data
+	// Step 5: Create data frame with grouped and aggregated data, a series per aggregate
+	if qm.Format == formatTimeSeries && queryInfo.TimeBucketField != "" && len(queryInfo.AggregateFields) > 0 {
+		if frame, ok := buildWideTimeSeriesFrame(results, queryInfo); ok {
+			response.Frames = append(response.Frames, frame)
+			return response
+		}
+	}
+
 	frame := data.NewFrame("response")
 
-	// Add group fields
+	// Add group fields, the time bucket as a time column
 	for i, groupField := range queryInfo.GroupByFields {
+		if groupField == queryInfo.TimeBucketField {
+			if buckets, ok := timeBucketValues(results, i); ok {
+				frame.Fields = append(frame.Fields, data.NewField(groupField, nil, buckets))
+				continue
+			}
+		}
+		if fieldType, ok := queryInfo.FieldTypes[groupField]; ok {
+			frame.Fields = append(frame.Fields, overrideField(groupField, groupColumn(results, i), fieldType, queryInfo.Location))
+			continue
+		}
+		if len(queryInfo.AggregateFields) == 0 {
+			// Without aggregates the groups are the distinct values, as SELECT DISTINCT
+			// returns them: typed like table columns, missing values are nulls
+			frame.Fields = append(frame.Fields, newTypedField(groupField, groupColumn(results, i)))
+			continue
+		}
 		groupValues := make([]string, len(results))
 		for j, result := range results {
 			if i < len(result.GroupValues) {
@@ -1403,53 +2541,88 @@ func (d *Datasource) processGroupByQueryWithOrdering(docs []*firestore.DocumentS
 
 	// Add aggregate fields with proper field names (use alias)
 	for i, aggField := range queryInfo.AggregateFields {
-		aggregateValues := make([]float64, len(results))
+		aggregateValues := make([]interface{}, len(results))
 		for j, result := range results {
 			if i < len(result.AggregateValues) {
-				if val, err := convertToFloat(result.AggregateValues[i]); err == nil {
-					aggregateValues[j] = val
-				}
+				aggregateValues[j] = result.AggregateValues[i]
 			}
 		}
 
 		// Use the alias from the query (e.g., "total" from "COUNT(*) as total")
-		fieldName := aggField.Alias
-
-		// Clean up the field name - remove function syntax if it's the default alias
-		if strings.Contains(fieldName, "(") && strings.Contains(fieldName, ")") {
-			// This looks like "COUNT(*) as total" or just "COUNT(*)" - extract the actual alias
-			if strings.Contains(strings.ToUpper(fieldName), " AS ") {
-				parts := strings.Split(fieldName, " ")
-				// Find the part after "AS"
-				for i, part := range parts {
-					if strings.ToUpper(part) == "AS" && i+1 < len(parts) {
-						fieldName = parts[i+1]
-						break
-					}
-				}
-			} else {
-				// No alias, use function name
-				fieldName = strings.ToLower(aggField.Function)
-			}
-		}
+		fieldName := aggregateFieldName(aggField)
 
-		log.DefaultLogger.Info("Creating aggregate field", "originalAlias", aggField.Alias, "finalFieldName", fieldName)
+		d.debugLog(ctx, "Creating aggregate field", "originalAlias", aggField.Alias, "finalFieldName", fieldName)
 
-		frame.Fields = append(frame.Fields, data.NewField(fieldName, nil, aggregateValues))
+		if aggField.Function == "STRING_AGG" {
+			frame.Fields = append(frame.Fields, data.NewField(fieldName, nil, nullableValues[string](aggregateValues)))
+			continue
+		}
+		field := newAggregateField(fieldName, aggregateValues)
+		setAggregateUnit(field, aggField, queryInfo.FieldTypes)
+		frame.Fields = append(frame.Fields, field)
 	}
 
 	response.Frames = append(response.Frames, frame)
 	return response
 }
 
+// groupColumn returns the values of a GROUP BY field of the results
+func groupColumn(results []AggregatedResult, field int) []interface{} {
+	values := make([]interface{}, len(results))
+	for i, result := range results {
+		if field < len(result.GroupValues) {
+			values[i] = result.GroupValues[field]
+		}
+	}
+	return values
+}
+
+// AggregatedResult holds the group values and aggregates computed for one GROUP BY group
+type AggregatedResult struct {
+	GroupValues     []interface{}
+	AggregateValues []interface{}
+}
+
+// aggregateFieldName returns the output column name for an aggregate
+func aggregateFieldName(aggField AggregateInfo) string {
+	fieldName := aggField.Alias
+
+	// Clean up the field name - remove function syntax if it's the default alias
+	if strings.Contains(fieldName, "(") && strings.Contains(fieldName, ")") {
+		// This looks like "COUNT(*) as total" or just "COUNT(*)" - extract the actual alias
+		if strings.Contains(strings.ToUpper(fieldName), " AS ") {
+			parts := strings.Split(fieldName, " ")
+			// Find the part after "AS"
+			for i, part := range parts {
+				if strings.ToUpper(part) == "AS" && i+1 < len(parts) {
+					fieldName = parts[i+1]
+					break
+				}
+			}
+		} else {
+			// No alias, use function name
+			fieldName = strings.ToLower(aggField.Function)
+		}
+	}
+	return fieldName
+}
+
+// groupFieldValue returns the value a document contributes to a GROUP BY field,
+// truncating the time bucket field to its interval
+func groupFieldValue(doc map[string]interface{}, groupField string, queryInfo *QueryInfo) interface{} {
+	value := convertDocumentRefs(selectFieldValue(doc, groupField), queryInfo.RefFormat)
+	if groupField == queryInfo.TimeBucketField && queryInfo.TimeBucket > 0 {
+		if ts, ok := toTime(value, queryInfo.timeFormatOf(groupField), queryInfo.Location); ok {
+			return truncateToBucket(ts, queryInfo)
+		}
+	}
+	return value
+}
+
 // getNestedFieldValue extracts nested field values like "clientData.BrandCliente"
 func getNestedFieldValue(doc map[string]interface{}, fieldPath string) interface{} {
-	log.DefaultLogger.Info("Getting nested field value", "fieldPath", fieldPath, "docKeys", getDocumentKeys(doc))
-
 	if !strings.Contains(fieldPath, ".") {
-		value := doc[fieldPath]
-		log.DefaultLogger.Info("Simple field lookup", "fieldPath", fieldPath, "value", value)
-		return value
+		return doc[fieldPath]
 	}
 
 	parts := strings.Split(fieldPath, ".")
@@ -1476,15 +2649,6 @@ func getNestedFieldValue(doc map[string]interface{}, fieldPath string) interface
 	return nil
 }
 
-// getDocumentKeys helper function to debug document contents
-func getDocumentKeys(doc map[string]interface{}) []string {
-	keys := make([]string, 0, len(doc))
-	for key := range doc {
-		keys = append(keys, key)
-	}
-	return keys
-}
-
 // findGroupByIndex finds the index of "group by" clause accounting for potential whitespace and newlines
 func findGroupByIndex(queryLower string) int {
 	// Look for different variations of "group by" with potential whitespace
@@ -1546,31 +2710,34 @@ func convertToFloat(val interface{}) (float64, error) {
 }
 
 // applyManualFiltering applies WHERE clause filters manually to avoid Firestore index requirements
-func (d *Datasource) applyManualFiltering(docs []*firestore.DocumentSnapshot, filters []FilterInfo) []*firestore.DocumentSnapshot {
+func (d *Datasource) applyManualFiltering(ctx context.Context, docs []*firestore.DocumentSnapshot, filters []FilterInfo) ([]*firestore.DocumentSnapshot, error) {
 	if len(filters) == 0 {
-		return docs
+		return docs, nil
 	}
 
 	if len(docs) == 0 {
-		log.DefaultLogger.Info("MANUAL FILTERING: No documents to filter")
-		return docs
+		d.debugLog(ctx, "MANUAL FILTERING: No documents to filter")
+		return docs, nil
 	}
 
-	log.DefaultLogger.Info("STARTING MANUAL FILTERING", "totalDocs", len(docs), "additionalFilters", len(filters))
+	d.debugLog(ctx, "STARTING MANUAL FILTERING", "totalDocs", len(docs), "additionalFilters", len(filters))
 	var filteredDocs []*firestore.DocumentSnapshot
 	includedCount := 0
 	excludedCount := 0
 
 	for i, doc := range docs {
+		if err := checkCanceled(ctx, i); err != nil {
+			return nil, err
+		}
 		if doc == nil {
-			log.DefaultLogger.Warn("MANUAL FILTER: Skipping nil document", "index", i)
+			d.logger(ctx).Warn("MANUAL FILTER: Skipping nil document", "index", i)
 			excludedCount++
 			continue
 		}
 
 		docData := doc.Data()
 		if docData == nil {
-			log.DefaultLogger.Warn("MANUAL FILTER: Skipping document with nil data", "index", i)
+			d.logger(ctx).Warn("MANUAL FILTER: Skipping document with nil data", "index", i)
 			excludedCount++
 			continue
 		}
@@ -1579,24 +2746,23 @@ func (d *Datasource) applyManualFiltering(docs []*firestore.DocumentSnapshot, fi
 		passesFilters := true
 		for _, filter := range filters {
 			fieldValue := getNestedFieldValue(docData, filter.Field)
+			if isMetadataColumn(filter.Field) {
+				fieldValue = documentMetadata(doc, filter.Field)
+			}
 			if fieldValue == nil {
-				log.DefaultLogger.Info("MANUAL FILTER: Field value is nil - EXCLUDING", "field", filter.Field, "expectedValue", filter.Value)
+				d.debugLog(ctx, "MANUAL FILTER: Field value is nil - EXCLUDING", "field", filter.Field, "expectedValue", filter.Value)
 				passesFilters = false
 				break
 			}
 
-			fieldValueStr := fmt.Sprintf("%v", fieldValue)
-			expectedValueStr := fmt.Sprintf("%v", filter.Value)
+			d.debugLog(ctx, "MANUAL FILTER: Checking value", "field", filter.Field, "actualValue", fieldValue, "expectedValue", filter.Value, "operator", filter.Operator)
 
-			log.DefaultLogger.Info("MANUAL FILTER: Checking value", "field", filter.Field, "actualValue", fieldValueStr, "expectedValue", expectedValueStr, "operator", filter.Operator)
-
-			if filter.Operator == "==" && fieldValueStr != expectedValueStr {
-				log.DefaultLogger.Info("MANUAL FILTER: Value mismatch - EXCLUDING", "field", filter.Field, "actualValue", fieldValueStr, "expectedValue", expectedValueStr)
+			if !filterMatches(fieldValue, filter) {
+				d.debugLog(ctx, "MANUAL FILTER: Value mismatch - EXCLUDING", "field", filter.Field, "actualValue", fieldValue, "expectedValue", filter.Value)
 				passesFilters = false
 				break
-			} else if filter.Operator == "==" && fieldValueStr == expectedValueStr {
-				log.DefaultLogger.Info("MANUAL FILTER: Value match - INCLUDING", "field", filter.Field, "value", fieldValueStr)
 			}
+			d.debugLog(ctx, "MANUAL FILTER: Value match - INCLUDING", "field", filter.Field, "value", fieldValue)
 		}
 
 		if !passesFilters {
@@ -1608,6 +2774,74 @@ func (d *Datasource) applyManualFiltering(docs []*firestore.DocumentSnapshot, fi
 		filteredDocs = append(filteredDocs, doc)
 	}
 
-	log.DefaultLogger.Info("MANUAL FILTERING COMPLETE", "totalDocs", len(docs), "includedCount", includedCount, "excludedCount", excludedCount)
-	return filteredDocs
-}
\ No newline at end of file
+	d.debugLog(ctx, "MANUAL FILTERING COMPLETE", "totalDocs", len(docs), "includedCount", includedCount, "excludedCount", excludedCount)
+	return filteredDocs, nil
+}
+
+// filterRows returns the rows matching every filter, a row without the filtered field
+// doesn't match
+func filterRows(rows []map[string]interface{}, filters []FilterInfo) []map[string]interface{} {
+	if len(filters) == 0 {
+		return rows
+	}
+	var filtered []map[string]interface{}
+	for _, row := range rows {
+		matches := true
+		for _, filter := range filters {
+			value := selectFieldValue(row, filter.Field)
+			if value == nil || !filterMatches(value, filter) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			filtered = append(filtered, row)
+		}
+	}
+	return filtered
+}
+
+// filterMatches evaluates a filter on a document value in memory like Firestore does on the
+// server, where range filters only match values of the filter's kind
+func filterMatches(value interface{}, filter FilterInfo) bool {
+	switch filter.Operator {
+	case "==":
+		return sameValue(value, filter.Value)
+	case "!=":
+		return !sameValue(value, filter.Value)
+	case "<", "<=", ">", ">=":
+		if kindRank(kindOf(value)) != kindRank(kindOf(filter.Value)) {
+			return false
+		}
+		comparison := compareValues(value, filter.Value)
+		switch filter.Operator {
+		case "<":
+			return comparison < 0
+		case "<=":
+			return comparison <= 0
+		case ">":
+			return comparison > 0
+		default:
+			return comparison >= 0
+		}
+	case "in", "not-in":
+		candidates, _ := filter.Value.([]interface{})
+		found := slices.ContainsFunc(candidates, func(candidate interface{}) bool { return sameValue(value, candidate) })
+		return found == (filter.Operator == "in")
+	case "array-contains":
+		elements, _ := value.([]interface{})
+		return slices.ContainsFunc(elements, func(element interface{}) bool { return sameValue(element, filter.Value) })
+	case "array-contains-any":
+		elements, _ := value.([]interface{})
+		candidates, _ := filter.Value.([]interface{})
+		return slices.ContainsFunc(elements, func(element interface{}) bool {
+			return slices.ContainsFunc(candidates, func(candidate interface{}) bool { return sameValue(element, candidate) })
+		})
+	}
+	return true
+}
+
+// sameValue compares values by their text, so numbers match whatever their Go type
+func sameValue(a, b interface{}) bool {
+	return fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b)
+}
@@ -17,6 +17,7 @@ import (
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
 func TestQueryData(t *testing.T) {
@@ -78,6 +79,7 @@ var healthTests = []healthTest{
 	{`{"ProjectId": "test"}`, map[string]string{"serviceAccount": "test"}, backend.HealthStatusError},
 	{`{"ProjectId": "test"}`, map[string]string{"serviceAccount": `{}`}, backend.HealthStatusError},
 	{`{"ProjectId": "test"}`, nil, backend.HealthStatusOk},
+	{`{"ProjectId": "test", "healthCheckCollection": "users"}`, nil, backend.HealthStatusOk},
 }
 
 func TestCheckHealth(t *testing.T) {
@@ -114,6 +116,21 @@ var queryTests = []TestExpect{
 		rowsLength:    5,
 		columnsLength: 6,
 	},
+	{
+		query:         "DOC users/1, users/2",
+		rowsLength:    2,
+		columnsLength: 7,
+	},
+	{
+		query:         "SHOW COLLECTIONS",
+		rowsLength:    1,
+		columnsLength: 2,
+	},
+	{
+		query:         "SELECT COUNT(*) FROM users",
+		rowsLength:    1,
+		columnsLength: 1,
+	},
 	//{
 	//	query:   "select * from `users`",
 	//	columns: []string{"id", "email", "username", "address", "name"},
@@ -444,3 +461,190 @@ func TestContainsGrafanaVariables(t *testing.T) {
 		})
 	}
 }
+
+func TestDescribeNativeQuery(t *testing.T) {
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2023, 1, 31, 23, 59, 59, 0, time.UTC)
+	timeRange := backend.TimeRange{From: from, To: to}
+
+	result := describeNativeQuery(
+		"SELECT * FROM events WHERE ts >= $__from AND ts <= $__to AND type = 'error'",
+		timeRange,
+		[]string{"collection(events)", "where(ts >= 2023-01-01T00:00:00Z)", "where(ts <= 2023-01-31T23:59:59Z)"},
+		[]string{"where(type == error)"},
+	)
+	require.Equal(t, "SELECT * FROM events WHERE ts >= '2023-01-01T00:00:00Z' AND ts <= '2023-01-31T23:59:59Z' AND type = 'error'\n"+
+		"-- native SDK: collection(events).where(ts >= 2023-01-01T00:00:00Z).where(ts <= 2023-01-31T23:59:59Z)\n"+
+		"-- in memory: where(type == error)", result)
+}
+
+func TestSelectFieldValue(t *testing.T) {
+	docData := map[string]interface{}{
+		"msisdn":      "600000000",
+		"clientData":  map[string]interface{}{"BrandCliente": "yoigo"},
+		"literal.key": "dotted",
+	}
+
+	require.Equal(t, "600000000", selectFieldValue(docData, "msisdn"))
+	require.Equal(t, "yoigo", selectFieldValue(docData, "clientData.BrandCliente"))
+	require.Equal(t, "dotted", selectFieldValue(docData, "literal.key"))
+	require.Nil(t, selectFieldValue(docData, "clientData.missing"))
+	require.Nil(t, selectFieldValue(docData, "missing"))
+}
+
+func TestCleanBackticks(t *testing.T) {
+	require.Equal(t, "clientData.BrandCliente", cleanBackticks("`clientData.BrandCliente`"))
+	require.Equal(t, "clientData.BrandCliente", cleanBackticks(" `clientData`.`BrandCliente` "))
+	require.Equal(t, "msisdn", cleanBackticks("msisdn"))
+}
+
+func TestEmptyResultKeepsSelectedColumns(t *testing.T) {
+	ds := Datasource{}
+	queryInfo := &QueryInfo{Fields: []string{"openTS", "msisdn", "__name__"}, TimeField: "openTS"}
+
+	response := ds.convertFirestoreDocsToResponseWithFields(context.Background(), nil, nil, queryInfo)
+	require.NoError(t, response.Error)
+	require.Len(t, response.Frames, 1)
+
+	frame := response.Frames[0]
+	require.Len(t, frame.Fields, 3)
+	require.Equal(t, "openTS", frame.Fields[0].Name)
+	require.Equal(t, data.FieldTypeTime, frame.Fields[0].Type())
+	require.Equal(t, "msisdn", frame.Fields[1].Name)
+	require.Equal(t, data.FieldTypeNullableString, frame.Fields[1].Type())
+	require.Equal(t, 0, frame.Rows())
+}
+
+func TestSelectsAllFields(t *testing.T) {
+	require.True(t, selectsAllFields("SELECT * FROM users"))
+	require.True(t, selectsAllFields("select *\nfrom users"))
+	require.False(t, selectsAllFields("SELECT msisdn, status FROM users"))
+	require.False(t, selectsAllFields("SELECT COUNT(*) FROM users"))
+}
+
+func TestQueryTimeout(t *testing.T) {
+	require.Equal(t, defaultQueryTimeout, queryTimeout(FirestoreQuery{}, &FirestoreSettings{}))
+	require.Equal(t, 10*time.Second, queryTimeout(FirestoreQuery{}, &FirestoreSettings{TimeoutSeconds: 10}))
+	require.Equal(t, 5*time.Second, queryTimeout(FirestoreQuery{TimeoutSeconds: 5}, &FirestoreSettings{TimeoutSeconds: 10}))
+}
+
+func TestFilterLiteral(t *testing.T) {
+	tests := []struct {
+		literal string
+		value   interface{}
+	}{
+		{"'633525465'", "633525465"},
+		{`"yoigo"`, "yoigo"},
+		{"633525465", int64(633525465)},
+		{"1.5", 1.5},
+		{"true", true},
+		{"'true'", "true"},
+		{"open", "open"},
+		{"NaN", "NaN"},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.value, filterLiteral(tt.literal), tt.literal)
+	}
+}
+
+func TestProjectionFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		qm     FirestoreQuery
+		fields []string
+	}{
+		{"select all", "SELECT * FROM users WHERE status = 'open'", FirestoreQuery{}, nil},
+		{"selected fields", "SELECT msisdn, status FROM users", FirestoreQuery{}, []string{"msisdn", "status"}},
+		{"filters and ordering", "SELECT msisdn FROM users WHERE status = 'open' ORDER BY createdAt DESC", FirestoreQuery{}, []string{"msisdn", "status", "createdAt"}},
+		{"metadata columns", "SELECT __name__, msisdn FROM users ORDER BY __updateTime__", FirestoreQuery{}, []string{"msisdn"}},
+		{"group by", "SELECT brand, SUM(amount) AS total FROM orders GROUP BY brand ORDER BY total DESC", FirestoreQuery{}, []string{"brand", "amount"}},
+		{"count only", "SELECT COUNT(*) FROM orders", FirestoreQuery{TimeField: "createdAt"}, []string{"createdAt"}},
+		{"logs", "SELECT msisdn FROM events", FirestoreQuery{Format: formatLogs, TimeField: "ts", LogLevelField: "severity"}, []string{"msisdn", "ts", "message", "severity"}},
+		{"invalid path", "SELECT `a/b` FROM users", FirestoreQuery{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queryInfo, err := parseSQLQueryWithVariables(tt.query)
+			require.NoError(t, err)
+			require.Equal(t, tt.fields, projectionFields(queryInfo, tt.qm))
+		})
+	}
+}
+
+func TestNewDatasourceDebugSetting(t *testing.T) {
+	instance, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(`{"debug":true}`)})
+	require.NoError(t, err)
+	defer instance.(*Datasource).Dispose()
+	require.True(t, instance.(*Datasource).debug)
+
+	meta := &queryMeta{}
+	instance.(*Datasource).debugNotice(meta, "Executed with FireQL")
+	require.Len(t, meta.notices, 1)
+
+	meta = &queryMeta{}
+	(&Datasource{}).debugNotice(meta, "Executed with FireQL")
+	require.Empty(t, meta.notices)
+}
+
+func TestQueryDataConcurrentResponses(t *testing.T) {
+	ds := Datasource{maxConcurrentQueries: 2}
+
+	var queries []backend.DataQuery
+	for i := 0; i < 5; i++ {
+		queries = append(queries, backend.DataQuery{RefID: fmt.Sprintf("Q%d", i), JSON: []byte(`{`)})
+	}
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{Queries: queries})
+	require.NoError(t, err)
+	require.Len(t, resp.Responses, 5)
+	for _, q := range queries {
+		require.Error(t, resp.Responses[q.RefID].Error)
+	}
+	require.Equal(t, defaultMaxConcurrentQueries, (&Datasource{}).queryConcurrency())
+}
+
+func TestQueryMetaApplyMaxRows(t *testing.T) {
+	meta := &queryMeta{}
+	require.Equal(t, 5, meta.applyMaxRows(5, 10))
+	require.Empty(t, meta.notices)
+
+	require.Equal(t, 10, meta.applyMaxRows(11, 10))
+	require.Len(t, meta.notices, 1)
+
+	response := meta.apply(backend.DataResponse{Frames: data.Frames{data.NewFrame("response")}})
+	require.Equal(t, map[string]interface{}{"maxRows": 10, "truncated": true}, response.Frames[0].Meta.Custom)
+}
+
+func TestQueryMetaDocumentsRead(t *testing.T) {
+	meta := &queryMeta{}
+	meta.addDocumentsRead(routeNative, 0)
+	meta.addDocumentsRead(routeNative, 42)
+
+	response := meta.apply(backend.DataResponse{Frames: data.Frames{data.NewFrame("response")}})
+	require.Equal(t, map[string]interface{}{"documentsRead": 42}, response.Frames[0].Meta.Custom)
+}
+
+func TestFilterMatches(t *testing.T) {
+	tests := []struct {
+		value  interface{}
+		filter FilterInfo
+		match  bool
+	}{
+		{int64(5), FilterInfo{Operator: "==", Value: float64(5)}, true},
+		{"a", FilterInfo{Operator: "!=", Value: "a"}, false},
+		{int64(5), FilterInfo{Operator: ">", Value: float64(4.5)}, true},
+		{int64(5), FilterInfo{Operator: "<=", Value: int64(4)}, false},
+		{"10", FilterInfo{Operator: ">", Value: int64(4)}, false},
+		{"paid", FilterInfo{Operator: "in", Value: []interface{}{"open", "paid"}}, true},
+		{"paid", FilterInfo{Operator: "not-in", Value: []interface{}{"open", "paid"}}, false},
+		{[]interface{}{"red", "blue"}, FilterInfo{Operator: "array-contains", Value: "blue"}, true},
+		{[]interface{}{"red"}, FilterInfo{Operator: "array-contains-any", Value: []interface{}{"blue", "green"}}, false},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.match, filterMatches(tt.value, tt.filter), "%v %s %v", tt.value, tt.filter.Operator, tt.filter.Value)
+	}
+}
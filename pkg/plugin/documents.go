@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// docQueryPattern matches a document fetch: DOC <path>[, <path>...]
+var docQueryPattern = regexp.MustCompile(`(?is)^\s*DOC\s+(.+?)\s*;?\s*$`)
+
+// parseDocQuery returns the document paths of a DOC query. isDoc is false for other queries.
+func parseDocQuery(query string) (paths []string, isDoc bool, err error) {
+	match := docQueryPattern.FindStringSubmatch(query)
+	if match == nil {
+		return nil, false, nil
+	}
+	for _, path := range strings.Split(match[1], ",") {
+		path = strings.Trim(strings.TrimSpace(path), "`'\"/")
+		segments := strings.Split(path, "/")
+		if len(segments)%2 != 0 || strings.Contains(path, "//") || path == "" {
+			return nil, true, fmt.Errorf("invalid document path %q, expected collection/document[/collection/document...]", path)
+		}
+		paths = append(paths, path)
+	}
+	return paths, true, nil
+}
+
+// fetchDocuments reads documents by path and returns each as a row, the document path first
+func (d *Datasource) fetchDocuments(ctx context.Context, pCtx backend.PluginContext, settings *FirestoreSettings, qm FirestoreQuery, paths []string, meta *queryMeta) backend.DataResponse {
+	location, err := settings.location()
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+
+	client, err := newFirestoreClient(ctx, pCtx)
+	if err != nil {
+		d.logger(ctx).Error("Failed to create Firestore client", "error", err)
+		return backend.ErrDataResponse(backend.StatusBadRequest, "Firestore client: "+err.Error())
+	}
+	defer client.Close()
+
+	if err := applyReadTime(client, &qm, meta); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+
+	refs := make([]*firestore.DocumentRef, len(paths))
+	for i, path := range paths {
+		refs[i] = client.Doc(path)
+	}
+
+	var snapshots []*firestore.DocumentSnapshot
+	started := time.Now()
+	err = d.withRetries(ctx, "document fetch", func() (err error) {
+		snapshots, err = client.GetAll(ctx, refs)
+		return err
+	})
+	if err != nil {
+		d.logger(ctx).Error("Document fetch failed", "documents", len(paths), "error", err)
+		return firestoreErrorResponse("Document fetch: ", err)
+	}
+	meta.addFetch(started, len(snapshots))
+	meta.addDocumentsRead(routeNative, len(snapshots))
+
+	docs := make([]*firestore.DocumentSnapshot, 0, len(snapshots))
+	docPaths := make([]string, 0, len(snapshots))
+	for i, snapshot := range snapshots {
+		if !snapshot.Exists() {
+			meta.addNotice(data.NoticeSeverityWarning, fmt.Sprintf("Document %s does not exist", paths[i]))
+			continue
+		}
+		if _, err := documentData(snapshot); err != nil {
+			meta.addUndecodable(err)
+			continue
+		}
+		docs = append(docs, snapshot)
+		docPaths = append(docPaths, paths[i])
+	}
+
+	queryInfo := &QueryInfo{
+		Fields:       []string{"*"},
+		TimeField:    qm.TimeField,
+		TimeFormat:   qm.TimeFormat,
+		Location:     location,
+		Flatten:      qm.Flatten,
+		GeoFormat:    qm.GeoFormat,
+		RefFormat:    qm.RefFormat,
+		MemoryBudget: newMemoryBudget(settings.MemoryBudgetMB),
+	}
+	response := d.convertFirestoreDocsToResponseWithFields(ctx, docs, nil, queryInfo)
+	if response.Error != nil || len(response.Frames) == 0 {
+		return response
+	}
+	frame := response.Frames[0]
+	frame.Fields = append([]*data.Field{data.NewField(docPathColumn, nil, docPaths)}, frame.Fields...)
+	return response
+}
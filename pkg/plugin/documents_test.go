@@ -0,0 +1,30 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDocQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		paths []string
+		isDoc bool
+		valid bool
+	}{
+		{"DOC customers/ACME/config/limits", []string{"customers/ACME/config/limits"}, true, true},
+		{"doc `customers/ACME`, /customers/BETA/;", []string{"customers/ACME", "customers/BETA"}, true, true},
+		{"DOC customers", nil, true, false},
+		{"DOC customers//limits/x", nil, true, false},
+		{"SELECT * FROM customers", nil, false, true},
+		{"SELECT doc FROM customers", nil, false, true},
+	}
+
+	for _, tt := range tests {
+		paths, isDoc, err := parseDocQuery(tt.query)
+		require.Equal(t, tt.isDoc, isDoc, tt.query)
+		require.Equal(t, tt.valid, err == nil, tt.query)
+		require.Equal(t, tt.paths, paths, tt.query)
+	}
+}
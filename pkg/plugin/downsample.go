@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Aggregators of the time series downsampled to the panel's max data points, set with the
+// downsample query option or setting
+const (
+	downsampleAvg  = "avg"  // average of the numbers of each interval, the default
+	downsampleLast = "last" // latest value of each interval
+	downsampleNone = "none" // every point is returned, up to maxRows
+)
+
+// validateDownsample checks the downsample aggregator, empty is the default
+func validateDownsample(downsample string) error {
+	switch downsample {
+	case "", downsampleAvg, downsampleLast, downsampleNone:
+		return nil
+	default:
+		return fmt.Errorf("unsupported downsample %q, expected one of %s, %s, %s", downsample, downsampleAvg, downsampleLast, downsampleNone)
+	}
+}
+
+// downsampleResponse buckets the frames of a time series query holding more points than the
+// panel's max data points into intervals of their time field. The string and boolean columns
+// keep the series apart, the other columns are aggregated per interval.
+func downsampleResponse(response backend.DataResponse, qm FirestoreQuery, meta *queryMeta) backend.DataResponse {
+	if response.Error != nil || qm.Format != formatTimeSeries || qm.MaxDataPoints <= 0 || qm.Downsample == downsampleNone {
+		return response
+	}
+	aggregator := qm.Downsample
+	if aggregator == "" {
+		aggregator = downsampleAvg
+	}
+	for i, frame := range response.Frames {
+		downsampled, interval, ok := downsampleFrame(frame, aggregator, qm.MaxDataPoints, qm.IntervalMs)
+		if !ok {
+			continue
+		}
+		meta.addNotice(data.NoticeSeverityInfo, fmt.Sprintf("%d points were downsampled to %d by their %s over %s intervals, the panel shows up to %d points",
+			frame.Rows(), downsampled.Rows(), aggregator, interval, qm.MaxDataPoints))
+		meta.setCustom("downsampled", map[string]interface{}{"aggregator": aggregator, "intervalMs": interval.Milliseconds()})
+		response.Frames[i] = downsampled
+	}
+	return response
+}
+
+// downsampleInterval returns the interval splitting a time span into at most maxDataPoints
+// buckets, in whole milliseconds, or the panel interval when it is longer
+func downsampleInterval(span time.Duration, maxDataPoints, intervalMs int64) time.Duration {
+	interval := (span + time.Duration(maxDataPoints) - 1) / time.Duration(maxDataPoints)
+	interval = (interval + time.Millisecond - 1).Truncate(time.Millisecond)
+	return max(interval, time.Duration(intervalMs)*time.Millisecond, time.Millisecond)
+}
+
+// downsampleBucket holds the points of a series within an interval
+type downsampleBucket struct {
+	time      time.Time
+	series    []interface{}
+	sums      []float64
+	counts    []int
+	last      []interface{}
+	lastTimes []time.Time
+}
+
+// downsampleFrame aggregates the rows of a frame with a time field into the intervals of
+// downsampleInterval, false when it has no more rows than maxDataPoints
+func downsampleFrame(frame *data.Frame, aggregator string, maxDataPoints, intervalMs int64) (*data.Frame, time.Duration, bool) {
+	rows := frame.Rows()
+	if int64(rows) <= maxDataPoints {
+		return nil, 0, false
+	}
+	timeIdx := -1
+	var seriesIdx, valueIdx []int
+	for i, field := range frame.Fields {
+		switch {
+		case field.Type().Time() && timeIdx == -1:
+			timeIdx = i
+		case field.Type() == data.FieldTypeString || field.Type() == data.FieldTypeNullableString ||
+			field.Type() == data.FieldTypeBool || field.Type() == data.FieldTypeNullableBool:
+			seriesIdx = append(seriesIdx, i)
+		default:
+			valueIdx = append(valueIdx, i)
+		}
+	}
+	if timeIdx == -1 {
+		return nil, 0, false
+	}
+
+	times := make([]time.Time, rows)
+	var first, last time.Time
+	for row := range times {
+		value, ok := frame.Fields[timeIdx].ConcreteAt(row)
+		if !ok {
+			continue
+		}
+		times[row] = value.(time.Time)
+		if first.IsZero() || times[row].Before(first) {
+			first = times[row]
+		}
+		if times[row].After(last) {
+			last = times[row]
+		}
+	}
+	if first.IsZero() {
+		return nil, 0, false
+	}
+	interval := downsampleInterval(last.Sub(first), maxDataPoints, intervalMs)
+
+	var buckets []*downsampleBucket
+	index := make(map[string]*downsampleBucket)
+	for row, ts := range times {
+		if ts.IsZero() {
+			continue
+		}
+		start := ts.Truncate(interval)
+		series := make([]interface{}, len(seriesIdx))
+		key := []string{start.String()}
+		for i, idx := range seriesIdx {
+			series[i], _ = frame.Fields[idx].ConcreteAt(row)
+			key = append(key, fmt.Sprint(series[i]))
+		}
+		bucket, ok := index[strings.Join(key, "\x00")]
+		if !ok {
+			bucket = &downsampleBucket{
+				time:      start,
+				series:    series,
+				sums:      make([]float64, len(valueIdx)),
+				counts:    make([]int, len(valueIdx)),
+				last:      make([]interface{}, len(valueIdx)),
+				lastTimes: make([]time.Time, len(valueIdx)),
+			}
+			index[strings.Join(key, "\x00")] = bucket
+			buckets = append(buckets, bucket)
+		}
+		for i, idx := range valueIdx {
+			value, ok := frame.Fields[idx].ConcreteAt(row)
+			if !ok {
+				continue
+			}
+			if n, err := convertToFloat(value); err == nil && frame.Fields[idx].Type().Numeric() {
+				bucket.sums[i] += n
+				bucket.counts[i]++
+			}
+			if !ts.Before(bucket.lastTimes[i]) {
+				bucket.last[i], bucket.lastTimes[i] = value, ts
+			}
+		}
+	}
+	sort.SliceStable(buckets, func(i, j int) bool { return buckets[i].time.Before(buckets[j].time) })
+
+	fields := make([]*data.Field, len(frame.Fields))
+	bucketTimes := make([]time.Time, len(buckets))
+	for i, bucket := range buckets {
+		bucketTimes[i] = bucket.time
+	}
+	fields[timeIdx] = data.NewField(frame.Fields[timeIdx].Name, nil, bucketTimes)
+	for i, idx := range seriesIdx {
+		values := make([]interface{}, len(buckets))
+		for row, bucket := range buckets {
+			values[row] = bucket.series[i]
+		}
+		fields[idx] = newTypedField(frame.Fields[idx].Name, values)
+	}
+	for i, idx := range valueIdx {
+		values := make([]interface{}, len(buckets))
+		for row, bucket := range buckets {
+			switch {
+			case aggregator == downsampleAvg && frame.Fields[idx].Type().Numeric():
+				if bucket.counts[i] > 0 {
+					values[row] = bucket.sums[i] / float64(bucket.counts[i])
+				}
+			default:
+				values[row] = bucket.last[i]
+			}
+		}
+		fields[idx] = newTypedField(frame.Fields[idx].Name, values)
+	}
+	for i, field := range fields {
+		field.Labels = frame.Fields[i].Labels
+		field.Config = frame.Fields[i].Config
+	}
+
+	downsampled := data.NewFrame(frame.Name, fields...)
+	downsampled.RefID = frame.RefID
+	downsampled.Meta = frame.Meta
+	return downsampled, interval, true
+}
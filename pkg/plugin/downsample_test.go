@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateDownsample(t *testing.T) {
+	require.NoError(t, validateDownsample(""))
+	require.NoError(t, validateDownsample(downsampleLast))
+	require.Error(t, validateDownsample("max"))
+}
+
+func TestDownsampleInterval(t *testing.T) {
+	require.Equal(t, time.Minute, downsampleInterval(time.Hour, 60, 0))
+	require.Equal(t, 1667*time.Millisecond, downsampleInterval(10*time.Second, 6, 0))
+	require.Equal(t, 5*time.Minute, downsampleInterval(time.Hour, 60, 300000))
+	require.Equal(t, time.Millisecond, downsampleInterval(0, 10, 0))
+}
+
+func TestDownsampleResponse(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newFrame := func() *data.Frame {
+		var times []time.Time
+		var hosts []string
+		var values []*float64
+		for i := 0; i < 8; i++ {
+			v := float64(i)
+			times = append(times, start.Add(time.Duration(i)*15*time.Second))
+			hosts = append(hosts, []string{"a", "b"}[i%2])
+			values = append(values, &v)
+		}
+		values[6] = nil
+		return data.NewFrame("response",
+			data.NewField("ts", nil, times),
+			data.NewField("host", nil, hosts),
+			data.NewField("cpu", nil, values),
+		)
+	}
+	qm := FirestoreQuery{Format: formatTimeSeries, MaxDataPoints: 4, IntervalMs: 60000}
+
+	meta := &queryMeta{}
+	response := downsampleResponse(backend.DataResponse{Frames: data.Frames{newFrame()}}, qm, meta)
+	frame := response.Frames[0]
+	require.Equal(t, 4, frame.Rows())
+	require.Equal(t, []string{"ts", "host", "cpu"}, []string{frame.Fields[0].Name, frame.Fields[1].Name, frame.Fields[2].Name})
+	require.Equal(t, start, frame.Fields[0].At(0))
+	host, _ := frame.Fields[1].ConcreteAt(0)
+	require.Equal(t, "a", host)
+	cpu, _ := frame.Fields[2].ConcreteAt(0)
+	require.Equal(t, 1.0, cpu) // avg of 0 and 2
+	cpu, _ = frame.Fields[2].ConcreteAt(2)
+	require.Equal(t, 4.0, cpu) // the null of the bucket is skipped
+	require.Len(t, meta.notices, 1)
+	require.Equal(t, map[string]interface{}{"aggregator": downsampleAvg, "intervalMs": int64(60000)}, meta.custom["downsampled"])
+
+	qm.Downsample = downsampleLast
+	response = downsampleResponse(backend.DataResponse{Frames: data.Frames{newFrame()}}, qm, &queryMeta{})
+	cpu, _ = response.Frames[0].Fields[2].ConcreteAt(1)
+	require.Equal(t, 3.0, cpu)
+
+	// Tables, small results and none are left as they are
+	for _, qm := range []FirestoreQuery{
+		{Format: formatTable, MaxDataPoints: 4},
+		{Format: formatTimeSeries, MaxDataPoints: 100},
+		{Format: formatTimeSeries, MaxDataPoints: 4, Downsample: downsampleNone},
+	} {
+		response = downsampleResponse(backend.DataResponse{Frames: data.Frames{newFrame()}}, qm, &queryMeta{})
+		require.Equal(t, 8, response.Frames[0].Rows())
+	}
+}
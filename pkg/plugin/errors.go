@@ -0,0 +1,224 @@
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/firestore/apiv1/admin/adminpb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// timeoutHint is appended to the error of a query that ran out of time
+const timeoutHint = ". Raise timeoutSeconds or narrow the query."
+
+// grpcStatuses maps the gRPC code of a failed Firestore call to the HTTP status reported to
+// Grafana, so panels explain the failure and only the failures that may pass later are retried
+var grpcStatuses = map[codes.Code]backend.Status{
+	codes.InvalidArgument:    backend.StatusBadRequest,
+	codes.OutOfRange:         backend.StatusBadRequest,
+	codes.FailedPrecondition: backend.StatusBadRequest,
+	codes.Unauthenticated:    backend.StatusUnauthorized,
+	codes.PermissionDenied:   backend.StatusForbidden,
+	codes.NotFound:           backend.StatusNotFound,
+	codes.AlreadyExists:      backend.Status(http.StatusConflict),
+	codes.Aborted:            backend.Status(http.StatusConflict),
+	codes.ResourceExhausted:  backend.StatusTooManyRequests,
+	codes.Canceled:           statusClientClosedRequest, // the panel was closed or refreshed
+	codes.DeadlineExceeded:   backend.StatusTimeout,
+	codes.Unimplemented:      backend.StatusNotImplemented,
+	codes.Unavailable:        backend.Status(http.StatusServiceUnavailable),
+	codes.Internal:           backend.StatusBadGateway,
+	codes.DataLoss:           backend.StatusBadGateway,
+	codes.Unknown:            backend.StatusBadGateway,
+}
+
+// grpcCodePattern matches the code of a gRPC error FireQL wrapped in its own error
+var grpcCodePattern = regexp.MustCompile(`rpc error: code = (\w+)`)
+
+// grpcCode returns the gRPC code of a failed Firestore call. A query context that is done is
+// reported like the matching gRPC code. ok is false for errors not coming from Firestore.
+func grpcCode(err error) (codes.Code, bool) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded, true
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled, true
+	}
+	if st, ok := status.FromError(err); ok {
+		return st.Code(), true
+	}
+	if match := grpcCodePattern.FindStringSubmatch(err.Error()); match != nil {
+		for code := range grpcStatuses {
+			if code.String() == match[1] {
+				return code, true
+			}
+		}
+	}
+	return codes.OK, false
+}
+
+// httpStatusOf returns the HTTP status of a failed Firestore call, 502 for errors of unknown
+// origin
+func httpStatusOf(err error) int {
+	if code, ok := grpcCode(err); ok {
+		if httpStatus, ok := grpcStatuses[code]; ok {
+			return int(httpStatus)
+		}
+	}
+	return http.StatusBadGateway
+}
+
+// firestoreErrorResponse builds the response for a failed Firestore call. Errors reported by
+// Firestore itself are mapped to a matching status and marked as downstream, so Firestore
+// outages and permission problems aren't counted as plugin failures. Other errors, like a
+// query the plugin can't run, are bad requests.
+func firestoreErrorResponse(prefix string, err error) backend.DataResponse {
+	message := prefix + err.Error()
+
+	if isDatastoreMode(err) {
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, prefix+errDatastoreMode.Error())
+	}
+
+	code, ok := grpcCode(err)
+	httpStatus, known := grpcStatuses[code]
+	if !ok || !known {
+		return backend.ErrDataResponse(backend.StatusBadRequest, message)
+	}
+	switch code {
+	case codes.FailedPrecondition:
+		if indexURL, missing := missingIndexURL(err); missing && indexURL != "" {
+			return missingIndexResponse(prefix, indexURL)
+		}
+	case codes.DeadlineExceeded:
+		message += timeoutHint
+	case codes.InvalidArgument:
+		// Firestore refused a query the plugin built
+		return backend.ErrDataResponse(httpStatus, message)
+	}
+	return backend.ErrDataResponseWithSource(httpStatus, backend.ErrorSourceDownstream, message)
+}
+
+// errDatastoreMode replaces the error of the Firestore API on a Firestore in Datastore mode
+// database, which it refuses to serve
+var errDatastoreMode = errors.New("the database is Firestore in Datastore mode, which the Firestore API can't query. " +
+	"The datasource supports databases in Firestore Native mode only")
+
+// isDatastoreMode reports whether Firestore rejected a call because the database is in
+// Datastore mode. FireQL wraps the gRPC errors it gets, so the message is checked as well.
+func isDatastoreMode(err error) bool {
+	if err == nil {
+		return false
+	}
+	if st, ok := status.FromError(err); ok && st.Code() != codes.FailedPrecondition {
+		return false
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "datastore mode")
+}
+
+// indexURLPattern matches the index creation link Firestore includes in missing index errors
+var indexURLPattern = regexp.MustCompile(`https://console\.firebase\.google\.com/\S+`)
+
+// missingIndexURL reports whether the error is Firestore rejecting a query for lack of a
+// composite index, returning the index creation link when the error carries one
+func missingIndexURL(err error) (string, bool) {
+	if err == nil {
+		return "", false
+	}
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.FailedPrecondition || isDatastoreMode(err) {
+		return "", false
+	}
+	return indexURLPattern.FindString(st.Message()), true
+}
+
+// missingIndexNotice is the notice shown when filters ran in memory for lack of an index
+func missingIndexNotice(indexURL string) string {
+	notice := "Firestore has no composite index for the WHERE filters, so they were applied in memory after reading the unfiltered documents."
+	if index, ok := parseIndexURL(indexURL); ok {
+		notice += fmt.Sprintf(" Create the index on %s to filter server-side: %s", index, indexURL)
+	} else if indexURL != "" {
+		notice += " Create the index to filter server-side: " + indexURL
+	}
+	return notice
+}
+
+// missingIndexResponse is the error of a query Firestore rejected for lack of a composite
+// index. It names the index instead of the raw gRPC message, and links the console page
+// creating it from a notice of the panel.
+func missingIndexResponse(prefix, indexURL string) backend.DataResponse {
+	message := prefix + "the query needs a composite index that doesn't exist"
+	if index, ok := parseIndexURL(indexURL); ok {
+		message = fmt.Sprintf("%sthe query needs a composite index on %s that doesn't exist", prefix, index)
+	}
+	message += ". Create it in the Firebase console: " + indexURL
+
+	response := backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, message)
+	frame := data.NewFrame("")
+	frame.AppendNotices(data.Notice{Severity: data.NoticeSeverityError, Text: "Create the missing composite index", Link: indexURL})
+	response.Frames = data.Frames{frame}
+	return response
+}
+
+// compositeIndex is the index a missing index error asks for
+type compositeIndex struct {
+	Collection string
+	Fields     []string
+}
+
+// String formats the index as collection (field ORDER, ...)
+func (i compositeIndex) String() string {
+	return fmt.Sprintf("%s (%s)", i.Collection, strings.Join(i.Fields, ", "))
+}
+
+// parseIndexURL decodes the index in the create_composite parameter of an index creation
+// link, a base64 encoded google.firestore.admin.v1.Index message
+func parseIndexURL(indexURL string) (compositeIndex, bool) {
+	parsed, err := url.Parse(indexURL)
+	if err != nil {
+		return compositeIndex{}, false
+	}
+	encoded := strings.TrimRight(parsed.Query().Get("create_composite"), "=")
+	if encoded == "" {
+		return compositeIndex{}, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		if raw, err = base64.RawStdEncoding.DecodeString(encoded); err != nil {
+			return compositeIndex{}, false
+		}
+	}
+	var index adminpb.Index
+	if err := proto.Unmarshal(raw, &index); err != nil {
+		return compositeIndex{}, false
+	}
+
+	// The name is projects/{project}/databases/{database}/collectionGroups/{collection}/indexes/_
+	var result compositeIndex
+	segments := strings.Split(index.GetName(), "/")
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i] == "collectionGroups" {
+			result.Collection = segments[i+1]
+		}
+	}
+	for _, field := range index.GetFields() {
+		switch {
+		case field.GetOrder() == adminpb.Index_IndexField_DESCENDING:
+			result.Fields = append(result.Fields, field.GetFieldPath()+" DESC")
+		case field.GetArrayConfig() == adminpb.Index_IndexField_CONTAINS:
+			result.Fields = append(result.Fields, field.GetFieldPath()+" ARRAY_CONTAINS")
+		default:
+			result.Fields = append(result.Fields, field.GetFieldPath()+" ASC")
+		}
+	}
+	return result, result.Collection != "" && len(result.Fields) > 0
+}
@@ -0,0 +1,129 @@
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/firestore/apiv1/admin/adminpb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFirestoreErrorResponse(t *testing.T) {
+	tests := []struct {
+		err        error
+		status     backend.Status
+		downstream bool
+	}{
+		{status.Error(codes.PermissionDenied, "denied"), backend.StatusForbidden, true},
+		{status.Error(codes.FailedPrecondition, "index"), backend.StatusBadRequest, true},
+		{status.Error(codes.DeadlineExceeded, "slow"), backend.StatusTimeout, true},
+		{status.Error(codes.ResourceExhausted, "quota"), backend.StatusTooManyRequests, true},
+		{fmt.Errorf("query execution stopped: %w", context.DeadlineExceeded), backend.StatusTimeout, true},
+		{fmt.Errorf("query stopped: %w", context.Canceled), statusClientClosedRequest, true},
+		{status.Error(codes.Canceled, "canceled"), statusClientClosedRequest, true},
+		{status.Error(codes.NotFound, "database not found"), backend.StatusNotFound, true},
+		{status.Error(codes.Unauthenticated, "expired token"), backend.StatusUnauthorized, true},
+		{status.Error(codes.Unavailable, "unavailable"), http.StatusServiceUnavailable, true},
+		{status.Error(codes.Internal, "internal"), backend.StatusBadGateway, true},
+		{status.Error(codes.InvalidArgument, "invalid filter"), backend.StatusBadRequest, false},
+		{fmt.Errorf("fireql: %w", status.Error(codes.PermissionDenied, "denied")), backend.StatusForbidden, true},
+		{errors.New("rpc error: code = ResourceExhausted desc = quota exceeded"), backend.StatusTooManyRequests, true},
+		{errors.New("parse error"), backend.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		response := firestoreErrorResponse("Native query: ", tt.err)
+		require.Equal(t, tt.status, response.Status)
+		require.Equal(t, tt.downstream, response.ErrorSource == backend.ErrorSourceDownstream)
+		require.Contains(t, response.Error.Error(), "Native query: ")
+	}
+}
+
+func TestHTTPStatusOf(t *testing.T) {
+	require.Equal(t, http.StatusForbidden, httpStatusOf(status.Error(codes.PermissionDenied, "denied")))
+	require.Equal(t, http.StatusGatewayTimeout, httpStatusOf(fmt.Errorf("schema sample: %w", context.DeadlineExceeded)))
+	require.Equal(t, http.StatusBadGateway, httpStatusOf(errors.New("connection reset")))
+}
+
+func TestMissingIndexURL(t *testing.T) {
+	indexURL := "https://console.firebase.google.com/v1/r/project/demo/firestore/indexes?create_composite=abc"
+
+	url, missing := missingIndexURL(status.Error(codes.FailedPrecondition, "The query requires an index. You can create it here: "+indexURL))
+	require.True(t, missing)
+	require.Equal(t, indexURL, url)
+	require.Contains(t, missingIndexNotice(url), indexURL)
+
+	url, missing = missingIndexURL(status.Error(codes.FailedPrecondition, "The query requires an index."))
+	require.True(t, missing)
+	require.Empty(t, url)
+
+	_, missing = missingIndexURL(status.Error(codes.PermissionDenied, "denied"))
+	require.False(t, missing)
+	_, missing = missingIndexURL(nil)
+	require.False(t, missing)
+}
+
+// testIndexURL builds an index creation link the way Firestore does
+func testIndexURL(t *testing.T) string {
+	raw, err := proto.Marshal(&adminpb.Index{
+		Name: "projects/demo/databases/(default)/collectionGroups/orders/indexes/_",
+		Fields: []*adminpb.Index_IndexField{
+			{FieldPath: "status", ValueMode: &adminpb.Index_IndexField_Order_{Order: adminpb.Index_IndexField_ASCENDING}},
+			{FieldPath: "createdAt", ValueMode: &adminpb.Index_IndexField_Order_{Order: adminpb.Index_IndexField_DESCENDING}},
+			{FieldPath: "tags", ValueMode: &adminpb.Index_IndexField_ArrayConfig_{ArrayConfig: adminpb.Index_IndexField_CONTAINS}},
+		},
+	})
+	require.NoError(t, err)
+	return "https://console.firebase.google.com/v1/r/project/demo/firestore/indexes?create_composite=" + base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func TestParseIndexURL(t *testing.T) {
+	index, ok := parseIndexURL(testIndexURL(t))
+	require.True(t, ok)
+	require.Equal(t, "orders", index.Collection)
+	require.Equal(t, []string{"status ASC", "createdAt DESC", "tags ARRAY_CONTAINS"}, index.Fields)
+	require.Equal(t, "orders (status ASC, createdAt DESC, tags ARRAY_CONTAINS)", index.String())
+
+	_, ok = parseIndexURL("https://console.firebase.google.com/v1/r/project/demo/firestore/indexes?create_composite=abc")
+	require.False(t, ok)
+	_, ok = parseIndexURL("")
+	require.False(t, ok)
+}
+
+func TestMissingIndexResponse(t *testing.T) {
+	indexURL := testIndexURL(t)
+	response := firestoreErrorResponse("Native query: ", status.Error(codes.FailedPrecondition, "The query requires an index. You can create it here: "+indexURL))
+	require.Equal(t, backend.StatusBadRequest, response.Status)
+	require.Equal(t, backend.ErrorSourceDownstream, response.ErrorSource)
+	require.Equal(t, "Native query: the query needs a composite index on orders (status ASC, createdAt DESC, tags ARRAY_CONTAINS) that doesn't exist. Create it in the Firebase console: "+indexURL, response.Error.Error())
+	require.Len(t, response.Frames, 1)
+	require.Equal(t, indexURL, response.Frames[0].Meta.Notices[0].Link)
+	require.Equal(t, data.NoticeSeverityError, response.Frames[0].Meta.Notices[0].Severity)
+
+	require.Contains(t, missingIndexNotice(indexURL), "orders (status ASC, createdAt DESC, tags ARRAY_CONTAINS)")
+}
+
+func TestDatastoreMode(t *testing.T) {
+	err := status.Error(codes.FailedPrecondition, "The Cloud Firestore API is not available for Firestore in Datastore Mode database projects/demo/databases/(default).")
+	require.True(t, isDatastoreMode(err))
+	require.True(t, isDatastoreMode(fmt.Errorf("fireql: %v", err)))
+	require.False(t, isDatastoreMode(status.Error(codes.FailedPrecondition, "The query requires an index.")))
+	require.False(t, isDatastoreMode(status.Error(codes.NotFound, "datastore mode")))
+	require.False(t, isDatastoreMode(nil))
+
+	_, missing := missingIndexURL(err)
+	require.False(t, missing)
+
+	response := firestoreErrorResponse("Native query: ", err)
+	require.Equal(t, backend.StatusBadRequest, response.Status)
+	require.Equal(t, "Native query: "+errDatastoreMode.Error(), response.Error.Error())
+}
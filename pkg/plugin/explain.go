@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// explainQuery profiles a native query with Firestore Query Explain and returns the plan,
+// the indexes used and the execution stats as a frame instead of the results. The query is
+// executed, so its reads are billed like a normal run.
+func (d *Datasource) explainQuery(ctx context.Context, query firestore.Query, pushdown, inMemory []string, meta *queryMeta) backend.DataResponse {
+	var metrics *firestore.ExplainMetrics
+	err := d.withRetries(ctx, "query explain", func() error {
+		it := query.WithRunOptions(firestore.ExplainOptions{Analyze: true}).Documents(ctx)
+		if _, err := it.GetAll(); err != nil {
+			return err
+		}
+		var err error
+		metrics, err = it.ExplainMetrics()
+		return err
+	})
+	if err != nil {
+		d.logger(ctx).Error("Query explain failed", "error", err)
+		return firestoreErrorResponse("Query explain: ", err)
+	}
+	if metrics.ExecutionStats != nil {
+		meta.addDocumentsRead(routeNative, int(metrics.ExecutionStats.ReadOperations))
+	}
+
+	frame := explainFrame(metrics, pushdown, inMemory)
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// explainFrame lists the steps of a query plan and its explain metrics as metric/value rows
+func explainFrame(metrics *firestore.ExplainMetrics, pushdown, inMemory []string) *data.Frame {
+	var names, values []string
+	add := func(name string, value interface{}) {
+		names = append(names, name)
+		values = append(values, fmt.Sprintf("%v", value))
+	}
+
+	for _, step := range pushdown {
+		add("plan", step)
+	}
+	for _, step := range inMemory {
+		add("in memory", step)
+	}
+	if metrics != nil && metrics.PlanSummary != nil {
+		for _, index := range metrics.PlanSummary.IndexesUsed {
+			if index == nil {
+				continue
+			}
+			add("index used", fmt.Sprintf("%v %v", (*index)["query_scope"], (*index)["properties"]))
+		}
+	}
+	if metrics != nil && metrics.ExecutionStats != nil {
+		stats := metrics.ExecutionStats
+		add("results returned", stats.ResultsReturned)
+		add("read operations", stats.ReadOperations)
+		if stats.ExecutionDuration != nil {
+			add("execution duration", stats.ExecutionDuration.String())
+		}
+		if stats.DebugStats != nil {
+			debugStats := flattenDebugStats("", *stats.DebugStats)
+			keys := make([]string, 0, len(debugStats))
+			for key := range debugStats {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				add(key, debugStats[key])
+			}
+		}
+	}
+
+	return data.NewFrame("explain",
+		data.NewField("metric", nil, names),
+		data.NewField("value", nil, values),
+	)
+}
+
+// flattenDebugStats flattens the nested debug stats, e.g. billing_details.documents_billable
+func flattenDebugStats(prefix string, stats map[string]any) map[string]any {
+	flat := make(map[string]any)
+	for key, value := range stats {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]any); ok {
+			for nestedKey, nestedValue := range flattenDebugStats(key, nested) {
+				flat[nestedKey] = nestedValue
+			}
+			continue
+		}
+		flat[key] = value
+	}
+	return flat
+}
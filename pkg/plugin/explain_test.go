@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainFrame(t *testing.T) {
+	duration := 12 * time.Millisecond
+	metrics := &firestore.ExplainMetrics{
+		PlanSummary: &firestore.PlanSummary{
+			IndexesUsed: []*map[string]any{{"query_scope": "Collection", "properties": "(status ASC, __name__ ASC)"}},
+		},
+		ExecutionStats: &firestore.ExecutionStats{
+			ResultsReturned:   3,
+			ReadOperations:    3,
+			ExecutionDuration: &duration,
+			DebugStats: &map[string]any{
+				"documents_scanned": "20",
+				"billing_details":   map[string]any{"documents_billable": "3"},
+			},
+		},
+	}
+
+	frame := explainFrame(metrics, []string{"collection(users)", "where(status == active)"}, []string{"limit(10)"})
+	require.Equal(t, "explain", frame.Name)
+	rows := make(map[string][]string)
+	for i := 0; i < frame.Rows(); i++ {
+		name := frame.Fields[0].At(i).(string)
+		rows[name] = append(rows[name], frame.Fields[1].At(i).(string))
+	}
+	require.Equal(t, []string{"collection(users)", "where(status == active)"}, rows["plan"])
+	require.Equal(t, []string{"limit(10)"}, rows["in memory"])
+	require.Equal(t, []string{"Collection (status ASC, __name__ ASC)"}, rows["index used"])
+	require.Equal(t, []string{"3"}, rows["read operations"])
+	require.Equal(t, []string{"12ms"}, rows["execution duration"])
+	require.Equal(t, []string{"20"}, rows["documents_scanned"])
+	require.Equal(t, []string{"3"}, rows["billing_details.documents_billable"])
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// selectExpression is a boolean column computed from a comparison of a field with a literal,
+// such as amount > 0 AS has_amount
+type selectExpression struct {
+	// Alias is the column of the results, the expression itself without AS
+	Alias string
+
+	// Condition is the comparison, evaluated like a WHERE condition filtered in memory
+	Condition FilterInfo
+}
+
+// String renders the expression for ExecutedQueryString
+func (e selectExpression) String() string {
+	return fmt.Sprintf("select(%s %s %v as %s)", e.Condition.Field, e.Condition.Operator, e.Condition.Value, e.Alias)
+}
+
+// parseSelectExpression parses a SELECT expression like amount > 0 AS has_amount.
+// isExpression is false for other expressions.
+func parseSelectExpression(field string) (expression selectExpression, isExpression bool) {
+	expr := stripAlias(field)
+	condition, ok := parseCondition(expr)
+	if !ok {
+		return selectExpression{}, false
+	}
+	expression = selectExpression{Alias: expr, Condition: condition}
+	if idx := strings.LastIndex(strings.ToUpper(field), " AS "); idx != -1 {
+		expression.Alias = cleanBackticks(field[idx+4:])
+	}
+	return expression, true
+}
+
+// validateExpressions checks that the query reads the computed columns after computing
+// them, WHERE and the ORDER BY of documents apply to the fields Firestore stores
+func validateExpressions(info *QueryInfo) error {
+	for _, filter := range info.AdditionalFilters {
+		if info.isExpression(filter.Field) {
+			return fmt.Errorf("WHERE %s: the column is computed after WHERE, compare the field it reads or filter on %s in an outer query", filter.Field, filter.Field)
+		}
+	}
+	if len(info.GroupByFields) > 0 || len(info.AggregateFields) > 0 {
+		return nil
+	}
+	for _, key := range info.OrderBy {
+		if info.isExpression(key.Field) {
+			return fmt.Errorf("ORDER BY %s: the column is computed after ORDER BY, order by %s in an outer query", key.Field, key.Field)
+		}
+	}
+	return nil
+}
+
+// isExpression reports whether a column is computed by a SELECT expression
+func (info *QueryInfo) isExpression(column string) bool {
+	for _, expression := range info.Expressions {
+		if expression.Alias == column {
+			return true
+		}
+	}
+	return false
+}
+
+// isComputed reports whether a column is computed in memory rather than read from the
+// documents, the numbers of a ranking function or a SELECT expression
+func (info *QueryInfo) isComputed(column string) bool {
+	return info.isRank(column) || info.isExpression(column)
+}
+
+// applyExpressions sets the columns of the SELECT expressions on a row. A comparison of a
+// missing or null field is null, as in SQL, rather than false.
+func applyExpressions(row map[string]interface{}, expressions []selectExpression) {
+	for _, expression := range expressions {
+		value := selectFieldValue(row, expression.Condition.Field)
+		if value == nil {
+			row[expression.Alias] = nil
+			continue
+		}
+		row[expression.Alias] = filterMatches(value, expression.Condition)
+	}
+}
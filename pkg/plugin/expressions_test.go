@@ -0,0 +1,80 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelectExpression(t *testing.T) {
+	tests := []struct {
+		field        string
+		expression   selectExpression
+		isExpression bool
+	}{
+		{"amount > 0 AS has_amount", selectExpression{Alias: "has_amount", Condition: FilterInfo{Field: "amount", Operator: ">", Value: int64(0)}}, true},
+		{"status = 'paid' as `paid`", selectExpression{Alias: "paid", Condition: FilterInfo{Field: "status", Operator: "==", Value: "paid"}}, true},
+		{"client.tier <> 3", selectExpression{Alias: "client.tier <> 3", Condition: FilterInfo{Field: "client.tier", Operator: "!=", Value: int64(3)}}, true},
+		{"amount", selectExpression{}, false},
+		{"id AS uid", selectExpression{}, false},
+		{"COUNT(*) > 1", selectExpression{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			expression, isExpression := parseSelectExpression(tt.field)
+			require.Equal(t, tt.isExpression, isExpression)
+			require.Equal(t, tt.expression, expression)
+		})
+	}
+}
+
+func TestParseSelectExpressionQuery(t *testing.T) {
+	info, err := parseSQLQueryWithVariables("SELECT msisdn, amount > 0 AS has_amount FROM orders WHERE status = 'open'")
+	require.NoError(t, err)
+	require.Equal(t, []string{"msisdn", "has_amount"}, info.Fields)
+	require.Len(t, info.Expressions, 1)
+	require.Equal(t, []string{"msisdn", "status", "amount"}, projectionFields(info, FirestoreQuery{}))
+
+	_, err = parseSQLQueryWithVariables("SELECT amount > 0 AS has_amount FROM orders WHERE has_amount = true")
+	require.ErrorContains(t, err, "computed after WHERE")
+	_, err = parseSQLQueryWithVariables("SELECT amount > 0 AS has_amount FROM orders ORDER BY has_amount")
+	require.ErrorContains(t, err, "computed after ORDER BY")
+	_, err = parseSQLQueryWithVariables("SELECT amount > 0 AS has_amount, COUNT(*) AS n FROM orders GROUP BY has_amount ORDER BY has_amount")
+	require.NoError(t, err)
+}
+
+func TestSelectExpressionRows(t *testing.T) {
+	rows := func() []map[string]interface{} {
+		return []map[string]interface{}{
+			{"msisdn": "600", "amount": int64(12)},
+			{"msisdn": "601", "amount": 0.0},
+			{"msisdn": "602"},
+			{"msisdn": "603", "amount": 3.5},
+		}
+	}
+	ctx := context.Background()
+
+	info, err := parseSQLQueryWithVariables("SELECT msisdn, amount > 0 AS has_amount FROM orders")
+	require.NoError(t, err)
+	response := (&Datasource{}).evalRows(ctx, rows(), documentColumns, false, info, FirestoreQuery{MaxRows: 100}, &queryMeta{})
+	require.NoError(t, response.Error)
+	field := response.Frames[0].Fields[1]
+	require.Equal(t, "has_amount", field.Name)
+	require.Equal(t, data.FieldTypeNullableBool, field.Type())
+	for i, want := range []*bool{ptr(true), ptr(false), nil, ptr(true)} {
+		require.Equal(t, want, field.At(i))
+	}
+
+	info, err = parseSQLQueryWithVariables("SELECT amount > 0 AS has_amount, COUNT(*) AS n FROM orders GROUP BY has_amount ORDER BY n DESC")
+	require.NoError(t, err)
+	response = (&Datasource{}).aggregateRows(ctx, rows(), info, FirestoreQuery{})
+	require.NoError(t, response.Error)
+	frame := response.Frames[0]
+	require.Equal(t, 3, frame.Rows())
+	require.Equal(t, "true", frame.Fields[0].At(0))
+	require.Equal(t, int64(2), frame.Fields[1].At(0))
+	require.Equal(t, int64(1), frame.Fields[1].At(1))
+}
@@ -0,0 +1,187 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// fanOutMaxGroups is the most group values aggregated with one server-side query each
+	fanOutMaxGroups = 20
+	// fanOutConcurrency is the number of group aggregation queries run at once
+	fanOutConcurrency = 8
+	// aggregationMaxFunctions is the most aggregations Firestore runs in one query
+	aggregationMaxFunctions = 5
+)
+
+// fanOutEligible reports whether a GROUP BY query can be aggregated server-side with one
+// aggregation query per group value: a single group field, and only COUNT(*), SUM and AVG,
+// the aggregations Firestore computes
+func fanOutEligible(queryInfo *QueryInfo, qm FirestoreQuery) bool {
+	if len(queryInfo.GroupByFields) != 1 || queryInfo.TimeBucketField != "" {
+		return false
+	}
+	groupField := queryInfo.GroupByFields[0]
+	if isMetadataColumn(groupField) || queryInfo.isExpression(groupField) || groupField == queryInfo.TimeField || groupField == qm.TimeField {
+		return false
+	}
+	// The count of each group is always requested to verify the groups are complete
+	if len(queryInfo.AggregateFields) == 0 || len(queryInfo.AggregateFields)+1 > aggregationMaxFunctions {
+		return false
+	}
+	for _, aggField := range queryInfo.AggregateFields {
+		switch {
+		case aggField.Function == "COUNT" && aggField.Field == "*":
+		case (aggField.Function == "SUM" || aggField.Function == "AVG") && fieldPathPattern.MatchString(aggField.Field):
+		default:
+			return false
+		}
+	}
+	for _, filter := range queryInfo.AdditionalFilters {
+		if queryInfo.filteredInMemory(filter) {
+			return false
+		}
+	}
+	return qm.ReadTime == "" && !qm.Explain && qm.Format != formatLogs
+}
+
+// fanOutGroupValues returns the known values of the GROUP BY field: the groupValues of the
+// query, typically a multi-value variable, or the values the collection schema sampled.
+// nil when they aren't known or there are too many.
+func (d *Datasource) fanOutGroupValues(ctx context.Context, queryInfo *QueryInfo, qm FirestoreQuery) []interface{} {
+	schema := d.collectionSchema(ctx, queryInfo.Collection)
+
+	// Firestore only sums numbers, numeric strings are aggregated in memory
+	for _, aggField := range queryInfo.AggregateFields {
+		if field, ok := schema.field(aggField.Field); ok && field.kind != kindInt && field.kind != kindFloat {
+			return nil
+		}
+	}
+
+	var values []interface{}
+	if qm.GroupValues != "" {
+		for _, value := range splitSQLList(qm.GroupValues) {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, filterLiteral(value))
+			}
+		}
+	} else if field, ok := schema.field(queryInfo.GroupByFields[0]); ok {
+		values = field.Values
+	}
+	if len(values) > fanOutMaxGroups {
+		return nil
+	}
+	return values
+}
+
+// fanOutAggregation aggregates each group value of the query server-side, in parallel. The
+// group counts must add up to the count of the whole query, otherwise some groups are
+// unknown and false is returned so the documents are aggregated in memory instead.
+func (d *Datasource) fanOutAggregation(ctx context.Context, query firestore.Query, queryInfo *QueryInfo, qm FirestoreQuery, groupValues []interface{}, meta *queryMeta) (backend.DataResponse, bool, error) {
+	defer meta.addFetch(time.Now(), 0)
+	groupField := queryInfo.GroupByFields[0]
+	groupResults := make([]firestore.AggregationResult, len(groupValues))
+	var total int64
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(fanOutConcurrency)
+	g.Go(func() error {
+		return d.withRetries(gctx, "group aggregation", func() error {
+			result, err := query.NewAggregationQuery().WithCount(countAlias).Get(gctx)
+			total = aggregationValueInt(result[countAlias])
+			return err
+		})
+	})
+	for i, value := range groupValues {
+		groupQuery := query.Where(groupField, "==", value)
+		aggregation := groupQuery.NewAggregationQuery().WithCount(countAlias)
+		for j, aggField := range queryInfo.AggregateFields {
+			switch aggField.Function {
+			case "SUM":
+				aggregation = aggregation.WithSum(aggField.Field, fanOutAlias(j))
+			case "AVG":
+				aggregation = aggregation.WithAvg(aggField.Field, fanOutAlias(j))
+			}
+		}
+		g.Go(func() error {
+			return d.withRetries(gctx, "group aggregation", func() (err error) {
+				groupResults[i], err = aggregation.Get(gctx)
+				return err
+			})
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return backend.DataResponse{}, false, err
+	}
+
+	// Firestore bills a read per batch of up to 1000 index entries aggregated
+	reads := max(1, (total+999)/1000)
+	var results []AggregatedResult
+	var counted int64
+	for i, groupResult := range groupResults {
+		count := aggregationValueInt(groupResult[countAlias])
+		reads += max(1, (count+999)/1000)
+		if count == 0 {
+			continue
+		}
+		counted += count
+
+		result := AggregatedResult{GroupValues: []interface{}{groupValues[i]}}
+		for j, aggField := range queryInfo.AggregateFields {
+			var value interface{}
+			switch aggField.Function {
+			case "COUNT":
+				value = count
+			case "AVG":
+				// Like computeAggregate, a group without numeric values averages to 0
+				if value = aggregationValue(groupResult[fanOutAlias(j)]); value == nil {
+					value = 0.0
+				}
+			default:
+				value = aggregationValue(groupResult[fanOutAlias(j)])
+			}
+			result.AggregateValues = append(result.AggregateValues, value)
+		}
+		results = append(results, result)
+	}
+	meta.addDocumentsRead(routeNative, int(reads))
+
+	if counted != total {
+		return backend.DataResponse{}, false, nil
+	}
+	return d.aggregatedResultsResponse(ctx, results, queryInfo, qm), true, nil
+}
+
+// fanOutAlias is the alias of the aggregate at an index in a group aggregation query
+func fanOutAlias(index int) string {
+	return fmt.Sprintf("aggregate_%d", index)
+}
+
+// aggregationValue converts a value of an aggregation result
+func aggregationValue(result interface{}) interface{} {
+	value, ok := result.(*firestorepb.Value)
+	if !ok {
+		return nil
+	}
+	switch v := value.GetValueType().(type) {
+	case *firestorepb.Value_IntegerValue:
+		return v.IntegerValue
+	case *firestorepb.Value_DoubleValue:
+		return v.DoubleValue
+	default:
+		return nil
+	}
+}
+
+// aggregationValueInt returns an integer value of an aggregation result, 0 when missing
+func aggregationValueInt(result interface{}) int64 {
+	count, _ := aggregationValue(result).(int64)
+	return count
+}
@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFanOutEligible(t *testing.T) {
+	tests := []struct {
+		query    string
+		qm       FirestoreQuery
+		eligible bool
+	}{
+		{"SELECT brand, COUNT(*) AS total, SUM(amount), AVG(amount) FROM orders WHERE status = 'open' GROUP BY brand", FirestoreQuery{}, true},
+		{"SELECT brand, MAX(amount) FROM orders GROUP BY brand", FirestoreQuery{}, false},
+		{"SELECT brand, status, COUNT(*) FROM orders GROUP BY brand, status", FirestoreQuery{}, false},
+		{"SELECT COUNT(*) FROM orders GROUP BY date_trunc('hour', ts)", FirestoreQuery{}, false},
+		{"SELECT ts, COUNT(*) FROM orders GROUP BY ts", FirestoreQuery{TimeField: "ts"}, false},
+		{"SELECT brand, COUNT(*) FROM orders WHERE __name__ = 'a' GROUP BY brand", FirestoreQuery{}, false},
+		{"SELECT brand FROM orders GROUP BY brand", FirestoreQuery{}, false},
+		{"SELECT brand, COUNT(*), SUM(a), SUM(b), SUM(c), SUM(d) FROM orders GROUP BY brand", FirestoreQuery{}, false},
+		{"SELECT brand, COUNT(*) FROM orders GROUP BY brand", FirestoreQuery{ReadTime: "2024-05-10T11:00:00Z"}, false},
+	}
+
+	for _, tt := range tests {
+		queryInfo, err := parseSQLQueryWithVariables(tt.query)
+		require.NoError(t, err)
+		require.Equal(t, tt.eligible, fanOutEligible(queryInfo, tt.qm), tt.query)
+	}
+}
+
+func TestFanOutGroupValues(t *testing.T) {
+	cache := newSchemaCache(func(ctx context.Context, collection string) (*collectionSchema, error) {
+		return inferSchema(collection, []map[string]interface{}{
+			{"brand": "yoigo", "amount": 1.5},
+			{"brand": "masmovil", "amount": 2.5},
+			{"brand": "yoigo"},
+		}), nil
+	}, time.Hour)
+	defer cache.close()
+	d := &Datasource{schemas: cache}
+	queryInfo := &QueryInfo{Collection: "orders", GroupByFields: []string{"brand"}}
+
+	require.Equal(t, []interface{}{"masmovil", "yoigo"}, d.fanOutGroupValues(context.Background(), queryInfo, FirestoreQuery{}))
+	require.Equal(t, []interface{}{"orange", int64(2), "a,b"}, d.fanOutGroupValues(context.Background(), queryInfo, FirestoreQuery{GroupValues: "orange, 2, 'a,b'"}))
+
+	queryInfo.GroupByFields = []string{"amount"}
+	require.Empty(t, d.fanOutGroupValues(context.Background(), queryInfo, FirestoreQuery{}))
+	require.Empty(t, (&Datasource{}).fanOutGroupValues(context.Background(), queryInfo, FirestoreQuery{}))
+}
+
+func TestDistinctValues(t *testing.T) {
+	require.Equal(t, []interface{}{false, true}, distinctValues(kindBool, []interface{}{true, nil, false, true}))
+	require.Equal(t, []interface{}{int64(1), int64(3)}, distinctValues(kindInt, []interface{}{int64(3), int64(1)}))
+	require.Nil(t, distinctValues(kindFloat, []interface{}{1.5}))
+
+	many := make([]interface{}, schemaMaxValues+1)
+	for i := range many {
+		many[i] = int64(i)
+	}
+	require.Nil(t, distinctValues(kindInt, many))
+}
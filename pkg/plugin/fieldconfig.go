@@ -0,0 +1,133 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// fieldConfigMaxDecimals caps the decimals of a column, as Grafana does
+const fieldConfigMaxDecimals = 20
+
+// FieldOptions are the display settings of a result column, written into the config of its
+// field so a metric renders the same on every dashboard without panel overrides
+type FieldOptions struct {
+	DisplayName string  `json:"displayName,omitempty"`
+	Unit        string  `json:"unit,omitempty"`
+	Decimals    *uint16 `json:"decimals,omitempty"`
+}
+
+// validateFieldConfig checks the fieldConfig query option, keyed by result column, and the
+// decimals query option
+func validateFieldConfig(qm FirestoreQuery) error {
+	if qm.Decimals != nil && *qm.Decimals > fieldConfigMaxDecimals {
+		return fmt.Errorf("decimals must be between 0 and %d", fieldConfigMaxDecimals)
+	}
+	for column, options := range qm.FieldConfig {
+		if column == "" {
+			return fmt.Errorf("fieldConfig: a column name is required")
+		}
+		if options.Decimals != nil && *options.Decimals > fieldConfigMaxDecimals {
+			return fmt.Errorf("fieldConfig: decimals of %s must be between 0 and %d", column, fieldConfigMaxDecimals)
+		}
+	}
+	return nil
+}
+
+// applyFieldConfig sets the display name, unit and decimals of the fields of the columns
+// with options. Every series of a column gets them, so a display name may name its labels as
+// ${__field.labels.host}, and a unit set by a field type override is kept unless the options
+// set another. With the decimals query option the float columns the query computes are
+// rounded as well, the fields read from documents keep the values stored.
+func applyFieldConfig(response backend.DataResponse, qm FirestoreQuery, info *QueryInfo) backend.DataResponse {
+	if response.Error != nil || (len(qm.FieldConfig) == 0 && qm.Decimals == nil) {
+		return response
+	}
+	computed := info.computedColumns()
+	for _, frame := range response.Frames {
+		for _, field := range frame.Fields {
+			options, ok := qm.FieldConfig[field.Name]
+			if qm.Decimals != nil && computed[field.Name] {
+				decimals := qm.Decimals
+				if options.Decimals != nil {
+					decimals = options.Decimals
+				}
+				if roundField(field, *decimals) {
+					options.Decimals = decimals
+					ok = true
+				}
+			}
+			if !ok {
+				continue
+			}
+			config := &data.FieldConfig{}
+			if field.Config != nil {
+				copied := *field.Config
+				config = &copied
+			}
+			if options.DisplayName != "" {
+				config.DisplayName = options.DisplayName
+			}
+			if options.Unit != "" {
+				config.Unit = options.Unit
+			}
+			if options.Decimals != nil {
+				config.Decimals = options.Decimals
+			}
+			field.Config = config
+		}
+	}
+	return response
+}
+
+// computedColumns returns the columns of the aggregates, window functions and SELECT
+// expressions of a query, those the decimals query option rounds
+func (info *QueryInfo) computedColumns() map[string]bool {
+	if info == nil {
+		return nil
+	}
+	columns := make(map[string]bool)
+	for _, aggField := range info.AggregateFields {
+		columns[aggregateFieldName(aggField)] = true
+	}
+	for _, expression := range info.Expressions {
+		columns[expression.Alias] = true
+	}
+	return columns
+}
+
+// roundField rounds the values of a float field to a number of decimals, so sums and
+// averages like 0.30000000000000004 read 0.3. It reports whether the field holds floats.
+func roundField(field *data.Field, decimals uint16) bool {
+	switch field.Type() {
+	case data.FieldTypeFloat64:
+		for i := 0; i < field.Len(); i++ {
+			field.Set(i, roundDecimals(field.At(i).(float64), decimals))
+		}
+	case data.FieldTypeNullableFloat64:
+		for i := 0; i < field.Len(); i++ {
+			if value := field.At(i).(*float64); value != nil {
+				*value = roundDecimals(*value, decimals)
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// roundDecimals rounds a number to a number of decimals through its decimal form, as
+// value*10^decimals adds float artifacts of its own
+func roundDecimals(value float64, decimals uint16) float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return value
+	}
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(value, 'f', int(decimals), 64), 64)
+	if err != nil {
+		return value
+	}
+	return rounded
+}
@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFieldConfig(t *testing.T) {
+	require.NoError(t, validateFieldConfig(FirestoreQuery{}))
+	require.NoError(t, validateFieldConfig(FirestoreQuery{FieldConfig: map[string]FieldOptions{"total": {Unit: "currencyEUR", Decimals: ptr(uint16(2))}}}))
+	require.Error(t, validateFieldConfig(FirestoreQuery{FieldConfig: map[string]FieldOptions{"total": {Decimals: ptr(uint16(21))}}}))
+	require.Error(t, validateFieldConfig(FirestoreQuery{FieldConfig: map[string]FieldOptions{"": {Unit: "s"}}}))
+	require.NoError(t, validateFieldConfig(FirestoreQuery{Decimals: ptr(uint16(0))}))
+	require.Error(t, validateFieldConfig(FirestoreQuery{Decimals: ptr(uint16(21))}))
+}
+
+func TestApplyFieldConfig(t *testing.T) {
+	var qm FirestoreQuery
+	require.NoError(t, json.Unmarshal([]byte(`{"fieldConfig": {
+		"total": {"displayName": "Total ${__field.labels.brand}", "unit": "currencyEUR", "decimals": 2},
+		"duration": {"decimals": 1}
+	}}`), &qm))
+
+	duration := data.NewField("duration", nil, []float64{1.5})
+	duration.SetConfig(&data.FieldConfig{Unit: "s"})
+	frame := data.NewFrame("response",
+		data.NewField("total", data.Labels{"brand": "a"}, []float64{1}),
+		data.NewField("total", data.Labels{"brand": "b"}, []float64{2}),
+		duration,
+		data.NewField("brand", nil, []string{"a"}),
+	)
+
+	response := applyFieldConfig(backend.DataResponse{Frames: data.Frames{frame}}, qm, nil)
+	for _, field := range response.Frames[0].Fields[:2] {
+		require.Equal(t, "Total ${__field.labels.brand}", field.Config.DisplayName)
+		require.Equal(t, "currencyEUR", field.Config.Unit)
+		require.Equal(t, uint16(2), *field.Config.Decimals)
+	}
+	require.Equal(t, "s", response.Frames[0].Fields[2].Config.Unit)
+	require.Equal(t, uint16(1), *response.Frames[0].Fields[2].Config.Decimals)
+	require.Nil(t, response.Frames[0].Fields[3].Config)
+}
+
+func TestApplyDecimals(t *testing.T) {
+	var qm FirestoreQuery
+	require.NoError(t, json.Unmarshal([]byte(`{"decimals": 2, "fieldConfig": {"ratio": {"decimals": 3}}}`), &qm))
+
+	info, err := parseSQLQueryWithVariables("SELECT AVG(amount) AS avg, SUM(share) AS ratio, COUNT(*) AS count, MAX(price) FROM orders GROUP BY price")
+	require.NoError(t, err)
+
+	avg := 0.1 + 0.2
+	frame := data.NewFrame("response",
+		data.NewField("avg", nil, []float64{0.1 + 0.2, -2.499, 1e300}),
+		data.NewField("ratio", nil, []*float64{&avg, nil, ptr(2.0 / 3)}),
+		data.NewField("count", nil, []int64{3, 1, 2}),
+		data.NewField("price", nil, []float64{0.1 + 0.2, 1, 2}),
+	)
+
+	response := applyFieldConfig(backend.DataResponse{Frames: data.Frames{frame}}, qm, info)
+	fields := response.Frames[0].Fields
+	require.Equal(t, []interface{}{0.3, -2.5, 1e300}, []interface{}{fields[0].At(0), fields[0].At(1), fields[0].At(2)})
+	require.Equal(t, uint16(2), *fields[0].Config.Decimals)
+	require.Equal(t, 0.3, *fields[1].At(0).(*float64))
+	require.Nil(t, fields[1].At(1))
+	require.Equal(t, 0.667, *fields[1].At(2).(*float64))
+	require.Equal(t, uint16(3), *fields[1].Config.Decimals)
+	require.Nil(t, fields[2].Config)
+	require.Equal(t, int64(3), fields[2].At(0))
+
+	// The group column is read from the documents, only the aggregate is rounded
+	require.Nil(t, fields[3].Config)
+	require.Equal(t, 0.1+0.2, fields[3].At(0))
+	require.True(t, info.computedColumns()["max"])
+}
+
+func TestRoundDecimals(t *testing.T) {
+	require.Equal(t, 0.3, roundDecimals(0.1+0.2, 2))
+	require.Equal(t, 3.0, roundDecimals(2.6, 0))
+	require.Equal(t, 0.1, roundDecimals(0.1, 20))
+	require.True(t, math.IsNaN(roundDecimals(math.NaN(), 2)))
+	require.Equal(t, math.Inf(1), roundDecimals(math.Inf(1), 2))
+}
@@ -0,0 +1,250 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Types of the fieldTypes setting besides the time formats, which read the field as a time
+const (
+	fieldTypeString       = "string"
+	fieldTypeNumber       = "number"
+	fieldTypeBoolean      = "boolean"
+	fieldTypeJSON         = "json"
+	fieldTypeSeconds      = "seconds"
+	fieldTypeMilliseconds = "milliseconds"
+)
+
+// fieldTypeAliases are alternative names of the time formats accepted in the fieldTypes setting
+var fieldTypeAliases = map[string]string{
+	"unix_millis":  timeFormatUnixMillis,
+	"unix_seconds": timeFormatUnixSeconds,
+	"unix_micros":  timeFormatUnixMicros,
+	"unix_nanos":   timeFormatUnixNanos,
+	"time":         timeFormatTimestamp,
+}
+
+// normalizeFieldType returns the canonical name of a field type, empty when it isn't one
+func normalizeFieldType(fieldType string) string {
+	fieldType = strings.ToLower(strings.TrimSpace(fieldType))
+	if alias, ok := fieldTypeAliases[fieldType]; ok {
+		return alias
+	}
+	switch fieldType {
+	case fieldTypeString, fieldTypeNumber, fieldTypeBoolean, fieldTypeJSON, fieldTypeSeconds, fieldTypeMilliseconds:
+		return fieldType
+	}
+	if isTimeFieldType(fieldType) {
+		return fieldType
+	}
+	return ""
+}
+
+// isTimeFieldType reports whether a field type reads the field as a time
+func isTimeFieldType(fieldType string) bool {
+	return validateTimeFormat(fieldType) == nil && fieldType != ""
+}
+
+// validateFieldTypes checks the fieldTypes setting, which maps field paths, or collection
+// IDs and field paths as collection:field, to the type their values are read as
+func validateFieldTypes(fieldTypes map[string]string) error {
+	for key, fieldType := range fieldTypes {
+		field := key
+		collection, scopedField, scoped := strings.Cut(key, ":")
+		if scoped {
+			field = scopedField
+		}
+		if scoped && (collection == "" || strings.Contains(collection, "/")) || !fieldPathPattern.MatchString(field) {
+			return fmt.Errorf("fieldTypes: invalid field %q, expected a field path or collection:field", key)
+		}
+		if normalizeFieldType(fieldType) == "" {
+			return fmt.Errorf("fieldTypes: invalid type %q of %s, expected string, number, boolean, json, seconds, milliseconds or a time format", fieldType, key)
+		}
+	}
+	return nil
+}
+
+// collectionFieldTypes returns the field types of the columns of a collection. A key naming
+// the collection ID before a colon, such as sessions:duration, applies to that collection and
+// wins over a field path key, such as payload or client.tier, which applies to every
+// collection.
+func (s *FirestoreSettings) collectionFieldTypes(collection string) map[string]string {
+	if len(s.FieldTypes) == 0 {
+		return nil
+	}
+	collection = strings.Trim(cleanBackticks(collection), "/")
+	id := collection[strings.LastIndex(collection, "/")+1:]
+
+	types := make(map[string]string)
+	for key, fieldType := range s.FieldTypes {
+		if !strings.Contains(key, ":") {
+			types[key] = normalizeFieldType(fieldType)
+		}
+	}
+	// Written last, so a collection-specific key isn't overwritten by a generic one
+	for key, fieldType := range s.FieldTypes {
+		if scope, field, ok := strings.Cut(key, ":"); ok && scope == id && id != "" {
+			types[field] = normalizeFieldType(fieldType)
+		}
+	}
+	return types
+}
+
+// convertFieldValue reads a value as a field type, nil when it can't be
+func convertFieldValue(value interface{}, fieldType string, loc *time.Location) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch fieldType {
+	case fieldTypeString:
+		return stringValue(value)
+	case fieldTypeNumber, fieldTypeSeconds, fieldTypeMilliseconds:
+		if b, ok := value.(bool); ok {
+			if b {
+				return 1.0
+			}
+			return 0.0
+		}
+		if n, err := convertToFloat(value); err == nil {
+			return n
+		}
+		return nil
+	case fieldTypeBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return nil
+			}
+			return b
+		default:
+			n, err := convertToFloat(v)
+			if err != nil {
+				return nil
+			}
+			return n != 0
+		}
+	case fieldTypeJSON:
+		// JSON stored as a string is decoded, so the column holds the document it encodes
+		if str, ok := value.(string); ok {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(str), &decoded); err != nil {
+				return nil
+			}
+			return decoded
+		}
+		return value
+	default:
+		if ts, ok := toTime(value, fieldType, loc); ok {
+			return ts
+		}
+		return nil
+	}
+}
+
+// overrideField builds the frame field of a column with a type override. Durations are
+// numbers with their unit, and JSON columns stay JSON even when every value is a scalar.
+func overrideField(name string, values []interface{}, fieldType string, loc *time.Location) *data.Field {
+	converted := make([]interface{}, len(values))
+	for i, value := range values {
+		converted[i] = convertFieldValue(value, fieldType, loc)
+	}
+
+	var field *data.Field
+	switch {
+	case fieldType == fieldTypeString:
+		field = data.NewField(name, nil, nullableValues[string](converted))
+	case fieldType == fieldTypeBoolean:
+		field = data.NewField(name, nil, nullableValues[bool](converted))
+	case fieldType == fieldTypeJSON:
+		field = data.NewField(name, nil, jsonValues(converted))
+	case isTimeFieldType(fieldType):
+		field = data.NewField(name, nil, nullableValues[time.Time](converted))
+	default:
+		field = data.NewField(name, nil, nullableValues[float64](converted))
+	}
+
+	if unit := fieldTypeUnit(fieldType); unit != "" {
+		field.SetConfig(&data.FieldConfig{Unit: unit})
+	}
+	return field
+}
+
+// fieldTypeUnit returns the Grafana unit of the numbers of a duration field type
+func fieldTypeUnit(fieldType string) string {
+	switch fieldType {
+	case fieldTypeSeconds:
+		return "s"
+	case fieldTypeMilliseconds:
+		return "ms"
+	}
+	return ""
+}
+
+// typedGroupFields returns the GROUP BY and aggregated fields of a query with a type override
+func typedGroupFields(queryInfo *QueryInfo) []string {
+	if len(queryInfo.FieldTypes) == 0 {
+		return nil
+	}
+	var fields []string
+	add := func(field string) {
+		if _, ok := queryInfo.FieldTypes[field]; ok && !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	for _, field := range queryInfo.GroupByFields {
+		add(field)
+	}
+	for _, aggField := range queryInfo.AggregateFields {
+		add(aggField.Field)
+	}
+	return fields
+}
+
+// applyFieldTypes reads the fields of a row with a type override as their type, so a row
+// is grouped and aggregated on the values its frame column would hold
+func applyFieldTypes(row map[string]interface{}, fields []string, queryInfo *QueryInfo) {
+	for _, field := range fields {
+		value := selectFieldValue(row, field)
+		if value == nil {
+			continue
+		}
+		setFieldValue(row, field, convertFieldValue(value, queryInfo.FieldTypes[field], queryInfo.Location))
+	}
+}
+
+// setFieldValue replaces the value of a field of a row, a top-level key or a dot-notation path
+func setFieldValue(row map[string]interface{}, field string, value interface{}) {
+	if _, exists := row[field]; exists || !strings.Contains(field, ".") {
+		row[field] = value
+		return
+	}
+	parts := strings.Split(field, ".")
+	current := row
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+}
+
+// setAggregateUnit gives the SUM, AVG, MIN or MAX of a duration field the unit of its type
+func setAggregateUnit(field *data.Field, aggField AggregateInfo, fieldTypes map[string]string) {
+	switch aggField.Function {
+	case "SUM", "AVG", "MIN", "MAX":
+		if unit := fieldTypeUnit(fieldTypes[aggField.Field]); unit != "" {
+			field.SetConfig(&data.FieldConfig{Unit: unit})
+		}
+	}
+}
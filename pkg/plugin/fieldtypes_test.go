@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFieldTypes(t *testing.T) {
+	require.NoError(t, validateFieldTypes(nil))
+	require.NoError(t, validateFieldTypes(map[string]string{"sessions:duration": "seconds", "events:ts": "unix_millis", "payload": "json", "client.tier": "Number"}))
+	require.Error(t, validateFieldTypes(map[string]string{"events:ts": "date"}))
+	require.Error(t, validateFieldTypes(map[string]string{"events ts": "string"}))
+	require.Error(t, validateFieldTypes(map[string]string{":ts": "string"}))
+	require.Error(t, validateFieldTypes(map[string]string{"customers/events:ts": "string"}))
+}
+
+func TestCollectionFieldTypes(t *testing.T) {
+	settings := &FirestoreSettings{FieldTypes: map[string]string{
+		"sessions:duration": "seconds",
+		"events:ts":         "unix_millis",
+		"payload":           "json",
+		"duration":          "milliseconds",
+		"sessions.count":    "number",
+	}}
+	types := settings.collectionFieldTypes("sessions")
+	require.Equal(t, fieldTypeSeconds, types["duration"])
+	require.Equal(t, fieldTypeJSON, types["payload"])
+	require.Empty(t, types["ts"])
+	// A field path starting with a collection ID is a nested field, not a collection key
+	require.Empty(t, types["count"])
+	require.Equal(t, fieldTypeNumber, types["sessions.count"])
+
+	types = settings.collectionFieldTypes("customers/ACME/events")
+	require.Equal(t, timeFormatUnixMillis, types["ts"])
+	require.Equal(t, fieldTypeMilliseconds, types["duration"])
+	require.NotContains(t, types, "events:ts")
+
+	require.Nil(t, (&FirestoreSettings{}).collectionFieldTypes("events"))
+}
+
+func TestConvertFieldValue(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		value     interface{}
+		fieldType string
+		expected  interface{}
+	}{
+		{"string of number", int64(42), fieldTypeString, "42"},
+		{"number of string", "1.5", fieldTypeNumber, 1.5},
+		{"number of int", int64(3), fieldTypeSeconds, 3.0},
+		{"number of bool", true, fieldTypeNumber, 1.0},
+		{"invalid number", "n/a", fieldTypeNumber, nil},
+		{"boolean of string", "true", fieldTypeBoolean, true},
+		{"boolean of number", int64(0), fieldTypeBoolean, false},
+		{"json of string", `{"a": 1}`, fieldTypeJSON, map[string]interface{}{"a": 1.0}},
+		{"json of map", map[string]interface{}{"a": 1}, fieldTypeJSON, map[string]interface{}{"a": 1}},
+		{"invalid json", "{", fieldTypeJSON, nil},
+		{"unix ms", ts.UnixMilli(), timeFormatUnixMillis, ts},
+		{"unix ms of date string", "2024-01-02T03:04:05Z", timeFormatUnixMillis, ts},
+		{"unix s", float64(ts.Unix()), timeFormatUnixSeconds, ts},
+		{"timestamp", ts, timeFormatTimestamp, ts},
+		{"nil", nil, fieldTypeNumber, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, convertFieldValue(tt.value, tt.fieldType, nil))
+		})
+	}
+}
+
+func TestOverrideField(t *testing.T) {
+	field := overrideField("duration", []interface{}{int64(30), "45", nil}, fieldTypeSeconds, nil)
+	require.Equal(t, data.FieldTypeNullableFloat64, field.Type())
+	require.Equal(t, "s", field.Config.Unit)
+	v, ok := field.ConcreteAt(1)
+	require.True(t, ok)
+	require.Equal(t, 45.0, v)
+
+	// A column mixing representations is read as times instead of strings
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	field = overrideField("ts", []interface{}{ts.UnixMilli(), "2024-01-02T03:04:05Z", ts}, timeFormatUnixMillis, nil)
+	require.Equal(t, data.FieldTypeNullableTime, field.Type())
+	for i := 0; i < field.Len(); i++ {
+		v, ok := field.ConcreteAt(i)
+		require.True(t, ok)
+		require.Equal(t, ts, v)
+	}
+
+	field = overrideField("payload", []interface{}{`{"a":1}`, "plain"}, fieldTypeJSON, nil)
+	require.Equal(t, data.FieldTypeNullableJSON, field.Type())
+	v, _ = field.ConcreteAt(0)
+	require.Equal(t, json.RawMessage(`{"a":1}`), v)
+	_, ok = field.ConcreteAt(1)
+	require.False(t, ok)
+}
+
+func TestRowsResponseFieldTypes(t *testing.T) {
+	rows := []map[string]interface{}{{"duration": "12"}, {"duration": int64(5)}}
+	info := &QueryInfo{Fields: []string{"duration"}, FieldTypes: map[string]string{"duration": fieldTypeSeconds}}
+	response := rowsResponse(rows, info, false)
+	field := response.Frames[0].Fields[0]
+	require.Equal(t, data.FieldTypeNullableFloat64, field.Type())
+	require.Empty(t, response.Frames[0].Meta)
+}
+
+func TestAggregateRowsFieldTypes(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"tier": "1", "client": map[string]interface{}{"plan": "gold"}, "duration": "30"},
+		{"tier": int64(1), "client": map[string]interface{}{"plan": "gold"}, "duration": int64(15)},
+		{"tier": 2.0, "client": map[string]interface{}{"plan": "silver"}, "duration": "n/a"},
+	}
+	info, err := parseSQLQueryWithVariables("SELECT tier, SUM(duration) AS total, COUNT(*) AS n FROM sessions GROUP BY tier ORDER BY tier")
+	require.NoError(t, err)
+	info.FieldTypes = map[string]string{"tier": fieldTypeNumber, "duration": fieldTypeSeconds}
+
+	// "1" and 1 are the same group, and the duration keeps its unit
+	response := (&Datasource{}).aggregateRows(context.Background(), rows, info, FirestoreQuery{})
+	require.NoError(t, response.Error)
+	frame := response.Frames[0]
+	require.Equal(t, 2, frame.Rows())
+	require.Equal(t, data.FieldTypeNullableFloat64, frame.Fields[0].Type())
+	v, _ := frame.Fields[0].ConcreteAt(0)
+	require.Equal(t, 1.0, v)
+	require.Equal(t, 45.0, frame.Fields[1].At(0))
+	require.Equal(t, "s", frame.Fields[1].Config.Unit)
+	require.Nil(t, frame.Fields[2].Config)
+
+	// Nested GROUP BY fields are read as their type too
+	row := map[string]interface{}{"client": map[string]interface{}{"tier": "3"}}
+	applyFieldTypes(row, []string{"client.tier"}, &QueryInfo{FieldTypes: map[string]string{"client.tier": fieldTypeNumber}})
+	require.Equal(t, 3.0, row["client"].(map[string]interface{})["tier"])
+}
@@ -0,0 +1,396 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// valueKind is the column type detected for a Firestore value
+type valueKind int
+
+const (
+	kindNull valueKind = iota
+	kindBool
+	kindInt
+	kindFloat
+	kindTime
+	kindString
+	kindJSON
+)
+
+// kindOf returns the column type a Firestore value maps to
+func kindOf(value interface{}) valueKind {
+	switch value.(type) {
+	case nil:
+		return kindNull
+	case bool:
+		return kindBool
+	case int, int32, int64:
+		return kindInt
+	case float32, float64:
+		return kindFloat
+	case time.Time:
+		return kindTime
+	case map[string]interface{}, []interface{}, []map[string]interface{}:
+		return kindJSON
+	default:
+		return kindString
+	}
+}
+
+// String returns the name of the kind used in notices
+func (k valueKind) String() string {
+	switch k {
+	case kindBool:
+		return "boolean"
+	case kindInt:
+		return "integer"
+	case kindFloat:
+		return "float"
+	case kindTime:
+		return "time"
+	case kindJSON:
+		return "JSON"
+	case kindNull:
+		return "null"
+	default:
+		return "string"
+	}
+}
+
+// columnKind detects the type shared by all non-nil values of a column and reports
+// whether values had to be coerced to it. Columns mixing integers and floats are
+// promoted to floats, any other mix falls back to strings so no value is lost.
+func columnKind(values []interface{}) (valueKind, bool) {
+	kind := kindNull
+	coerced := false
+	for _, v := range values {
+		k := kindOf(v)
+		if k == kindNull || k == kind {
+			continue
+		}
+		if kind == kindNull {
+			kind = k
+			continue
+		}
+		coerced = true
+		if (kind == kindInt && k == kindFloat) || (kind == kindFloat && k == kindInt) {
+			kind = kindFloat
+			continue
+		}
+		return kindString, true
+	}
+	return kind, coerced
+}
+
+// coercionNotice returns the notice text shown when a column mixes value types
+func coercionNotice(name string, values []interface{}) (string, bool) {
+	kind, coerced := columnKind(values)
+	if !coerced {
+		return "", false
+	}
+	return fmt.Sprintf("Column %q mixes value types across documents and was converted to %s", name, kind), true
+}
+
+// frameChunkSize is the number of values of a nullable column allocated together, so a
+// large result costs an allocation per chunk instead of one per value
+const frameChunkSize = 4096
+
+// chunkedValues stores the values a nullable column points to in fixed-size chunks
+type chunkedValues[T any] struct {
+	chunk     []T
+	remaining int
+}
+
+// newChunkedValues returns the storage of the values of a column of rows rows
+func newChunkedValues[T any](rows int) *chunkedValues[T] {
+	return &chunkedValues[T]{remaining: rows}
+}
+
+// store keeps a value and returns a pointer to it, valid for the life of the frame
+func (c *chunkedValues[T]) store(value T) *T {
+	if len(c.chunk) == cap(c.chunk) {
+		c.chunk = make([]T, 0, max(1, min(frameChunkSize, c.remaining)))
+	}
+	c.remaining--
+	c.chunk = append(c.chunk, value)
+	return &c.chunk[len(c.chunk)-1]
+}
+
+// newTypedField builds a nullable frame field whose type matches the Firestore values,
+// so numeric panels, sorting and alerting work on the real types instead of strings.
+// Missing values become nulls.
+func newTypedField(name string, values []interface{}) *data.Field {
+	kind, _ := columnKind(values)
+	switch kind {
+	case kindBool:
+		return data.NewField(name, nil, nullableValues[bool](values))
+	case kindInt:
+		out := make([]*int64, len(values))
+		stored := newChunkedValues[int64](len(values))
+		for i, v := range values {
+			if n, ok := toInt64(v); ok {
+				out[i] = stored.store(n)
+			}
+		}
+		return data.NewField(name, nil, out)
+	case kindFloat:
+		out := make([]*float64, len(values))
+		stored := newChunkedValues[float64](len(values))
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			if f, err := convertToFloat(v); err == nil {
+				out[i] = stored.store(f)
+			}
+		}
+		return data.NewField(name, nil, out)
+	case kindTime:
+		return data.NewField(name, nil, nullableValues[time.Time](values))
+	case kindJSON:
+		return data.NewField(name, nil, jsonValues(values))
+	case kindNull:
+		return data.NewField(name, nil, make([]*string, len(values)))
+	default:
+		out := make([]*string, len(values))
+		stored := newChunkedValues[string](len(values))
+		for i, v := range values {
+			if v == nil {
+				continue
+			}
+			out[i] = stored.store(stringValue(v))
+		}
+		return data.NewField(name, nil, out)
+	}
+}
+
+// nullableValues returns the values of type T as a nullable field slice, others are nulls
+func nullableValues[T any](values []interface{}) []*T {
+	out := make([]*T, len(values))
+	stored := newChunkedValues[T](len(values))
+	for i, v := range values {
+		if typed, ok := v.(T); ok {
+			out[i] = stored.store(typed)
+		}
+	}
+	return out
+}
+
+// jsonValues returns the values encoded as JSON as a nullable field slice
+func jsonValues(values []interface{}) []*json.RawMessage {
+	out := make([]*json.RawMessage, len(values))
+	stored := newChunkedValues[json.RawMessage](len(values))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		if raw, err := json.Marshal(v); err == nil {
+			out[i] = stored.store(raw)
+		}
+	}
+	return out
+}
+
+// stringValue formats a value for a string column, keeping times and JSON values readable
+func stringValue(value interface{}) string {
+	switch kindOf(value) {
+	case kindTime:
+		return value.(time.Time).UTC().Format(time.RFC3339Nano)
+	case kindJSON:
+		if raw, err := json.Marshal(value); err == nil {
+			return string(raw)
+		}
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// toInt64 converts the integer types returned by Firestore to int64
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// flattenMap expands nested maps into a single level keyed by dot-notation leaf
+// paths, e.g. {"clientData": {"BrandCliente": "yoigo"}} becomes {"clientData.BrandCliente": "yoigo"}
+func flattenMap(value map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(value))
+	flattenInto(out, "", value)
+	return out
+}
+
+func flattenInto(out map[string]interface{}, prefix string, value map[string]interface{}) {
+	for key, v := range value {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+			flattenInto(out, path, nested)
+			continue
+		}
+		out[path] = v
+	}
+}
+
+// expandFlattenedFields replaces selected fields that refer to maps with the sorted leaf
+// paths found under them in the flattened rows
+func expandFlattenedFields(fields []string, rows []map[string]interface{}) []string {
+	expanded := make([]string, 0, len(fields))
+	for _, field := range fields {
+		leafSet := make(map[string]bool)
+		for _, row := range rows {
+			for key := range row {
+				if strings.HasPrefix(key, field+".") {
+					leafSet[key] = true
+				}
+			}
+		}
+		if len(leafSet) == 0 {
+			expanded = append(expanded, field)
+			continue
+		}
+		leaves := make([]string, 0, len(leafSet))
+		for key := range leafSet {
+			leaves = append(leaves, key)
+		}
+		sort.Strings(leaves)
+		expanded = append(expanded, leaves...)
+	}
+	return expanded
+}
+
+// flattenRecords expands map values of FireQL result columns into one column per leaf path
+func flattenRecords(columns []string, records [][]interface{}) ([]string, [][]interface{}) {
+	// Discover the leaf paths of every column that holds maps
+	leavesByColumn := make([][]string, len(columns))
+	for idx := range columns {
+		leafSet := make(map[string]bool)
+		for _, record := range records {
+			if idx >= len(record) {
+				continue
+			}
+			if nested, ok := record[idx].(map[string]interface{}); ok {
+				for key := range flattenMap(nested) {
+					leafSet[key] = true
+				}
+			}
+		}
+		if len(leafSet) == 0 {
+			continue
+		}
+		leaves := make([]string, 0, len(leafSet))
+		for key := range leafSet {
+			leaves = append(leaves, key)
+		}
+		sort.Strings(leaves)
+		leavesByColumn[idx] = leaves
+	}
+
+	var flatColumns []string
+	for idx, column := range columns {
+		if leavesByColumn[idx] == nil {
+			flatColumns = append(flatColumns, column)
+			continue
+		}
+		for _, leaf := range leavesByColumn[idx] {
+			flatColumns = append(flatColumns, column+"."+leaf)
+		}
+	}
+
+	flatRecords := make([][]interface{}, len(records))
+	for r, record := range records {
+		if record == nil {
+			continue
+		}
+		flat := make([]interface{}, 0, len(flatColumns))
+		for idx := range columns {
+			var value interface{}
+			if idx < len(record) {
+				value = record[idx]
+			}
+			if leavesByColumn[idx] == nil {
+				flat = append(flat, value)
+				continue
+			}
+			var leafValues map[string]interface{}
+			if nested, ok := value.(map[string]interface{}); ok {
+				leafValues = flattenMap(nested)
+			}
+			for _, leaf := range leavesByColumn[idx] {
+				flat = append(flat, leafValues[leaf])
+			}
+		}
+		flatRecords[r] = flat
+	}
+	return flatColumns, flatRecords
+}
+
+// sortRecordColumns orders FireQL result columns by name, moving the record values along
+func sortRecordColumns(columns []string, records [][]interface{}) ([]string, [][]interface{}) {
+	order := make([]int, len(columns))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return columns[order[i]] < columns[order[j]] })
+
+	sortedColumns := make([]string, len(columns))
+	for i, idx := range order {
+		sortedColumns[i] = columns[idx]
+	}
+
+	sortedRecords := make([][]interface{}, len(records))
+	for r, record := range records {
+		if record == nil {
+			continue
+		}
+		sorted := make([]interface{}, len(columns))
+		for i, idx := range order {
+			if idx < len(record) {
+				sorted[i] = record[idx]
+			}
+		}
+		sortedRecords[r] = sorted
+	}
+	return sortedColumns, sortedRecords
+}
+
+// alignColumns returns the sorted field names found in the rows and their values filled
+// row by row, so fields missing from a document are nulls at that document's index
+func alignColumns(rows []map[string]interface{}) ([]string, map[string][]interface{}) {
+	fieldSet := make(map[string]bool)
+	for _, row := range rows {
+		for fieldName := range row {
+			fieldSet[fieldName] = true
+		}
+	}
+
+	fieldNames := make([]string, 0, len(fieldSet))
+	for fieldName := range fieldSet {
+		fieldNames = append(fieldNames, fieldName)
+	}
+	sort.Strings(fieldNames)
+
+	columns := make(map[string][]interface{}, len(fieldNames))
+	for _, fieldName := range fieldNames {
+		values := make([]interface{}, len(rows))
+		for i, row := range rows {
+			values[i] = row[fieldName]
+		}
+		columns[fieldName] = values
+	}
+	return fieldNames, columns
+}
@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTypedField(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		values    []interface{}
+		fieldType data.FieldType
+	}{
+		{"bool", []interface{}{true, nil, false}, data.FieldTypeNullableBool},
+		{"int", []interface{}{int64(1), nil, int64(3)}, data.FieldTypeNullableInt64},
+		{"float", []interface{}{1.5, 2.5, nil}, data.FieldTypeNullableFloat64},
+		{"time", []interface{}{ts, nil, ts}, data.FieldTypeNullableTime},
+		{"string", []interface{}{"a", "b", nil}, data.FieldTypeNullableString},
+		{"json", []interface{}{map[string]interface{}{"a": 1}, nil, []interface{}{1}}, data.FieldTypeNullableJSON},
+		{"mixed", []interface{}{"a", true, nil}, data.FieldTypeNullableString},
+		{"int and float", []interface{}{int64(1), 2.5, nil}, data.FieldTypeNullableFloat64},
+		{"number and string", []interface{}{int64(1), "two", 3.5}, data.FieldTypeNullableString},
+		{"empty", []interface{}{nil, nil, nil}, data.FieldTypeNullableString},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field := newTypedField(tt.name, tt.values)
+			require.Equal(t, tt.fieldType, field.Type())
+			require.Equal(t, len(tt.values), field.Len())
+			for i, v := range tt.values {
+				if v == nil {
+					require.Nil(t, field.At(i))
+				}
+			}
+		})
+	}
+}
+
+func TestNewTypedFieldJSONValue(t *testing.T) {
+	field := newTypedField("data", []interface{}{map[string]interface{}{"brand": "yoigo"}})
+	require.Equal(t, json.RawMessage(`{"brand":"yoigo"}`), *field.At(0).(*json.RawMessage))
+}
+
+func TestNewTypedFieldChunks(t *testing.T) {
+	rows := 2*frameChunkSize + 10
+	values := make([]interface{}, rows)
+	for i := range values {
+		if i%3 != 0 {
+			values[i] = int64(i)
+		}
+	}
+
+	field := newTypedField("count", values)
+	require.Equal(t, rows, field.Len())
+	for _, i := range []int{1, frameChunkSize - 2, frameChunkSize + 1, rows - 1} {
+		require.Equal(t, int64(i), *field.At(i).(*int64))
+	}
+	require.Nil(t, field.At(frameChunkSize-1).(*int64))
+
+	// An allocation per chunk instead of one per value
+	allocs := testing.AllocsPerRun(5, func() { newTypedField("count", values) })
+	require.Less(t, allocs, float64(rows/100))
+}
+
+func TestChunkedValues(t *testing.T) {
+	stored := newChunkedValues[string](3)
+	a, b := stored.store("a"), stored.store("b")
+	require.Equal(t, 3, cap(stored.chunk))
+	c, d := stored.store("c"), stored.store("d")
+	require.Equal(t, []string{"a", "b", "c", "d"}, []string{*a, *b, *c, *d})
+}
+
+func TestCoercionNotice(t *testing.T) {
+	_, ok := coercionNotice("count", []interface{}{int64(1), nil, int64(2)})
+	require.False(t, ok)
+
+	notice, ok := coercionNotice("count", []interface{}{int64(1), 2.5})
+	require.True(t, ok)
+	require.Contains(t, notice, "converted to float")
+
+	notice, ok = coercionNotice("count", []interface{}{int64(1), "n/a"})
+	require.True(t, ok)
+	require.Contains(t, notice, "converted to string")
+}
+
+func TestNewTypedFieldCoercedValues(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	field := newTypedField("value", []interface{}{int64(1), 2.5})
+	require.Equal(t, 1.0, *field.At(0).(*float64))
+
+	field = newTypedField("value", []interface{}{ts, "n/a", map[string]interface{}{"a": 1}})
+	require.Equal(t, "2024-01-01T00:00:00Z", *field.At(0).(*string))
+	require.Equal(t, `{"a":1}`, *field.At(2).(*string))
+}
+
+func TestFlattenMap(t *testing.T) {
+	flat := flattenMap(map[string]interface{}{
+		"msisdn": "600000000",
+		"clientData": map[string]interface{}{
+			"BrandCliente": "yoigo",
+			"address":      map[string]interface{}{"city": "Madrid"},
+		},
+		"empty": map[string]interface{}{},
+	})
+
+	require.Equal(t, map[string]interface{}{
+		"msisdn":                  "600000000",
+		"clientData.BrandCliente": "yoigo",
+		"clientData.address.city": "Madrid",
+		"empty":                   map[string]interface{}{},
+	}, flat)
+}
+
+func TestExpandFlattenedFields(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"msisdn": "1", "clientData.b": "x"},
+		{"msisdn": "2", "clientData.a": "y"},
+	}
+	require.Equal(t, []string{"msisdn", "clientData.a", "clientData.b"}, expandFlattenedFields([]string{"msisdn", "clientData"}, rows))
+}
+
+func TestFlattenRecords(t *testing.T) {
+	columns, records := flattenRecords(
+		[]string{"id", "address"},
+		[][]interface{}{
+			{1, map[string]interface{}{"city": "Madrid", "zip": "28001"}},
+			{2, map[string]interface{}{"city": "Bilbao"}},
+		},
+	)
+
+	require.Equal(t, []string{"id", "address.city", "address.zip"}, columns)
+	require.Equal(t, [][]interface{}{{1, "Madrid", "28001"}, {2, "Bilbao", nil}}, records)
+}
+
+func TestSortRecordColumns(t *testing.T) {
+	columns, records := sortRecordColumns(
+		[]string{"status", "msisdn", "brand"},
+		[][]interface{}{{"closed", "600000000", "yoigo"}, nil},
+	)
+
+	require.Equal(t, []string{"brand", "msisdn", "status"}, columns)
+	require.Equal(t, [][]interface{}{{"yoigo", "600000000", "closed"}, nil}, records)
+}
+
+func TestAlignColumns(t *testing.T) {
+	names, columns := alignColumns([]map[string]interface{}{
+		{"msisdn": "1"},
+		{"msisdn": "2", "status": "closed"},
+		{"status": "open"},
+	})
+
+	require.Equal(t, []string{"msisdn", "status"}, names)
+	require.Equal(t, []interface{}{"1", "2", nil}, columns["msisdn"])
+	require.Equal(t, []interface{}{nil, "closed", "open"}, columns["status"])
+}
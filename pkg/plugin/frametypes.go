@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// dataplaneVersion is the version of the dataplane contract the typed frames follow
+var dataplaneVersion = data.FrameTypeVersion{0, 1}
+
+// setFrameTypes declares the type of every frame of a response, so panels, alert rules and
+// expressions read them without guessing: time series for the time series format and
+// tables otherwise. Frames typed while they were built, such as heatmap cells, grouped
+// time series and dataplane log lines, keep their type, and log frames with the time and
+// level fields stay untyped. The time field of a time series is moved first, which is the
+// field dataplane consumers read the time from.
+func setFrameTypes(response backend.DataResponse, format, timeField string) backend.DataResponse {
+	if response.Error != nil {
+		return response
+	}
+	for _, frame := range response.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		if frame.Meta.Type == "" {
+			frame.Meta.Type = frameType(frame, format, timeField)
+		}
+		if frame.Meta.TypeVersion.IsZero() && frame.Meta.Type != "" && frame.Meta.Type != frameTypeHeatmapCells {
+			frame.Meta.TypeVersion = dataplaneVersion
+		}
+	}
+
+	// Frames of a single series each, such as those of the collections of a wildcard FROM
+	if len(response.Frames) > 1 && format == formatTimeSeries {
+		multi := true
+		for _, frame := range response.Frames {
+			schema := frame.TimeSeriesSchema()
+			multi = multi && schema.Type == data.TimeSeriesTypeWide && len(schema.ValueIndices) == 1
+		}
+		if multi {
+			for _, frame := range response.Frames {
+				frame.Meta.Type = data.FrameTypeTimeSeriesMulti
+			}
+		}
+	}
+	return response
+}
+
+// frameType returns the type of an untyped frame of a query format, empty for a log frame
+// whose fields aren't named after the dataplane log lines
+func frameType(frame *data.Frame, format, timeField string) data.FrameType {
+	if frame.Meta != nil && frame.Meta.PreferredVisualization == data.VisTypeLogs {
+		return ""
+	}
+	if format != formatTimeSeries {
+		return data.FrameTypeTable
+	}
+
+	moveTimeFieldFirst(frame, timeField)
+	switch frame.TimeSeriesSchema().Type {
+	case data.TimeSeriesTypeWide:
+		return data.FrameTypeTimeSeriesWide
+	case data.TimeSeriesTypeLong:
+		return data.FrameTypeTimeSeriesLong
+	default:
+		return data.FrameTypeTable
+	}
+}
+
+// moveTimeFieldFirst moves the time field of the query before the other fields of a frame,
+// or the first field holding times when the query has none
+func moveTimeFieldFirst(frame *data.Frame, timeField string) {
+	idx := -1
+	for i, field := range frame.Fields {
+		if !field.Type().Time() {
+			continue
+		}
+		if field.Name == timeField {
+			idx = i
+			break
+		}
+		if idx == -1 {
+			idx = i
+		}
+	}
+	if idx <= 0 {
+		return
+	}
+	field := frame.Fields[idx]
+	copy(frame.Fields[1:idx+1], frame.Fields[:idx])
+	frame.Fields[0] = field
+}
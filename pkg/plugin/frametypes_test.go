@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetFrameTypes(t *testing.T) {
+	ts := []time.Time{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		name      string
+		format    string
+		frame     *data.Frame
+		frameType data.FrameType
+	}{
+		{"table", formatTable, data.NewFrame("response", data.NewField("ts", nil, ts), data.NewField("cpu", nil, []float64{1})), data.FrameTypeTable},
+		{"wide", formatTimeSeries, data.NewFrame("response", data.NewField("cpu", nil, []float64{1}), data.NewField("ts", nil, ts)), data.FrameTypeTimeSeriesWide},
+		{"long", formatTimeSeries, data.NewFrame("response", data.NewField("ts", nil, ts), data.NewField("host", nil, []string{"a"}), data.NewField("cpu", nil, []float64{1})), data.FrameTypeTimeSeriesLong},
+		{"no numbers", formatTimeSeries, data.NewFrame("response", data.NewField("ts", nil, ts)), data.FrameTypeTable},
+		{"logs", formatLogs, data.NewFrame("logs", data.NewField("time", nil, ts)).SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeLogs}), ""},
+		{"dataplane logs", formatLogs, data.NewFrame("logs", data.NewField("timestamp", nil, ts)).SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeLogs, Type: data.FrameTypeLogLines}), data.FrameTypeLogLines},
+		{"typed", formatHeatmap, data.NewFrame("heatmap").SetMeta(&data.FrameMeta{Type: frameTypeHeatmapCells}), frameTypeHeatmapCells},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := setFrameTypes(backend.DataResponse{Frames: data.Frames{tt.frame}}, tt.format, "ts")
+			require.Equal(t, tt.frameType, response.Frames[0].Meta.Type)
+		})
+	}
+}
+
+func TestSetFrameTypesTimeField(t *testing.T) {
+	ts := []time.Time{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	frame := data.NewFrame("response",
+		data.NewField("cpu", nil, []float64{1}),
+		data.NewField("createdAt", nil, ts),
+		data.NewField("ts", nil, ts),
+	)
+	response := setFrameTypes(backend.DataResponse{Frames: data.Frames{frame}}, formatTimeSeries, "ts")
+	fields := response.Frames[0].Fields
+	require.Equal(t, []string{"ts", "cpu", "createdAt"}, []string{fields[0].Name, fields[1].Name, fields[2].Name})
+	require.Equal(t, dataplaneVersion, response.Frames[0].Meta.TypeVersion)
+}
+
+func TestSetFrameTypesMulti(t *testing.T) {
+	ts := []time.Time{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	frames := data.Frames{
+		data.NewFrame("response", data.NewField("ts", nil, ts), data.NewField("count", data.Labels{"__collection__": "logs_a"}, []int64{1})),
+		data.NewFrame("response", data.NewField("ts", nil, ts), data.NewField("count", data.Labels{"__collection__": "logs_b"}, []int64{2})),
+	}
+	response := setFrameTypes(backend.DataResponse{Frames: frames}, formatTimeSeries, "")
+	for _, frame := range response.Frames {
+		require.Equal(t, data.FrameTypeTimeSeriesMulti, frame.Meta.Type)
+	}
+}
@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+)
+
+// Fill policies of the empty time buckets of a GROUP BY, set with the fill query option
+const (
+	fillNull     = "null"     // empty buckets have null aggregates
+	fillZero     = "zero"     // empty buckets have zero aggregates
+	fillPrevious = "previous" // empty buckets repeat the aggregates of the previous bucket
+)
+
+// gapFillMaxBuckets caps the buckets of a series, above it the gaps are left unfilled
+const gapFillMaxBuckets = 10000
+
+// validateFill checks the fill policy, empty leaves the gaps unfilled
+func validateFill(fill string) error {
+	switch fill {
+	case "", fillNull, fillZero, fillPrevious:
+		return nil
+	default:
+		return fmt.Errorf("unsupported fill %q, expected one of %s, %s, %s", fill, fillNull, fillZero, fillPrevious)
+	}
+}
+
+// fillTimeBuckets adds a result for every empty time bucket of each series, from the start
+// of the panel time range to its end, or between the first and last bucket without one.
+// Counter and window functions of the added results are computed afterwards; counters have
+// no readings in an empty bucket and stay null.
+func fillTimeBuckets(results []AggregatedResult, queryInfo *QueryInfo, fill string) []AggregatedResult {
+	if fill == "" || queryInfo.TimeBucket <= 0 || len(results) == 0 {
+		return results
+	}
+	series, timeIdx := resultSeries(results, queryInfo)
+	if timeIdx == -1 {
+		return results
+	}
+
+	var first, last time.Time
+	for _, result := range results {
+		ts, ok := resultTime(result, timeIdx)
+		if !ok {
+			// A bucket field that doesn't hold times can't be filled
+			return results
+		}
+		if first.IsZero() || ts.Before(first) {
+			first = ts
+		}
+		if ts.After(last) {
+			last = ts
+		}
+	}
+	if !queryInfo.TimeRange.From.IsZero() && !queryInfo.TimeRange.To.IsZero() {
+		first = truncateToBucket(queryInfo.TimeRange.From, queryInfo)
+		last = queryInfo.TimeRange.To.UTC()
+	}
+	if last.Sub(first)/queryInfo.TimeBucket >= gapFillMaxBuckets {
+		return results
+	}
+
+	filled := make([]AggregatedResult, 0, len(results))
+	for _, rows := range series {
+		pos := 0
+		var previous []interface{}
+		for ts := first; !ts.After(last); ts = nextBucket(ts, queryInfo) {
+			// Buckets outside the range are kept as they are
+			for pos < len(rows) {
+				rowTime, _ := resultTime(results[rows[pos]], timeIdx)
+				if !rowTime.Before(ts) {
+					break
+				}
+				filled = append(filled, results[rows[pos]])
+				previous = results[rows[pos]].AggregateValues
+				pos++
+			}
+			if pos < len(rows) {
+				if rowTime, _ := resultTime(results[rows[pos]], timeIdx); rowTime.Equal(ts) {
+					continue
+				}
+			}
+			filled = append(filled, emptyBucket(results[rows[0]], timeIdx, ts, queryInfo, fill, previous))
+		}
+		for ; pos < len(rows); pos++ {
+			filled = append(filled, results[rows[pos]])
+		}
+	}
+	return filled
+}
+
+// emptyBucket returns the result of an empty time bucket of the series of template
+func emptyBucket(template AggregatedResult, timeIdx int, ts time.Time, queryInfo *QueryInfo, fill string, previous []interface{}) AggregatedResult {
+	result := AggregatedResult{
+		GroupValues:     append([]interface{}{}, template.GroupValues...),
+		AggregateValues: make([]interface{}, len(queryInfo.AggregateFields)),
+	}
+	result.GroupValues[timeIdx] = ts
+	for i, aggField := range queryInfo.AggregateFields {
+		if aggField.Window != nil || counterFunctions[aggField.Function] {
+			continue
+		}
+		switch fill {
+		case fillZero:
+			// An empty bucket joins no values, null like an empty group
+			if aggField.Function != "STRING_AGG" {
+				result.AggregateValues[i] = int64(0)
+			}
+		case fillPrevious:
+			if i < len(previous) {
+				result.AggregateValues[i] = previous[i]
+			}
+		}
+	}
+	return result
+}
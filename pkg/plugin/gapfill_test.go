@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFill(t *testing.T) {
+	for _, fill := range []string{"", "null", "zero", "previous"} {
+		require.NoError(t, validateFill(fill))
+	}
+	require.Error(t, validateFill("linear"))
+}
+
+func TestFillTimeBuckets(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	hour := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Hour) }
+	queryInfo := &QueryInfo{
+		GroupByFields: []string{"ts", "brand"},
+		AggregateFields: []AggregateInfo{
+			{Function: "SUM", Field: "amount", Alias: "total"},
+			{Function: "MOVING_AVG", Field: "total", Alias: "smooth", Window: &windowFrame{Rows: 2}},
+		},
+		TimeBucketField: "ts",
+		TimeBucket:      time.Hour,
+	}
+	results := func() []AggregatedResult {
+		return []AggregatedResult{
+			{GroupValues: []interface{}{hour(3), "yoigo"}, AggregateValues: []interface{}{int64(8), nil}},
+			{GroupValues: []interface{}{hour(0), "yoigo"}, AggregateValues: []interface{}{int64(2), nil}},
+			{GroupValues: []interface{}{hour(1), "masmovil"}, AggregateValues: []interface{}{int64(5), nil}},
+		}
+	}
+	totals := func(filled []AggregatedResult, brand string) []interface{} {
+		var values []interface{}
+		for _, result := range filled {
+			if result.GroupValues[1] == brand {
+				values = append(values, result.AggregateValues[0])
+			}
+		}
+		return values
+	}
+
+	filled := fillTimeBuckets(results(), queryInfo, fillNull)
+	require.Len(t, filled, 8)
+	require.Equal(t, []interface{}{int64(2), nil, nil, int64(8)}, totals(filled, "yoigo"))
+	require.Equal(t, []interface{}{nil, int64(5), nil, nil}, totals(filled, "masmovil"))
+
+	filled = fillTimeBuckets(results(), queryInfo, fillZero)
+	require.Equal(t, []interface{}{int64(2), int64(0), int64(0), int64(8)}, totals(filled, "yoigo"))
+	require.Nil(t, filled[1].AggregateValues[1], "window functions are computed afterwards")
+
+	filled = fillTimeBuckets(results(), queryInfo, fillPrevious)
+	require.Equal(t, []interface{}{int64(2), int64(2), int64(2), int64(8)}, totals(filled, "yoigo"))
+	require.Equal(t, []interface{}{nil, int64(5), int64(5), int64(5)}, totals(filled, "masmovil"))
+
+	require.Len(t, fillTimeBuckets(results(), queryInfo, ""), 3)
+}
+
+func TestFillTimeBucketsTimeRange(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	queryInfo := &QueryInfo{
+		GroupByFields:   []string{"ts"},
+		AggregateFields: []AggregateInfo{{Function: "COUNT", Field: "*", Alias: "COUNT(*)"}},
+		TimeBucketField: "ts",
+		TimeBucket:      time.Hour,
+		TimeRange:       backend.TimeRange{From: t0.Add(-90 * time.Minute), To: t0.Add(time.Hour)},
+	}
+	results := []AggregatedResult{{GroupValues: []interface{}{t0}, AggregateValues: []interface{}{int64(4)}}}
+
+	filled := fillTimeBuckets(results, queryInfo, fillZero)
+	require.Len(t, filled, 4)
+	require.Equal(t, t0.Add(-2*time.Hour), filled[0].GroupValues[0])
+	require.Equal(t, []interface{}{int64(4)}, filled[2].AggregateValues)
+	require.Equal(t, t0.Add(time.Hour), filled[3].GroupValues[0])
+}
+
+func TestNewAggregateFieldNulls(t *testing.T) {
+	field := newAggregateField("total", []interface{}{int64(1), nil})
+	require.Equal(t, int64(1), *field.At(0).(*int64))
+	require.Nil(t, field.At(1))
+
+	field = newAggregateField("avg", []interface{}{nil, 2.5})
+	require.Nil(t, field.At(0))
+	require.Equal(t, 2.5, *field.At(1).(*float64))
+}
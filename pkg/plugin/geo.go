@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+// Supported representations of Firestore GeoPoint values, set with the geoFormat query option
+const (
+	geoFormatLatLng  = "latlng"  // field.lat and field.lng float columns (default)
+	geoFormatGeoJSON = "geojson" // GeoJSON Point object
+)
+
+// validateGeoFormat checks the requested GeoPoint representation
+func validateGeoFormat(format string) error {
+	switch format {
+	case "", geoFormatLatLng, geoFormatGeoJSON:
+		return nil
+	default:
+		return fmt.Errorf("unsupported geoFormat %q, expected one of %s, %s", format, geoFormatLatLng, geoFormatGeoJSON)
+	}
+}
+
+// geoJSONPoint returns the GeoJSON Point for a GeoPoint. GeoJSON orders coordinates as longitude, latitude.
+func geoJSONPoint(point *latlng.LatLng) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "Point",
+		"coordinates": []interface{}{point.GetLongitude(), point.GetLatitude()},
+	}
+}
+
+// expandGeoPoints replaces the GeoPoint values of a document row with field.lat and
+// field.lng values, or with a GeoJSON Point. It reports whether the row held any GeoPoint.
+func expandGeoPoints(row map[string]interface{}, format string) bool {
+	var keys []string
+	for key, value := range row {
+		if _, ok := value.(*latlng.LatLng); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	for _, key := range keys {
+		point := row[key].(*latlng.LatLng)
+		if format == geoFormatGeoJSON {
+			row[key] = geoJSONPoint(point)
+			continue
+		}
+		delete(row, key)
+		row[key+".lat"] = point.GetLatitude()
+		row[key+".lng"] = point.GetLongitude()
+	}
+	return len(keys) > 0
+}
+
+// expandGeoPointRecords applies expandGeoPoints to FireQL result columns
+func expandGeoPointRecords(columns []string, records [][]interface{}, format string) ([]string, [][]interface{}) {
+	geoColumns := make([]bool, len(columns))
+	found := false
+	for _, record := range records {
+		for idx, value := range record {
+			if _, ok := value.(*latlng.LatLng); ok && idx < len(columns) {
+				geoColumns[idx] = true
+				found = true
+			}
+		}
+	}
+	if !found {
+		return columns, records
+	}
+
+	if format == geoFormatGeoJSON {
+		for _, record := range records {
+			for idx, value := range record {
+				if point, ok := value.(*latlng.LatLng); ok {
+					record[idx] = geoJSONPoint(point)
+				}
+			}
+		}
+		return columns, records
+	}
+
+	var expandedColumns []string
+	for idx, column := range columns {
+		if geoColumns[idx] {
+			expandedColumns = append(expandedColumns, column+".lat", column+".lng")
+			continue
+		}
+		expandedColumns = append(expandedColumns, column)
+	}
+
+	expanded := make([][]interface{}, len(records))
+	for r, record := range records {
+		if record == nil {
+			continue
+		}
+		out := make([]interface{}, 0, len(expandedColumns))
+		for idx := range columns {
+			var value interface{}
+			if idx < len(record) {
+				value = record[idx]
+			}
+			if !geoColumns[idx] {
+				out = append(out, value)
+				continue
+			}
+			if point, ok := value.(*latlng.LatLng); ok {
+				out = append(out, point.GetLatitude(), point.GetLongitude())
+			} else {
+				out = append(out, nil, nil)
+			}
+		}
+		expanded[r] = out
+	}
+	return expandedColumns, expanded
+}
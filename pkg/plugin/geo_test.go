@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+func TestExpandGeoPoints(t *testing.T) {
+	row := map[string]interface{}{
+		"name":     "Madrid",
+		"location": &latlng.LatLng{Latitude: 40.4168, Longitude: -3.7038},
+	}
+	require.True(t, expandGeoPoints(row, ""))
+	require.Equal(t, map[string]interface{}{
+		"name":         "Madrid",
+		"location.lat": 40.4168,
+		"location.lng": -3.7038,
+	}, row)
+
+	row = map[string]interface{}{"location": &latlng.LatLng{Latitude: 40.4168, Longitude: -3.7038}}
+	require.True(t, expandGeoPoints(row, geoFormatGeoJSON))
+	require.Equal(t, map[string]interface{}{
+		"type":        "Point",
+		"coordinates": []interface{}{-3.7038, 40.4168},
+	}, row["location"])
+
+	require.False(t, expandGeoPoints(map[string]interface{}{"name": "Madrid"}, ""))
+}
+
+func TestExpandGeoPointRecords(t *testing.T) {
+	columns, records := expandGeoPointRecords(
+		[]string{"id", "location"},
+		[][]interface{}{
+			{1, &latlng.LatLng{Latitude: 40.4168, Longitude: -3.7038}},
+			{2, nil},
+		},
+		geoFormatLatLng,
+	)
+
+	require.Equal(t, []string{"id", "location.lat", "location.lng"}, columns)
+	require.Equal(t, [][]interface{}{{1, 40.4168, -3.7038}, {2, nil, nil}}, records)
+}
+
+func TestValidateGeoFormat(t *testing.T) {
+	require.NoError(t, validateGeoFormat(""))
+	require.NoError(t, validateGeoFormat(geoFormatGeoJSON))
+	require.Error(t, validateGeoFormat("wkt"))
+}
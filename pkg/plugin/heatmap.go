@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// frameTypeHeatmapCells is the frame type the Heatmap panel reads as precomputed cells,
+// one row per time and value bucket
+const frameTypeHeatmapCells data.FrameType = "heatmap-cells"
+
+// defaultHeatmapInterval is the time bucket of a heatmap when the panel sends no interval
+const defaultHeatmapInterval = time.Minute
+
+// errHeatmapTimeField is returned when a heatmap query has no time field to bucket by
+var errHeatmapTimeField = errors.New("heatmap format: a time field is required, filter on $__from and $__to or set timeField")
+
+// Column names of the heatmap frame besides the count
+const (
+	heatmapTimeColumn = "xMin"
+	heatmapMinColumn  = "yMin"
+	heatmapMaxColumn  = "yMax"
+)
+
+// convertFirestoreDocsToHeatmapResponse counts the histogram field of the documents into
+// cells of a time bucket, the panel interval, and a value bucket
+func convertFirestoreDocsToHeatmapResponse(docs []*firestore.DocumentSnapshot, queryInfo *QueryInfo, qm FirestoreQuery) backend.DataResponse {
+	timeField := queryInfo.TimeField
+	if timeField == "" {
+		timeField = qm.TimeField
+	}
+	if timeField == "" {
+		return backend.ErrDataResponse(backend.StatusBadRequest, errHeatmapTimeField.Error())
+	}
+	interval := time.Duration(qm.IntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultHeatmapInterval
+	}
+
+	times := make([]time.Time, 0, len(docs))
+	values := make([]float64, 0, len(docs))
+	for _, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		docData, err := queryInfo.Decoded.take(doc)
+		if err != nil {
+			continue
+		}
+		val, ok := numericValue(selectFieldValue(docData, qm.HistogramField))
+		if !ok {
+			continue
+		}
+		ts, ok := toTime(selectFieldValue(docData, timeField), queryInfo.timeFormatOf(timeField), queryInfo.Location)
+		if !ok {
+			continue
+		}
+		numVal, _ := convertToFloat(val)
+		times = append(times, ts.Truncate(interval).UTC())
+		values = append(values, numVal)
+	}
+
+	frame, err := newHeatmapFrame(times, values, qm.HistogramBucketWidth, qm.HistogramBuckets)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "heatmap format: "+err.Error())
+	}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// newHeatmapFrame counts the values into cells of their time bucket and value bucket. The
+// value buckets are the same for every time bucket, the bounds of a histogram of all the values.
+func newHeatmapFrame(times []time.Time, values []float64, width float64, count int) (*data.Frame, error) {
+	h, err := newHistogram(values, width, count)
+	if err != nil {
+		return nil, err
+	}
+
+	cells := make(map[time.Time][]int64)
+	for i, ts := range times {
+		if cells[ts] == nil {
+			cells[ts] = make([]int64, len(h.counts))
+		}
+		cells[ts][h.bucket(values[i])]++
+	}
+	buckets := make([]time.Time, 0, len(cells))
+	for ts := range cells {
+		buckets = append(buckets, ts)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Before(buckets[j]) })
+
+	size := len(buckets) * len(h.counts)
+	xs, yMins, yMaxs, counts := make([]time.Time, 0, size), make([]float64, 0, size), make([]float64, 0, size), make([]int64, 0, size)
+	for _, ts := range buckets {
+		for i, count := range cells[ts] {
+			xs = append(xs, ts)
+			yMins = append(yMins, h.mins[i])
+			yMaxs = append(yMaxs, h.maxs[i])
+			counts = append(counts, count)
+		}
+	}
+
+	frame := data.NewFrame("heatmap",
+		data.NewField(heatmapTimeColumn, nil, xs),
+		data.NewField(heatmapMinColumn, nil, yMins),
+		data.NewField(heatmapMaxColumn, nil, yMaxs),
+		data.NewField(histogramCountColumn, nil, counts),
+	)
+	frame.SetMeta(&data.FrameMeta{Type: frameTypeHeatmapCells})
+	return frame, nil
+}
@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHeatmapFrame(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	times := []time.Time{t1, t0, t0, t1}
+	values := []float64{250, 20, 80, 140}
+
+	frame, err := newHeatmapFrame(times, values, 100, 0)
+	require.NoError(t, err)
+	require.Equal(t, frameTypeHeatmapCells, frame.Meta.Type)
+	require.Equal(t, 6, frame.Rows())
+
+	rows := make([][]interface{}, frame.Rows())
+	for i := range rows {
+		rows[i] = frame.RowCopy(i)
+	}
+	require.Equal(t, [][]interface{}{
+		{t0, 0.0, 100.0, int64(2)},
+		{t0, 100.0, 200.0, int64(0)},
+		{t0, 200.0, 300.0, int64(0)},
+		{t1, 0.0, 100.0, int64(0)},
+		{t1, 100.0, 200.0, int64(1)},
+		{t1, 200.0, 300.0, int64(1)},
+	}, rows)
+
+	frame, err = newHeatmapFrame(nil, nil, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 0, frame.Rows())
+}
+
+func TestValidateHeatmap(t *testing.T) {
+	require.NoError(t, validateHistogram(FirestoreQuery{Format: formatHeatmap, HistogramField: "latencyMs"}))
+	require.ErrorContains(t, validateHistogram(FirestoreQuery{Format: formatHeatmap}), "heatmap format")
+}
@@ -0,0 +1,25 @@
+package plugin
+
+import (
+	"encoding/json"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// fromExpressionHeader is set by Grafana on the queries of server-side expressions and alerts
+const fromExpressionHeader = "FromExpression"
+
+// isHiddenQuery reports whether a query is hidden in its panel and needs no results. Queries
+// of expressions are run even when hidden, since the expressions read their results.
+func isHiddenQuery(req *backend.QueryDataRequest, query backend.DataQuery) bool {
+	if req.Headers[fromExpressionHeader] == "true" {
+		return false
+	}
+	var qm struct {
+		Hide bool `json:"hide"`
+	}
+	if err := json.Unmarshal(query.JSON, &qm); err != nil {
+		return false
+	}
+	return qm.Hide
+}
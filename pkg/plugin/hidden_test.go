@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsHiddenQuery(t *testing.T) {
+	hidden := backend.DataQuery{RefID: "A", JSON: []byte(`{"query": "SELECT * FROM dialogs", "hide": true}`)}
+	shown := backend.DataQuery{RefID: "B", JSON: []byte(`{"query": "SELECT * FROM dialogs"}`)}
+
+	req := &backend.QueryDataRequest{}
+	require.True(t, isHiddenQuery(req, hidden))
+	require.False(t, isHiddenQuery(req, shown))
+	require.False(t, isHiddenQuery(req, backend.DataQuery{JSON: []byte(`{`)}))
+
+	// Expressions read the results of hidden queries
+	req.Headers = map[string]string{fromExpressionHeader: "true"}
+	require.False(t, isHiddenQuery(req, hidden))
+}
+
+func TestQueryDataSkipsHiddenQueries(t *testing.T) {
+	ds := Datasource{}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{
+		{RefID: "A", JSON: []byte(`{"query": "SELECT * FROM dialogs", "hide": true}`)},
+		{RefID: "B", JSON: []byte(`{`)},
+	}})
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+	require.Empty(t, resp.Responses["A"].Frames)
+	require.Error(t, resp.Responses["B"].Error)
+}
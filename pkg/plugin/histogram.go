@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// defaultHistogramBuckets is the bucket count of a histogram without a width or count
+const defaultHistogramBuckets = 10
+
+// histogramMaxBuckets caps the buckets of a histogram
+const histogramMaxBuckets = 1000
+
+// Column names of the histogram frame, the ones the Histogram panel reads as bucket bounds
+const (
+	histogramMinColumn   = "xMin"
+	histogramMaxColumn   = "xMax"
+	histogramCountColumn = "count"
+)
+
+// distributionFormat reports whether a format counts the values of the histogram field of
+// every matching document: the histogram and heatmap formats
+func distributionFormat(format string) bool {
+	return format == formatHistogram || format == formatHeatmap
+}
+
+// validateHistogram checks the histogram options of a query in the histogram or heatmap format
+func validateHistogram(qm FirestoreQuery) error {
+	if !distributionFormat(qm.Format) {
+		return nil
+	}
+	switch {
+	case qm.HistogramField == "":
+		return fmt.Errorf("%s format: histogramField is required", qm.Format)
+	case qm.HistogramBucketWidth < 0 || math.IsNaN(qm.HistogramBucketWidth) || math.IsInf(qm.HistogramBucketWidth, 0):
+		return fmt.Errorf("%s format: invalid histogramBucketWidth %v", qm.Format, qm.HistogramBucketWidth)
+	case qm.HistogramBuckets < 0 || qm.HistogramBuckets > histogramMaxBuckets:
+		return fmt.Errorf("%s format: histogramBuckets must be between 1 and %d", qm.Format, histogramMaxBuckets)
+	}
+	return nil
+}
+
+// histogram counts values into buckets of equal width. The buckets start at a multiple of
+// width, or at the minimum value when the width follows from the bucket count.
+type histogram struct {
+	start, width float64
+	mins, maxs   []float64
+	counts       []int64
+}
+
+// newHistogram buckets the values by width, or into count buckets spanning the values
+func newHistogram(values []float64, width float64, count int) (*histogram, error) {
+	h := &histogram{}
+	if len(values) == 0 {
+		return h, nil
+	}
+	low, high := values[0], values[0]
+	for _, val := range values {
+		low = math.Min(low, val)
+		high = math.Max(high, val)
+	}
+
+	start := low
+	if width > 0 {
+		start = math.Floor(low/width) * width
+		count = int(math.Floor((high-start)/width)) + 1
+		if count > histogramMaxBuckets {
+			return nil, fmt.Errorf("a bucket width of %v makes %d buckets, more than %d", width, count, histogramMaxBuckets)
+		}
+	} else {
+		if count == 0 {
+			count = defaultHistogramBuckets
+		}
+		if high == low {
+			count = 1
+		}
+		width = (high - low) / float64(count)
+	}
+
+	h.start, h.width = start, width
+	h.mins = make([]float64, count)
+	h.maxs = make([]float64, count)
+	h.counts = make([]int64, count)
+	for i := range h.mins {
+		h.mins[i] = start + float64(i)*width
+		h.maxs[i] = start + float64(i+1)*width
+	}
+	for _, val := range values {
+		h.counts[h.bucket(val)]++
+	}
+	return h, nil
+}
+
+// bucket returns the index of the bucket of a value within the histogram's range. The
+// maximum value falls in the last bucket.
+func (h *histogram) bucket(val float64) int {
+	last := len(h.counts) - 1
+	if h.width == 0 {
+		return last
+	}
+	return max(0, min(int((val-h.start)/h.width), last))
+}
+
+// frame returns the bucket bounds and counts as a frame for the Histogram panel
+func (h *histogram) frame() *data.Frame {
+	return data.NewFrame("histogram",
+		data.NewField(histogramMinColumn, nil, h.mins),
+		data.NewField(histogramMaxColumn, nil, h.maxs),
+		data.NewField(histogramCountColumn, nil, h.counts),
+	)
+}
+
+// convertFirestoreDocsToHistogramResponse counts the numeric values of the histogram field
+// of the documents into buckets. Documents without a number in the field are skipped.
+func convertFirestoreDocsToHistogramResponse(docs []*firestore.DocumentSnapshot, qm FirestoreQuery) backend.DataResponse {
+	values := make([]float64, 0, len(docs))
+	for _, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		val, ok := numericValue(selectFieldValue(doc.Data(), qm.HistogramField))
+		if !ok {
+			continue
+		}
+		numVal, _ := convertToFloat(val)
+		values = append(values, numVal)
+	}
+
+	h, err := newHistogram(values, qm.HistogramBucketWidth, qm.HistogramBuckets)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "histogram format: "+err.Error())
+	}
+	return backend.DataResponse{Frames: data.Frames{h.frame()}}
+}
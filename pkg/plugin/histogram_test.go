@@ -0,0 +1,57 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHistogram(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		width  float64
+		count  int
+		mins   []float64
+		maxs   []float64
+		counts []int64
+	}{
+		{"width", []float64{12, 3, 27, 20, 19.5}, 10, 0, []float64{0, 10, 20}, []float64{10, 20, 30}, []int64{1, 2, 2}},
+		{"negative values", []float64{-5, 4}, 5, 0, []float64{-5, 0}, []float64{0, 5}, []int64{1, 1}},
+		{"count", []float64{0, 10, 4, 6}, 0, 2, []float64{0, 5}, []float64{5, 10}, []int64{2, 2}},
+		{"default count", []float64{0, 100}, 0, 0, []float64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90}, []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}, []int64{1, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+		{"single value", []float64{7, 7}, 0, 4, []float64{7}, []float64{7}, []int64{2}},
+		{"no values", nil, 10, 0, nil, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := newHistogram(tt.values, tt.width, tt.count)
+			require.NoError(t, err)
+			require.Equal(t, tt.mins, h.mins)
+			require.Equal(t, tt.maxs, h.maxs)
+			require.Equal(t, tt.counts, h.counts)
+		})
+	}
+
+	_, err := newHistogram([]float64{0, 1e9}, 1, 0)
+	require.Error(t, err)
+}
+
+func TestHistogramFrame(t *testing.T) {
+	h, err := newHistogram([]float64{1, 2, 3}, 2, 0)
+	require.NoError(t, err)
+	frame := h.frame()
+	require.Len(t, frame.Fields, 3)
+	require.Equal(t, "xMin", frame.Fields[0].Name)
+	require.Equal(t, "xMax", frame.Fields[1].Name)
+	require.Equal(t, int64(2), frame.Fields[2].At(1))
+}
+
+func TestValidateHistogram(t *testing.T) {
+	require.NoError(t, validateHistogram(FirestoreQuery{Format: formatTable}))
+	require.NoError(t, validateHistogram(FirestoreQuery{Format: formatHistogram, HistogramField: "latencyMs", HistogramBucketWidth: 50}))
+	require.Error(t, validateHistogram(FirestoreQuery{Format: formatHistogram}))
+	require.Error(t, validateHistogram(FirestoreQuery{Format: formatHistogram, HistogramField: "latencyMs", HistogramBuckets: -1}))
+	require.Error(t, validateHistogram(FirestoreQuery{Format: formatHistogram, HistogramField: "latencyMs", HistogramBucketWidth: -5}))
+}
@@ -0,0 +1,429 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// joinMaxRows caps the documents read from each collection of a JOIN and the rows it produces
+const joinMaxRows = 10000
+
+// joinPattern matches the FROM clause of a join: FROM a [x] [INNER | LEFT [OUTER]] JOIN b [y]
+// ON x.field = y.field. The aliases default to the last segment of the collection path.
+var joinPattern = regexp.MustCompile(`(?is)\bFROM\s+([^\s,()]+)(?:\s+(?:AS\s+)?([\p{L}_][\p{L}\p{N}_]*))??\s+(?:(INNER|LEFT)(?:\s+OUTER)?\s+)?JOIN\s+([^\s,()]+)(?:\s+(?:AS\s+)?([\p{L}_][\p{L}\p{N}_]*))??\s+ON\s+([^\s=]+)\s*==?\s*([^\s=]+)`)
+
+// unsupportedJoinPattern matches the joins the native route can't evaluate
+var unsupportedJoinPattern = regexp.MustCompile(`(?i)\b(RIGHT|FULL|CROSS|NATURAL)\s+(?:OUTER\s+)?JOIN\b`)
+
+// joinSpec is a join of two collections on the equality of a field of each. Both collections
+// are read and hash joined in memory.
+type joinSpec struct {
+	Left, Right joinSide
+
+	// Outer keeps the left rows without a match, a LEFT JOIN
+	Outer bool
+}
+
+// joinSide is a collection of a join, the alias qualifying its columns and the field of the
+// ON condition
+type joinSide struct {
+	Collection string
+	Alias      string
+	Key        string
+}
+
+// String renders the join for ExecutedQueryString
+func (j *joinSpec) String() string {
+	kind := "join"
+	if j.Outer {
+		kind = "leftJoin"
+	}
+	return fmt.Sprintf("%s(%s %s on %s.%s = %s.%s)", kind, j.Right.Collection, j.Right.Alias, j.Left.Alias, j.Left.Key, j.Right.Alias, j.Right.Key)
+}
+
+// field returns the field of the side's documents a qualified column names
+func (s joinSide) field(column string) (string, bool) {
+	field, ok := strings.CutPrefix(column, s.Alias+".")
+	return field, ok && field != ""
+}
+
+// parseJoin parses the join of a query and returns the query reading the left collection
+// only, parsed as usual for the columns and clauses. Queries without JOIN are returned as
+// they are with a nil join.
+func parseJoin(query string) (*joinSpec, string, error) {
+	words, _ := sqlWords(query)
+	joins := 0
+	for _, word := range words {
+		if word == "JOIN" {
+			joins++
+		}
+	}
+	switch {
+	case joins == 0:
+		return nil, query, nil
+	case joins > 1:
+		return nil, "", fmt.Errorf("a query can join two collections only")
+	}
+	if match := unsupportedJoinPattern.FindStringSubmatch(query); match != nil {
+		return nil, "", fmt.Errorf("%s JOIN is not supported, use JOIN or LEFT JOIN", strings.ToUpper(match[1]))
+	}
+
+	loc := joinPattern.FindStringSubmatchIndex(query)
+	if loc == nil {
+		return nil, "", fmt.Errorf("unsupported JOIN, expected FROM a x JOIN b y ON x.field = y.field")
+	}
+	group := func(i int) string {
+		if loc[2*i] < 0 {
+			return ""
+		}
+		return query[loc[2*i]:loc[2*i+1]]
+	}
+
+	join := &joinSpec{
+		Left:  newJoinSide(group(1), group(2)),
+		Right: newJoinSide(group(4), group(5)),
+		Outer: strings.EqualFold(group(3), "LEFT"),
+	}
+	if isCollectionPattern(join.Left.Collection) || isCollectionPattern(join.Right.Collection) {
+		return nil, "", fmt.Errorf("JOIN collections can't be patterns")
+	}
+	if join.Left.Alias == join.Right.Alias {
+		return nil, "", fmt.Errorf("JOIN of %s and %s needs distinct aliases", join.Left.Collection, join.Right.Collection)
+	}
+
+	first, second := cleanBackticks(group(6)), cleanBackticks(group(7))
+	leftKey, leftFirst := join.Left.field(first)
+	rightKey, rightSecond := join.Right.field(second)
+	if !leftFirst || !rightSecond {
+		// The condition may compare the right collection first
+		leftKey, leftFirst = join.Left.field(second)
+		rightKey, rightSecond = join.Right.field(first)
+	}
+	if !leftFirst || !rightSecond {
+		return nil, "", fmt.Errorf("JOIN condition %s = %s must compare a field of %s with a field of %s", first, second, join.Left.Alias, join.Right.Alias)
+	}
+	join.Left.Key, join.Right.Key = leftKey, rightKey
+
+	rest := query[:loc[0]] + "FROM " + group(1) + query[loc[1]:]
+	return join, rest, nil
+}
+
+// newJoinSide returns the side of a join reading a collection, aliased by the last segment
+// of its path without an alias
+func newJoinSide(collection, alias string) joinSide {
+	collection = cleanBackticks(collection)
+	if alias == "" {
+		alias = collection[strings.LastIndex(collection, "/")+1:]
+	}
+	return joinSide{Collection: collection, Alias: alias}
+}
+
+// validateJoinColumns checks that the columns of a join query are qualified with the alias
+// of a collection
+func validateJoinColumns(info *QueryInfo) error {
+	join := info.Join
+	columns := append([]string{}, info.Fields...)
+	columns = append(columns, info.GroupByFields...)
+	if info.TimeField != "" {
+		columns = append(columns, info.TimeField)
+	}
+	for _, filter := range info.AdditionalFilters {
+		columns = append(columns, filter.Field)
+	}
+	for _, aggField := range info.AggregateFields {
+		if aggField.Window == nil && aggField.Field != "*" {
+			columns = append(columns, aggField.Field)
+		}
+	}
+	for _, rank := range info.Ranks {
+		columns = append(columns, rank.fields()...)
+	}
+	for _, expression := range info.Expressions {
+		columns = append(columns, expression.Condition.Field)
+	}
+
+	for _, column := range columns {
+		if column == "*" || info.isComputed(column) {
+			continue
+		}
+		if _, ok := join.Left.field(column); ok {
+			continue
+		}
+		if _, ok := join.Right.field(column); ok {
+			continue
+		}
+		return fmt.Errorf("JOIN column %s must be qualified with %s. or %s.", column, join.Left.Alias, join.Right.Alias)
+	}
+	return nil
+}
+
+// joinRow is a document of one side of a join with the value of its ON field
+type joinRow struct {
+	key   string
+	keyed bool
+	data  map[string]interface{}
+}
+
+// executeJoin reads both collections of a join, each capped at joinMaxRows documents, and
+// evaluates the rest of the query over the joined rows in memory
+func (d *Datasource) executeJoin(ctx context.Context, client *firestore.Client, qm FirestoreQuery, queryInfo *QueryInfo, timeRange backend.TimeRange, meta *queryMeta) backend.DataResponse {
+	join := queryInfo.Join
+	switch {
+	case qm.Explain:
+		return backend.ErrDataResponse(backend.StatusBadRequest, "explain is not supported for JOIN queries")
+	case qm.Format == formatLogs || distributionFormat(qm.Format):
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("the %s format is not supported for JOIN queries", qm.Format))
+	}
+	if queryInfo.TimeField != "" {
+		queryInfo.TimeRange = timeRange
+	}
+
+	inMemory := append([]string{join.String()}, rowsTrace(queryInfo)...)
+
+	// References match document IDs when the other side joins on __name__
+	var pushdown []string
+	var sides [2][]joinRow
+	hasGeoPoints := false
+	for i, side := range []joinSide{join.Left, join.Right} {
+		other := join.Right
+		if i == 1 {
+			other = join.Left
+		}
+		started := time.Now()
+		docs, trace, err := d.fetchJoinSide(ctx, client, side, queryInfo, timeRange, meta)
+		pushdown = append(pushdown, trace...)
+		meta.executedQuery = describeNativeQuery(qm.Query, timeRange, pushdown, inMemory)
+		if err != nil {
+			d.logger(ctx).Error("JOIN query failed", "collection", side.Collection, "error", err)
+			return firestoreErrorResponse(fmt.Sprintf("JOIN %s: ", side.Collection), err)
+		}
+		meta.addFetch(started, len(docs))
+		meta.addDocumentsRead(routeNative, len(docs))
+		if len(docs) > joinMaxRows {
+			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("JOIN reads at most %d documents of %s, narrow it with a WHERE condition on %s", joinMaxRows, side.Collection, side.Alias))
+		}
+		var decoded decodedDocuments
+		if docs, decoded, err = d.skipUndecodable(ctx, docs, meta); err != nil {
+			return firestoreErrorResponse(fmt.Sprintf("JOIN %s: ", side.Collection), err)
+		}
+
+		rows, geo, err := joinSideRows(docs, decoded, side, other.Key == docNameColumn, queryInfo)
+		if err != nil {
+			return budgetExceededResponse(err)
+		}
+		sides[i] = rows
+		hasGeoPoints = hasGeoPoints || geo
+	}
+
+	rows, err := hashJoin(sides[0], sides[1], join)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	d.debugLog(ctx, "JOIN complete", "left", len(sides[0]), "right", len(sides[1]), "rows", len(rows))
+	return d.evalRows(ctx, rows, func(rows []map[string]interface{}) []string {
+		return joinColumns(rows, join)
+	}, hasGeoPoints, queryInfo, qm, meta)
+}
+
+// fetchJoinSide reads the documents of a side of a join, filtered server-side by the time
+// range and the WHERE conditions on its columns. The conditions are evaluated again on the
+// joined rows, so without a composite index the side is read unfiltered.
+func (d *Datasource) fetchJoinSide(ctx context.Context, client *firestore.Client, side joinSide, queryInfo *QueryInfo, timeRange backend.TimeRange, meta *queryMeta) ([]*firestore.DocumentSnapshot, []string, error) {
+	base := client.Collection(side.Collection).Query
+	baseTrace := []string{fmt.Sprintf("collection(%s)", side.Collection)}
+	if field, ok := side.field(queryInfo.TimeField); ok {
+		fromValue, toValue := timeRangeBounds(timeRange, queryInfo.timeFormatOf(queryInfo.TimeField))
+		base = base.Where(field, ">=", fromValue).Where(field, "<=", toValue)
+		baseTrace = append(baseTrace,
+			fmt.Sprintf("where(%s >= %s)", field, describeTimeValue(fromValue)),
+			fmt.Sprintf("where(%s <= %s)", field, describeTimeValue(toValue)))
+	}
+
+	query, trace := base, baseTrace
+	for _, filter := range queryInfo.AdditionalFilters {
+		field, ok := side.field(filter.Field)
+		// The conditions are evaluated again on the joined rows, where a number or boolean
+		// literal also matches the string holding it
+		if !ok || isMetadataColumn(field) || looseLiteral(filter) {
+			continue
+		}
+		query = query.Where(field, filter.Operator, filter.Value)
+		trace = append(trace, fmt.Sprintf("where(%s %s %v)", field, filter.Operator, filter.Value))
+	}
+
+	// Read one document past the cap so an oversized side is reported
+	limit := fmt.Sprintf("limit(%d)", joinMaxRows+1)
+	docs, err := d.getAllDocuments(ctx, "join "+side.Alias, query.Limit(joinMaxRows+1))
+	if indexURL, missing := missingIndexURL(err); missing && len(trace) > len(baseTrace) {
+		d.debugLog(ctx, "Missing composite index, filtering the join in memory", "collection", side.Collection, "error", err)
+		meta.addNotice(data.NoticeSeverityWarning, missingIndexNotice(indexURL))
+		trace = baseTrace
+		docs, err = d.getAllDocuments(ctx, "join "+side.Alias, base.Limit(joinMaxRows+1))
+	}
+	return docs, append(trace, limit), err
+}
+
+// joinSideRows reads the documents of a side of a join into rows keyed by the ON field. The
+// metadata pseudo-columns of the side that the query reads are added to the rows. It
+// reports whether the documents held GeoPoints.
+func joinSideRows(docs []*firestore.DocumentSnapshot, decoded decodedDocuments, side joinSide, byName bool, queryInfo *QueryInfo) ([]joinRow, bool, error) {
+	metadata := []string{side.Key}
+	for _, column := range queryInfo.Fields {
+		if field, ok := side.field(column); ok {
+			metadata = append(metadata, field)
+		}
+	}
+	for _, filter := range queryInfo.AdditionalFilters {
+		if field, ok := side.field(filter.Field); ok {
+			metadata = append(metadata, field)
+		}
+	}
+	for _, key := range queryInfo.OrderBy {
+		if field, ok := side.field(key.Field); ok {
+			metadata = append(metadata, field)
+		}
+	}
+	for _, rank := range queryInfo.Ranks {
+		for _, column := range rank.fields() {
+			if field, ok := side.field(column); ok {
+				metadata = append(metadata, field)
+			}
+		}
+	}
+
+	rows := make([]joinRow, 0, len(docs))
+	hasGeoPoints := false
+	for _, doc := range docs {
+		docData, err := decoded.take(doc)
+		if err != nil || docData == nil {
+			continue
+		}
+		addDocumentMetadata(docData, doc, metadata)
+		key, keyed := joinKey(selectFieldValue(docData, side.Key), byName)
+
+		if queryInfo.Flatten {
+			docData = flattenMap(docData)
+		}
+		convertDocumentRefs(docData, queryInfo.RefFormat)
+		if expandGeoPoints(docData, queryInfo.GeoFormat) {
+			hasGeoPoints = true
+		}
+		if err := queryInfo.MemoryBudget.add(docData); err != nil {
+			return nil, false, err
+		}
+		rows = append(rows, joinRow{key: key, keyed: keyed, data: docData})
+	}
+	return rows, hasGeoPoints, nil
+}
+
+// joinKey returns the hash key of an ON field value, false for a missing value. Integers and
+// whole floats share keys so the sides match across number types, and references are keyed
+// by their document ID when byName, to match __name__, or else by their path.
+func joinKey(value interface{}, byName bool) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case *firestore.DocumentRef:
+		if v == nil {
+			return "", false
+		}
+		if byName {
+			return "s:" + v.ID, true
+		}
+		return "s:" + v.Path, true
+	case string:
+		return "s:" + v, true
+	case int64:
+		return "n:" + strconv.FormatInt(v, 10), true
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < math.MaxInt64 {
+			return "n:" + strconv.FormatInt(int64(v), 10), true
+		}
+		return "n:" + strconv.FormatFloat(v, 'g', -1, 64), true
+	case time.Time:
+		return "t:" + v.UTC().Format(time.RFC3339Nano), true
+	default:
+		return fmt.Sprintf("%T:%v", value, value), true
+	}
+}
+
+// hashJoin joins the rows of the two sides on their keys. A joined row holds the columns of
+// each side qualified with its alias, and each side's data under its alias for nested paths.
+func hashJoin(left, right []joinRow, join *joinSpec) ([]map[string]interface{}, error) {
+	index := make(map[string][]int, len(right))
+	for i, row := range right {
+		if row.keyed {
+			index[row.key] = append(index[row.key], i)
+		}
+	}
+
+	var rows []map[string]interface{}
+	for _, leftRow := range left {
+		var matches []int
+		if leftRow.keyed {
+			matches = index[leftRow.key]
+		}
+		if len(matches) == 0 && join.Outer {
+			rows = append(rows, joinedRow(join, leftRow.data, nil))
+		}
+		for _, match := range matches {
+			rows = append(rows, joinedRow(join, leftRow.data, right[match].data))
+		}
+		if len(rows) > joinMaxRows {
+			return nil, fmt.Errorf("JOIN produces more than %d rows, narrow it with a WHERE condition", joinMaxRows)
+		}
+	}
+	return rows, nil
+}
+
+// joinedRow combines a document of each side into a row, right is nil for an unmatched row
+// of a LEFT JOIN
+func joinedRow(join *joinSpec, left, right map[string]interface{}) map[string]interface{} {
+	row := make(map[string]interface{}, len(left)+len(right)+2)
+	for _, side := range []struct {
+		alias   string
+		docData map[string]interface{}
+	}{{join.Left.Alias, left}, {join.Right.Alias, right}} {
+		if side.docData == nil {
+			continue
+		}
+		row[side.alias] = side.docData
+		for key, value := range side.docData {
+			row[side.alias+"."+key] = value
+		}
+	}
+	return row
+}
+
+// joinColumns returns the columns of SELECT * over joined rows: the fields of the left
+// collection, then those of the right one, each sorted and qualified with its alias
+func joinColumns(rows []map[string]interface{}, join *joinSpec) []string {
+	var columns []string
+	for _, alias := range []string{join.Left.Alias, join.Right.Alias} {
+		fieldSet := make(map[string]bool)
+		for _, row := range rows {
+			if docData, ok := row[alias].(map[string]interface{}); ok {
+				for field := range docData {
+					fieldSet[field] = true
+				}
+			}
+		}
+		fields := make([]string, 0, len(fieldSet))
+		for field := range fieldSet {
+			fields = append(fields, alias+"."+field)
+		}
+		sort.Strings(fields)
+		columns = append(columns, fields...)
+	}
+	return columns
+}
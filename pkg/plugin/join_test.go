@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJoin(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		join  joinSpec
+		rest  string
+	}{
+		{
+			"aliases",
+			"SELECT o.total, c.name FROM orders o JOIN customers c ON o.customerId = c.__name__ WHERE o.status = 'paid'",
+			joinSpec{Left: joinSide{"orders", "o", "customerId"}, Right: joinSide{"customers", "c", "__name__"}},
+			"SELECT o.total, c.name FROM orders WHERE o.status = 'paid'",
+		},
+		{
+			"left join with as",
+			"select * from orders as o left outer join customers as c on c.id = o.customerId limit 5",
+			joinSpec{Left: joinSide{"orders", "o", "customerId"}, Right: joinSide{"customers", "c", "id"}, Outer: true},
+			"select * FROM orders limit 5",
+		},
+		{
+			"default aliases",
+			"SELECT * FROM shops/acme/orders INNER JOIN customers ON orders.customerId=customers.__name__",
+			joinSpec{Left: joinSide{"shops/acme/orders", "orders", "customerId"}, Right: joinSide{"customers", "customers", "__name__"}},
+			"SELECT * FROM shops/acme/orders",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			join, rest, err := parseJoin(tt.query)
+			require.NoError(t, err)
+			require.Equal(t, tt.join, *join)
+			require.Equal(t, tt.rest, rest)
+		})
+	}
+
+	join, rest, err := parseJoin("SELECT * FROM orders WHERE note = 'join'")
+	require.NoError(t, err)
+	require.Nil(t, join)
+	require.Equal(t, "SELECT * FROM orders WHERE note = 'join'", rest)
+
+	for _, query := range []string{
+		"SELECT * FROM orders o JOIN customers c ON o.customerId = x.id",
+		"SELECT * FROM orders o JOIN customers o ON o.customerId = o.id",
+		"SELECT * FROM orders o FULL JOIN customers c ON o.customerId = c.id",
+		"SELECT * FROM a JOIN b ON a.x = b.x JOIN c ON b.y = c.y",
+		"SELECT * FROM orders o JOIN customers c USING (id)",
+	} {
+		_, _, err := parseJoin(query)
+		require.Error(t, err, query)
+	}
+}
+
+func TestParseJoinQuery(t *testing.T) {
+	info, err := parseSQLQueryWithVariables("SELECT c.brand, SUM(o.total) AS revenue FROM orders o JOIN customers c ON o.customerId = c.__name__ WHERE o.ts >= $__from AND o.ts <= $__to GROUP BY c.brand")
+	require.NoError(t, err)
+	require.Equal(t, "orders", info.Collection)
+	require.Equal(t, "o.ts", info.TimeField)
+	require.Equal(t, []string{"c.brand"}, info.GroupByFields)
+	require.Equal(t, "customers", info.Join.Right.Collection)
+
+	_, err = parseSQLQueryWithVariables("SELECT total FROM orders o JOIN customers c ON o.customerId = c.__name__")
+	require.ErrorContains(t, err, "must be qualified")
+}
+
+func TestJoinKey(t *testing.T) {
+	ref := &firestore.DocumentRef{ID: "c1", Path: "projects/p/databases/(default)/documents/customers/c1"}
+	byID, _ := joinKey("c1", false)
+	refByName, _ := joinKey(ref, true)
+	require.Equal(t, byID, refByName)
+	refByPath, _ := joinKey(ref, false)
+	require.NotEqual(t, byID, refByPath)
+
+	intKey, _ := joinKey(int64(7), false)
+	floatKey, _ := joinKey(7.0, false)
+	require.Equal(t, intKey, floatKey)
+	stringKey, _ := joinKey("7", false)
+	require.NotEqual(t, intKey, stringKey)
+
+	tsKey, ok := joinKey(time.Unix(0, 0), false)
+	require.True(t, ok)
+	require.Equal(t, "t:1970-01-01T00:00:00Z", tsKey)
+
+	_, ok = joinKey(nil, false)
+	require.False(t, ok)
+}
+
+func TestHashJoin(t *testing.T) {
+	key := func(value interface{}) (string, bool) { return joinKey(value, false) }
+	row := func(value interface{}, docData map[string]interface{}) joinRow {
+		k, keyed := key(value)
+		return joinRow{key: k, keyed: keyed, data: docData}
+	}
+	left := []joinRow{
+		row("c1", map[string]interface{}{"total": int64(10), "customerId": "c1"}),
+		row("c2", map[string]interface{}{"total": int64(20), "customerId": "c2"}),
+		row(nil, map[string]interface{}{"total": int64(30)}),
+	}
+	right := []joinRow{
+		row("c1", map[string]interface{}{"name": "Ana", "address": map[string]interface{}{"city": "Madrid"}}),
+	}
+
+	join := &joinSpec{Left: joinSide{"orders", "o", "customerId"}, Right: joinSide{"customers", "c", "__name__"}}
+	rows, err := hashJoin(left, right, join)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, int64(10), selectFieldValue(rows[0], "o.total"))
+	require.Equal(t, "Ana", selectFieldValue(rows[0], "c.name"))
+	require.Equal(t, "Madrid", selectFieldValue(rows[0], "c.address.city"))
+	require.Equal(t, []string{"o.customerId", "o.total", "c.address", "c.name"}, joinColumns(rows, join))
+
+	join.Outer = true
+	rows, err = hashJoin(left, right, join)
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.Nil(t, selectFieldValue(rows[1], "c.name"))
+	require.Equal(t, int64(30), selectFieldValue(rows[2], "o.total"))
+
+	many := make([]joinRow, joinMaxRows+1)
+	for i := range many {
+		many[i] = row("c1", map[string]interface{}{})
+	}
+	_, err = hashJoin(many, right, join)
+	require.Error(t, err)
+}
@@ -0,0 +1,53 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// defaultLimitFor returns the defaultLimit setting applied to a query without a LIMIT, 0 when it
+// doesn't apply. Aggregates and histograms read every matching document whatever their LIMIT,
+// paged queries are limited by their page size and a default limit above maxRows is already
+// enforced by maxRows.
+func defaultLimitFor(info *QueryInfo, qm FirestoreQuery, settings *FirestoreSettings) int {
+	limit := settings.DefaultLimit
+	switch {
+	case limit <= 0 || limit > qm.MaxRows:
+		return 0
+	case info != nil && (info.Limit > 0 || len(info.GroupByFields) > 0 || len(info.AggregateFields) > 0):
+		return 0
+	case distributionFormat(qm.Format) || qm.PageSize > 0:
+		return 0
+	}
+	return limit
+}
+
+// hasLimitClause reports whether a query has a LIMIT outside literals and comments
+func hasLimitClause(query string) bool {
+	words, _ := sqlWords(query)
+	for _, word := range words {
+		if word == "LIMIT" {
+			return true
+		}
+	}
+	return false
+}
+
+// reportDefaultLimit adds a notice when a query without a LIMIT returned as many rows as the
+// default limit, so more rows may match
+func (m *queryMeta) reportDefaultLimit(rows, limit int) {
+	if limit > 0 && rows >= limit {
+		m.addNotice(data.NoticeSeverityInfo, fmt.Sprintf("The query has no LIMIT, only the first %d rows are returned by the data source's default limit. Add a LIMIT to return more.", limit))
+	}
+}
+
+// responseRows returns the rows of the largest frame of a response
+func responseRows(response backend.DataResponse) int {
+	rows := 0
+	for _, frame := range response.Frames {
+		rows = max(rows, frame.Rows())
+	}
+	return rows
+}
@@ -0,0 +1,52 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultLimitFor(t *testing.T) {
+	settings := &FirestoreSettings{DefaultLimit: 100}
+	qm := FirestoreQuery{MaxRows: defaultMaxRows}
+	tests := []struct {
+		name     string
+		info     *QueryInfo
+		qm       FirestoreQuery
+		settings *FirestoreSettings
+		limit    int
+	}{
+		{"no limit", &QueryInfo{Collection: "dialogs"}, qm, settings, 100},
+		{"fireql", nil, qm, settings, 100},
+		{"not set", &QueryInfo{Collection: "dialogs"}, qm, &FirestoreSettings{}, 0},
+		{"query limit", &QueryInfo{Collection: "dialogs", Limit: 5}, qm, settings, 0},
+		{"group by", &QueryInfo{Collection: "dialogs", GroupByFields: []string{"brand"}}, qm, settings, 0},
+		{"aggregate", &QueryInfo{Collection: "dialogs", AggregateFields: []AggregateInfo{{Function: "COUNT", Field: "*"}}}, qm, settings, 0},
+		{"histogram", &QueryInfo{Collection: "dialogs"}, FirestoreQuery{MaxRows: defaultMaxRows, Format: formatHistogram}, settings, 0},
+		{"above maxRows", &QueryInfo{Collection: "dialogs"}, FirestoreQuery{MaxRows: 50}, settings, 0},
+		{"paged", &QueryInfo{Collection: "dialogs"}, FirestoreQuery{MaxRows: defaultMaxRows, PageSize: 20}, settings, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.limit, defaultLimitFor(tt.info, tt.qm, tt.settings))
+		})
+	}
+}
+
+func TestHasLimitClause(t *testing.T) {
+	require.True(t, hasLimitClause("SELECT * FROM dialogs LIMIT 10"))
+	require.True(t, hasLimitClause("select * from dialogs limit 10"))
+	require.False(t, hasLimitClause("SELECT * FROM dialogs WHERE note = 'LIMIT 10'"))
+	require.False(t, hasLimitClause("SELECT * FROM dialogs -- LIMIT 10"))
+}
+
+func TestReportDefaultLimit(t *testing.T) {
+	meta := &queryMeta{}
+	meta.reportDefaultLimit(99, 100)
+	meta.reportDefaultLimit(10, 0)
+	require.Empty(t, meta.notices)
+
+	meta.reportDefaultLimit(100, 100)
+	require.Len(t, meta.notices, 1)
+	require.Contains(t, meta.notices[0].Text, "first 100 rows")
+}
@@ -0,0 +1,40 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// defaultMaxActiveQueries is the number of queries a datasource runs at the same time when
+// the maxActiveQueries setting is not set
+const defaultMaxActiveQueries = 50
+
+// newQuerySlots returns the slots of the queries a datasource runs at the same time, across
+// every dashboard and user, so simultaneous refreshes queue instead of exhausting the plugin
+// memory and the Firestore quotas of the project
+func newQuerySlots(maxActiveQueries int) (*semaphore.Weighted, int) {
+	if maxActiveQueries <= 0 {
+		maxActiveQueries = defaultMaxActiveQueries
+	}
+	return semaphore.NewWeighted(int64(maxActiveQueries)), maxActiveQueries
+}
+
+// acquireQuerySlot waits for a free query slot until the query context is done, returning
+// the function releasing it
+func (d *Datasource) acquireQuerySlot(ctx context.Context) (func(), error) {
+	if d.querySlots == nil {
+		return func() {}, nil
+	}
+	if !d.querySlots.TryAcquire(1) {
+		queriesQueued.Inc()
+		d.debugLog(ctx, "Waiting for a query slot", "maxActiveQueries", d.maxActiveQueries)
+		err := d.querySlots.Acquire(ctx, 1)
+		queriesQueued.Dec()
+		if err != nil {
+			return nil, fmt.Errorf("all %d query slots of the datasource stayed busy: %w", d.maxActiveQueries, err)
+		}
+	}
+	return func() { d.querySlots.Release(1) }, nil
+}
@@ -0,0 +1,52 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewQuerySlots(t *testing.T) {
+	_, limit := newQuerySlots(0)
+	require.Equal(t, defaultMaxActiveQueries, limit)
+	_, limit = newQuerySlots(5)
+	require.Equal(t, 5, limit)
+}
+
+func TestAcquireQuerySlot(t *testing.T) {
+	d := &Datasource{}
+	d.querySlots, d.maxActiveQueries = newQuerySlots(1)
+
+	release, err := d.acquireQuerySlot(context.Background())
+	require.NoError(t, err)
+
+	// The next query waits until its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = d.acquireQuerySlot(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "all 1 query slots of the datasource stayed busy")
+
+	// or a slot is released
+	acquired := make(chan struct{})
+	go func() {
+		next, err := d.acquireQuerySlot(context.Background())
+		if err == nil {
+			next()
+		}
+		close(acquired)
+	}()
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("slot not acquired after the release")
+	}
+
+	// Datasources without slots don't wait
+	release, err = (&Datasource{}).acquireQuerySlot(context.Background())
+	require.NoError(t, err)
+	release()
+}
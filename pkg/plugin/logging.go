@@ -0,0 +1,216 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// parseLogLevel returns the level of the logLevel setting, Info when it is not set
+func parseLogLevel(level string) (log.Level, error) {
+	switch strings.ToLower(level) {
+	case "":
+		return log.Info, nil
+	case "debug":
+		return log.Debug, nil
+	case "info":
+		return log.Info, nil
+	case "warn", "warning":
+		return log.Warn, nil
+	case "error":
+		return log.Error, nil
+	default:
+		return log.Info, fmt.Errorf("invalid logLevel %q, expected debug, info, warn or error", level)
+	}
+}
+
+// levelLogger drops the messages below the log level of a datasource instance
+type levelLogger struct {
+	logger log.Logger
+	level  log.Level
+}
+
+func (l *levelLogger) Debug(msg string, args ...interface{}) {
+	if l.level <= log.Debug {
+		l.logger.Debug(msg, args...)
+	}
+}
+
+func (l *levelLogger) Info(msg string, args ...interface{}) {
+	if l.level <= log.Info {
+		l.logger.Info(msg, args...)
+	}
+}
+
+func (l *levelLogger) Warn(msg string, args ...interface{}) {
+	if l.level <= log.Warn {
+		l.logger.Warn(msg, args...)
+	}
+}
+
+func (l *levelLogger) Error(msg string, args ...interface{}) {
+	l.logger.Error(msg, args...)
+}
+
+func (l *levelLogger) With(args ...interface{}) log.Logger {
+	return &levelLogger{logger: l.logger.With(args...), level: l.level}
+}
+
+func (l *levelLogger) Level() log.Level {
+	if l.logger.Level() > l.level {
+		return l.logger.Level()
+	}
+	return l.level
+}
+
+func (l *levelLogger) FromContext(ctx context.Context) log.Logger {
+	return &levelLogger{logger: l.logger.FromContext(ctx), level: l.level}
+}
+
+// logger returns the logger of a request: it carries the plugin context and trace fields
+// of ctx, honours the logLevel setting of the datasource instance and redacts sensitive values
+func (d *Datasource) logger(ctx context.Context) log.Logger {
+	level := d.logLevel
+	if level == log.NoLevel {
+		level = log.Info
+	}
+	return &levelLogger{logger: &redactingLogger{logger: log.DefaultLogger.FromContext(ctx)}, level: level}
+}
+
+// defaultLogger is the logger of code running outside a datasource instance, it redacts
+// sensitive values like the datasource logger
+func defaultLogger() log.Logger {
+	return &redactingLogger{logger: log.DefaultLogger}
+}
+
+// redactedText replaces a sensitive value in the logs
+const redactedText = "[redacted]"
+
+// Log keys whose values are query text: their literals are redacted, their structure is kept
+var queryLogKeys = map[string]bool{
+	"query":           true,
+	"originalQuery":   true,
+	"finalQuery":      true,
+	"whereClause":     true,
+	"condition":       true,
+	"splitConditions": true,
+}
+
+// Log keys whose values are document contents, filter values or credentials
+var valueLogKeys = map[string]bool{
+	"value":          true,
+	"expectedValue":  true,
+	"actualValue":    true,
+	"parts":          true,
+	"data":           true,
+	"doc":            true,
+	"serviceAccount": true,
+	"token":          true,
+}
+
+// queryLiteralPattern matches the string and number literals of a query
+var queryLiteralPattern = regexp.MustCompile(`'(?:[^']|'')*'|"(?:[^"]|"")*"|\b\d+(?:\.\d+)?\b`)
+
+// errorLiteralPattern matches the quoted values and the document paths an error message
+// repeats, like the literal FireQL failed near or the document Firestore didn't find. Bare
+// numbers are kept, they are mostly positions and status codes.
+var errorLiteralPattern = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.|"")*"|/documents/\S+`)
+
+// redactQuery replaces the literals of a query, which hold the filter values, with ?
+func redactQuery(query string) string {
+	return queryLiteralPattern.ReplaceAllString(query, "?")
+}
+
+// redactError replaces the quoted values and document paths of an error message with ?
+func redactError(message string) string {
+	return errorLiteralPattern.ReplaceAllStringFunc(message, func(literal string) string {
+		if strings.HasPrefix(literal, "/documents/") {
+			return "/documents/?"
+		}
+		return "?"
+	})
+}
+
+// redactArgs returns the key/value pairs of a log call with sensitive values redacted
+func redactArgs(args []interface{}) []interface{} {
+	redacted := make([]interface{}, len(args))
+	copy(redacted, args)
+	for i := 0; i+1 < len(redacted); i += 2 {
+		key, _ := redacted[i].(string)
+		redacted[i+1] = redactValue(key, redacted[i+1])
+	}
+	return redacted
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	switch {
+	case valueLogKeys[key]:
+		return redactedText
+	case key == "error":
+		switch v := value.(type) {
+		case error:
+			return redactError(v.Error())
+		case string:
+			return redactError(v)
+		}
+		return value
+	case queryLogKeys[key]:
+		switch v := value.(type) {
+		case string:
+			return redactQuery(v)
+		case []string:
+			queries := make([]string, len(v))
+			for i, query := range v {
+				queries[i] = redactQuery(query)
+			}
+			return queries
+		default:
+			return redactedText
+		}
+	}
+	// Filters are logged as their field and operator only
+	if filters, ok := value.([]FilterInfo); ok {
+		described := make([]string, len(filters))
+		for i, filter := range filters {
+			described[i] = filter.Field + " " + filter.Operator + " ?"
+		}
+		return described
+	}
+	return value
+}
+
+// redactingLogger keeps document contents, filter values and credentials out of the logs
+type redactingLogger struct {
+	logger log.Logger
+}
+
+func (l *redactingLogger) Debug(msg string, args ...interface{}) {
+	l.logger.Debug(msg, redactArgs(args)...)
+}
+
+func (l *redactingLogger) Info(msg string, args ...interface{}) {
+	l.logger.Info(msg, redactArgs(args)...)
+}
+
+func (l *redactingLogger) Warn(msg string, args ...interface{}) {
+	l.logger.Warn(msg, redactArgs(args)...)
+}
+
+func (l *redactingLogger) Error(msg string, args ...interface{}) {
+	l.logger.Error(msg, redactArgs(args)...)
+}
+
+func (l *redactingLogger) With(args ...interface{}) log.Logger {
+	return &redactingLogger{logger: l.logger.With(redactArgs(args)...)}
+}
+
+func (l *redactingLogger) Level() log.Level {
+	return l.logger.Level()
+}
+
+func (l *redactingLogger) FromContext(ctx context.Context) log.Logger {
+	return &redactingLogger{logger: l.logger.FromContext(ctx)}
+}
@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingLogger keeps the messages logged through it
+type recordingLogger struct {
+	messages *[]string
+}
+
+func (l recordingLogger) Debug(msg string, _ ...interface{}) { *l.messages = append(*l.messages, msg) }
+func (l recordingLogger) Info(msg string, _ ...interface{})  { *l.messages = append(*l.messages, msg) }
+func (l recordingLogger) Warn(msg string, _ ...interface{})  { *l.messages = append(*l.messages, msg) }
+func (l recordingLogger) Error(msg string, _ ...interface{}) { *l.messages = append(*l.messages, msg) }
+func (l recordingLogger) With(_ ...interface{}) log.Logger   { return l }
+func (l recordingLogger) Level() log.Level                   { return log.Debug }
+func (l recordingLogger) FromContext(_ context.Context) log.Logger {
+	return l
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		setting string
+		level   log.Level
+		valid   bool
+	}{
+		{"", log.Info, true},
+		{"debug", log.Debug, true},
+		{"WARN", log.Warn, true},
+		{"error", log.Error, true},
+		{"verbose", log.Info, false},
+	}
+
+	for _, tt := range tests {
+		level, err := parseLogLevel(tt.setting)
+		require.Equal(t, tt.level, level, tt.setting)
+		require.Equal(t, tt.valid, err == nil, tt.setting)
+	}
+}
+
+func TestLevelLogger(t *testing.T) {
+	var messages []string
+	logger := (&levelLogger{logger: recordingLogger{&messages}, level: log.Warn}).With("refId", "A")
+
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+
+	require.Equal(t, []string{"warn", "error"}, messages)
+}
+
+func TestNewDatasourceLogLevel(t *testing.T) {
+	instance, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(`{"logLevel":"error"}`)})
+	require.NoError(t, err)
+	defer instance.(*Datasource).Dispose()
+	require.Equal(t, log.Error, instance.(*Datasource).logLevel)
+
+	require.Equal(t, log.Info, (&Datasource{}).logger(context.Background()).(*levelLogger).level)
+}
+
+func TestRedactArgs(t *testing.T) {
+	args := redactArgs([]interface{}{
+		"query", "SELECT * FROM customers WHERE msisdn = '600000000' AND age > 30 LIMIT 10",
+		"actualValue", "600000000",
+		"additionalFilters", []FilterInfo{{Field: "msisdn", Operator: "==", Value: "600000000"}},
+		"splitConditions", []string{"msisdn = 600000000"},
+		"collection", "customers",
+		"documents", 3,
+		"error", errors.New("syntax error at position 48 near '600000000'"),
+		"error", "rpc error: code = NotFound desc = no entity: projects/p/databases/(default)/documents/customers/600000000",
+	})
+
+	require.Equal(t, []interface{}{
+		"query", "SELECT * FROM customers WHERE msisdn = ? AND age > ? LIMIT ?",
+		"actualValue", redactedText,
+		"additionalFilters", []string{"msisdn == ?"},
+		"splitConditions", []string{"msisdn = ?"},
+		"collection", "customers",
+		"documents", 3,
+		"error", "syntax error at position 48 near ?",
+		"error", "rpc error: code = NotFound desc = no entity: projects/p/databases/(default)/documents/?",
+	}, args)
+}
@@ -0,0 +1,154 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// defaultLogMessageField is used as the log body when the query doesn't set logMessageField
+const defaultLogMessageField = "message"
+
+// convertFirestoreDocsToLogsResponse converts Firestore documents to a logs frame
+// (time, body, level, labels) that the Logs panel and Explore render natively
+func (d *Datasource) convertFirestoreDocsToLogsResponse(ctx context.Context, docs []*firestore.DocumentSnapshot, queryInfo *QueryInfo, qm FirestoreQuery) backend.DataResponse {
+	var response backend.DataResponse
+
+	timeField := queryInfo.TimeField
+	if timeField == "" {
+		timeField = qm.TimeField
+	}
+	messageField := qm.LogMessageField
+	if messageField == "" {
+		messageField = defaultLogMessageField
+	}
+	labelFields := logLabelFields(queryInfo, qm, timeField, messageField)
+
+	times := make([]time.Time, 0, len(docs))
+	bodies := make([]string, 0, len(docs))
+	levels := make([]string, 0, len(docs))
+	labels := make([]json.RawMessage, 0, len(docs))
+	ids := make([]string, 0, len(docs))
+
+	for i, doc := range docs {
+		if err := checkCanceled(ctx, i); err != nil {
+			return firestoreErrorResponse("", err)
+		}
+		if doc == nil {
+			d.logger(ctx).Warn("convertFirestoreDocsToLogsResponse: Skipping nil document", "index", i)
+			continue
+		}
+		// Tails convert their snapshots here, a document that can't be decoded is skipped.
+		// The documents of a query were charged to the budget as they were read.
+		_, charged := queryInfo.Decoded[doc]
+		docData, err := queryInfo.Decoded.take(doc)
+		if err != nil {
+			d.logger(ctx).Warn("convertFirestoreDocsToLogsResponse: Skipping a document that can't be decoded", "document", doc.Ref.ID, "error", err)
+			continue
+		}
+		if docData == nil {
+			continue
+		}
+		if !charged {
+			if err := queryInfo.MemoryBudget.add(docData); err != nil {
+				return budgetExceededResponse(err)
+			}
+		}
+		convertDocumentRefs(docData, queryInfo.RefFormat)
+		addDocumentMetadata(docData, doc, labelFields)
+
+		ts, _ := toTime(getNestedFieldValue(docData, timeField), queryInfo.timeFormatOf(timeField), queryInfo.Location)
+		times = append(times, ts)
+
+		body := ""
+		if value := getNestedFieldValue(docData, messageField); value != nil {
+			body = fmt.Sprintf("%v", value)
+		}
+		bodies = append(bodies, body)
+
+		level := "unknown"
+		if qm.LogLevelField != "" {
+			level = normalizeLogLevel(getNestedFieldValue(docData, qm.LogLevelField))
+		}
+		levels = append(levels, level)
+
+		rowLabels := make(map[string]string, len(labelFields))
+		for _, labelField := range labelFields {
+			if value := getNestedFieldValue(docData, labelField); value != nil {
+				rowLabels[labelField] = fmt.Sprintf("%v", value)
+			}
+		}
+		labelsJSON, err := json.Marshal(rowLabels)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusInternal, "json.Marshal labels: "+err.Error())
+		}
+		labels = append(labels, labelsJSON)
+
+		ids = append(ids, doc.Ref.ID)
+	}
+
+	frame := data.NewFrame("logs",
+		data.NewField("time", nil, times),
+		data.NewField("body", nil, bodies),
+		data.NewField("level", nil, levels),
+		data.NewField("labels", nil, labels),
+		data.NewField("id", nil, ids),
+	)
+	frame.SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeLogs})
+	if queryInfo.DataplaneLogs {
+		// Named after the fields of the dataplane log lines
+		frame.Fields[0].Name = "timestamp"
+		frame.Fields[2].Name = "severity"
+		frame.Meta.Type = data.FrameTypeLogLines
+	}
+
+	response.Frames = append(response.Frames, frame)
+	return response
+}
+
+// logLabelFields returns the fields used as log labels: the explicit logLabelFields option,
+// or otherwise every selected field that isn't already the time, message or level field
+func logLabelFields(queryInfo *QueryInfo, qm FirestoreQuery, timeField, messageField string) []string {
+	if len(qm.LogLabelFields) > 0 {
+		return qm.LogLabelFields
+	}
+
+	var fields []string
+	for _, field := range queryInfo.Fields {
+		if field == "*" || field == timeField || field == messageField || field == qm.LogLevelField {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// normalizeLogLevel maps the stored level value to one of the levels understood by Grafana
+func normalizeLogLevel(value interface{}) string {
+	if value == nil {
+		return "unknown"
+	}
+
+	switch strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", value))) {
+	case "critical", "crit", "fatal", "emerg", "emergency", "alert", "panic":
+		return "critical"
+	case "error", "err", "eror", "severe":
+		return "error"
+	case "warning", "warn":
+		return "warning"
+	case "info", "information", "informational", "notice":
+		return "info"
+	case "debug", "dbug", "fine":
+		return "debug"
+	case "trace", "finest", "finer":
+		return "trace"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeLogLevel(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"ERROR", "error"},
+		{"warn", "warning"},
+		{" Info ", "info"},
+		{"fatal", "critical"},
+		{"debug", "debug"},
+		{"trace", "trace"},
+		{"something", "unknown"},
+		{nil, "unknown"},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, normalizeLogLevel(tt.value))
+	}
+}
+
+func TestLogsFrameFieldNames(t *testing.T) {
+	docs := readSnapshots(t, validDocument("a"))
+	names := func(frame *data.Frame) []string {
+		var names []string
+		for _, field := range frame.Fields {
+			names = append(names, field.Name)
+		}
+		return names
+	}
+
+	response := (&Datasource{}).convertFirestoreDocsToLogsResponse(context.Background(), docs, &QueryInfo{}, FirestoreQuery{Format: formatLogs})
+	require.NoError(t, response.Error)
+	require.Equal(t, []string{"time", "body", "level", "labels", "id"}, names(response.Frames[0]))
+	require.Empty(t, setFrameTypes(response, formatLogs, "").Frames[0].Meta.Type)
+
+	// The dataplane names are opt-in, with the log lines type
+	response = (&Datasource{}).convertFirestoreDocsToLogsResponse(context.Background(), docs, &QueryInfo{DataplaneLogs: true}, FirestoreQuery{Format: formatLogs})
+	require.NoError(t, response.Error)
+	require.Equal(t, []string{"timestamp", "body", "severity", "labels", "id"}, names(response.Frames[0]))
+	require.Equal(t, data.FrameTypeLogLines, setFrameTypes(response, formatLogs, "").Frames[0].Meta.Type)
+}
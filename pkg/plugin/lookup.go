@@ -0,0 +1,291 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// lookupMaxFields caps the fields a lookup adds to the results
+	lookupMaxFields = 5
+	// lookupMaxDocuments caps the documents of a lookup collection, larger ones need a JOIN
+	lookupMaxDocuments = 10000
+	// lookupCacheTTL is how long a lookup collection is served from the cache
+	lookupCacheTTL = 5 * time.Minute
+	// lookupCacheSize is the most lookup tables a datasource instance caches
+	lookupCacheSize = 32
+)
+
+// LookupOptions enrich the results of a query with fields of the documents of another
+// collection, matched on a column of the results like a LEFT JOIN. The lookup collection is
+// read once and cached, so dimension tables such as customers or products are cheap to join.
+type LookupOptions struct {
+	Collection string `json:"collection"`
+
+	// Key is the result column holding the looked up value
+	Key string `json:"key"`
+
+	// By is the field of the lookup documents matching Key, the document ID when empty. A
+	// document ID also matches a document path, as references are returned.
+	By string `json:"by,omitempty"`
+
+	// Fields are the fields of the lookup documents added to the results as columns
+	Fields []string `json:"fields"`
+}
+
+// validateLookup checks the lookup options of a query, nil is no lookup
+func validateLookup(lookup *LookupOptions) error {
+	if lookup == nil {
+		return nil
+	}
+	switch {
+	case !collectionPathPattern.MatchString(lookup.Collection):
+		return fmt.Errorf("lookup: invalid collection %q", lookup.Collection)
+	case lookup.Key == "":
+		return fmt.Errorf("lookup: key is required")
+	case lookup.By != "" && lookup.By != docNameColumn && !fieldPathPattern.MatchString(lookup.By):
+		return fmt.Errorf("lookup: invalid by field %q", lookup.By)
+	case len(lookup.Fields) == 0 || len(lookup.Fields) > lookupMaxFields:
+		return fmt.Errorf("lookup: between 1 and %d fields are required", lookupMaxFields)
+	}
+	for _, field := range lookup.Fields {
+		if !fieldPathPattern.MatchString(field) {
+			return fmt.Errorf("lookup: invalid field %q", field)
+		}
+	}
+	return nil
+}
+
+// byName reports whether the lookup matches the document IDs
+func (l *LookupOptions) byName() bool {
+	return l.By == "" || l.By == docNameColumn
+}
+
+// cacheKey identifies the lookup table read for the options, at the snapshot time of a
+// readTime query
+func (l *LookupOptions) cacheKey(refFormat string, readTime time.Time) string {
+	var snapshot string
+	if !readTime.IsZero() {
+		snapshot = readTime.UTC().Format(time.RFC3339Nano)
+	}
+	return strings.Join([]string{l.Collection, l.By, strings.Join(l.Fields, ","), refFormat, snapshot}, "\x00")
+}
+
+// lookupTable holds the looked up fields of the documents of a lookup collection by key
+type lookupTable struct {
+	rows     map[string][]interface{}
+	loadedAt time.Time
+}
+
+// lookupCache keeps the lookup tables read through a datasource until they expire. Once it
+// holds lookupCacheSize tables, the expired ones and then the oldest make room for new ones.
+type lookupCache struct {
+	mu     sync.Mutex
+	tables map[string]*lookupTable
+}
+
+func newLookupCache() *lookupCache {
+	return &lookupCache{tables: make(map[string]*lookupTable)}
+}
+
+// get returns a cached lookup table read less than lookupCacheTTL ago
+func (c *lookupCache) get(key string, now time.Time) (*lookupTable, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	table, ok := c.tables[key]
+	if !ok || now.Sub(table.loadedAt) >= lookupCacheTTL {
+		return nil, false
+	}
+	return table, true
+}
+
+func (c *lookupCache) put(key string, table *lookupTable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.tables[key]; !ok && len(c.tables) >= lookupCacheSize {
+		c.evict(table.loadedAt)
+	}
+	c.tables[key] = table
+}
+
+// evict drops the expired tables, or the oldest one when none has expired. The caller
+// holds the lock.
+func (c *lookupCache) evict(now time.Time) {
+	var oldest string
+	for key, table := range c.tables {
+		if now.Sub(table.loadedAt) >= lookupCacheTTL {
+			delete(c.tables, key)
+			continue
+		}
+		if oldest == "" || table.loadedAt.Before(c.tables[oldest].loadedAt) {
+			oldest = key
+		}
+	}
+	if len(c.tables) >= lookupCacheSize {
+		delete(c.tables, oldest)
+	}
+}
+
+// lookupKey returns the key a value is matched on, its text so numbers match however they
+// are typed in the results. False for a missing value.
+func lookupKey(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, v != ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), true
+	default:
+		return fmt.Sprintf("%v", v), true
+	}
+}
+
+// lookupTableFor returns the lookup table of a query, from the cache or read from Firestore
+// as of the query's readTime
+func (d *Datasource) lookupTableFor(ctx context.Context, pCtx backend.PluginContext, qm FirestoreQuery, meta *queryMeta) (*lookupTable, error) {
+	lookup := qm.Lookup
+	readTime, err := qm.readTime(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	key := lookup.cacheKey(qm.RefFormat, readTime)
+	if d.lookups != nil {
+		if table, ok := d.lookups.get(key, time.Now()); ok {
+			return table, nil
+		}
+	}
+
+	client, err := newFirestoreClient(ctx, pCtx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	if !readTime.IsZero() {
+		client.WithReadOptions(firestore.ReadTime(readTime))
+	}
+
+	query := client.Collection(lookup.Collection).Query
+	fields := append([]string{}, lookup.Fields...)
+	if !lookup.byName() {
+		fields = append(fields, lookup.By)
+	}
+	query = query.Select(fields...).Limit(lookupMaxDocuments + 1)
+	started := time.Now()
+	docs, err := d.getAllDocuments(ctx, "lookup", query)
+	if err != nil {
+		return nil, err
+	}
+	meta.addFetch(started, len(docs))
+	meta.addDocumentsRead(routeNative, len(docs))
+	if len(docs) > lookupMaxDocuments {
+		return nil, fmt.Errorf("lookup collection %s has more than %d documents, use a JOIN", lookup.Collection, lookupMaxDocuments)
+	}
+	docs, decoded, err := d.skipUndecodable(ctx, docs, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	table := newLookupTable(docs, decoded, lookup, qm.RefFormat)
+	if d.lookups != nil {
+		d.lookups.put(key, table)
+	}
+	return table, nil
+}
+
+// newLookupTable keys the looked up fields of the documents by their document ID or By field
+func newLookupTable(docs []*firestore.DocumentSnapshot, decoded decodedDocuments, lookup *LookupOptions, refFormat string) *lookupTable {
+	table := &lookupTable{rows: make(map[string][]interface{}, len(docs)), loadedAt: time.Now()}
+	for _, doc := range docs {
+		docData, err := decoded.take(doc)
+		if err != nil {
+			continue
+		}
+		docData = convertDocumentRefs(docData, refFormat).(map[string]interface{})
+		var byValue interface{}
+		if lookup.byName() {
+			byValue = documentMetadata(doc, docNameColumn)
+		} else {
+			byValue = selectFieldValue(docData, lookup.By)
+		}
+		key, ok := lookupKey(byValue)
+		if !ok {
+			continue
+		}
+		values := make([]interface{}, len(lookup.Fields))
+		for i, field := range lookup.Fields {
+			values[i] = selectFieldValue(docData, field)
+		}
+		table.rows[key] = values
+	}
+	return table
+}
+
+// applyLookup adds the looked up fields as columns to the frames of a response that have the
+// key column. Rows without a matching document get nulls.
+func (d *Datasource) applyLookup(ctx context.Context, pCtx backend.PluginContext, qm FirestoreQuery, response backend.DataResponse, meta *queryMeta) backend.DataResponse {
+	lookup := qm.Lookup
+	table, err := d.lookupTableFor(ctx, pCtx, qm, meta)
+	if err != nil {
+		d.logger(ctx).Error("Lookup failed", "collection", lookup.Collection, "error", err)
+		return firestoreErrorResponse("Lookup: ", err)
+	}
+	if err := enrichFrames(response.Frames, lookup, table); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	return response
+}
+
+// enrichFrames adds the looked up fields to the frames with the key column
+func enrichFrames(frames data.Frames, lookup *LookupOptions, table *lookupTable) error {
+	found := false
+	for _, frame := range frames {
+		keyField, idx := frame.FieldByName(lookup.Key)
+		if idx == -1 {
+			continue
+		}
+		found = true
+		for _, field := range lookup.Fields {
+			if _, existing := frame.FieldByName(field); existing != -1 {
+				return fmt.Errorf("lookup: field %s is already a column of the results", field)
+			}
+		}
+
+		columns := make([][]interface{}, len(lookup.Fields))
+		for i := range columns {
+			columns[i] = make([]interface{}, keyField.Len())
+		}
+		for row := 0; row < keyField.Len(); row++ {
+			value, _ := keyField.ConcreteAt(row)
+			key, ok := lookupKey(value)
+			if ok && lookup.byName() {
+				// References hold the path of the document
+				key = key[strings.LastIndex(key, "/")+1:]
+			}
+			values, ok := table.rows[key]
+			if !ok {
+				continue
+			}
+			for i := range columns {
+				columns[i][row] = values[i]
+			}
+		}
+		for i, field := range lookup.Fields {
+			frame.Fields = append(frame.Fields, newTypedField(field, columns[i]))
+		}
+	}
+	if !found && len(frames) > 0 {
+		return fmt.Errorf("lookup: key %s is not a column of the results", lookup.Key)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateLookup(t *testing.T) {
+	require.NoError(t, validateLookup(nil))
+	require.NoError(t, validateLookup(&LookupOptions{Collection: "customers", Key: "customerId", Fields: []string{"name", "segment"}}))
+	require.NoError(t, validateLookup(&LookupOptions{Collection: "products", Key: "sku", By: "sku", Fields: []string{"title"}}))
+	require.Error(t, validateLookup(&LookupOptions{Collection: "customers", Key: "customerId"}))
+	require.Error(t, validateLookup(&LookupOptions{Collection: "customers", Fields: []string{"name"}}))
+	require.Error(t, validateLookup(&LookupOptions{Collection: "a b", Key: "customerId", Fields: []string{"name"}}))
+	require.Error(t, validateLookup(&LookupOptions{Collection: "customers", Key: "customerId", Fields: []string{"name", "a", "b", "c", "d", "e"}}))
+}
+
+func TestLookupKey(t *testing.T) {
+	intKey, _ := lookupKey(int64(7))
+	floatKey, _ := lookupKey(7.0)
+	require.Equal(t, "7", intKey)
+	require.Equal(t, intKey, floatKey)
+
+	_, ok := lookupKey("")
+	require.False(t, ok)
+	_, ok = lookupKey(nil)
+	require.False(t, ok)
+}
+
+func TestLookupCache(t *testing.T) {
+	cache := newLookupCache()
+	now := time.Now()
+	cache.put("customers", &lookupTable{loadedAt: now})
+
+	_, ok := cache.get("customers", now.Add(time.Minute))
+	require.True(t, ok)
+	_, ok = cache.get("customers", now.Add(lookupCacheTTL))
+	require.False(t, ok)
+	_, ok = cache.get("products", now)
+	require.False(t, ok)
+
+	// A full cache drops the oldest table, or the expired ones when there are
+	for i := 0; i < lookupCacheSize; i++ {
+		cache.put(fmt.Sprintf("table%d", i), &lookupTable{loadedAt: now.Add(time.Duration(i+1) * time.Second)})
+	}
+	require.Len(t, cache.tables, lookupCacheSize)
+	require.NotContains(t, cache.tables, "customers")
+	require.Contains(t, cache.tables, "table0")
+	cache.put("products", &lookupTable{loadedAt: now.Add(lookupCacheTTL + 2*time.Second)})
+	require.Len(t, cache.tables, lookupCacheSize-1)
+	require.NotContains(t, cache.tables, "table0")
+	require.NotContains(t, cache.tables, "table1")
+	require.Contains(t, cache.tables, "products")
+}
+
+func TestLookupCacheKey(t *testing.T) {
+	lookup := &LookupOptions{Collection: "customers", Key: "customerId", Fields: []string{"name"}}
+	readTime := time.Date(2024, 5, 10, 11, 30, 0, 0, time.UTC)
+	require.NotEqual(t, lookup.cacheKey("", time.Time{}), lookup.cacheKey("", readTime))
+	require.NotEqual(t, lookup.cacheKey("", readTime), lookup.cacheKey("", readTime.Add(time.Minute)))
+	require.Equal(t, lookup.cacheKey("", readTime), lookup.cacheKey("", readTime.In(time.FixedZone("CEST", 2*60*60))))
+}
+
+func TestEnrichFrames(t *testing.T) {
+	lookup := &LookupOptions{Collection: "customers", Key: "customer", Fields: []string{"name", "tier"}}
+	table := &lookupTable{rows: map[string][]interface{}{
+		"c1": {"Ana", int64(1)},
+		"c2": {"Luis", int64(2)},
+	}}
+
+	frame := data.NewFrame("response",
+		data.NewField("customer", nil, []*string{ptr("customers/c1"), ptr("c2"), ptr("c3"), nil}),
+		data.NewField("total", nil, []int64{10, 20, 30, 40}),
+	)
+	require.NoError(t, enrichFrames(data.Frames{frame}, lookup, table))
+	require.Len(t, frame.Fields, 4)
+
+	name, _ := frame.FieldByName("name")
+	require.Equal(t, "Ana", *name.At(0).(*string))
+	require.Equal(t, "Luis", *name.At(1).(*string))
+	require.Nil(t, name.At(2))
+	require.Nil(t, name.At(3))
+	tier, _ := frame.FieldByName("tier")
+	require.Equal(t, int64(2), *tier.At(1).(*int64))
+
+	// The looked up fields can't replace a column
+	require.Error(t, enrichFrames(data.Frames{frame}, lookup, table))
+
+	byField := &LookupOptions{Collection: "tiers", Key: "total", By: "amount", Fields: []string{"label"}}
+	require.Error(t, enrichFrames(data.Frames{data.NewFrame("response", data.NewField("other", nil, []int64{1}))}, byField, table))
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
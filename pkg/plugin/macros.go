@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"net/http"
+	"regexp"
+)
+
+// catalogEntry describes a macro, function, operator or column of the query language
+type catalogEntry struct {
+	Name        string `json:"name"`
+	Signature   string `json:"signature"`
+	Description string `json:"description"`
+}
+
+// queryCatalog lists what the backend supports in queries, so the editor's autocompletion
+// follows the backend
+type queryCatalog struct {
+	Macros     []catalogEntry `json:"macros"`
+	Aggregates []catalogEntry `json:"aggregates"`
+	Functions  []catalogEntry `json:"functions"`
+	Operators  []catalogEntry `json:"operators"`
+	Columns    []catalogEntry `json:"columns"`
+}
+
+// timeFilterMacro matches $__timeFilter(field)
+var timeFilterMacro = regexp.MustCompile(`\$__timeFilter\(\s*([^()\s]+)\s*\)`)
+
+// expandTimeFilterMacro rewrites $__timeFilter(field) as field >= $__from AND field <= $__to,
+// which the native route and FireQL filter in the format the field is stored in
+func expandTimeFilterMacro(query string) string {
+	return timeFilterMacro.ReplaceAllString(query, "$1 >= $$__from AND $1 <= $$__to")
+}
+
+// catalog is the query language supported by the backend
+var catalog = queryCatalog{
+	Macros: []catalogEntry{
+		{"$__from", "field >= $__from", "Start of the panel time range, filters the time field server-side"},
+		{"$__to", "field <= $__to", "End of the panel time range, filters the time field server-side"},
+		{"$__timeFilter", "$__timeFilter(field)", "Panel time range of a field, in the epoch unit or format of the field"},
+		{":name", "field = :name", "Parameter bound from the query's params as a typed value"},
+	},
+	Aggregates: []catalogEntry{
+		{"COUNT", "COUNT(*)", "Number of documents of the group, COUNT(field) those where the field is set and not null"},
+		{"SUM", "SUM(field)", "Sum of a numeric field, items[].price sums through arrays"},
+		{"AVG", "AVG(field)", "Average of a numeric field"},
+		{"MIN", "MIN(field)", "Smallest value of a field"},
+		{"MAX", "MAX(field)", "Largest value of a field"},
+		{"STRING_AGG", "STRING_AGG([DISTINCT] field, 'separator')", "Values of a field joined by the separator, also GROUP_CONCAT(field SEPARATOR 'separator')"},
+		{"RATE", "RATE(field)", "Per-second increase of a counter over each time bucket, resets handled"},
+		{"DELTA", "DELTA(field)", "Increase of a counter over each time bucket, resets handled"},
+	},
+	Functions: []catalogEntry{
+		{"date_trunc", "date_trunc('unit', field[, 'timezone'])", "Time bucket of a GROUP BY: second, minute, hour, day, week, month, quarter or year"},
+		{"week", "week(field[, 'timezone'])", "Calendar week bucket of a GROUP BY, starting on Monday"},
+		{"month", "month(field[, 'timezone'])", "Calendar month bucket of a GROUP BY"},
+		{"quarter", "quarter(field[, 'timezone'])", "Calendar quarter bucket of a GROUP BY"},
+		{"year", "year(field[, 'timezone'])", "Calendar year bucket of a GROUP BY"},
+		{"MOVING_AVG", "MOVING_AVG(aggregate, rows | 'duration')", "Moving average of another aggregate of the query over its series"},
+		{"ROW_NUMBER", "ROW_NUMBER() OVER (PARTITION BY field ORDER BY field DESC)", "Position of the row in its partition"},
+		{"RANK", "RANK() OVER (PARTITION BY field ORDER BY field DESC)", "Position of the row in its partition, ties share a rank"},
+	},
+	Operators: []catalogEntry{
+		{"=", "field = value", "Equal, also =="},
+		{"!=", "field != value", "Not equal, also <>"},
+		{"<", "field < value", "Less than"},
+		{"<=", "field <= value", "Less than or equal"},
+		{">", "field > value", "Greater than"},
+		{">=", "field >= value", "Greater than or equal"},
+		{"AND", "condition AND condition", "Both conditions"},
+		{"OR", "condition OR condition", "Either condition, evaluated by FireQL"},
+		{"IN", "field IN (value, ...)", "Any of the values, evaluated by FireQL"},
+		{"LIKE", "field LIKE 'pattern%'", "Pattern match, evaluated by FireQL"},
+	},
+	Columns: []catalogEntry{
+		{docNameColumn, docNameColumn, "Document ID"},
+		{docPathColumn, docPathColumn, "Full document path"},
+		{docCreateTimeColumn, docCreateTimeColumn, "Time the document was created"},
+		{docUpdateTimeColumn, docUpdateTimeColumn, "Time the document was last updated"},
+		{docCollectionColumn, docCollectionColumn, "Collection path of the document"},
+	},
+}
+
+// handleMacros returns the macros, functions, operators and pseudo-columns the backend
+// supports: GET /metadata/macros
+func (d *Datasource) handleMacros(w http.ResponseWriter, r *http.Request) {
+	writeResourceJSON(w, catalog)
+}
@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMacrosResource(t *testing.T) {
+	d := &Datasource{}
+	d.resourceHandler = newResourceHandler(d)
+
+	var response *backend.CallResourceResponse
+	sender := backend.CallResourceResponseSenderFunc(func(res *backend.CallResourceResponse) error {
+		response = res
+		return nil
+	})
+	err := d.CallResource(context.Background(), &backend.CallResourceRequest{Method: http.MethodGet, Path: "metadata/macros", URL: "metadata/macros"}, sender)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.Status)
+
+	var result queryCatalog
+	require.NoError(t, json.Unmarshal(response.Body, &result))
+	require.Equal(t, catalog, result)
+}
+
+func TestCatalogAggregates(t *testing.T) {
+	names := make(map[string]bool)
+	for _, entry := range catalog.Aggregates {
+		names[entry.Name] = true
+	}
+	for function := range builderFunctions {
+		require.True(t, names[function], function)
+	}
+	for function := range counterFunctions {
+		require.True(t, names[function], function)
+	}
+
+	for _, entry := range catalog.Functions {
+		switch {
+		case entry.Name == "MOVING_AVG":
+			_, isWindow, err := parseWindowFunction("MOVING_AVG(total, 5)")
+			require.True(t, isWindow)
+			require.NoError(t, err)
+		case rankPattern.MatchString(entry.Name + "() OVER (ORDER BY ts)"):
+		default:
+			_, isBucket, err := parseDateTrunc(entry.Name + "(ts)")
+			if entry.Name == "date_trunc" {
+				_, isBucket, err = parseDateTrunc("date_trunc('day', ts)")
+			}
+			require.True(t, isBucket, entry.Name)
+			require.NoError(t, err, entry.Name)
+		}
+	}
+}
+
+func TestExpandTimeFilterMacro(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT * FROM events WHERE $__timeFilter(ts)", "SELECT * FROM events WHERE ts >= $__from AND ts <= $__to"},
+		{"SELECT * FROM events WHERE $__timeFilter( meta.createdAt ) AND type = 'click'", "SELECT * FROM events WHERE meta.createdAt >= $__from AND meta.createdAt <= $__to AND type = 'click'"},
+		{"SELECT * FROM events WHERE ts >= $__from", "SELECT * FROM events WHERE ts >= $__from"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, expandTimeFilterMacro(tt.query))
+	}
+}
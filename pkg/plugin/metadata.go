@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Document metadata pseudo-columns selectable in the native SDK path
+const (
+	docNameColumn       = "__name__"       // document ID
+	docPathColumn       = "__path__"       // full document path
+	docCreateTimeColumn = "__createTime__" // snapshot create time
+	docUpdateTimeColumn = "__updateTime__" // snapshot update time
+	docCollectionColumn = "__collection__" // path of the collection holding the document
+)
+
+// isMetadataColumn reports whether the field is a document metadata pseudo-column
+func isMetadataColumn(field string) bool {
+	switch field {
+	case docNameColumn, docPathColumn, docCreateTimeColumn, docUpdateTimeColumn, docCollectionColumn:
+		return true
+	default:
+		return false
+	}
+}
+
+// documentMetadata returns the value of a metadata pseudo-column for a document
+func documentMetadata(doc *firestore.DocumentSnapshot, column string) interface{} {
+	switch column {
+	case docNameColumn:
+		if doc.Ref != nil {
+			return doc.Ref.ID
+		}
+	case docPathColumn:
+		if doc.Ref != nil {
+			return doc.Ref.Path
+		}
+	case docCreateTimeColumn:
+		if !doc.CreateTime.IsZero() {
+			return doc.CreateTime
+		}
+	case docUpdateTimeColumn:
+		if !doc.UpdateTime.IsZero() {
+			return doc.UpdateTime
+		}
+	case docCollectionColumn:
+		if doc.Ref != nil && doc.Ref.Parent != nil {
+			// Relative to the database, as FROM names the collection
+			_, collection, _ := strings.Cut(doc.Ref.Parent.Path, "/documents/")
+			return collection
+		}
+	}
+	return nil
+}
+
+// addDocumentMetadata adds the selected metadata pseudo-columns to the document data
+func addDocumentMetadata(docData map[string]interface{}, doc *firestore.DocumentSnapshot, fields []string) {
+	for _, field := range fields {
+		if isMetadataColumn(field) {
+			docData[field] = documentMetadata(doc, field)
+		}
+	}
+}
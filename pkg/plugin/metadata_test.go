@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortDocsByMetadataTime(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	docs := []*firestore.DocumentSnapshot{
+		{CreateTime: base.Add(2 * time.Hour), UpdateTime: base},
+		{CreateTime: base, UpdateTime: base.Add(time.Hour)},
+		{CreateTime: base.Add(time.Hour), UpdateTime: base.Add(2 * time.Hour)},
+	}
+
+	sortDocuments(docs, []OrderKey{{Field: docCreateTimeColumn}})
+	require.Equal(t, base, docs[0].CreateTime)
+	require.Equal(t, base.Add(2*time.Hour), docs[2].CreateTime)
+
+	sortDocuments(docs, []OrderKey{{Field: docUpdateTimeColumn, Descending: true}})
+	require.Equal(t, base.Add(2*time.Hour), docs[0].UpdateTime)
+	require.Equal(t, base, docs[2].UpdateTime)
+}
+
+func TestDocumentMetadata(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	doc := &firestore.DocumentSnapshot{CreateTime: created}
+
+	require.Equal(t, created, documentMetadata(doc, docCreateTimeColumn))
+	require.Nil(t, documentMetadata(doc, docUpdateTimeColumn))
+	require.Nil(t, documentMetadata(doc, docNameColumn))
+	require.True(t, isMetadataColumn(docPathColumn))
+	require.False(t, isMetadataColumn("name"))
+}
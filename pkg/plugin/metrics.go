@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metricsNamespace prefixes the plugin metrics, which the SDK serves to Grafana from
+// the default Prometheus registry
+const metricsNamespace = "firestore_datasource"
+
+// Query execution routes used as metric labels
+const (
+	routeNative = "native"
+	routeFireQL = "fireql"
+)
+
+var (
+	queriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "queries_total",
+		Help:      "Queries executed, by execution route.",
+	}, []string{"route"})
+
+	queryErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "query_errors_total",
+		Help:      "Queries that returned an error, by response status and error source.",
+	}, []string{"status", "source"})
+
+	queryDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "query_duration_seconds",
+		Help:      "Query execution latency.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	})
+
+	documentsFetchedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "documents_fetched_total",
+		Help:      "Documents read from Firestore, by execution route.",
+	}, []string{"route"})
+
+	queriesQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queries_queued",
+		Help:      "Queries waiting for a free slot of their datasource.",
+	})
+
+	schemaCacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "schema_cache_requests_total",
+		Help:      "Collection schema cache lookups, by result (hit or miss).",
+	}, []string{"result"})
+)
+
+// observeQuery records the latency and the outcome of a query
+func observeQuery(start time.Time, response backend.DataResponse) {
+	queryDuration.Observe(time.Since(start).Seconds())
+	if response.Error == nil {
+		return
+	}
+	source := string(response.ErrorSource)
+	if source == "" {
+		source = string(backend.ErrorSourcePlugin)
+	}
+	queryErrorsTotal.WithLabelValues(strconv.Itoa(int(response.Status)), source).Inc()
+}
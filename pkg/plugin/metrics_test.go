@@ -0,0 +1,43 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserveQuery(t *testing.T) {
+	downstream := queryErrorsTotal.WithLabelValues("403", string(backend.ErrorSourceDownstream))
+	plugin := queryErrorsTotal.WithLabelValues("400", string(backend.ErrorSourcePlugin))
+	downstreamBefore, pluginBefore := testutil.ToFloat64(downstream), testutil.ToFloat64(plugin)
+
+	observeQuery(time.Now(), backend.DataResponse{})
+	observeQuery(time.Now(), backend.ErrDataResponseWithSource(backend.StatusForbidden, backend.ErrorSourceDownstream, "denied"))
+	observeQuery(time.Now(), backend.ErrDataResponse(backend.StatusBadRequest, "parse error"))
+
+	require.Equal(t, downstreamBefore+1, testutil.ToFloat64(downstream))
+	require.Equal(t, pluginBefore+1, testutil.ToFloat64(plugin))
+}
+
+func TestSchemaCacheMetrics(t *testing.T) {
+	hits := schemaCacheRequestsTotal.WithLabelValues("hit")
+	misses := schemaCacheRequestsTotal.WithLabelValues("miss")
+	hitsBefore, missesBefore := testutil.ToFloat64(hits), testutil.ToFloat64(misses)
+
+	cache := newSchemaCache(func(_ context.Context, collection string) (*collectionSchema, error) {
+		return &collectionSchema{Collection: collection}, nil
+	}, time.Hour)
+	defer cache.close()
+
+	_, err := cache.get(context.Background(), "users")
+	require.NoError(t, err)
+	_, err = cache.get(context.Background(), "users")
+	require.NoError(t, err)
+
+	require.Equal(t, hitsBefore+1, testutil.ToFloat64(hits))
+	require.Equal(t, missesBefore+1, testutil.ToFloat64(misses))
+}
@@ -0,0 +1,205 @@
+package plugin
+
+import (
+	"cmp"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// OrderKey is one ORDER BY sort key
+type OrderKey struct {
+	Field      string
+	Descending bool
+}
+
+// direction returns the SQL keyword of the key's sort direction
+func (k OrderKey) direction() string {
+	if k.Descending {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// parseOrderKeys parses a comma-separated ORDER BY clause such as "brand ASC, total DESC"
+func parseOrderKeys(orderClause string) []OrderKey {
+	var keys []OrderKey
+	for _, part := range strings.Split(orderClause, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 {
+			continue
+		}
+		keys = append(keys, OrderKey{
+			Field:      cleanBackticks(words[0]),
+			Descending: len(words) >= 2 && strings.ToUpper(words[1]) == "DESC",
+		})
+	}
+	return keys
+}
+
+// kindRank orders values of different kinds: nulls first, then booleans, numbers, times,
+// strings and JSON values
+func kindRank(kind valueKind) int {
+	switch kind {
+	case kindNull:
+		return 0
+	case kindBool:
+		return 1
+	case kindInt, kindFloat:
+		return 2
+	case kindTime:
+		return 3
+	case kindString:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// compareValues compares two Firestore values, returning -1, 0 or 1. Values of the same
+// kind compare naturally, integers exactly, values of different kinds by kindRank.
+func compareValues(a, b interface{}) int {
+	kindA, kindB := kindOf(a), kindOf(b)
+	if rankA, rankB := kindRank(kindA), kindRank(kindB); rankA != rankB {
+		if rankA < rankB {
+			return -1
+		}
+		return 1
+	}
+
+	switch kindA {
+	case kindNull:
+		return 0
+	case kindBool:
+		boolA, boolB := a.(bool), b.(bool)
+		switch {
+		case boolA == boolB:
+			return 0
+		case !boolA:
+			return -1
+		default:
+			return 1
+		}
+	case kindInt, kindFloat:
+		if intA, ok := toInt64(a); ok {
+			if intB, ok := toInt64(b); ok {
+				return cmp.Compare(intA, intB)
+			}
+		}
+		floatA, _ := convertToFloat(a)
+		floatB, _ := convertToFloat(b)
+		return cmp.Compare(floatA, floatB)
+	case kindTime:
+		return a.(time.Time).Compare(b.(time.Time))
+	default:
+		return strings.Compare(stringValue(a), stringValue(b))
+	}
+}
+
+// lessByKeys reports whether the item read by a sorts before the item read by b, comparing
+// the order keys in turn until one differs
+func lessByKeys(keys []OrderKey, a, b func(key OrderKey) interface{}) bool {
+	for _, key := range keys {
+		c := compareValues(a(key), b(key))
+		if c == 0 {
+			continue
+		}
+		if key.Descending {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+// sortDocuments orders documents in memory by the order keys, reading metadata
+// pseudo-columns from the snapshots. Used when Firestore can't order server-side,
+// e.g. by snapshot create or update time, and to merge the collections of a wildcard FROM.
+func sortDocuments(docs []*firestore.DocumentSnapshot, keys []OrderKey) {
+	type sortRow struct {
+		doc    *firestore.DocumentSnapshot
+		values map[string]interface{}
+	}
+	rows := make([]sortRow, len(docs))
+	for i, doc := range docs {
+		rows[i] = sortRow{doc: doc, values: make(map[string]interface{}, len(keys))}
+		var docData map[string]interface{}
+		for _, key := range keys {
+			if isMetadataColumn(key.Field) {
+				rows[i].values[key.Field] = documentMetadata(doc, key.Field)
+				continue
+			}
+			if docData == nil {
+				docData = doc.Data()
+			}
+			rows[i].values[key.Field] = getNestedFieldValue(docData, key.Field)
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		return lessByKeys(keys,
+			func(key OrderKey) interface{} { return rows[i].values[key.Field] },
+			func(key OrderKey) interface{} { return rows[j].values[key.Field] })
+	})
+	for i, row := range rows {
+		docs[i] = row.doc
+	}
+}
+
+// sortRows orders in-memory rows by the order keys
+func sortRows(rows []map[string]interface{}, keys []OrderKey) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return lessByKeys(keys,
+			func(key OrderKey) interface{} { return selectFieldValue(rows[i], key.Field) },
+			func(key OrderKey) interface{} { return selectFieldValue(rows[j], key.Field) })
+	})
+}
+
+// aggregatedResultValue returns the value a GROUP BY result has for an ORDER BY field,
+// which names either a group field or an aggregate by its alias or function name
+func aggregatedResultValue(result AggregatedResult, queryInfo *QueryInfo, field string) interface{} {
+	for i, groupField := range queryInfo.GroupByFields {
+		if groupField == field && i < len(result.GroupValues) {
+			return result.GroupValues[i]
+		}
+	}
+	for i, aggField := range queryInfo.AggregateFields {
+		if !strings.EqualFold(field, aggField.Alias) && field != aggregateFieldName(aggField) && field != strings.ToLower(aggField.Function) {
+			continue
+		}
+		if i < len(result.AggregateValues) {
+			return result.AggregateValues[i]
+		}
+	}
+	return nil
+}
+
+// sortAggregatedResults orders GROUP BY results by the query's ORDER BY keys. Results that
+// tie on every key are ordered by their group values, so bar charts don't reshuffle
+// between refreshes.
+func sortAggregatedResults(results []AggregatedResult, queryInfo *QueryInfo) {
+	sort.SliceStable(results, func(i, j int) bool {
+		valueI := func(key OrderKey) interface{} { return aggregatedResultValue(results[i], queryInfo, key.Field) }
+		valueJ := func(key OrderKey) interface{} { return aggregatedResultValue(results[j], queryInfo, key.Field) }
+		switch {
+		case lessByKeys(queryInfo.OrderBy, valueI, valueJ):
+			return true
+		case lessByKeys(queryInfo.OrderBy, valueJ, valueI):
+			return false
+		default:
+			return compareGroupValues(results[i], results[j]) < 0
+		}
+	})
+}
+
+// compareGroupValues compares the group values of two results in GROUP BY order
+func compareGroupValues(a, b AggregatedResult) int {
+	for i := 0; i < len(a.GroupValues) && i < len(b.GroupValues); i++ {
+		if c := compareValues(a.GroupValues[i], b.GroupValues[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
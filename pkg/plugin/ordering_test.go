@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOrderKeys(t *testing.T) {
+	require.Equal(t, []OrderKey{
+		{Field: "brand"},
+		{Field: "total", Descending: true},
+		{Field: "clientData.region"},
+	}, parseOrderKeys("brand ASC, total desc, `clientData`.`region`"))
+	require.Empty(t, parseOrderKeys(" "))
+}
+
+func TestCompareValues(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		a, b interface{}
+		want int
+	}{
+		{int64(2), int64(10), -1},
+		{int64(9007199254740993), int64(9007199254740992), 1},
+		{int64(2), 1.5, 1},
+		{1.5, 1.5, 0},
+		{"b", "a", 1},
+		{false, true, -1},
+		{ts, ts.Add(time.Second), -1},
+		{nil, int64(0), -1},
+		{int64(5), "5", -1},
+		{nil, nil, 0},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, compareValues(tt.a, tt.b), "%v vs %v", tt.a, tt.b)
+	}
+}
+
+func TestSortAggregatedResults(t *testing.T) {
+	queryInfo, err := parseSQLQueryWithVariables("SELECT brand, status, COUNT(*) AS total FROM orders GROUP BY brand, status ORDER BY total DESC, brand")
+	require.NoError(t, err)
+
+	results := []AggregatedResult{
+		{GroupValues: []interface{}{"yoigo", "open"}, AggregateValues: []interface{}{int64(2)}},
+		{GroupValues: []interface{}{"orange", "open"}, AggregateValues: []interface{}{int64(5)}},
+		{GroupValues: []interface{}{"masmovil", "closed"}, AggregateValues: []interface{}{int64(2)}},
+		{GroupValues: []interface{}{nil, "closed"}, AggregateValues: []interface{}{int64(2)}},
+	}
+	sortAggregatedResults(results, queryInfo)
+
+	var brands []interface{}
+	for _, result := range results {
+		brands = append(brands, result.GroupValues[0])
+	}
+	require.Equal(t, []interface{}{"orange", nil, "masmovil", "yoigo"}, brands)
+}
+
+func TestSortAggregatedResultsByAggregates(t *testing.T) {
+	queryInfo, err := parseSQLQueryWithVariables("SELECT region, COUNT(*) AS total, AVG(latency) AS avg_latency FROM requests GROUP BY region ORDER BY total DESC, AVG_LATENCY ASC")
+	require.NoError(t, err)
+
+	results := []AggregatedResult{
+		{GroupValues: []interface{}{int64(10)}, AggregateValues: []interface{}{int64(3), 20.0}},
+		{GroupValues: []interface{}{int64(9)}, AggregateValues: []interface{}{int64(3), 20.0}},
+		{GroupValues: []interface{}{int64(2)}, AggregateValues: []interface{}{int64(3), 35.0}},
+		{GroupValues: []interface{}{int64(1)}, AggregateValues: []interface{}{int64(7), 50.0}},
+		{GroupValues: []interface{}{int64(4)}, AggregateValues: []interface{}{int64(3), 10.0}},
+	}
+	sortAggregatedResults(results, queryInfo)
+
+	var regions []interface{}
+	for _, result := range results {
+		regions = append(regions, result.GroupValues[0])
+	}
+	// Ties on both aggregates are ordered by the group value, 9 before 10
+	require.Equal(t, []interface{}{int64(1), int64(4), int64(9), int64(10), int64(2)}, regions)
+}
@@ -0,0 +1,166 @@
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pageToken is the cursor of the next page of a query, handed to the frontend opaque
+type pageToken struct {
+	// ID is the document the next page starts after
+	ID string `json:"id"`
+
+	// Rows is the number of rows of the previous pages, counted against the LIMIT
+	Rows int `json:"rows"`
+}
+
+// encodePageToken returns the opaque form of a page token
+func encodePageToken(token pageToken) string {
+	raw, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodePageToken parses the pageToken of a query
+func decodePageToken(text string) (pageToken, error) {
+	var token pageToken
+	raw, err := base64.RawURLEncoding.DecodeString(text)
+	if err == nil {
+		err = json.Unmarshal(raw, &token)
+	}
+	if err != nil || token.ID == "" || token.Rows < 0 {
+		return pageToken{}, errors.New("invalid pageToken, pass the nextPageToken of the previous page")
+	}
+	return token, nil
+}
+
+// validatePaging checks the pageSize and pageToken options
+func validatePaging(qm FirestoreQuery) error {
+	switch {
+	case qm.PageSize < 0:
+		return fmt.Errorf("pageSize must be positive, got %d", qm.PageSize)
+	case qm.PageSize > qm.MaxRows:
+		return fmt.Errorf("pageSize %d is above maxRows %d", qm.PageSize, qm.MaxRows)
+	case qm.PageToken != "" && qm.PageSize == 0:
+		return errors.New("pageToken needs a pageSize")
+	case qm.PageSize > 0 && qm.Stream:
+		return errors.New("streamed queries can't be paged")
+	}
+	if qm.PageToken != "" {
+		_, err := decodePageToken(qm.PageToken)
+		return err
+	}
+	return nil
+}
+
+// pagingUnsupported returns why a query can't be paged, empty when Firestore can page it. A
+// page is a Firestore cursor, so the query must be filtered, ordered and limited server-side.
+func pagingUnsupported(qm FirestoreQuery, info *QueryInfo) string {
+	switch {
+	case info.Join != nil:
+		return "joins can't be paged"
+	case info.Subquery != nil:
+		return "subqueries can't be paged"
+	case len(info.GroupByFields) > 0 || len(info.AggregateFields) > 0:
+		return "GROUP BY and aggregates can't be paged"
+	case len(info.Ranks) > 0:
+		return "window functions can't be paged"
+	case isCollectionPattern(info.Collection):
+		return "wildcard collections can't be paged"
+	case distributionFormat(qm.Format):
+		return fmt.Sprintf("the %s format can't be paged", qm.Format)
+	}
+	for _, filter := range info.AdditionalFilters {
+		if info.filteredInMemory(filter) {
+			return fmt.Sprintf("WHERE %s is filtered in memory and can't be paged", filter.Field)
+		}
+	}
+	for _, key := range info.OrderBy {
+		if key.Field == docCreateTimeColumn || key.Field == docUpdateTimeColumn {
+			return fmt.Sprintf("ORDER BY %s can't be paged", key.Field)
+		}
+	}
+	return ""
+}
+
+// queryPage is the page of a query read with the pageSize option
+type queryPage struct {
+	size int
+
+	// limit is the LIMIT of the query across its pages, 0 without
+	limit int
+
+	// rows is the number of rows of the previous pages
+	rows int
+
+	// after is the last document of the previous page, nil on the first page
+	after *firestore.DocumentSnapshot
+}
+
+// queryPageOf returns the page a query reads, reading the document its token starts after
+func (d *Datasource) queryPageOf(ctx context.Context, client *firestore.Client, info *QueryInfo, qm FirestoreQuery, meta *queryMeta) (*queryPage, error) {
+	page := &queryPage{size: qm.PageSize, limit: info.Limit}
+	if qm.PageToken == "" {
+		return page, nil
+	}
+	token, err := decodePageToken(qm.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	if page.limit > 0 && token.Rows >= page.limit {
+		return nil, fmt.Errorf("invalid pageToken, its %d rows reach the LIMIT %d", token.Rows, page.limit)
+	}
+	page.rows = token.Rows
+
+	err = d.withRetries(ctx, "page cursor", func() (err error) {
+		page.after, err = client.Collection(info.Collection).Doc(token.ID).Get(ctx)
+		return err
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("the document %s the page starts after was deleted, restart from the first page", token.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta.addDocumentsRead(routeNative, 1)
+	return page, nil
+}
+
+// pageLimit returns the rows of the page, fewer on the last page of a query with a LIMIT
+func (p *queryPage) pageLimit() int {
+	if p.limit > 0 {
+		return min(p.size, p.limit-p.rows)
+	}
+	return p.size
+}
+
+// apply starts the query after the previous page and limits it to the page, reading one
+// document more when a next page may follow
+func (p *queryPage) apply(query firestore.Query, pushdown []string) (firestore.Query, []string) {
+	if p.after != nil {
+		query = query.StartAfter(p.after)
+		pushdown = append(pushdown, fmt.Sprintf("startAfter(%s)", p.after.Ref.ID))
+	}
+	limit := p.pageLimit()
+	if p.limit == 0 || p.rows+limit < p.limit {
+		limit++
+	}
+	return query.Limit(limit), append(pushdown, fmt.Sprintf("limit(%d)", limit))
+}
+
+// trim returns the documents of the page and records the page in the frame meta, with the
+// token of the next page when more documents follow
+func (p *queryPage) trim(docs []*firestore.DocumentSnapshot, meta *queryMeta) []*firestore.DocumentSnapshot {
+	meta.setCustom("pageSize", p.size)
+	if limit := p.pageLimit(); len(docs) > limit {
+		docs = docs[:limit]
+		meta.setCustom("nextPageToken", encodePageToken(pageToken{ID: docs[limit-1].Ref.ID, Rows: p.rows + limit}))
+	}
+	return docs
+}
@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPageToken(t *testing.T) {
+	text := encodePageToken(pageToken{ID: "dialog-42", Rows: 200})
+	token, err := decodePageToken(text)
+	require.NoError(t, err)
+	require.Equal(t, pageToken{ID: "dialog-42", Rows: 200}, token)
+
+	for _, invalid := range []string{"not a token", encodePageToken(pageToken{}), encodePageToken(pageToken{ID: "a", Rows: -1})} {
+		_, err := decodePageToken(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestValidatePaging(t *testing.T) {
+	token := encodePageToken(pageToken{ID: "a", Rows: 10})
+	tests := []struct {
+		name     string
+		qm       FirestoreQuery
+		expected string
+	}{
+		{name: "not paged", qm: FirestoreQuery{MaxRows: 100}},
+		{name: "first page", qm: FirestoreQuery{MaxRows: 100, PageSize: 10}},
+		{name: "next page", qm: FirestoreQuery{MaxRows: 100, PageSize: 10, PageToken: token}},
+		{name: "negative", qm: FirestoreQuery{MaxRows: 100, PageSize: -1}, expected: "pageSize must be positive, got -1"},
+		{name: "above maxRows", qm: FirestoreQuery{MaxRows: 100, PageSize: 500}, expected: "pageSize 500 is above maxRows 100"},
+		{name: "token only", qm: FirestoreQuery{MaxRows: 100, PageToken: token}, expected: "pageToken needs a pageSize"},
+		{name: "stream", qm: FirestoreQuery{MaxRows: 100, PageSize: 10, Stream: true}, expected: "streamed queries can't be paged"},
+		{name: "invalid token", qm: FirestoreQuery{MaxRows: 100, PageSize: 10, PageToken: "x"}, expected: "invalid pageToken, pass the nextPageToken of the previous page"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePaging(tt.qm)
+			if tt.expected == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPagingUnsupported(t *testing.T) {
+	tests := []struct {
+		name     string
+		qm       FirestoreQuery
+		expected string
+	}{
+		{name: "filters and ordering", qm: FirestoreQuery{Query: "SELECT * FROM dialogs WHERE status = 'open' ORDER BY created DESC LIMIT 100"}},
+		{name: "logs", qm: FirestoreQuery{Query: "SELECT * FROM events", Format: formatLogs}},
+		{name: "aggregates", qm: FirestoreQuery{Query: "SELECT status, COUNT(*) FROM dialogs GROUP BY status"}, expected: "GROUP BY and aggregates can't be paged"},
+		{name: "histogram", qm: FirestoreQuery{Query: "SELECT * FROM dialogs", Format: formatHistogram}, expected: "the histogram format can't be paged"},
+		{name: "metadata ordering", qm: FirestoreQuery{Query: "SELECT * FROM dialogs ORDER BY __createTime__"}, expected: "ORDER BY __createTime__ can't be paged"},
+		{name: "wildcard", qm: FirestoreQuery{Query: "SELECT * FROM logs_*"}, expected: "wildcard collections can't be paged"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := nativeQueryInfo(tt.qm, backend.TimeRange{})
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, pagingUnsupported(tt.qm, info))
+		})
+	}
+}
+
+func pageDocs(ids ...string) []*firestore.DocumentSnapshot {
+	docs := make([]*firestore.DocumentSnapshot, len(ids))
+	for i, id := range ids {
+		docs[i] = &firestore.DocumentSnapshot{Ref: &firestore.DocumentRef{ID: id}}
+	}
+	return docs
+}
+
+func TestQueryPage(t *testing.T) {
+	// The first page reads one document more to know whether a next page follows
+	page := &queryPage{size: 2}
+	_, pushdown := page.apply(firestore.Query{}, nil)
+	require.Equal(t, []string{"limit(3)"}, pushdown)
+
+	meta := &queryMeta{}
+	docs := page.trim(pageDocs("a", "b", "c"), meta)
+	require.Len(t, docs, 2)
+	require.Equal(t, 2, meta.custom["pageSize"])
+	token, err := decodePageToken(meta.custom["nextPageToken"].(string))
+	require.NoError(t, err)
+	require.Equal(t, pageToken{ID: "b", Rows: 2}, token)
+
+	// The last page has no next page
+	meta = &queryMeta{}
+	require.Len(t, page.trim(pageDocs("c"), meta), 1)
+	require.NotContains(t, meta.custom, "nextPageToken")
+
+	// The page reaching the LIMIT of the query is its last
+	page = &queryPage{size: 2, limit: 5, rows: 4, after: pageDocs("d")[0]}
+	_, pushdown = page.apply(firestore.Query{}, nil)
+	require.Equal(t, []string{"startAfter(d)", "limit(1)"}, pushdown)
+	meta = &queryMeta{}
+	require.Len(t, page.trim(pageDocs("e"), meta), 1)
+	require.NotContains(t, meta.custom, "nextPageToken")
+}
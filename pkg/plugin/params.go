@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// paramPlaceholder stands for a parameter in the SQL the native planner parses, its value is
+// bound to the filter afterwards. The NUL byte can't be typed in a query.
+const paramPlaceholder = "\x00param:"
+
+// paramNamePattern matches the name of a query parameter
+var paramNamePattern = regexp.MustCompile(`^[\p{L}_][\p{L}\p{N}_]*$`)
+
+// validateParams checks the parameters of a query: their names and their values, which
+// must be strings, numbers, booleans or null
+func validateParams(params map[string]interface{}) error {
+	for name, value := range params {
+		if !paramNamePattern.MatchString(name) {
+			return fmt.Errorf("params: invalid parameter name %q", name)
+		}
+		switch v := value.(type) {
+		case nil, string, bool, int, int64:
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("params: :%s is not a finite number", name)
+			}
+		default:
+			return fmt.Errorf("params: :%s must be a string, number, boolean or null, got %T", name, value)
+		}
+	}
+	return nil
+}
+
+// bindParams replaces the :name parameters of a query, outside string literals, quoted
+// identifiers and comments, with the text bind returns for them. A parameter is the value
+// of a comparison like msisdn = :msisdn; other words starting with a colon are kept unless
+// params has a value for them.
+func bindParams(query string, params map[string]interface{}, bind func(name string, value interface{}) string) (string, error) {
+	var b strings.Builder
+	runes := []rune(query)
+	last := 0
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\'' || r == '"' || r == '`':
+			for i++; i < len(runes); i++ {
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			for i += 2; i < len(runes) && !(runes[i-1] == '*' && runes[i] == '/'); i++ {
+			}
+		case r == ':' && i+1 < len(runes) && (unicode.IsLetter(runes[i+1]) || runes[i+1] == '_'):
+			if i > 0 && (runes[i-1] == ':' || unicode.IsLetter(runes[i-1]) || unicode.IsDigit(runes[i-1]) || runes[i-1] == '_') {
+				continue
+			}
+			end := i + 1
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_') {
+				end++
+			}
+			name := string(runes[i+1 : end])
+			value, bound := params[name]
+			compared := comparedParam(runes[:i])
+			switch {
+			case !compared && !bound:
+				continue
+			case !compared:
+				return "", fmt.Errorf("parameter :%s must be the value of a comparison like field = :%s", name, name)
+			case !bound:
+				return "", fmt.Errorf("parameter :%s has no value in params", name)
+			}
+			b.WriteString(string(runes[last:i]))
+			b.WriteString(bind(name, value))
+			last = end
+			i = end - 1
+		}
+	}
+	b.WriteString(string(runes[last:]))
+	return b.String(), nil
+}
+
+// comparedParam reports whether the text before a parameter ends with a comparison operator
+func comparedParam(before []rune) bool {
+	i := len(before) - 1
+	for i >= 0 && unicode.IsSpace(before[i]) {
+		i--
+	}
+	return i >= 0 && strings.ContainsRune("=<>", before[i])
+}
+
+// paramValue returns the value a parameter is compared with, whole numbers as integers as
+// in SQL literals
+func paramValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < math.MaxInt64 {
+			return int64(v)
+		}
+	case int:
+		return int64(v)
+	}
+	return value
+}
+
+// sqlLiteralEscaper escapes a string for a FireQL literal. FireQL parses the MySQL grammar,
+// where a backslash escapes the next character, so backslashes are escaped before quotes
+// are doubled and a value can't end the literal.
+var sqlLiteralEscaper = strings.NewReplacer(`\`, `\\`, "'", "''")
+
+// sqlLiteral renders a parameter as a SQL literal for FireQL, which has no parameters
+func sqlLiteral(_ string, value interface{}) string {
+	switch v := paramValue(value).(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + sqlLiteralEscaper.Replace(v) + "'"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// nativeParam binds a parameter with a placeholder the native planner parses as a literal
+func nativeParam(name string, _ interface{}) string {
+	return paramPlaceholder + name
+}
+
+// bindFilterParams sets the values of the parameters on the filters and SELECT expressions of
+// a parsed query and its subqueries
+func bindFilterParams(info *QueryInfo, params map[string]interface{}) {
+	bind := func(filter *FilterInfo) {
+		if literal, ok := filter.Value.(string); ok && strings.HasPrefix(literal, paramPlaceholder) {
+			filter.Value = paramValue(params[strings.TrimPrefix(literal, paramPlaceholder)])
+		}
+	}
+	for query := info; query != nil; query = query.Subquery {
+		for i := range query.AdditionalFilters {
+			bind(&query.AdditionalFilters[i])
+		}
+		for i, expression := range query.Expressions {
+			bind(&query.Expressions[i].Condition)
+			// An expression without AS is named after its text, as the query wrote it
+			alias := strings.ReplaceAll(expression.Alias, paramPlaceholder, ":")
+			query.Expressions[i].Alias = alias
+			for j, field := range query.Fields {
+				if field == expression.Alias {
+					query.Fields[j] = alias
+				}
+			}
+		}
+		for i, condition := range query.IgnoredConditions {
+			query.IgnoredConditions[i] = strings.ReplaceAll(condition, paramPlaceholder, ":")
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+	"github.com/xwb1989/sqlparser"
+)
+
+func TestBindParams(t *testing.T) {
+	params := map[string]interface{}{"msisdn": "6'33", "min": 10.0, "ratio": 0.5, "active": true, "gone": nil}
+	tests := []struct {
+		name  string
+		query string
+		bound string
+	}{
+		{
+			"values",
+			"SELECT * FROM users WHERE msisdn = :msisdn AND total >= :min AND ratio<:ratio AND active == :active AND deletedAt = :gone",
+			"SELECT * FROM users WHERE msisdn = '6''33' AND total >= 10 AND ratio<0.5 AND active == true AND deletedAt = NULL",
+		},
+		{
+			"literals and comments",
+			"SELECT * FROM users WHERE note = ':msisdn' -- = :msisdn\nAND `a:b` = :min",
+			"SELECT * FROM users WHERE note = ':msisdn' -- = :msisdn\nAND `a:b` = 10",
+		},
+		{
+			"not parameters",
+			"SELECT * FROM users WHERE x = y::text AND path = 'a' AND t = 10:30",
+			"SELECT * FROM users WHERE x = y::text AND path = 'a' AND t = 10:30",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bound, err := bindParams(tt.query, params, sqlLiteral)
+			require.NoError(t, err)
+			require.Equal(t, tt.bound, bound)
+		})
+	}
+
+	_, err := bindParams("SELECT * FROM users WHERE msisdn = :other", params, sqlLiteral)
+	require.ErrorContains(t, err, ":other has no value")
+	_, err = bindParams("SELECT * FROM users LIMIT :min", params, sqlLiteral)
+	require.ErrorContains(t, err, "must be the value of a comparison")
+}
+
+// A bound value is a single literal for the parser FireQL uses, whatever it holds
+func TestSQLLiteralInjection(t *testing.T) {
+	for _, value := range []string{`x\' OR 1 = 1 -- `, `x\`, `x'' OR 1 = 1 -- `, `x\\\' OR 1 = 1 -- `} {
+		t.Run(value, func(t *testing.T) {
+			bound, err := bindParams("SELECT * FROM users WHERE msisdn = :m", map[string]interface{}{"m": value}, sqlLiteral)
+			require.NoError(t, err)
+			stmt, err := sqlparser.Parse(bound)
+			require.NoError(t, err)
+			where := stmt.(*sqlparser.Select).Where.Expr
+			comparison, ok := where.(*sqlparser.ComparisonExpr)
+			require.True(t, ok, "WHERE %s isn't a single comparison", sqlparser.String(where))
+			require.Equal(t, value, string(comparison.Right.(*sqlparser.SQLVal).Val))
+		})
+	}
+}
+
+func TestValidateParams(t *testing.T) {
+	require.NoError(t, validateParams(nil))
+	require.NoError(t, validateParams(map[string]interface{}{"msisdn": "633", "min": 1.5, "on": false, "none": nil}))
+	require.Error(t, validateParams(map[string]interface{}{"bad name": "x"}))
+	require.Error(t, validateParams(map[string]interface{}{"ids": []interface{}{"a", "b"}}))
+}
+
+func TestNativeQueryInfoParams(t *testing.T) {
+	qm := FirestoreQuery{
+		Query:  "SELECT * FROM (SELECT * FROM users WHERE msisdn = :msisdn) WHERE total > :min AND LOWER(name) = :msisdn",
+		Params: map[string]interface{}{"msisdn": "633 AND 1=1", "min": 10.0},
+	}
+	info, err := nativeQueryInfo(qm, backend.TimeRange{})
+	require.NoError(t, err)
+	require.Equal(t, []FilterInfo{{Field: "total", Operator: ">", Value: int64(10)}}, info.AdditionalFilters)
+	require.Equal(t, []string{"LOWER(name) = :msisdn"}, info.IgnoredConditions)
+	require.Equal(t, FilterInfo{Field: "msisdn", Operator: "==", Value: "633 AND 1=1"}, info.Subquery.AdditionalFilters[0])
+
+	// The parameters of SELECT expressions are bound as well
+	qm = FirestoreQuery{
+		Query:  "SELECT msisdn, amount >= :min AS big, status = :status FROM orders",
+		Params: map[string]interface{}{"min": 100.0, "status": "paid"},
+	}
+	info, err = nativeQueryInfo(qm, backend.TimeRange{})
+	require.NoError(t, err)
+	require.Equal(t, []selectExpression{
+		{Alias: "big", Condition: FilterInfo{Field: "amount", Operator: ">=", Value: int64(100)}},
+		{Alias: "status = :status", Condition: FilterInfo{Field: "status", Operator: "==", Value: "paid"}},
+	}, info.Expressions)
+	require.Equal(t, []string{"msisdn", "big", "status = :status"}, info.Fields)
+}
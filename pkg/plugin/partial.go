@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// documentData returns the fields of a document. The Firestore client panics on values it
+// can't decode, like a corrupt field or a value type it doesn't know, which is returned as
+// an error instead.
+func documentData(doc *firestore.DocumentSnapshot) (docData map[string]interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("document %s can't be decoded: %v", doc.Ref.ID, r)
+		}
+	}()
+	return doc.Data(), nil
+}
+
+// decodedDocuments holds the fields of the documents decoded as they were read, so the rows
+// of a query are built without decoding them again
+type decodedDocuments map[*firestore.DocumentSnapshot]map[string]interface{}
+
+// take returns the fields of a document, handing them over as rows modify them: a document
+// taken twice or never decoded is decoded again, and an error returned when it can't be
+func (decoded decodedDocuments) take(doc *firestore.DocumentSnapshot) (map[string]interface{}, error) {
+	if docData, ok := decoded[doc]; ok {
+		delete(decoded, doc)
+		return docData, nil
+	}
+	return documentData(doc)
+}
+
+// skipUndecodable returns the documents that decode and their fields, so a few corrupt
+// documents don't fail the whole panel. The documents skipped are reported in a warning
+// notice.
+func (d *Datasource) skipUndecodable(ctx context.Context, docs []*firestore.DocumentSnapshot, meta *queryMeta) ([]*firestore.DocumentSnapshot, decodedDocuments, error) {
+	kept := make([]*firestore.DocumentSnapshot, 0, len(docs))
+	decoded := make(decodedDocuments, len(docs))
+	for i, doc := range docs {
+		if err := checkCanceled(ctx, i); err != nil {
+			return nil, nil, err
+		}
+		if doc == nil {
+			continue
+		}
+		docData, err := documentData(doc)
+		if err != nil {
+			d.logger(ctx).Warn("Skipping a document that can't be decoded", "document", doc.Ref.ID, "error", err)
+			meta.addUndecodable(err)
+			continue
+		}
+		kept = append(kept, doc)
+		decoded[doc] = docData
+	}
+	return kept, decoded, nil
+}
+
+// addUndecodable records a document skipped because it can't be decoded
+func (m *queryMeta) addUndecodable(err error) {
+	if m.undecodable == 0 {
+		m.firstUndecodable = err
+	}
+	m.undecodable++
+}
+
+// undecodableNotice returns the notice of the documents skipped, with the first error
+func (m *queryMeta) undecodableNotice() (data.Notice, bool) {
+	if m.undecodable == 0 {
+		return data.Notice{}, false
+	}
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("%d documents were skipped because they can't be decoded, the results are partial. First error: %v", m.undecodable, m.firstUndecodable),
+	}, true
+}
@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"context"
+	"math"
+	"net"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeFirestore answers every query with the documents of its collection, as Firestore
+// sends them, without filtering, ordering or limiting them
+type fakeFirestore struct {
+	firestorepb.UnimplementedFirestoreServer
+	docs []*firestorepb.Document
+}
+
+func (f *fakeFirestore) RunQuery(req *firestorepb.RunQueryRequest, stream firestorepb.Firestore_RunQueryServer) error {
+	collection := req.GetStructuredQuery().GetFrom()[0].GetCollectionId()
+	for _, doc := range f.docs {
+		if path := strings.Split(doc.Name, "/"); path[len(path)-2] != collection {
+			continue
+		}
+		if err := stream.Send(&firestorepb.RunQueryResponse{Document: doc, ReadTime: timestamppb.Now()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fakeFirestoreClient returns a client of a fake Firestore serving the documents
+func fakeFirestoreClient(t *testing.T, docs ...*firestorepb.Document) *firestore.Client {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	firestorepb.RegisterFirestoreServer(server, &fakeFirestore{docs: docs})
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	t.Setenv("FIRESTORE_EMULATOR_HOST", listener.Addr().String())
+	client, err := firestore.NewClient(context.Background(), "test-project")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+// readSnapshots returns the snapshots the client reads of documents served by a fake
+// Firestore, the fields it can't decode included
+func readSnapshots(t *testing.T, docs ...*firestorepb.Document) []*firestore.DocumentSnapshot {
+	t.Helper()
+	snapshots, err := fakeFirestoreClient(t, docs...).Collection("events").Documents(context.Background()).GetAll()
+	require.NoError(t, err)
+	return snapshots
+}
+
+func eventDocument(id string, fields map[string]*firestorepb.Value) *firestorepb.Document {
+	return collectionDocument("events", id, fields)
+}
+
+func collectionDocument(collection, id string, fields map[string]*firestorepb.Value) *firestorepb.Document {
+	return &firestorepb.Document{
+		Name:       "projects/test-project/databases/(default)/documents/" + collection + "/" + id,
+		Fields:     fields,
+		CreateTime: timestamppb.Now(),
+		UpdateTime: timestamppb.Now(),
+	}
+}
+
+func validDocument(id string) *firestorepb.Document {
+	return eventDocument(id, map[string]*firestorepb.Value{"count": {ValueType: &firestorepb.Value_IntegerValue{IntegerValue: 1}}})
+}
+
+// corruptDocument returns a document with a timestamp out of range, which the client can't decode
+func corruptDocument(id string) *firestorepb.Document {
+	ts := &timestamppb.Timestamp{Seconds: math.MaxInt64}
+	return eventDocument(id, map[string]*firestorepb.Value{"ts": {ValueType: &firestorepb.Value_TimestampValue{TimestampValue: ts}}})
+}
+
+func TestDocumentData(t *testing.T) {
+	docs := readSnapshots(t, validDocument("a"), corruptDocument("b"))
+
+	docData, err := documentData(docs[0])
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"count": int64(1)}, docData)
+
+	_, err = documentData(docs[1])
+	require.ErrorContains(t, err, "document b can't be decoded")
+}
+
+func TestSkipUndecodable(t *testing.T) {
+	meta := &queryMeta{}
+	docs, decoded, err := (&Datasource{}).skipUndecodable(context.Background(), readSnapshots(t,
+		validDocument("a"), corruptDocument("b"), validDocument("c"), corruptDocument("d"),
+	), meta)
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+	require.Equal(t, "c", docs[1].Ref.ID)
+
+	// The rows take the decoded fields over, a second read decodes the document again
+	require.Len(t, decoded, 2)
+	docData, err := decoded.take(docs[0])
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"count": int64(1)}, docData)
+	require.Len(t, decoded, 1)
+	again, err := decoded.take(docs[0])
+	require.NoError(t, err)
+	require.Equal(t, docData, again)
+
+	response := meta.apply(backend.DataResponse{Frames: data.Frames{data.NewFrame("response")}})
+	frameMeta := response.Frames[0].Meta
+	require.Len(t, frameMeta.Notices, 1)
+	require.Equal(t, data.NoticeSeverityWarning, frameMeta.Notices[0].Severity)
+	require.Contains(t, frameMeta.Notices[0].Text, "2 documents were skipped")
+	require.Contains(t, frameMeta.Notices[0].Text, "document b can't be decoded")
+	require.Equal(t, 2, frameMeta.Custom.(map[string]interface{})["skippedDocuments"])
+}
+
+// Streams convert their snapshots without skipUndecodable, on a goroutine without recover
+func TestStreamFramesSkipUndecodable(t *testing.T) {
+	docs := readSnapshots(t, validDocument("a"), corruptDocument("b"), validDocument("c"))
+	ctx := context.Background()
+
+	response := (&Datasource{}).convertFirestoreDocsToResponseWithFields(ctx, docs, nil, &QueryInfo{Fields: []string{"*"}})
+	require.NoError(t, response.Error)
+	require.Equal(t, 2, response.Frames[0].Rows())
+
+	logs := (&Datasource{}).convertFirestoreDocsToLogsResponse(ctx, docs, &QueryInfo{TimeField: "ts"}, FirestoreQuery{Format: formatLogs})
+	require.NoError(t, logs.Error)
+	require.Equal(t, 2, logs.Frames[0].Rows())
+}
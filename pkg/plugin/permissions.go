@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"slices"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/option"
+)
+
+// requiredPermissions are the IAM permissions the datasource needs on the project, all
+// granted by roles/datastore.viewer
+var requiredPermissions = []string{
+	"datastore.databases.get",
+	"datastore.entities.get",
+	"datastore.entities.list",
+}
+
+// missingPermissions asks Cloud Resource Manager which of the required permissions the
+// credentials lack on the project
+func missingPermissions(ctx context.Context, projectID string, options []option.ClientOption) ([]string, error) {
+	service, err := cloudresourcemanager.NewService(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	response, err := service.Projects.TestIamPermissions(projectID, &cloudresourcemanager.TestIamPermissionsRequest{
+		Permissions: requiredPermissions,
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return permissionsNotGranted(requiredPermissions, response.Permissions), nil
+}
+
+// permissionsNotGranted returns the required permissions missing from the granted ones
+func permissionsNotGranted(required, granted []string) []string {
+	var missing []string
+	for _, permission := range required {
+		if !slices.Contains(granted, permission) {
+			missing = append(missing, permission)
+		}
+	}
+	return missing
+}
+
+// verifiesPermissions reports whether the health check can verify IAM permissions, an
+// emulator has none
+func verifiesPermissions(settings *FirestoreSettings) bool {
+	return settings.EmulatorHost == "" && os.Getenv("FIRESTORE_EMULATOR_HOST") == ""
+}
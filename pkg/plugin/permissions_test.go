@@ -0,0 +1,23 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionsNotGranted(t *testing.T) {
+	require.Empty(t, permissionsNotGranted(requiredPermissions, requiredPermissions))
+	require.Equal(t, []string{"datastore.entities.get", "datastore.entities.list"},
+		permissionsNotGranted(requiredPermissions, []string{"datastore.databases.get"}))
+	require.Equal(t, requiredPermissions, permissionsNotGranted(requiredPermissions, nil))
+}
+
+func TestVerifiesPermissions(t *testing.T) {
+	t.Setenv("FIRESTORE_EMULATOR_HOST", "")
+	require.True(t, verifiesPermissions(&FirestoreSettings{}))
+	require.False(t, verifiesPermissions(&FirestoreSettings{EmulatorHost: "localhost:8080"}))
+
+	t.Setenv("FIRESTORE_EMULATOR_HOST", "localhost:8765")
+	require.False(t, verifiesPermissions(&FirestoreSettings{}))
+}
@@ -0,0 +1,296 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// queryPlan is the engine a query runs on, decided once per query. The native SDK runs every
+// query it can fully express, pushing filters, ordering and limits down to Firestore and
+// doing the rest in memory, so frames don't change shape with the engine. FireQL only runs
+// the SQL the native planner can't express.
+type queryPlan struct {
+	route string
+
+	// info is the parsed query of the native route
+	info *QueryInfo
+
+	// reason explains the route in debug notices
+	reason string
+
+	// timeField is the time field of the collectionTimeFields setting filtering the query,
+	// empty when the query names its own or has none
+	timeField string
+
+	// parseTime is the time spent parsing and planning the query
+	parseTime time.Duration
+}
+
+// fireqlOnlyKeywords are SQL constructs only FireQL evaluates
+var fireqlOnlyKeywords = map[string]bool{
+	"OR":       true,
+	"IN":       true,
+	"NOT":      true,
+	"LIKE":     true,
+	"IS":       true,
+	"BETWEEN":  true,
+	"HAVING":   true,
+	"DISTINCT": true,
+	"UNION":    true,
+	"OFFSET":   true,
+	"CASE":     true,
+}
+
+// fieldPathPattern matches a plain field path, the only column expression of the native route
+var fieldPathPattern = regexp.MustCompile(`^[\p{L}_$][\p{L}\p{N}_$]*(\.[\p{L}_$][\p{L}\p{N}_$]*)*$`)
+
+// aggregateFieldPattern matches the field of an aggregate, a field path that may run through
+// arrays like items[].price
+var aggregateFieldPattern = regexp.MustCompile(`^[\p{L}_$][\p{L}\p{N}_$]*(\[\])?(\.[\p{L}_$][\p{L}\p{N}_$]*(\[\])?)*$`)
+
+// collectionPathPattern matches a collection or subcollection path
+var collectionPathPattern = regexp.MustCompile(`^[^\s,()]+$`)
+
+// planQuery parses a query once and decides the engine that runs it
+func planQuery(qm FirestoreQuery, settings *FirestoreSettings, timeRange backend.TimeRange) (*queryPlan, error) {
+	started := time.Now()
+	plan, err := planQueryInfo(qm, settings, timeRange)
+	if plan != nil {
+		plan.parseTime = time.Since(started)
+	}
+	return plan, err
+}
+
+// planQueryInfo parses a query and decides its engine
+func planQueryInfo(qm FirestoreQuery, settings *FirestoreSettings, timeRange backend.TimeRange) (*queryPlan, error) {
+	info, err := nativeQueryInfo(qm, timeRange)
+	var timeField string
+	if err == nil {
+		timeField = applyCollectionTimeField(info, settings, timeRange)
+	}
+	if qm.Builder != nil {
+		if err != nil {
+			return nil, err
+		}
+		return &queryPlan{route: routeNative, info: info, reason: "builder query", timeField: timeField}, nil
+	}
+
+	unsupported := nativeUnsupported(qm.Query, info, err)
+	if unsupported == "" {
+		return &queryPlan{route: routeNative, info: info, reason: "the native SDK supports every clause", timeField: timeField}, nil
+	}
+
+	// Some queries need the native SDK even when it can't evaluate every clause: the
+	// unsupported conditions are then reported in notices
+	if required := nativeRequired(qm, settings, timeRange); required != "" {
+		if err != nil {
+			return nil, err
+		}
+		return &queryPlan{route: routeNative, info: info, reason: required + ", although " + unsupported, timeField: timeField}, nil
+	}
+	return &queryPlan{route: routeFireQL, reason: unsupported}, nil
+}
+
+// nativeUnsupported returns why the native planner can't fully express a parsed query,
+// empty when it can
+func nativeUnsupported(query string, info *QueryInfo, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	words, _ := sqlWords(stringAggDistinctPattern.ReplaceAllString(query, "$1("))
+	for _, word := range words {
+		if fireqlOnlyKeywords[word] {
+			return word + " is evaluated by FireQL"
+		}
+	}
+	if !collectionPathPattern.MatchString(cleanBackticks(info.Collection)) {
+		return fmt.Sprintf("FROM %s is not a collection path", info.Collection)
+	}
+	for _, field := range info.Fields {
+		if field != "*" && !fieldPathPattern.MatchString(field) && !info.isComputed(field) {
+			return fmt.Sprintf("column %s is not a field path", field)
+		}
+	}
+	for _, aggregate := range info.AggregateFields {
+		if aggregate.Field != "*" && !aggregateFieldPattern.MatchString(aggregate.Field) {
+			return fmt.Sprintf("%s(%s) is not an aggregate of a field", aggregate.Function, aggregate.Field)
+		}
+	}
+	if len(info.IgnoredConditions) > 0 {
+		return fmt.Sprintf("WHERE condition %q is evaluated by FireQL", info.IgnoredConditions[0])
+	}
+	return ""
+}
+
+// nativeRequired returns why a query must run on the native SDK, empty when FireQL can run it
+func nativeRequired(qm FirestoreQuery, settings *FirestoreSettings, timeRange backend.TimeRange) string {
+	switch {
+	case containsGrafanaVariables(qm.Query) && !timeRange.From.IsZero() && !timeRange.To.IsZero():
+		return "the time range variables filter server-side"
+	case containsGroupBy(qm.Query):
+		return "GROUP BY is aggregated in memory"
+	case qm.Format == formatLogs:
+		// Logs output needs the document snapshots
+		return "logs need the document snapshots"
+	case distributionFormat(qm.Format):
+		return "histograms and heatmaps are computed in memory"
+	case isCollectionPattern(extractCollectionName(qm.Query)):
+		return "wildcard collections are expanded by the native SDK"
+	case overPattern.MatchString(qm.Query):
+		return "window functions are evaluated in memory"
+	case stringAggCallPattern.MatchString(qm.Query):
+		return "string aggregates are evaluated in memory"
+	case ctePattern.MatchString(qm.Query):
+		return "common table expressions are evaluated in memory"
+	case subqueryPattern.MatchString(qm.Query):
+		return "subqueries are evaluated in memory"
+	case qm.ReadTime != "":
+		return "readTime needs a snapshot read"
+	case qm.Explain:
+		return "explain needs query profiling"
+	case !fireqlSupported(settings):
+		// FireQL builds its own client from the project and service account key
+		return "FireQL can't use the connection settings"
+	default:
+		return ""
+	}
+}
+
+// executeNativePlan runs a query planned for the native SDK
+func (d *Datasource) executeNativePlan(ctx context.Context, pCtx backend.PluginContext, settings *FirestoreSettings, qm FirestoreQuery, timeRange backend.TimeRange, plan *queryPlan) backend.DataResponse {
+	d.resolveFieldKinds(ctx, plan.info)
+	if qm.PageSize > 0 {
+		if reason := pagingUnsupported(qm, plan.info); reason != "" {
+			return backend.ErrDataResponse(backend.StatusBadRequest, "pageSize: "+reason)
+		}
+	}
+	if qm.TotalCount {
+		if reason := totalCountUnsupported(qm, plan.info); reason != "" {
+			return backend.ErrDataResponse(backend.StatusBadRequest, "totalCount: "+reason)
+		}
+	}
+	queriesTotal.WithLabelValues(routeNative).Inc()
+	meta := &queryMeta{stats: queryStats{Engine: routeNative, ParseMs: milliseconds(plan.parseTime)}}
+	d.debugNotice(meta, "Executed with the native Firestore SDK: "+plan.reason)
+	if plan.timeField != "" {
+		meta.setCustom("timeField", plan.timeField)
+	} else if field := d.detectTimeField(ctx, plan.info, qm, timeRange); field != "" {
+		meta.setCustom("timeField", field)
+		meta.setCustom("timeFieldDetected", true)
+		d.debugNotice(meta, fmt.Sprintf("Time field %s detected from the collection's schema", field))
+	}
+	defaultLimit := defaultLimitFor(plan.info, qm, settings)
+	if defaultLimit > 0 {
+		plan.info.Limit = defaultLimit
+	}
+	response := d.executeNativeQuery(ctx, pCtx, settings, qm, plan.info, timeRange, meta)
+	if defaultLimit > 0 && response.Error == nil {
+		meta.reportDefaultLimit(responseRows(response), defaultLimit)
+	}
+	if qm.Lookup != nil && response.Error == nil && !qm.Explain {
+		response = d.applyLookup(ctx, pCtx, qm, response, meta)
+	}
+	// Raw points are downsampled, aggregated queries already have their buckets
+	if len(plan.info.GroupByFields) == 0 && len(plan.info.AggregateFields) == 0 && !qm.Explain {
+		response = downsampleResponse(response, qm, meta)
+	}
+	if qm.ConvertToWide && !qm.Explain {
+		response = wideResponse(response, qm)
+	}
+	if qm.Alerting && !qm.Explain {
+		response = alertingResponse(response)
+	}
+	response = applyFieldConfig(response, qm, plan.info)
+	return meta.apply(setFrameTypes(response, qm.Format, plan.info.TimeField))
+}
+
+// executeNativeQuery runs a parsed query on the native SDK, reading FROM a subquery, the
+// collections a wildcard matches or a collection
+func (d *Datasource) executeNativeQuery(ctx context.Context, pCtx backend.PluginContext, settings *FirestoreSettings, qm FirestoreQuery, info *QueryInfo, timeRange backend.TimeRange, meta *queryMeta) backend.DataResponse {
+	switch {
+	case info.Subquery != nil:
+		return d.executeSubquery(ctx, pCtx, settings, qm, info, timeRange, meta)
+	case isCollectionPattern(info.Collection):
+		return d.executeWildcardQuery(ctx, pCtx, settings, qm, info, timeRange, meta)
+	default:
+		return d.executeWithNativeSDKForVariables(ctx, pCtx, settings, qm, info, timeRange, meta)
+	}
+}
+
+// looseLiteral reports whether a WHERE condition compares a field for equality with a number
+// or boolean literal. In memory the literal also matches the string holding it, amount = 10
+// matching "10" as the filters always did, while Firestore only matches its type.
+func looseLiteral(filter FilterInfo) bool {
+	switch filter.Operator {
+	case "==", "!=", "array-contains":
+		return isTypedLiteral(filter.Value)
+	case "in", "not-in", "array-contains-any":
+		values, _ := filter.Value.([]interface{})
+		return slices.ContainsFunc(values, isTypedLiteral)
+	}
+	return false
+}
+
+// isTypedLiteral reports whether a literal is a number or a boolean
+func isTypedLiteral(value interface{}) bool {
+	switch kindOf(value) {
+	case kindBool, kindInt, kindFloat:
+		return true
+	}
+	return false
+}
+
+// filteredInMemory reports whether a WHERE condition is evaluated on the documents read rather
+// than by Firestore: the metadata pseudo-columns, and the number and boolean literals compared
+// for equality with a field the collection schema didn't find of their type
+func (info *QueryInfo) filteredInMemory(filter FilterInfo) bool {
+	if isMetadataColumn(filter.Field) {
+		return true
+	}
+	if !looseLiteral(filter) {
+		return false
+	}
+	kind, ok := info.FieldKinds[filter.Field]
+	if !ok {
+		return true
+	}
+	values := []interface{}{filter.Value}
+	if list, isList := filter.Value.([]interface{}); isList {
+		values = list
+	}
+	for _, value := range values {
+		switch kindOf(value) {
+		case kindBool:
+			if kind != kindBool {
+				return true
+			}
+		case kindInt, kindFloat:
+			if kind != kindInt && kind != kindFloat {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveFieldKinds sets the field types of the schema of the collection of a query comparing
+// fields with number or boolean literals, so Firestore evaluates the comparisons of fields
+// known to hold the literal's type
+func (d *Datasource) resolveFieldKinds(ctx context.Context, info *QueryInfo) {
+	if !slices.ContainsFunc(info.AdditionalFilters, looseLiteral) || isCollectionPattern(info.Collection) {
+		return
+	}
+	schema := d.collectionSchema(ctx, info.Collection)
+	if schema == nil {
+		return
+	}
+	info.FieldKinds = make(map[string]valueKind, len(schema.Fields))
+	for _, field := range schema.Fields {
+		info.FieldKinds[field.Name] = field.kind
+	}
+}
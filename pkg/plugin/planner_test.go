@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanQuery(t *testing.T) {
+	timeRange := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+	tests := []struct {
+		name     string
+		qm       FirestoreQuery
+		settings FirestoreSettings
+		route    string
+	}{
+		{"select all", FirestoreQuery{Query: "select * from users"}, FirestoreSettings{}, routeNative},
+		{"filters", FirestoreQuery{Query: "SELECT msisdn FROM users WHERE status = 'open' AND amount >= 10 ORDER BY amount DESC LIMIT 5"}, FirestoreSettings{}, routeNative},
+		{"subcollection", FirestoreQuery{Query: "SELECT * FROM customers/ACME/orders"}, FirestoreSettings{}, routeNative},
+		{"or", FirestoreQuery{Query: "SELECT * FROM users WHERE status = 'open' OR status = 'new'"}, FirestoreSettings{}, routeFireQL},
+		{"in", FirestoreQuery{Query: "SELECT * FROM users WHERE status IN ('open', 'new')"}, FirestoreSettings{}, routeFireQL},
+		{"alias", FirestoreQuery{Query: "SELECT id AS uid FROM users"}, FirestoreSettings{}, routeFireQL},
+		{"function", FirestoreQuery{Query: "SELECT LENGTH(username) FROM users"}, FirestoreSettings{}, routeFireQL},
+		{"literal keyword", FirestoreQuery{Query: "SELECT * FROM users WHERE note = 'in or out'"}, FirestoreSettings{}, routeNative},
+		{"or with time range", FirestoreQuery{Query: "SELECT * FROM users WHERE ts >= $__from AND (a = 1 OR b = 2)"}, FirestoreSettings{}, routeNative},
+		{"or with group by", FirestoreQuery{Query: "SELECT brand, COUNT(*) FROM users WHERE a = 1 OR b = 2 GROUP BY brand"}, FirestoreSettings{}, routeNative},
+		{"or with emulator", FirestoreQuery{Query: "SELECT * FROM users WHERE a = 1 OR b = 2"}, FirestoreSettings{EmulatorHost: "localhost:8080"}, routeNative},
+		{"array aggregate", FirestoreQuery{Query: "SELECT SUM(items[].price) AS total FROM orders"}, FirestoreSettings{}, routeNative},
+		{"boolean column", FirestoreQuery{Query: "SELECT msisdn, amount > 0 AS has_amount FROM orders"}, FirestoreSettings{}, routeNative},
+		{"string aggregate", FirestoreQuery{Query: "SELECT STRING_AGG(DISTINCT code, ', ') AS codes FROM errors"}, FirestoreSettings{}, routeNative},
+		{"group concat with or", FirestoreQuery{Query: "SELECT GROUP_CONCAT(code SEPARATOR '|') FROM errors WHERE a = 1 OR b = 2"}, FirestoreSettings{}, routeNative},
+		{"join", FirestoreQuery{Query: "SELECT o.total, c.name FROM orders o JOIN customers c ON o.customerId = c.__name__"}, FirestoreSettings{}, routeNative},
+		{"right join", FirestoreQuery{Query: "SELECT * FROM orders o RIGHT JOIN customers c ON o.customerId = c.__name__"}, FirestoreSettings{}, routeFireQL},
+		{"wildcard", FirestoreQuery{Query: "SELECT * FROM logs_* WHERE level = 'error' OR level = 'warn'"}, FirestoreSettings{}, routeNative},
+		{"subquery", FirestoreQuery{Query: "SELECT brand, AVG(total) AS avgTotal FROM (SELECT brand, customerId, SUM(total) AS total FROM orders GROUP BY brand, customerId) t GROUP BY brand"}, FirestoreSettings{}, routeNative},
+		{"cte", FirestoreQuery{Query: "WITH paid AS (SELECT * FROM orders WHERE status = 'paid') SELECT brand, COUNT(*) AS n FROM paid GROUP BY brand"}, FirestoreSettings{}, routeNative},
+		{"latest per key", FirestoreQuery{Query: "SELECT * FROM (SELECT msisdn, ts, status, ROW_NUMBER() OVER (PARTITION BY msisdn ORDER BY ts DESC) AS rn FROM events) WHERE rn = 1"}, FirestoreSettings{}, routeNative},
+		{"builder", FirestoreQuery{Builder: &BuilderQuery{Collection: "users"}}, FirestoreSettings{}, routeNative},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planQuery(tt.qm, &tt.settings, timeRange)
+			require.NoError(t, err)
+			require.Equal(t, tt.route, plan.route, plan.reason)
+			require.Equal(t, tt.route == routeNative, plan.info != nil)
+		})
+	}
+
+	_, err := planQuery(FirestoreQuery{Builder: &BuilderQuery{}}, &FirestoreSettings{}, timeRange)
+	require.Error(t, err)
+}
+
+func TestPlanQueryTimeField(t *testing.T) {
+	qm := FirestoreQuery{Query: "SELECT * FROM events WHERE ts >= $__from AND ts <= $__to"}
+
+	plan, err := planQuery(qm, &FirestoreSettings{}, backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)})
+	require.NoError(t, err)
+	require.Equal(t, "ts", plan.info.TimeField)
+
+	plan, err = planQuery(qm, &FirestoreSettings{}, backend.TimeRange{})
+	require.NoError(t, err)
+	require.Empty(t, plan.info.TimeField)
+}
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		condition string
+		filter    FilterInfo
+		ok        bool
+	}{
+		{"msisdn = '633525465'", FilterInfo{"msisdn", "==", "633525465"}, true},
+		{`clientData.BrandCliente == "yoigo"`, FilterInfo{"clientData.BrandCliente", "==", "yoigo"}, true},
+		{"msisdn==\"681021597\"", FilterInfo{"msisdn", "==", "681021597"}, true},
+		{"`address.city` = 'Glendale'", FilterInfo{"address.city", "==", "Glendale"}, true},
+		{"amount>=10", FilterInfo{"amount", ">=", int64(10)}, true},
+		{"amount < 1.5", FilterInfo{"amount", "<", 1.5}, true},
+		{"status != 'closed'", FilterInfo{"status", "!=", "closed"}, true},
+		{"status <> 'closed'", FilterInfo{"status", "!=", "closed"}, true},
+		{"note = 'a=b'", FilterInfo{"note", "==", "a=b"}, true},
+		{"LENGTH(name) = 3", FilterInfo{}, false},
+		{"active", FilterInfo{}, false},
+	}
+
+	for _, tt := range tests {
+		filter, ok := parseCondition(tt.condition)
+		require.Equal(t, tt.ok, ok, tt.condition)
+		require.Equal(t, tt.filter, filter, tt.condition)
+	}
+}
+
+func TestParseWhereClause(t *testing.T) {
+	tests := []struct {
+		query   string
+		filters []FilterInfo
+	}{
+		{"SELECT * FROM users WHERE status = 'open' and amount >= 10", []FilterInfo{{"status", "==", "open"}, {"amount", ">=", int64(10)}}},
+		{"SELECT * FROM users WHERE note = 'rock AND roll' AND status = 'open'", []FilterInfo{{"note", "==", "rock AND roll"}, {"status", "==", "open"}}},
+		{"SELECT * FROM users WHERE brand = 'band'\nAnD land = 'x'", []FilterInfo{{"brand", "==", "band"}, {"land", "==", "x"}}},
+	}
+
+	for _, tt := range tests {
+		info, err := parseSQLQueryWithVariables(tt.query)
+		require.NoError(t, err, tt.query)
+		require.Equal(t, tt.filters, info.AdditionalFilters, tt.query)
+		require.Empty(t, info.IgnoredConditions, tt.query)
+	}
+}
+
+func TestFilteredInMemory(t *testing.T) {
+	info, err := parseSQLQueryWithVariables("SELECT * FROM orders WHERE status = 'paid' AND amount = 10 AND paid = true AND total > 5 AND __name__ = 'a'")
+	require.NoError(t, err)
+	info.AdditionalFilters = append(info.AdditionalFilters, FilterInfo{Field: "code", Operator: "in", Value: []interface{}{int64(1), "2"}})
+	filters := info.AdditionalFilters
+	require.Len(t, filters, 6)
+
+	// Without the field types, a number or boolean compared for equality also matches the
+	// strings of documents storing it as text, as sameValue does, so it isn't pushed down
+	inMemory := func() []bool {
+		var result []bool
+		for _, filter := range filters {
+			result = append(result, info.filteredInMemory(filter))
+		}
+		return result
+	}
+	require.Equal(t, []bool{false, true, true, false, true, true}, inMemory())
+	require.True(t, filterMatches("10", filters[1]))
+	require.False(t, isCountOnly(&QueryInfo{AdditionalFilters: filters[1:2], AggregateFields: []AggregateInfo{{Function: "COUNT", Field: "*"}}}, FirestoreQuery{}))
+	require.Equal(t, "WHERE amount is filtered in memory and can't be paged", pagingUnsupported(FirestoreQuery{PageSize: 10}, &QueryInfo{Collection: "orders", AdditionalFilters: filters[1:2]}))
+
+	// Fields the schema found of the literal's type are filtered by Firestore
+	info.FieldKinds = map[string]valueKind{"amount": kindFloat, "paid": kindString, "code": kindInt}
+	require.Equal(t, []bool{false, false, true, false, true, false}, inMemory())
+}
@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// rankPattern matches a ranking window function such as
+// ROW_NUMBER() OVER (PARTITION BY msisdn ORDER BY ts DESC)
+var rankPattern = regexp.MustCompile(`(?is)^(ROW_NUMBER|RANK)\s*\(\s*\)\s*OVER\s*\(\s*(?:PARTITION\s+BY\s+(.+?))?\s*(?:ORDER\s+BY\s+(.+?))?\s*\)$`)
+
+// overPattern matches the OVER clause of a window function in a query
+var overPattern = regexp.MustCompile(`(?i)\bOVER\s*\(`)
+
+// rankFunction numbers the rows of each partition in order: ROW_NUMBER from 1 up, RANK
+// giving rows that tie on every order key the same number
+type rankFunction struct {
+	Function    string
+	PartitionBy []string
+	OrderBy     []OrderKey
+
+	// Alias is the column of the numbers
+	Alias string
+}
+
+// String renders the function for ExecutedQueryString
+func (r rankFunction) String() string {
+	keys := make([]string, len(r.OrderBy))
+	for i, key := range r.OrderBy {
+		keys[i] = key.Field + " " + key.direction()
+	}
+	return fmt.Sprintf("%s(partitionBy(%s) orderBy(%s))", strings.ToLower(r.Function), strings.Join(r.PartitionBy, ", "), strings.Join(keys, ", "))
+}
+
+// fields returns the fields the function reads
+func (r rankFunction) fields() []string {
+	fields := append([]string{}, r.PartitionBy...)
+	for _, key := range r.OrderBy {
+		fields = append(fields, key.Field)
+	}
+	return fields
+}
+
+// parseRankFunction parses a SELECT expression like
+// ROW_NUMBER() OVER (PARTITION BY msisdn ORDER BY ts DESC) AS rn. isRank is false for
+// other expressions.
+func parseRankFunction(field string) (rank rankFunction, isRank bool, err error) {
+	match := rankPattern.FindStringSubmatch(stripAlias(field))
+	if match == nil {
+		if overPattern.MatchString(field) {
+			return rank, true, fmt.Errorf("unsupported window function %s, expected ROW_NUMBER() or RANK() OVER (PARTITION BY ... ORDER BY ...)", field)
+		}
+		return rank, false, nil
+	}
+
+	rank = rankFunction{Function: strings.ToUpper(match[1]), Alias: field}
+	if idx := strings.LastIndex(strings.ToUpper(field), " AS "); idx != -1 {
+		rank.Alias = strings.TrimSpace(field[idx+4:])
+	}
+	if match[2] != "" {
+		for _, partition := range splitSQLList(match[2]) {
+			partition = cleanBackticks(strings.TrimSpace(partition))
+			if !fieldPathPattern.MatchString(partition) && !isMetadataColumn(partition) {
+				return rank, true, fmt.Errorf("%s: PARTITION BY %s is not a field", rank.Function, partition)
+			}
+			rank.PartitionBy = append(rank.PartitionBy, partition)
+		}
+	}
+	rank.OrderBy = parseOrderKeys(match[3])
+	return rank, true, nil
+}
+
+// validateRanks checks that the ranking functions of a query number rows of documents, as
+// filters and groups apply before the rows are numbered
+func validateRanks(info *QueryInfo) error {
+	if len(info.Ranks) == 0 {
+		return nil
+	}
+	rank := info.Ranks[0]
+	if len(info.GroupByFields) > 0 || len(info.AggregateFields) > 0 {
+		return fmt.Errorf("%s can't be combined with GROUP BY or aggregates, number the grouped rows in an outer query", rank.Function)
+	}
+	for _, filter := range info.AdditionalFilters {
+		if info.isRank(filter.Field) {
+			return fmt.Errorf("WHERE %s: rows are numbered after WHERE, filter on %s in an outer query", filter.Field, filter.Field)
+		}
+	}
+	return nil
+}
+
+// isRank reports whether a column is the numbers of a ranking function
+func (info *QueryInfo) isRank(column string) bool {
+	for _, rank := range info.Ranks {
+		if rank.Alias == column {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRanks sets the numbers of the ranking functions on the rows, numbering the rows of
+// each partition in order. Partition values match across number types like join keys.
+func applyRanks(rows []map[string]interface{}, ranks []rankFunction) {
+	for _, rank := range ranks {
+		partitions := make(map[string][]int)
+		var keys []string
+		for i, row := range rows {
+			values := make([]string, len(rank.PartitionBy))
+			for j, field := range rank.PartitionBy {
+				values[j], _ = joinKey(selectFieldValue(row, field), false)
+			}
+			key := strings.Join(values, "\x00")
+			if _, ok := partitions[key]; !ok {
+				keys = append(keys, key)
+			}
+			partitions[key] = append(partitions[key], i)
+		}
+
+		for _, key := range keys {
+			partition := partitions[key]
+			value := func(i int) func(key OrderKey) interface{} {
+				return func(key OrderKey) interface{} { return selectFieldValue(rows[i], key.Field) }
+			}
+			sort.SliceStable(partition, func(a, b int) bool {
+				return lessByKeys(rank.OrderBy, value(partition[a]), value(partition[b]))
+			})
+			for pos, i := range partition {
+				number := int64(pos + 1)
+				if rank.Function == "RANK" && pos > 0 && !lessByKeys(rank.OrderBy, value(partition[pos-1]), value(i)) {
+					number = rows[partition[pos-1]][rank.Alias].(int64)
+				}
+				rows[i][rank.Alias] = number
+			}
+		}
+	}
+}
+
+// rankDocuments numbers the filtered documents of a query with ranking functions, then
+// orders and limits them in memory
+func (d *Datasource) rankDocuments(ctx context.Context, docs []*firestore.DocumentSnapshot, queryInfo *QueryInfo, qm FirestoreQuery, meta *queryMeta) backend.DataResponse {
+	if qm.Format == formatLogs || distributionFormat(qm.Format) {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("the %s format is not supported with %s", qm.Format, queryInfo.Ranks[0].Function))
+	}
+	rows, hasGeoPoints, err := d.documentRows(ctx, docs, queryInfo)
+	if isCanceled(err) {
+		return firestoreErrorResponse("", err)
+	}
+	if err != nil {
+		return budgetExceededResponse(err)
+	}
+	return selectRows(rows, documentColumns, hasGeoPoints, queryInfo, qm, meta)
+}
+
+// documentColumns returns the sorted fields of document rows
+func documentColumns(rows []map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	var columns []string
+	for _, row := range rows {
+		for field := range row {
+			if !seen[field] {
+				seen[field] = true
+				columns = append(columns, field)
+			}
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
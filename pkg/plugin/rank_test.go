@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRankFunction(t *testing.T) {
+	rank, isRank, err := parseRankFunction("ROW_NUMBER() OVER (PARTITION BY msisdn, `clientData`.`brand` ORDER BY ts DESC, __name__) AS rn")
+	require.NoError(t, err)
+	require.True(t, isRank)
+	require.Equal(t, rankFunction{
+		Function:    "ROW_NUMBER",
+		PartitionBy: []string{"msisdn", "clientData.brand"},
+		OrderBy:     []OrderKey{{Field: "ts", Descending: true}, {Field: "__name__"}},
+		Alias:       "rn",
+	}, rank)
+	require.Equal(t, "row_number(partitionBy(msisdn, clientData.brand) orderBy(ts DESC, __name__ ASC))", rank.String())
+
+	rank, isRank, err = parseRankFunction("rank() over (order by score desc)")
+	require.NoError(t, err)
+	require.True(t, isRank)
+	require.Equal(t, "RANK", rank.Function)
+	require.Empty(t, rank.PartitionBy)
+	require.Equal(t, "rank() over (order by score desc)", rank.Alias)
+
+	_, isRank, err = parseRankFunction("MOVING_AVG(total, 5)")
+	require.NoError(t, err)
+	require.False(t, isRank)
+
+	for _, field := range []string{
+		"LAG(total) OVER (ORDER BY ts)",
+		"ROW_NUMBER() OVER (PARTITION BY LOWER(msisdn) ORDER BY ts)",
+	} {
+		_, isRank, err := parseRankFunction(field)
+		require.True(t, isRank, field)
+		require.Error(t, err, field)
+	}
+}
+
+func TestParseRankQuery(t *testing.T) {
+	info, err := parseSQLQueryWithVariables("SELECT msisdn, ts, ROW_NUMBER() OVER (PARTITION BY msisdn ORDER BY ts DESC) AS rn FROM events WHERE status = 'ok' ORDER BY msisdn LIMIT 10")
+	require.NoError(t, err)
+	require.Equal(t, "events", info.Collection)
+	require.Equal(t, []string{"msisdn", "ts", "rn"}, info.Fields)
+	require.Len(t, info.Ranks, 1)
+	require.Equal(t, []OrderKey{{Field: "msisdn"}}, info.OrderBy)
+	require.Equal(t, 10, info.Limit)
+	require.True(t, info.isRank("rn"))
+	require.Equal(t, []string{"msisdn", "ts", "status"}, projectionFields(info, FirestoreQuery{}))
+
+	for _, query := range []string{
+		"SELECT msisdn, COUNT(*) AS n, ROW_NUMBER() OVER (ORDER BY msisdn) AS rn FROM events GROUP BY msisdn",
+		"SELECT msisdn, ROW_NUMBER() OVER (PARTITION BY msisdn ORDER BY ts DESC) AS rn FROM events WHERE rn = 1",
+	} {
+		_, err := parseSQLQueryWithVariables(query)
+		require.Error(t, err, query)
+	}
+}
+
+func TestApplyRanks(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := []map[string]interface{}{
+		{"msisdn": "a", "ts": base, "score": int64(3)},
+		{"msisdn": "b", "ts": base.Add(time.Hour), "score": 5.0},
+		{"msisdn": "a", "ts": base.Add(2 * time.Hour), "score": int64(5)},
+		{"msisdn": "a", "ts": base.Add(time.Hour), "score": int64(3)},
+	}
+	applyRanks(rows, []rankFunction{
+		{Function: "ROW_NUMBER", PartitionBy: []string{"msisdn"}, OrderBy: []OrderKey{{Field: "ts", Descending: true}}, Alias: "rn"},
+		{Function: "RANK", OrderBy: []OrderKey{{Field: "score", Descending: true}}, Alias: "place"},
+	})
+
+	rn := make([]interface{}, len(rows))
+	place := make([]interface{}, len(rows))
+	for i, row := range rows {
+		rn[i], place[i] = row["rn"], row["place"]
+	}
+	require.Equal(t, []interface{}{int64(3), int64(1), int64(1), int64(2)}, rn)
+	require.Equal(t, []interface{}{int64(3), int64(1), int64(1), int64(3)}, place)
+}
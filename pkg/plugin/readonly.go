@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// mutatingKeywords start statements that write to or administer a database. The datasource
+// only reads, so they are rejected even where FireQL wouldn't execute them.
+var mutatingKeywords = map[string]bool{
+	"INSERT":   true,
+	"UPDATE":   true,
+	"DELETE":   true,
+	"UPSERT":   true,
+	"MERGE":    true,
+	"CREATE":   true,
+	"DROP":     true,
+	"ALTER":    true,
+	"TRUNCATE": true,
+	"GRANT":    true,
+	"REVOKE":   true,
+}
+
+// validateReadOnly rejects anything but a single SELECT statement, which may start with
+// WITH common table expressions, before it is executed, so a datasource with an overly
+// broad service account can't be used to change data
+func validateReadOnly(query string) error {
+	tokens, statements := sqlTokens(query)
+	words := tokenWords(tokens)
+	if len(words) == 0 {
+		return errors.New("query is empty")
+	}
+	if statements > 1 {
+		return errors.New("only a single SELECT statement is allowed")
+	}
+	if words[0] != "SELECT" && words[0] != "WITH" {
+		return fmt.Errorf("only SELECT statements are allowed, got %s", words[0])
+	}
+	// A statement nested in parentheses starts with its keyword followed by the table or
+	// clause it writes; fields named like the keywords, as in SELECT update, are read
+	for i, token := range tokens {
+		if token.word && mutatingKeywords[token.text] && i > 0 && tokens[i-1].text == "(" && i+1 < len(tokens) && tokens[i+1].word {
+			return fmt.Errorf("%s is not allowed, the datasource is read-only", token.text)
+		}
+	}
+	return nil
+}
+
+// sqlToken is a word, upper-cased, or a symbol of a query. A string literal or quoted
+// identifier is the symbol ?.
+type sqlToken struct {
+	text string
+	word bool
+}
+
+// sqlWords returns the upper-cased words of a query outside string literals, quoted
+// identifiers and comments, and the number of statements separated by semicolons
+func sqlWords(query string) ([]string, int) {
+	tokens, statements := sqlTokens(query)
+	return tokenWords(tokens), statements
+}
+
+// tokenWords returns the words of the tokens of a query. Segments of a field path like
+// data.update are not keywords.
+func tokenWords(tokens []sqlToken) []string {
+	var words []string
+	for i, token := range tokens {
+		if token.word && (i == 0 || tokens[i-1].text != ".") {
+			words = append(words, token.text)
+		}
+	}
+	return words
+}
+
+// sqlTokens returns the tokens of a query outside comments, and the number of statements
+// separated by semicolons
+func sqlTokens(query string) ([]sqlToken, int) {
+	var tokens []sqlToken
+	statements := 0
+	inStatement := false
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\'' || r == '"' || r == '`':
+			// Skip the literal, a doubled quote is an escaped quote
+			for i++; i < len(runes); i++ {
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			tokens = append(tokens, sqlToken{text: "?"})
+			inStatement = true
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			for i += 2; i < len(runes) && !(runes[i-1] == '*' && runes[i] == '/'); i++ {
+			}
+		case r == ';':
+			if inStatement {
+				statements++
+			}
+			inStatement = false
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i+1 < len(runes) && (unicode.IsLetter(runes[i+1]) || unicode.IsDigit(runes[i+1]) || runes[i+1] == '_' || runes[i+1] == '$') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{text: strings.ToUpper(string(runes[start : i+1])), word: true})
+			inStatement = true
+		case !unicode.IsSpace(r):
+			tokens = append(tokens, sqlToken{text: string(r)})
+			inStatement = true
+		}
+	}
+	if inStatement {
+		statements++
+	}
+	return tokens, statements
+}
@@ -0,0 +1,36 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateReadOnly(t *testing.T) {
+	tests := []struct {
+		query string
+		valid bool
+	}{
+		{"SELECT * FROM users", true},
+		{"  select name FROM users WHERE status = 'delete me' LIMIT 10;", true},
+		{"-- latest\nSELECT * FROM users /* DROP */ WHERE data.update > 1", true},
+		{"SELECT `delete` FROM users", true},
+		{"WITH paid AS (SELECT * FROM orders WHERE status = 'paid') SELECT * FROM paid", true},
+		{"WITH gone AS (DELETE FROM users) SELECT * FROM gone", false},
+		{"", false},
+		{"-- only a comment", false},
+		{"DELETE FROM users", false},
+		{"UPDATE users SET name = 'x'", false},
+		{"SELECT * FROM users; DROP TABLE users", false},
+		{"SELECT * FROM users WHERE id IN (DELETE FROM users)", false},
+		{"SELECT update FROM x", true},
+		{"SELECT id, update, `drop` FROM x WHERE delete = false AND (update > 1 OR (create))", true},
+		{"SELECT * FROM users WHERE id IN (UPDATE users SET name = 'x')", false},
+		{"WITH changed AS ( /* rows */ INSERT INTO users VALUES (1)) SELECT * FROM changed", false},
+	}
+
+	for _, tt := range tests {
+		err := validateReadOnly(tt.query)
+		require.Equal(t, tt.valid, err == nil, "%s: %v", tt.query, err)
+	}
+}
@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Firestore serves snapshot reads of any time within the last hour and, with point-in-time
+// recovery enabled, of whole minutes within the last 7 days
+const (
+	readTimeRecentWindow = time.Hour
+	readTimePITRWindow   = 7 * 24 * time.Hour
+)
+
+// readTime returns the snapshot time of the readTime query option, zero when it isn't set.
+// The Firestore client reads at whole seconds, so the fraction of a second is dropped and
+// the time returned is the one read.
+func (qm *FirestoreQuery) readTime(now time.Time) (time.Time, error) {
+	if qm.ReadTime == "" {
+		return time.Time{}, nil
+	}
+	readTime, err := time.Parse(time.RFC3339Nano, qm.ReadTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid readTime %q, expected an RFC3339 timestamp", qm.ReadTime)
+	}
+	readTime = readTime.Truncate(time.Second)
+	age := now.Sub(readTime)
+	switch {
+	case age < 0:
+		return time.Time{}, fmt.Errorf("readTime %s is in the future", qm.ReadTime)
+	case age > readTimePITRWindow:
+		return time.Time{}, fmt.Errorf("readTime %s is older than the 7 day point-in-time recovery window", qm.ReadTime)
+	case age > readTimeRecentWindow && !readTime.Equal(readTime.Truncate(time.Minute)):
+		return time.Time{}, fmt.Errorf("readTime %s is older than an hour and must be a whole minute", qm.ReadTime)
+	}
+	return readTime, nil
+}
+
+// applyReadTime makes every read of the client return the database as of the query's
+// readTime, recording it in the frame meta
+func applyReadTime(client *firestore.Client, qm *FirestoreQuery, meta *queryMeta) error {
+	readTime, err := qm.readTime(time.Now())
+	if err != nil || readTime.IsZero() {
+		return err
+	}
+	client.WithReadOptions(firestore.ReadTime(readTime))
+	meta.setCustom("readTime", readTime.UTC().Format(time.RFC3339Nano))
+	return nil
+}
@@ -0,0 +1,34 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadTime(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 30, 0, time.UTC)
+	tests := []struct {
+		readTime string
+		want     time.Time
+		valid    bool
+	}{
+		{"", time.Time{}, true},
+		{"2024-05-10T11:30:15.5Z", time.Date(2024, 5, 10, 11, 30, 15, 0, time.UTC), true},
+		{"2024-05-10T12:00:30.5Z", time.Date(2024, 5, 10, 12, 0, 30, 0, time.UTC), true},
+		{"2024-05-05T08:00:00Z", time.Date(2024, 5, 5, 8, 0, 0, 0, time.UTC), true},
+		{"2024-05-05T10:00:00+02:00", time.Date(2024, 5, 5, 8, 0, 0, 0, time.UTC), true},
+		{"2024-05-05T08:00:10Z", time.Time{}, false},
+		{"2024-05-01T08:00:00Z", time.Time{}, false},
+		{"2024-05-10T12:05:00Z", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		qm := FirestoreQuery{ReadTime: tt.readTime}
+		readTime, err := qm.readTime(now)
+		require.Equal(t, tt.valid, err == nil, "%s: %v", tt.readTime, err)
+		require.True(t, tt.want.Equal(readTime), "%s: got %v", tt.readTime, readTime)
+	}
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Supported representations of Firestore DocumentRef values, set with the refFormat query option
+const (
+	refFormatPath = "path" // document path relative to the database root, e.g. users/alice (default)
+	refFormatID   = "id"   // document ID only, e.g. alice
+)
+
+// validateRefFormat checks the requested DocumentRef representation
+func validateRefFormat(format string) error {
+	switch format {
+	case "", refFormatPath, refFormatID:
+		return nil
+	default:
+		return fmt.Errorf("unsupported refFormat %q, expected one of %s, %s", format, refFormatPath, refFormatID)
+	}
+}
+
+// documentRefString returns the path or ID of a referenced document
+func documentRefString(ref *firestore.DocumentRef, format string) string {
+	if format == refFormatID {
+		return ref.ID
+	}
+	if _, path, ok := strings.Cut(ref.Path, "/documents/"); ok {
+		return path
+	}
+	return ref.Path
+}
+
+// convertDocumentRefs replaces DocumentRef values, including those nested in maps and
+// arrays, with their path or ID strings
+func convertDocumentRefs(value interface{}, format string) interface{} {
+	switch v := value.(type) {
+	case *firestore.DocumentRef:
+		if v == nil {
+			return nil
+		}
+		return documentRefString(v, format)
+	case map[string]interface{}:
+		for key, nested := range v {
+			v[key] = convertDocumentRefs(nested, format)
+		}
+		return v
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = convertDocumentRefs(nested, format)
+		}
+		return v
+	default:
+		return value
+	}
+}
@@ -0,0 +1,38 @@
+package plugin
+
+import (
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertDocumentRefs(t *testing.T) {
+	ref := &firestore.DocumentRef{
+		ID:   "alice",
+		Path: "projects/test-project/databases/(default)/documents/users/alice",
+	}
+	row := map[string]interface{}{
+		"owner":   ref,
+		"members": []interface{}{ref},
+		"meta":    map[string]interface{}{"createdBy": ref},
+		"count":   int64(1),
+	}
+
+	convertDocumentRefs(row, "")
+	require.Equal(t, map[string]interface{}{
+		"owner":   "users/alice",
+		"members": []interface{}{"users/alice"},
+		"meta":    map[string]interface{}{"createdBy": "users/alice"},
+		"count":   int64(1),
+	}, row)
+
+	require.Equal(t, "alice", convertDocumentRefs(ref, refFormatID))
+	require.Nil(t, convertDocumentRefs((*firestore.DocumentRef)(nil), ""))
+}
+
+func TestValidateRefFormat(t *testing.T) {
+	require.NoError(t, validateRefFormat(""))
+	require.NoError(t, validateRefFormat(refFormatID))
+	require.Error(t, validateRefFormat("name"))
+}
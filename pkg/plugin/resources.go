@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// newResourceHandler serves the resource endpoints used by the query editor
+func newResourceHandler(d *Datasource) backend.CallResourceHandler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/schema", d.handleSchema)
+	mux.HandleFunc("GET /collections/{collection}/fields/{field}/values", d.handleFieldValues)
+	mux.HandleFunc("GET /metadata/macros", d.handleMacros)
+	mux.HandleFunc("GET /templates", d.handleListTemplates)
+	mux.HandleFunc("GET /templates/{name}", d.handleGetTemplate)
+	mux.HandleFunc("PUT /templates/{name}", d.handleSaveTemplate)
+	mux.HandleFunc("DELETE /templates/{name}", d.handleDeleteTemplate)
+	return httpadapter.New(mux)
+}
+
+// CallResource handles the resource requests sent by the frontend
+func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if d.resourceHandler == nil {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusNotFound})
+	}
+	return d.resourceHandler.CallResource(withForwardedIdentity(ctx, req), req, sender)
+}
+
+// handleSchema returns the cached schema of a collection: GET /schema?collection=<name>
+func (d *Datasource) handleSchema(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+	if collection == "" {
+		writeResourceError(w, http.StatusBadRequest, "collection is required")
+		return
+	}
+
+	var schema *collectionSchema
+	var err error
+	switch {
+	case d.schemas != nil:
+		schema, err = d.schemas.get(r.Context(), collection)
+	case d.forwardOAuth:
+		// Sampled with the identity of the requesting user and never cached
+		schema, err = d.loadSchema(r.Context(), collection)
+	default:
+		writeResourceError(w, http.StatusServiceUnavailable, "schema cache is not available")
+		return
+	}
+	if err != nil {
+		writeResourceError(w, httpStatusOf(err), err.Error())
+		return
+	}
+	writeResourceJSON(w, schema)
+}
+
+func writeResourceJSON(w http.ResponseWriter, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		writeResourceError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+func writeResourceError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
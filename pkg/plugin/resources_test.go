@@ -0,0 +1,40 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaResource(t *testing.T) {
+	d := &Datasource{}
+	d.schemas = newSchemaCache(func(ctx context.Context, collection string) (*collectionSchema, error) {
+		return inferSchema(collection, []map[string]interface{}{{"openTS": time.Now()}}), nil
+	}, time.Hour)
+	defer d.Dispose()
+	d.resourceHandler = newResourceHandler(d)
+
+	var response *backend.CallResourceResponse
+	sender := backend.CallResourceResponseSenderFunc(func(res *backend.CallResourceResponse) error {
+		response = res
+		return nil
+	})
+
+	err := d.CallResource(context.Background(), &backend.CallResourceRequest{Method: http.MethodGet, Path: "schema", URL: "schema?collection=dialogs"}, sender)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.Status)
+
+	var schema collectionSchema
+	require.NoError(t, json.Unmarshal(response.Body, &schema))
+	require.Equal(t, "dialogs", schema.Collection)
+	require.Equal(t, []string{"openTS"}, schema.TimeFields)
+
+	err = d.CallResource(context.Background(), &backend.CallResourceRequest{Method: http.MethodGet, Path: "schema", URL: "schema"}, sender)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, response.Status)
+}
@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultMaxRetries is the number of retries used when the maxRetries setting is not set
+	defaultMaxRetries = 3
+	// retryBaseDelay is the backoff before the first retry, doubled for every further retry
+	retryBaseDelay = 200 * time.Millisecond
+	// retryMaxDelay caps the backoff between two retries
+	retryMaxDelay = 5 * time.Second
+)
+
+// isTransientError reports whether a Firestore error is likely to succeed when retried
+func isTransientError(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok || err == nil {
+		return false
+	}
+	switch st.Code() {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryDelay returns the backoff before a retry, attempt counting from 0. Half of the
+// delay is random so the retries of concurrent queries don't hit Firestore together.
+func retryDelay(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 16 && retryBaseDelay<<attempt < retryMaxDelay {
+		delay = retryBaseDelay << attempt
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// withRetries calls fn, retrying transient Firestore errors with exponential backoff
+// until the retries run out or the query context is done
+func (d *Datasource) withRetries(ctx context.Context, operation string, fn func() error) error {
+	err := fn()
+	for attempt := 0; attempt < d.maxRetries && isTransientError(err); attempt++ {
+		delay := retryDelay(attempt)
+		d.logger(ctx).Warn("Retrying transient Firestore error", "operation", operation, "attempt", attempt+1, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		err = fn()
+	}
+	return err
+}
+
+// getAllDocuments runs a Firestore query, retrying transient errors
+func (d *Datasource) getAllDocuments(ctx context.Context, operation string, query firestore.Query) ([]*firestore.DocumentSnapshot, error) {
+	var docs []*firestore.DocumentSnapshot
+	err := d.withRetries(ctx, operation, func() (err error) {
+		docs, err = collectDocuments(ctx, query.Documents(ctx))
+		return err
+	})
+	return docs, err
+}
@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsTransientError(t *testing.T) {
+	require.True(t, isTransientError(status.Error(codes.Unavailable, "unavailable")))
+	require.True(t, isTransientError(status.Error(codes.DeadlineExceeded, "slow")))
+	require.True(t, isTransientError(status.Error(codes.ResourceExhausted, "quota")))
+	require.False(t, isTransientError(status.Error(codes.PermissionDenied, "denied")))
+	require.False(t, isTransientError(errors.New("parse error")))
+	require.False(t, isTransientError(nil))
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := 0; attempt < 40; attempt++ {
+		delay := retryDelay(attempt)
+		require.Greater(t, delay, time.Duration(0))
+		require.LessOrEqual(t, delay, retryMaxDelay)
+	}
+	require.LessOrEqual(t, retryDelay(0), retryBaseDelay)
+}
+
+func TestWithRetries(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+
+	calls := 0
+	d := &Datasource{maxRetries: 1}
+	err := d.withRetries(context.Background(), "test", func() error {
+		calls++
+		if calls == 1 {
+			return unavailable
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+
+	// Non-transient errors aren't retried
+	calls = 0
+	err = d.withRetries(context.Background(), "test", func() error {
+		calls++
+		return status.Error(codes.PermissionDenied, "denied")
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, calls)
+
+	// A done query context stops the retries
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	err = (&Datasource{maxRetries: 3}).withRetries(ctx, "test", func() error {
+		calls++
+		return unavailable
+	})
+	require.ErrorIs(t, err, unavailable)
+	require.Equal(t, 1, calls)
+}
+
+func TestNewDatasourceMaxRetries(t *testing.T) {
+	instance, err := NewDatasource(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(`{}`)})
+	require.NoError(t, err)
+	defer instance.(*Datasource).Dispose()
+	require.Equal(t, defaultMaxRetries, instance.(*Datasource).maxRetries)
+
+	instance, err = NewDatasource(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(`{"maxRetries":0}`)})
+	require.NoError(t, err)
+	defer instance.(*Datasource).Dispose()
+	require.Equal(t, 0, instance.(*Datasource).maxRetries)
+}
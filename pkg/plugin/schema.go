@@ -0,0 +1,394 @@
+package plugin
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// schemaSampleSize is the number of documents read to infer a collection schema
+	schemaSampleSize = 50
+	// schemaRefreshInterval is how often cached schemas are inferred again in the background
+	schemaRefreshInterval = 5 * time.Minute
+	// schemaIdleTimeout is how long a cached schema is kept and refreshed without being read
+	schemaIdleTimeout = 30 * time.Minute
+	// schemaCacheSize is the most collection schemas a datasource instance caches
+	schemaCacheSize = 256
+	// schemaMaxValues is the most distinct values a sampled field can have to be listed
+	schemaMaxValues = 20
+)
+
+// schemaField is a field found in the sampled documents of a collection
+type schemaField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Nested is set for the dot-notation leaf paths of map fields
+	Nested bool `json:"nested,omitempty"`
+	// Values are the distinct values of a low-cardinality string, integer or boolean field
+	// in the sample
+	Values []interface{} `json:"values,omitempty"`
+
+	kind valueKind
+}
+
+// collectionSchema is the schema inferred from a sample of a collection's documents.
+// Nested maps are described both as a JSON field and as their dot-notation leaf paths.
+type collectionSchema struct {
+	Collection string        `json:"collection"`
+	Fields     []schemaField `json:"fields"`
+	TimeFields []string      `json:"timeFields"`
+	UpdatedAt  time.Time     `json:"updatedAt"`
+}
+
+// field returns the schema field with the given name
+func (s *collectionSchema) field(name string) (schemaField, bool) {
+	if s == nil {
+		return schemaField{}, false
+	}
+	for _, field := range s.Fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return schemaField{}, false
+}
+
+// topLevelFields returns the names of the fields that aren't nested leaf paths of another field
+func (s *collectionSchema) topLevelFields() []string {
+	if s == nil {
+		return nil
+	}
+	var names []string
+	for _, field := range s.Fields {
+		if !field.Nested {
+			names = append(names, field.Name)
+		}
+	}
+	return names
+}
+
+// inferSchema detects the fields, their types and the time field candidates of a collection
+// from sampled document rows. Time candidates are Timestamp fields and date strings.
+func inferSchema(collection string, rows []map[string]interface{}) *collectionSchema {
+	values := make(map[string][]interface{})
+	nested := make(map[string]bool)
+	for i, row := range rows {
+		add := func(name string, value interface{}) {
+			if values[name] == nil {
+				values[name] = make([]interface{}, len(rows))
+			}
+			values[name][i] = value
+		}
+		for name, value := range row {
+			add(name, value)
+		}
+		for name, value := range flattenMap(row) {
+			if _, ok := row[name]; !ok {
+				nested[name] = true
+				add(name, value)
+			}
+		}
+	}
+
+	schema := &collectionSchema{Collection: collection, Fields: []schemaField{}, TimeFields: []string{}, UpdatedAt: time.Now()}
+	for name, fieldValues := range values {
+		kind, _ := columnKind(fieldValues)
+		schema.Fields = append(schema.Fields, schemaField{Name: name, Type: kind.String(), Nested: nested[name], Values: distinctValues(kind, fieldValues), kind: kind})
+		if kind == kindTime || (kind == kindString && allDateStrings(fieldValues)) {
+			schema.TimeFields = append(schema.TimeFields, name)
+		}
+	}
+	sort.Slice(schema.Fields, func(i, j int) bool { return schema.Fields[i].Name < schema.Fields[j].Name })
+	sort.Strings(schema.TimeFields)
+	return schema
+}
+
+// distinctValues returns the sorted distinct values of a string, integer or boolean column,
+// nil when it has more than schemaMaxValues of them
+func distinctValues(kind valueKind, values []interface{}) []interface{} {
+	if kind != kindString && kind != kindInt && kind != kindBool {
+		return nil
+	}
+	seen := make(map[interface{}]bool)
+	var distinct []interface{}
+	for _, value := range values {
+		if value == nil || seen[value] {
+			continue
+		}
+		if len(distinct) == schemaMaxValues {
+			return nil
+		}
+		seen[value] = true
+		distinct = append(distinct, value)
+	}
+	sort.Slice(distinct, func(i, j int) bool { return compareValues(distinct[i], distinct[j]) < 0 })
+	return distinct
+}
+
+// allDateStrings reports whether every non-nil value is a parseable date string
+func allDateStrings(values []interface{}) bool {
+	found := false
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		str, ok := value.(string)
+		if !ok {
+			return false
+		}
+		if _, ok := parseDateString(str, nil); !ok {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// fieldType returns the nullable frame field type used for values of a kind
+func (k valueKind) fieldType() data.FieldType {
+	switch k {
+	case kindBool:
+		return data.FieldTypeNullableBool
+	case kindInt:
+		return data.FieldTypeNullableInt64
+	case kindFloat:
+		return data.FieldTypeNullableFloat64
+	case kindTime:
+		return data.FieldTypeNullableTime
+	case kindJSON:
+		return data.FieldTypeNullableJSON
+	default:
+		return data.FieldTypeNullableString
+	}
+}
+
+// leafFields returns the fields that aren't maps expanded into nested leaf paths
+func (s *collectionSchema) leafFields() []string {
+	if s == nil {
+		return nil
+	}
+	var names []string
+	for i, field := range s.Fields {
+		// Fields are sorted, so the leaf paths of a map follow it
+		if i+1 < len(s.Fields) && strings.HasPrefix(s.Fields[i+1].Name, field.Name+".") {
+			continue
+		}
+		names = append(names, field.Name)
+	}
+	return names
+}
+
+// emptySchemaFrame builds the frame returned when no documents match: the selected columns
+// with the types of the collection schema, or strings for fields the schema doesn't know
+func emptySchemaFrame(queryInfo *QueryInfo, schema *collectionSchema) *data.Frame {
+	fields := queryInfo.Fields
+	selectAll := len(fields) == 1 && fields[0] == "*"
+	switch {
+	case selectAll && queryInfo.Flatten:
+		fields = schema.leafFields()
+	case selectAll:
+		fields = schema.topLevelFields()
+	case queryInfo.Flatten:
+		leafRow := make(map[string]interface{})
+		for _, name := range schema.leafFields() {
+			leafRow[name] = nil
+		}
+		fields = expandFlattenedFields(fields, []map[string]interface{}{leafRow})
+	}
+	if len(fields) == 0 && queryInfo.TimeField != "" {
+		fields = []string{queryInfo.TimeField}
+	}
+
+	frame := data.NewFrame("response")
+	for _, name := range fields {
+		fieldType := data.FieldTypeNullableString
+		if name == queryInfo.TimeField {
+			fieldType = data.FieldTypeTime
+		} else if name == docCreateTimeColumn || name == docUpdateTimeColumn {
+			fieldType = data.FieldTypeNullableTime
+		} else if queryInfo.isExpression(name) {
+			fieldType = data.FieldTypeNullableBool
+		} else if field, ok := schema.field(name); ok {
+			fieldType = field.kind.fieldType()
+		}
+		field := data.NewFieldFromFieldType(fieldType, 0)
+		field.Name = name
+		frame.Fields = append(frame.Fields, field)
+	}
+	return frame
+}
+
+// schemaLoader infers the schema of a collection
+type schemaLoader func(ctx context.Context, collection string) (*collectionSchema, error)
+
+// schemaCache keeps the inferred schemas of the collections queried through a datasource
+// instance. Schemas are inferred on first use and refreshed in the background, so editor
+// requests and empty results don't sample documents every time. Only schemas read within
+// schemaIdleTimeout are refreshed, idle ones are dropped, and the least recently read
+// schema makes room once schemaCacheSize collections are cached.
+type schemaCache struct {
+	load schemaLoader
+
+	mu      sync.Mutex
+	schemas map[string]*schemaEntry
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// schemaEntry is a cached schema and when it was last read
+type schemaEntry struct {
+	schema *collectionSchema
+	used   time.Time
+}
+
+// newSchemaCache creates a cache that refreshes its schemas every interval until closed
+func newSchemaCache(load schemaLoader, interval time.Duration) *schemaCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &schemaCache{
+		load:    load,
+		schemas: make(map[string]*schemaEntry),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go c.refreshLoop(interval)
+	return c
+}
+
+// get returns the cached schema of a collection, inferring it when it isn't cached yet
+func (c *schemaCache) get(ctx context.Context, collection string) (*collectionSchema, error) {
+	c.mu.Lock()
+	var schema *collectionSchema
+	entry, ok := c.schemas[collection]
+	if ok {
+		entry.used = time.Now()
+		schema = entry.schema
+	}
+	c.mu.Unlock()
+	if ok {
+		schemaCacheRequestsTotal.WithLabelValues("hit").Inc()
+		return schema, nil
+	}
+	schemaCacheRequestsTotal.WithLabelValues("miss").Inc()
+
+	schema, err := c.load(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.schemas[collection]; !ok && len(c.schemas) >= schemaCacheSize {
+		c.evictOldest()
+	}
+	c.schemas[collection] = &schemaEntry{schema: schema, used: time.Now()}
+	return schema, nil
+}
+
+// evictOldest drops the least recently read schema. The caller holds the lock.
+func (c *schemaCache) evictOldest() {
+	var oldest string
+	var oldestUsed time.Time
+	for collection, entry := range c.schemas {
+		if oldest == "" || entry.used.Before(oldestUsed) {
+			oldest, oldestUsed = collection, entry.used
+		}
+	}
+	delete(c.schemas, oldest)
+}
+
+// refresh infers the schemas read within schemaIdleTimeout again, keeping the previous
+// schema when that fails, and drops the idle ones
+func (c *schemaCache) refresh(ctx context.Context) {
+	idleSince := time.Now().Add(-schemaIdleTimeout)
+	c.mu.Lock()
+	collections := make([]string, 0, len(c.schemas))
+	for collection, entry := range c.schemas {
+		if entry.used.Before(idleSince) {
+			delete(c.schemas, collection)
+			continue
+		}
+		collections = append(collections, collection)
+	}
+	c.mu.Unlock()
+
+	for _, collection := range collections {
+		schema, err := c.load(ctx, collection)
+		if err != nil {
+			defaultLogger().FromContext(ctx).Warn("Failed to refresh collection schema", "collection", collection, "error", err)
+			continue
+		}
+		c.mu.Lock()
+		// A schema evicted while it was inferred stays evicted
+		if entry, ok := c.schemas[collection]; ok {
+			entry.schema = schema
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *schemaCache) refreshLoop(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(c.ctx, interval)
+			c.refresh(ctx)
+			cancel()
+		}
+	}
+}
+
+// close stops the background refresh, cancelling a refresh in progress
+func (c *schemaCache) close() {
+	c.cancel()
+	<-c.done
+}
+
+// loadSchema samples the documents of a collection and infers its schema
+func (d *Datasource) loadSchema(ctx context.Context, collection string) (*collectionSchema, error) {
+	client, err := newFirestoreClient(ctx, backend.PluginContext{DataSourceInstanceSettings: &d.settings})
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	docs, err := d.getAllDocuments(ctx, "schema sample", client.Collection(collection).Limit(schemaSampleSize))
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		row := doc.Data()
+		convertDocumentRefs(row, "")
+		expandGeoPoints(row, "")
+		rows = append(rows, row)
+	}
+	return inferSchema(collection, rows), nil
+}
+
+// collectionSchema returns the cached schema of a collection, or nil when it can't be inferred
+func (d *Datasource) collectionSchema(ctx context.Context, collection string) *collectionSchema {
+	if d.schemas == nil {
+		return nil
+	}
+	schema, err := d.schemas.get(ctx, collection)
+	if err != nil {
+		d.logger(ctx).Warn("Failed to infer collection schema", "collection", collection, "error", err)
+		return nil
+	}
+	return schema
+}
@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInferSchema(t *testing.T) {
+	schema := inferSchema("dialogs", []map[string]interface{}{
+		{"openTS": time.Now(), "count": int64(1), "clientData": map[string]interface{}{"brand": "yoigo"}},
+		{"openTS": time.Now(), "count": 2.5, "closedAt": "2024-01-01T10:00:00Z"},
+	})
+
+	require.Equal(t, "dialogs", schema.Collection)
+	require.Equal(t, []string{"clientData", "closedAt", "count", "openTS"}, schema.topLevelFields())
+	require.Equal(t, []string{"clientData.brand", "closedAt", "count", "openTS"}, schema.leafFields())
+	require.Equal(t, []string{"closedAt", "openTS"}, schema.TimeFields)
+
+	count, ok := schema.field("count")
+	require.True(t, ok)
+	require.Equal(t, "float", count.Type)
+
+	brand, ok := schema.field("clientData.brand")
+	require.True(t, ok)
+	require.True(t, brand.Nested)
+	require.Equal(t, kindString, brand.kind)
+}
+
+func TestEmptySchemaFrame(t *testing.T) {
+	schema := inferSchema("dialogs", []map[string]interface{}{
+		{"openTS": time.Now(), "count": int64(1), "clientData": map[string]interface{}{"brand": "yoigo"}},
+	})
+
+	frame := emptySchemaFrame(&QueryInfo{Fields: []string{"*"}, TimeField: "openTS"}, schema)
+	require.Len(t, frame.Fields, 3)
+	require.Equal(t, data.FieldTypeNullableJSON, frame.Fields[0].Type())
+	require.Equal(t, data.FieldTypeNullableInt64, frame.Fields[1].Type())
+	require.Equal(t, data.FieldTypeTime, frame.Fields[2].Type())
+
+	frame = emptySchemaFrame(&QueryInfo{Fields: []string{"clientData", "unknown"}, Flatten: true}, schema)
+	require.Len(t, frame.Fields, 2)
+	require.Equal(t, "clientData.brand", frame.Fields[0].Name)
+	require.Equal(t, data.FieldTypeNullableString, frame.Fields[1].Type())
+
+	frame = emptySchemaFrame(&QueryInfo{Fields: []string{"*"}, TimeField: "openTS"}, nil)
+	require.Len(t, frame.Fields, 1)
+	require.Equal(t, "openTS", frame.Fields[0].Name)
+}
+
+func TestSchemaCache(t *testing.T) {
+	loads := 0
+	fail := false
+	cache := newSchemaCache(func(ctx context.Context, collection string) (*collectionSchema, error) {
+		if fail {
+			return nil, errors.New("unavailable")
+		}
+		loads++
+		return inferSchema(collection, nil), nil
+	}, time.Hour)
+	defer cache.close()
+
+	first, err := cache.get(context.Background(), "dialogs")
+	require.NoError(t, err)
+	cached, err := cache.get(context.Background(), "dialogs")
+	require.NoError(t, err)
+	require.Same(t, first, cached)
+	require.Equal(t, 1, loads)
+
+	cache.refresh(context.Background())
+	require.Equal(t, 2, loads)
+
+	// A failed refresh keeps the previous schema
+	fail = true
+	cache.refresh(context.Background())
+	cached, err = cache.get(context.Background(), "dialogs")
+	require.NoError(t, err)
+	require.NotNil(t, cached)
+
+	_, err = cache.get(context.Background(), "users")
+	require.Error(t, err)
+}
+
+func TestSchemaCacheEviction(t *testing.T) {
+	var loads []string
+	cache := newSchemaCache(func(ctx context.Context, collection string) (*collectionSchema, error) {
+		loads = append(loads, collection)
+		return inferSchema(collection, nil), nil
+	}, time.Hour)
+	defer cache.close()
+	ctx := context.Background()
+
+	// Idle schemas are dropped instead of being refreshed
+	_, err := cache.get(ctx, "dialogs")
+	require.NoError(t, err)
+	_, err = cache.get(ctx, "users")
+	require.NoError(t, err)
+	cache.schemas["users"].used = time.Now().Add(-schemaIdleTimeout - time.Minute)
+	loads = nil
+	cache.refresh(ctx)
+	require.Equal(t, []string{"dialogs"}, loads)
+	require.Contains(t, cache.schemas, "dialogs")
+	require.NotContains(t, cache.schemas, "users")
+
+	// A full cache makes room by dropping the least recently read schema
+	for i := len(cache.schemas); i < schemaCacheSize; i++ {
+		_, err = cache.get(ctx, fmt.Sprintf("collection%d", i))
+		require.NoError(t, err)
+	}
+	cache.schemas["collection1"].used = time.Now().Add(-time.Minute)
+	_, err = cache.get(ctx, "users")
+	require.NoError(t, err)
+	require.Len(t, cache.schemas, schemaCacheSize)
+	require.NotContains(t, cache.schemas, "collection1")
+	require.Contains(t, cache.schemas, "users")
+}
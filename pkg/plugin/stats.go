@@ -0,0 +1,53 @@
+package plugin
+
+import "time"
+
+// queryStats are the statistics of a query, attached to its frames as Meta.Custom.stats for
+// the panel inspector
+type queryStats struct {
+	// Engine is the engine that ran the query, native or fireql
+	Engine string `json:"engine"`
+
+	// ParseMs is the time spent parsing and planning the query
+	ParseMs float64 `json:"parseMs"`
+
+	// FetchMs is the time spent waiting on Firestore, reads and aggregations
+	FetchMs float64 `json:"fetchMs"`
+
+	// DocumentsFetched are the documents downloaded, DocumentsAfterFiltering those left
+	// once the conditions Firestore didn't evaluate filtered them
+	DocumentsFetched        int `json:"documentsFetched"`
+	DocumentsAfterFiltering int `json:"documentsAfterFiltering"`
+
+	// Groups are the groups a GROUP BY produced
+	Groups int `json:"groups,omitempty"`
+
+	// filtered is set once the documents left after filtering are counted
+	filtered bool
+}
+
+// milliseconds returns a duration in fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// addFetch records a Firestore call started at a time that downloaded a number of documents
+func (m *queryMeta) addFetch(started time.Time, docs int) {
+	m.stats.FetchMs += milliseconds(time.Since(started))
+	m.stats.DocumentsFetched += docs
+}
+
+// addFiltered records the documents left after filtering in memory
+func (m *queryMeta) addFiltered(docs int) {
+	m.stats.filtered = true
+	m.stats.DocumentsAfterFiltering += docs
+}
+
+// finish returns the statistics of a completed query, the documents of a query that filtered
+// none in memory are all left after filtering
+func (s queryStats) finish() queryStats {
+	if !s.filtered {
+		s.DocumentsAfterFiltering = s.DocumentsFetched
+	}
+	return s
+}
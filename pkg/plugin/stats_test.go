@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryStats(t *testing.T) {
+	meta := &queryMeta{stats: queryStats{Engine: routeNative, ParseMs: 1.5}}
+	meta.addFetch(time.Now().Add(-20*time.Millisecond), 120)
+	meta.addFetch(time.Now(), 30)
+	meta.addFiltered(40)
+	meta.stats.Groups = 3
+
+	response := meta.apply(backend.DataResponse{Frames: data.Frames{data.NewFrame("response")}})
+	stats := response.Frames[0].Meta.Custom.(map[string]interface{})["stats"].(queryStats)
+	require.Equal(t, routeNative, stats.Engine)
+	require.Equal(t, 1.5, stats.ParseMs)
+	require.GreaterOrEqual(t, stats.FetchMs, 20.0)
+	require.Equal(t, 150, stats.DocumentsFetched)
+	require.Equal(t, 40, stats.DocumentsAfterFiltering)
+	require.Equal(t, 3, stats.Groups)
+}
+
+func TestQueryStatsWithoutFiltering(t *testing.T) {
+	// Documents nothing filtered in memory are all left after filtering
+	meta := &queryMeta{stats: queryStats{Engine: routeFireQL}}
+	meta.addFetch(time.Now(), 25)
+	stats := meta.stats.finish()
+	require.Equal(t, 25, stats.DocumentsAfterFiltering)
+
+	// Queries without an engine, like failed ones, have no stats
+	response := (&queryMeta{}).apply(backend.DataResponse{Frames: data.Frames{data.NewFrame("response")}})
+	require.Nil(t, response.Frames[0].Meta.Custom)
+}
+
+func TestPlanQueryParseTime(t *testing.T) {
+	plan, err := planQuery(FirestoreQuery{Query: "SELECT * FROM dialogs WHERE status = 'open'"}, &FirestoreSettings{}, backend.TimeRange{})
+	require.NoError(t, err)
+	require.Positive(t, plan.parseTime)
+}
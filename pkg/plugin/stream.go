@@ -0,0 +1,704 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+)
+
+const (
+	// streamPathPrefix starts the channel path of a streaming query, followed by its hash
+	streamPathPrefix = "query/"
+
+	// streamQueryTTL is how long a streaming query stays registered without subscribers
+	streamQueryTTL = time.Hour
+
+	// streamMaxQueries caps the streaming queries registered per datasource
+	streamMaxQueries = 1000
+
+	// streamBuffer is how many frames a subscriber may fall behind before its pending
+	// frames are replaced by one catching it up
+	streamBuffer = 16
+)
+
+// Kinds of the document changes a stream batches
+const (
+	changeAdded    = "added"
+	changeModified = "modified"
+	changeRemoved  = "removed"
+)
+
+// errStreamNotFound is returned for a channel no query registered
+var errStreamNotFound = errors.New("unknown stream, run the query again to register it")
+
+// streamQuery is a streaming query registered by QueryData under the path of its channel
+type streamQuery struct {
+	qm       FirestoreQuery
+	info     *QueryInfo
+	settings *FirestoreSettings
+
+	// tail is where a tail query starts, nil for the other streams
+	tail *streamTail
+
+	registered time.Time
+}
+
+// streamUpdate is what a listener publishes per snapshot: the frame sent to the subscribers,
+// and the whole result for the subscribers that join. A panel replaces its result with each
+// frame of a stream, the whole result, and appends the frames of a tail, the documents it
+// added.
+type streamUpdate struct {
+	frame    *data.Frame
+	snapshot func() *data.Frame
+	tail     bool
+}
+
+// streamListenFunc listens to the results of a query, publishing an update per snapshot until
+// ctx is done or the listener fails
+type streamListenFunc func(ctx context.Context, query *streamQuery, publish func(streamUpdate)) error
+
+// streamListener is the Firestore listener of a channel and the subscribers it publishes to
+type streamListener struct {
+	cancel      context.CancelFunc
+	subscribers map[chan *data.Frame]bool
+	last        *streamUpdate
+	err         error
+	done        bool
+}
+
+// streamHub shares one Firestore snapshot listener between every subscriber of a channel, so
+// any number of panels watching the same query cost a single listener. The listener starts
+// with the first subscriber and is torn down when the last one leaves.
+type streamHub struct {
+	listen streamListenFunc
+
+	mu        sync.Mutex
+	queries   map[string]*streamQuery
+	listeners map[string]*streamListener
+	closed    bool
+}
+
+// newStreamHub creates a hub listening to the queries with listen
+func newStreamHub(listen streamListenFunc) *streamHub {
+	return &streamHub{
+		listen:    listen,
+		queries:   make(map[string]*streamQuery),
+		listeners: make(map[string]*streamListener),
+	}
+}
+
+// streamTail is the time field a tail query follows and the time after which its documents
+// are streamed, the end of the time range of the panel
+type streamTail struct {
+	field string
+	start time.Time
+}
+
+// streamKey is what decides the frames of a stream: the query, its parameters and the
+// options shaping its results. The options of a panel, like its interval and data points, are
+// left out so every panel running the query shares the channel.
+type streamKey struct {
+	Query           string                  `json:"query"`
+	Builder         *BuilderQuery           `json:"builder,omitempty"`
+	Params          map[string]interface{}  `json:"params,omitempty"`
+	Format          string                  `json:"format,omitempty"`
+	TimeField       string                  `json:"timeField,omitempty"`
+	TimeFormat      string                  `json:"timeFormat,omitempty"`
+	Flatten         bool                    `json:"flatten,omitempty"`
+	GeoFormat       string                  `json:"geoFormat,omitempty"`
+	RefFormat       string                  `json:"refFormat,omitempty"`
+	MaxRows         int                     `json:"maxRows,omitempty"`
+	FieldConfig     map[string]FieldOptions `json:"fieldConfig,omitempty"`
+	Decimals        *uint16                 `json:"decimals,omitempty"`
+	LogMessageField string                  `json:"logMessageField,omitempty"`
+	LogLevelField   string                  `json:"logLevelField,omitempty"`
+	LogLabelFields  []string                `json:"logLabelFields,omitempty"`
+	TailField       string                  `json:"tailField,omitempty"`
+	TimeFrom        string                  `json:"timeFrom,omitempty"`
+}
+
+// streamPath returns the channel path of a query, the same for every panel running it. The
+// start of a tail is left out: it moves with every refresh of a relative time range, so a
+// tail keeps its channel and its listener starts after the range of the latest refresh. The
+// start of the time range of a stream is kept, as panels with other ranges stream other
+// documents.
+func streamPath(query *streamQuery) (string, error) {
+	qm := query.qm
+	key := streamKey{
+		Query:           qm.Query,
+		Builder:         qm.Builder,
+		Params:          qm.Params,
+		Format:          qm.Format,
+		TimeField:       qm.TimeField,
+		TimeFormat:      qm.TimeFormat,
+		Flatten:         qm.Flatten,
+		GeoFormat:       qm.GeoFormat,
+		RefFormat:       qm.RefFormat,
+		MaxRows:         qm.MaxRows,
+		FieldConfig:     qm.FieldConfig,
+		Decimals:        qm.Decimals,
+		LogMessageField: qm.LogMessageField,
+		LogLevelField:   qm.LogLevelField,
+		LogLabelFields:  qm.LogLabelFields,
+	}
+	if query.tail != nil {
+		key.TailField = query.tail.field
+	}
+	if query.info != nil && !query.info.TimeRange.From.IsZero() {
+		key.TimeFrom = query.info.TimeRange.From.UTC().Format(time.RFC3339Nano)
+	}
+	canonical, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return streamPathPrefix + hex.EncodeToString(sum[:16]), nil
+}
+
+// register records a streaming query and returns the path of its channel. Queries nobody
+// subscribed to for streamQueryTTL are forgotten.
+func (h *streamHub) register(query *streamQuery, now time.Time) (string, error) {
+	path, err := streamPath(query)
+	if err != nil {
+		return "", err
+	}
+	query.registered = now
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for registered, q := range h.queries {
+		if h.listeners[registered] == nil && now.Sub(q.registered) > streamQueryTTL {
+			delete(h.queries, registered)
+		}
+	}
+	if _, ok := h.queries[path]; !ok && len(h.queries) >= streamMaxQueries {
+		return "", fmt.Errorf("too many streaming queries, at most %d are registered per datasource", streamMaxQueries)
+	}
+	h.queries[path] = query
+	return path, nil
+}
+
+// registered reports whether a query is registered under a channel path
+func (h *streamHub) registered(path string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.queries[path]
+	return ok
+}
+
+// streamSubscription receives the frames of a channel until it is released
+type streamSubscription struct {
+	frames  <-chan *data.Frame
+	release func()
+
+	hub      *streamHub
+	listener *streamListener
+}
+
+// err returns why the listener of a subscription stopped, nil while it runs or when it was
+// torn down
+func (s *streamSubscription) err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.listener.err
+}
+
+// subscribe joins the listener of a channel, starting it for the first subscriber. The
+// whole result is sent first so a new panel doesn't wait for the next change, then the
+// updates.
+func (h *streamHub) subscribe(path string) (*streamSubscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errors.New("the datasource is closed")
+	}
+	query, ok := h.queries[path]
+	if !ok {
+		return nil, errStreamNotFound
+	}
+	listener := h.listeners[path]
+	if listener == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		listener = &streamListener{cancel: cancel, subscribers: make(map[chan *data.Frame]bool)}
+		h.listeners[path] = listener
+		go h.run(ctx, path, listener, query)
+	}
+	query.registered = time.Now()
+
+	frames := make(chan *data.Frame, streamBuffer)
+	if listener.last != nil {
+		frames <- listener.last.snapshot()
+	}
+	listener.subscribers[frames] = true
+	return &streamSubscription{
+		frames:   frames,
+		release:  sync.OnceFunc(func() { h.unsubscribe(path, listener, frames) }),
+		hub:      h,
+		listener: listener,
+	}, nil
+}
+
+// unsubscribe leaves the listener of a channel, tearing it down after the last subscriber
+func (h *streamHub) unsubscribe(path string, listener *streamListener, frames chan *data.Frame) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(listener.subscribers, frames)
+	if len(listener.subscribers) > 0 {
+		return
+	}
+	listener.cancel()
+	if h.listeners[path] == listener {
+		delete(h.listeners, path)
+	}
+	if query, ok := h.queries[path]; ok {
+		query.registered = time.Now()
+	}
+}
+
+// run listens to a query until the listener is torn down or fails, then closes the frames of
+// its subscribers
+func (h *streamHub) run(ctx context.Context, path string, listener *streamListener, query *streamQuery) {
+	err := h.listen(ctx, query, func(update streamUpdate) { h.publish(listener, update) })
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ctx.Err() == nil {
+		listener.err = err
+	}
+	listener.done = true
+	listener.cancel()
+	for frames := range listener.subscribers {
+		close(frames)
+	}
+	listener.subscribers = map[chan *data.Frame]bool{}
+	if h.listeners[path] == listener {
+		delete(h.listeners, path)
+	}
+}
+
+// publish sends the frame of an update to the subscribers of a listener. A subscriber that
+// fell behind streamBuffer updates gets a single frame instead of its pending ones: the latest
+// result of a stream, or the rows of every pending frame of a tail, which it can't skip
+// without missing documents.
+func (h *streamHub) publish(listener *streamListener, update streamUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if listener.done {
+		return
+	}
+	listener.last = &update
+	for frames := range listener.subscribers {
+		select {
+		case frames <- update.frame:
+		default:
+			var pending []*data.Frame
+			for len(frames) > 0 {
+				pending = append(pending, <-frames)
+			}
+			frames <- catchUpFrame(pending, update)
+		}
+	}
+}
+
+// catchUpFrame returns the frame replacing the pending frames of a subscriber that fell
+// behind. The frames of a tail are appended into one, or replaced by the whole result when
+// their columns differ, which Grafana takes as a new result.
+func catchUpFrame(pending []*data.Frame, update streamUpdate) *data.Frame {
+	if !update.tail {
+		return update.frame
+	}
+	merged := update.frame.EmptyCopy()
+	for _, frame := range append(pending, update.frame) {
+		if len(frame.Fields) != len(merged.Fields) {
+			return update.snapshot()
+		}
+		for i, field := range frame.Fields {
+			if field.Name != merged.Fields[i].Name || field.Type() != merged.Fields[i].Type() {
+				return update.snapshot()
+			}
+			for row := 0; row < field.Len(); row++ {
+				merged.Fields[i].Append(field.CopyAt(row))
+			}
+		}
+	}
+	return merged
+}
+
+// close tears down every listener
+func (h *streamHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, listener := range h.listeners {
+		listener.cancel()
+	}
+}
+
+// streamUnsupported returns why a query can't be streamed, empty when it can. A stream
+// listens to a single collection and sends the documents matching its filters, ordering and
+// limit; what is computed over the whole result runs on the refreshes of the dashboard.
+func streamUnsupported(qm FirestoreQuery, info *QueryInfo) string {
+	if qm.Builder == nil {
+		if reason := nativeUnsupported(qm.Query, info, nil); reason != "" {
+			return reason
+		}
+	}
+	switch {
+	case info.Join != nil:
+		return "joins can't be streamed"
+	case info.Subquery != nil:
+		return "subqueries can't be streamed"
+	case len(info.GroupByFields) > 0 || len(info.AggregateFields) > 0:
+		return "GROUP BY and aggregates can't be streamed"
+	case len(info.Ranks) > 0 || overPattern.MatchString(qm.Query):
+		return "window functions can't be streamed"
+	case isCollectionPattern(info.Collection):
+		return "wildcard collections can't be streamed"
+	case qm.Lookup != nil:
+		return "lookups can't be streamed"
+	case qm.ReadTime != "" || qm.Explain:
+		return "readTime and explain queries can't be streamed"
+	case qm.Format == formatLogs && qm.Tail:
+		// A tail streams logs, the checks of the filters and ordering below still apply
+	case qm.Format != "" && qm.Format != formatTable && qm.Format != formatTimeSeries:
+		return fmt.Sprintf("the %s format can't be streamed", qm.Format)
+	}
+	for _, filter := range info.AdditionalFilters {
+		if info.filteredInMemory(filter) {
+			return fmt.Sprintf("WHERE %s is filtered in memory and can't be streamed", filter.Field)
+		}
+	}
+	for _, key := range info.OrderBy {
+		if key.Field == docCreateTimeColumn || key.Field == docUpdateTimeColumn {
+			return fmt.Sprintf("ORDER BY %s can't be streamed", key.Field)
+		}
+	}
+	return ""
+}
+
+// queryStream runs a query with the stream option and registers it for streaming, pointing
+// its frames to the Live channel that pushes the later changes of its results
+func (d *Datasource) queryStream(ctx context.Context, pCtx backend.PluginContext, settings *FirestoreSettings, qm FirestoreQuery, timeRange backend.TimeRange, plan *queryPlan) backend.DataResponse {
+	if d.streams == nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "stream: streaming is unavailable when queries forward the user's OAuth identity")
+	}
+	info, err := nativeQueryInfo(qm, timeRange)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "Query parsing: "+err.Error())
+	}
+	applyCollectionTimeField(info, settings, timeRange)
+	d.resolveFieldKinds(ctx, info)
+	if reason := streamUnsupported(qm, info); reason != "" {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "stream: "+reason)
+	}
+	if err := validateStreamSettings(settings); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	query := &streamQuery{qm: qm, info: info, settings: settings}
+	if qm.Tail {
+		field := plan.info.TimeField
+		if field == "" {
+			field = qm.TimeField
+		}
+		if field == "" {
+			return backend.ErrDataResponse(backend.StatusBadRequest, "tail: the query needs a time field, filter it with $__timeFilter(field) or set the collectionTimeFields setting")
+		}
+		query.tail = &streamTail{field: field, start: timeRange.To}
+	} else if info.TimeField != "" {
+		// The stream follows the documents written since the start of the time range
+		info.TimeRange = timeRange
+	} else if len(info.OrderBy) == 0 {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "stream: the query needs a time field or ORDER BY to follow the latest documents, filter it with $__timeFilter(field) or set the collectionTimeFields setting")
+	}
+	path, err := d.streams.register(query, time.Now())
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "stream: "+err.Error())
+	}
+
+	response := d.executeNativePlan(ctx, pCtx, settings, qm, timeRange, plan)
+	channel := live.Channel{Scope: live.ScopeDatasource, Namespace: d.settings.UID, Path: path}
+	for _, frame := range response.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Channel = channel.String()
+	}
+	return response
+}
+
+// SubscribeStream authorizes the subscription of a panel to the channel of a registered query
+func (d *Datasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if d.streams == nil || !d.streams.registered(req.Path) {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream rejects publications, the channels only carry query results
+func (d *Datasource) PublishStream(_ context.Context, _ *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream sends the results of the query of a channel until Grafana stops the stream
+func (d *Datasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	if d.streams == nil {
+		return errStreamNotFound
+	}
+	subscription, err := d.streams.subscribe(req.Path)
+	if err != nil {
+		return err
+	}
+	defer subscription.release()
+	d.debugLog(ctx, "Stream started", "path", req.Path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			d.debugLog(ctx, "Stream stopped", "path", req.Path)
+			return nil
+		case frame, ok := <-subscription.frames:
+			if !ok {
+				return subscription.err()
+			}
+			if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// listenStream listens to the snapshots of a streaming query on Firestore, publishing the
+// whole result when documents were added, modified or removed since its previous update, and
+// only the added documents for a tail. Changes are batched by the streaming settings, the
+// first snapshot is published at once.
+func (d *Datasource) listenStream(ctx context.Context, query *streamQuery, publish func(streamUpdate)) error {
+	location, err := query.settings.location()
+	if err != nil {
+		return err
+	}
+	client, err := newFirestoreClient(ctx, backend.PluginContext{DataSourceInstanceSettings: &d.settings})
+	if err != nil {
+		return err
+	}
+
+	qm, info := query.qm, *query.info
+	info.TimeFormat = qm.TimeFormat
+	info.Location = location
+	info.Flatten = qm.Flatten
+	info.GeoFormat = qm.GeoFormat
+	info.RefFormat = qm.RefFormat
+	info.FieldTypes = query.settings.collectionFieldTypes(info.Collection)
+	info.DataplaneLogs = query.settings.DataplaneLogs
+	if query.tail != nil {
+		info.TimeField = query.tail.field
+	}
+
+	// The snapshots are read apart so batched changes are sent while no snapshot arrives. The
+	// reader owns the iterator and the client, stopping and closing them once Next returns,
+	// and the listener waits for it so neither outlives the stream.
+	type snapshotResult struct {
+		snapshot *firestore.QuerySnapshot
+		err      error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	results := make(chan snapshotResult)
+	read := make(chan struct{})
+	defer func() {
+		cancel()
+		<-read
+	}()
+	snapshots := streamFirestoreQuery(client, &info, qm, query.tail).Snapshots(ctx)
+	go func() {
+		defer close(read)
+		defer client.Close()
+		defer snapshots.Stop()
+		for {
+			snapshot, err := snapshots.Next()
+			select {
+			case results <- snapshotResult{snapshot: snapshot, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// frame converts the documents of a snapshot, a row per document in their order
+	frame := func(docs []*firestore.DocumentSnapshot) (*data.Frame, map[string]int, error) {
+		snapshotInfo := info
+		snapshotInfo.MemoryBudget = newMemoryBudget(query.settings.MemoryBudgetMB)
+		var response backend.DataResponse
+		if qm.Format == formatLogs {
+			response = d.convertFirestoreDocsToLogsResponse(ctx, docs, &snapshotInfo, qm)
+		} else {
+			response = d.convertFirestoreDocsToResponseWithFields(ctx, docs, nil, &snapshotInfo)
+		}
+		response = applyFieldConfig(response, qm, &snapshotInfo)
+		if response.Error != nil {
+			return nil, nil, response.Error
+		}
+		rows := make(map[string]int, len(docs))
+		for i, doc := range docs {
+			rows[doc.Ref.Path] = i
+		}
+		return response.Frames[0], rows, nil
+	}
+	// A tail only sends the documents it adds, the rows are new log lines or points appended
+	// by the panel
+	tailed := query.tail != nil
+	typed := func(frame *data.Frame) *data.Frame {
+		return setFrameTypes(backend.DataResponse{Frames: data.Frames{frame}}, qm.Format, info.TimeField).Frames[0]
+	}
+
+	batcher := newStreamBatcher(query.settings)
+	var docs []*firestore.DocumentSnapshot
+	var published bool
+	var timer *time.Timer
+	var due <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case result := <-results:
+			if result.err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("stream: %w", result.err)
+			}
+			docs, err = result.snapshot.Documents.GetAll()
+			if err != nil {
+				return fmt.Errorf("stream: %w", err)
+			}
+			// Firestore bills the documents a snapshot changed, every one on the first snapshot
+			documentsFetchedTotal.WithLabelValues(routeNative).Add(float64(len(result.snapshot.Changes)))
+
+			if !published {
+				current, _, err := frame(docs)
+				if err != nil {
+					return err
+				}
+				snapshot := typed(current)
+				publish(streamUpdate{frame: snapshot, snapshot: func() *data.Frame { return snapshot }, tail: tailed})
+				published = true
+				batcher.flush(time.Now())
+				continue
+			}
+			now := time.Now()
+			for _, change := range result.snapshot.Changes {
+				switch {
+				case change.Kind == firestore.DocumentAdded:
+					batcher.add(change.Doc.Ref.Path, changeAdded, now)
+				case tailed:
+					// Older documents leave the window of a tail as new ones arrive
+				case change.Kind == firestore.DocumentModified:
+					batcher.add(change.Doc.Ref.Path, changeModified, now)
+				case change.Kind == firestore.DocumentRemoved:
+					batcher.add(change.Doc.Ref.Path, changeRemoved, now)
+				}
+			}
+			if timer == nil && !batcher.due().IsZero() {
+				timer = time.NewTimer(time.Until(batcher.due()))
+				due = timer.C
+			}
+
+		case <-due:
+			timer, due = nil, nil
+			current, rows, err := frame(docs)
+			if err != nil {
+				return err
+			}
+			changes := batcher.flush(time.Now())
+			if !tailed {
+				if len(changes) > 0 {
+					whole := typed(current)
+					publish(streamUpdate{frame: whole, snapshot: func() *data.Frame { return whole }})
+				}
+				continue
+			}
+			var added []int
+			for _, change := range changes {
+				if row, ok := rows[change.path]; ok {
+					added = append(added, row)
+				}
+			}
+			if len(added) > 0 {
+				snapshot := sync.OnceValue(func() *data.Frame { return typed(current) })
+				publish(streamUpdate{frame: typed(rowsFrame(current, added)), snapshot: snapshot, tail: true})
+			}
+		}
+	}
+}
+
+// rowsFrame returns the rows of a frame holding the documents a tail added
+func rowsFrame(current *data.Frame, rows []int) *data.Frame {
+	frame := data.NewFrame(current.Name)
+	frame.RefID = current.RefID
+	for _, field := range current.Fields {
+		out := data.NewFieldFromFieldType(field.Type(), len(rows))
+		out.Name, out.Labels, out.Config = field.Name, field.Labels, field.Config
+		for i, row := range rows {
+			out.Set(i, field.CopyAt(row))
+		}
+		frame.Fields = append(frame.Fields, out)
+	}
+	return frame
+}
+
+// streamFirestoreQuery builds the Firestore query a stream listens to, with the filters,
+// ordering and limit of the streaming query. A stream with a time field listens to the
+// documents since the start of its time range, newest first unless the query orders them,
+// and a tail to the latest documents after its start, newest first.
+func streamFirestoreQuery(client *firestore.Client, info *QueryInfo, qm FirestoreQuery, tail *streamTail) firestore.Query {
+	query := client.Collection(info.Collection).Query
+	for _, filter := range info.AdditionalFilters {
+		query = query.Where(filter.Field, filter.Operator, filter.Value)
+	}
+	orderBy := info.OrderBy
+	if tail != nil {
+		// The bound after the end of the time range, as the time field is stored
+		_, after := timeRangeBounds(backend.TimeRange{From: tail.start, To: tail.start}, info.timeFormatOf(tail.field))
+		query = query.Where(tail.field, ">", after)
+		orderBy = []OrderKey{{Field: tail.field, Descending: true}}
+	} else if info.TimeField != "" && !info.TimeRange.From.IsZero() {
+		// Without the upper bound, so the documents written later are streamed
+		from, _ := timeRangeBounds(info.TimeRange, info.timeFormatOf(info.TimeField))
+		query = query.Where(info.TimeField, ">=", from)
+		if len(orderBy) == 0 {
+			orderBy = []OrderKey{{Field: info.TimeField, Descending: true}}
+		}
+	}
+	for _, key := range orderBy {
+		direction := firestore.Asc
+		if key.Descending {
+			direction = firestore.Desc
+		}
+		if key.Field == docNameColumn {
+			query = query.OrderBy(firestore.DocumentID, direction)
+		} else {
+			query = query.OrderBy(key.Field, direction)
+		}
+	}
+	limit := info.Limit
+	if limit <= 0 || limit > qm.MaxRows {
+		limit = qm.MaxRows
+	}
+	query = query.Limit(limit)
+	if fields := projectionFields(info, qm); fields != nil {
+		query = query.Select(fields...)
+	}
+	return query
+}
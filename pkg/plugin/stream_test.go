@@ -0,0 +1,359 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeListener counts the listeners a hub starts and publishes the frames sent to it
+type fakeListener struct {
+	started atomic.Int32
+	stopped atomic.Int32
+	updates chan streamUpdate
+	err     chan error
+}
+
+func newFakeListener() *fakeListener {
+	return &fakeListener{updates: make(chan streamUpdate), err: make(chan error, 1)}
+}
+
+func (l *fakeListener) listen(ctx context.Context, _ *streamQuery, publish func(streamUpdate)) error {
+	l.started.Add(1)
+	defer l.stopped.Add(1)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-l.err:
+			return err
+		case update := <-l.updates:
+			publish(update)
+		}
+	}
+}
+
+// fakeUpdate returns the update of a snapshot sending frame
+func fakeUpdate(frame, snapshot *data.Frame) streamUpdate {
+	return streamUpdate{frame: frame, snapshot: func() *data.Frame { return snapshot }}
+}
+
+func receiveFrame(t *testing.T, subscription *streamSubscription) *data.Frame {
+	t.Helper()
+	select {
+	case frame := <-subscription.frames:
+		return frame
+	case <-time.After(time.Second):
+		t.Fatal("no frame received")
+		return nil
+	}
+}
+
+func TestStreamPath(t *testing.T) {
+	a, err := streamPath(&streamQuery{qm: FirestoreQuery{Query: "SELECT * FROM dialogs", Stream: true}})
+	require.NoError(t, err)
+	b, err := streamPath(&streamQuery{qm: FirestoreQuery{Query: "SELECT * FROM dialogs", Stream: true}})
+	require.NoError(t, err)
+	c, err := streamPath(&streamQuery{qm: FirestoreQuery{Query: "SELECT * FROM dialogs LIMIT 5", Stream: true}})
+	require.NoError(t, err)
+
+	require.Equal(t, a, b)
+	require.NotEqual(t, a, c)
+	require.Regexp(t, `^query/[0-9a-f]{32}$`, a)
+
+	// Panels of other widths share the channel, other parameters don't
+	panel, err := streamPath(&streamQuery{qm: FirestoreQuery{Query: "SELECT * FROM dialogs", Stream: true, IntervalMs: 15000, MaxDataPoints: 800, TimeoutSeconds: 30}})
+	require.NoError(t, err)
+	require.Equal(t, a, panel)
+	params := FirestoreQuery{Query: "SELECT * FROM dialogs WHERE status = :status", Stream: true}
+	params.Params = map[string]interface{}{"status": "open"}
+	open, err := streamPath(&streamQuery{qm: params})
+	require.NoError(t, err)
+	params.Params = map[string]interface{}{"status": "closed"}
+	closed, err := streamPath(&streamQuery{qm: params})
+	require.NoError(t, err)
+	require.NotEqual(t, open, closed)
+
+	// Tails starting at another time share their channel, a relative range moves the start
+	// on every refresh
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tailQuery := FirestoreQuery{Query: "SELECT * FROM events", Tail: true}
+	first, err := streamPath(&streamQuery{qm: tailQuery, tail: &streamTail{field: "ts", start: start}})
+	require.NoError(t, err)
+	again, err := streamPath(&streamQuery{qm: tailQuery, tail: &streamTail{field: "ts", start: start}})
+	require.NoError(t, err)
+	later, err := streamPath(&streamQuery{qm: tailQuery, tail: &streamTail{field: "ts", start: start.Add(time.Minute)}})
+	require.NoError(t, err)
+	require.Equal(t, first, again)
+	require.Equal(t, first, later)
+}
+
+func TestStreamHubRegisterMovingTail(t *testing.T) {
+	hub := newStreamHub(newFakeListener().listen)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	qm := FirestoreQuery{Query: "SELECT * FROM events", Format: formatLogs, Tail: true}
+
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := hub.register(&streamQuery{qm: qm, tail: &streamTail{field: "ts", start: start.Add(time.Duration(i) * time.Minute)}}, time.Now())
+		require.NoError(t, err)
+		paths = append(paths, path)
+	}
+	require.Equal(t, paths[0], paths[2])
+	require.Len(t, hub.queries, 1)
+
+	// The next listener starts after the latest refresh
+	require.Equal(t, start.Add(2*time.Minute), hub.queries[paths[0]].tail.start)
+}
+
+func TestStreamHubSharesListener(t *testing.T) {
+	listener := newFakeListener()
+	hub := newStreamHub(listener.listen)
+	path, err := hub.register(&streamQuery{qm: FirestoreQuery{Query: "SELECT * FROM dialogs"}}, time.Now())
+	require.NoError(t, err)
+
+	first, err := hub.subscribe(path)
+	require.NoError(t, err)
+	second, err := hub.subscribe(path)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return listener.started.Load() == 1 }, time.Second, time.Millisecond)
+
+	result, snapshot := data.NewFrame("result"), data.NewFrame("snapshot")
+	listener.updates <- fakeUpdate(result, snapshot)
+	require.Same(t, result, receiveFrame(t, first))
+	require.Same(t, result, receiveFrame(t, second))
+
+	// A late subscriber starts from the whole result
+	third, err := hub.subscribe(path)
+	require.NoError(t, err)
+	require.Same(t, snapshot, receiveFrame(t, third))
+
+	first.release()
+	first.release()
+	second.release()
+	require.Never(t, func() bool { return listener.stopped.Load() > 0 }, 20*time.Millisecond, time.Millisecond)
+
+	third.release()
+	require.Eventually(t, func() bool { return listener.stopped.Load() == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, int32(1), listener.started.Load())
+
+	// The query stays registered, the next subscriber starts a new listener
+	again, err := hub.subscribe(path)
+	require.NoError(t, err)
+	defer again.release()
+	require.Eventually(t, func() bool { return listener.started.Load() == 2 }, time.Second, time.Millisecond)
+}
+
+func TestStreamHubResyncsSlowSubscriber(t *testing.T) {
+	listener := newFakeListener()
+	hub := newStreamHub(listener.listen)
+	path, err := hub.register(&streamQuery{qm: FirestoreQuery{Query: "SELECT * FROM dialogs"}}, time.Now())
+	require.NoError(t, err)
+	subscription, err := hub.subscribe(path)
+	require.NoError(t, err)
+	defer subscription.release()
+
+	for i := 0; i < streamBuffer; i++ {
+		listener.updates <- fakeUpdate(data.NewFrame("result"), data.NewFrame("result"))
+	}
+	latest := data.NewFrame("latest")
+	listener.updates <- fakeUpdate(latest, latest)
+
+	// The pending results are replaced by the latest one
+	require.Eventually(t, func() bool { return len(subscription.frames) == 1 }, time.Second, time.Millisecond)
+	require.Same(t, latest, receiveFrame(t, subscription))
+}
+
+func TestStreamHubCatchesUpSlowTail(t *testing.T) {
+	listener := newFakeListener()
+	hub := newStreamHub(listener.listen)
+	path, err := hub.register(&streamQuery{qm: FirestoreQuery{Query: "SELECT * FROM events", Tail: true}, tail: &streamTail{field: "ts"}}, time.Now())
+	require.NoError(t, err)
+	subscription, err := hub.subscribe(path)
+	require.NoError(t, err)
+	defer subscription.release()
+
+	added := func(messages ...string) streamUpdate {
+		frame := data.NewFrame("events", data.NewField("message", nil, messages))
+		return streamUpdate{frame: frame, snapshot: func() *data.Frame { return data.NewFrame("snapshot") }, tail: true}
+	}
+	for i := 0; i < streamBuffer; i++ {
+		listener.updates <- added("line")
+	}
+	listener.updates <- added("last", "line")
+
+	// The pending rows are appended into one frame, none is sent twice or missed
+	require.Eventually(t, func() bool { return len(subscription.frames) == 1 }, time.Second, time.Millisecond)
+	frame := receiveFrame(t, subscription)
+	require.Equal(t, streamBuffer+2, frame.Rows())
+	require.Equal(t, "last", frame.Fields[0].At(streamBuffer))
+}
+
+func TestCatchUpFrame(t *testing.T) {
+	snapshot := data.NewFrame("snapshot")
+	update := streamUpdate{
+		frame:    data.NewFrame("events", data.NewField("message", nil, []string{"c"})),
+		snapshot: func() *data.Frame { return snapshot },
+		tail:     true,
+	}
+	pending := []*data.Frame{
+		data.NewFrame("events", data.NewField("message", nil, []string{"a", "b"})),
+	}
+	merged := catchUpFrame(pending, update)
+	require.Equal(t, []string{"a", "b", "c"}, []string{merged.Fields[0].At(0).(string), merged.Fields[0].At(1).(string), merged.Fields[0].At(2).(string)})
+
+	// Frames of other columns are a new result
+	pending = append(pending, data.NewFrame("events", data.NewField("level", nil, []string{"info"})))
+	require.Same(t, snapshot, catchUpFrame(pending, update))
+
+	// A stream sends its latest result
+	update.tail = false
+	require.Same(t, update.frame, catchUpFrame(pending, update))
+}
+
+func TestRowsFrame(t *testing.T) {
+	current := data.NewFrame("dialogs",
+		data.NewField("status", nil, []*string{ptr("open"), ptr("pending"), ptr("closed")}),
+		data.NewField("priority", nil, []*float64{ptr(1.0), nil, ptr(3.0)}),
+	)
+	current.RefID = "A"
+
+	frame := rowsFrame(current, []int{2, 1})
+	require.Equal(t, "A", frame.RefID)
+	require.Equal(t, 2, frame.Rows())
+	status, _ := frame.Fields[0].ConcreteAt(0)
+	require.Equal(t, "closed", status)
+	_, ok := frame.Fields[1].ConcreteAt(1)
+	require.False(t, ok)
+}
+
+func TestStreamHubListenerError(t *testing.T) {
+	listener := newFakeListener()
+	hub := newStreamHub(listener.listen)
+	path, err := hub.register(&streamQuery{qm: FirestoreQuery{Query: "SELECT * FROM dialogs"}}, time.Now())
+	require.NoError(t, err)
+
+	subscription, err := hub.subscribe(path)
+	require.NoError(t, err)
+	defer subscription.release()
+	listener.err <- errors.New("permission denied")
+
+	select {
+	case _, ok := <-subscription.frames:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("frames not closed")
+	}
+	require.EqualError(t, subscription.err(), "permission denied")
+}
+
+func TestStreamHubRegister(t *testing.T) {
+	hub := newStreamHub(newFakeListener().listen)
+	_, err := hub.subscribe("query/unknown")
+	require.ErrorIs(t, err, errStreamNotFound)
+
+	now := time.Now()
+	old, err := hub.register(&streamQuery{qm: FirestoreQuery{Query: "SELECT * FROM a"}}, now.Add(-2*streamQueryTTL))
+	require.NoError(t, err)
+	require.True(t, hub.registered(old))
+
+	// Queries without subscribers expire
+	recent, err := hub.register(&streamQuery{qm: FirestoreQuery{Query: "SELECT * FROM b"}}, now)
+	require.NoError(t, err)
+	require.False(t, hub.registered(old))
+	require.True(t, hub.registered(recent))
+
+	hub.close()
+	_, err = hub.subscribe(recent)
+	require.Error(t, err)
+}
+
+func TestStreamUnsupported(t *testing.T) {
+	tests := []struct {
+		name     string
+		qm       FirestoreQuery
+		expected string
+	}{
+		{name: "filters and ordering", qm: FirestoreQuery{Query: "SELECT * FROM dialogs WHERE status = 'open' ORDER BY created DESC LIMIT 10"}},
+		{name: "aggregates", qm: FirestoreQuery{Query: "SELECT status, COUNT(*) FROM dialogs GROUP BY status"}, expected: "GROUP BY and aggregates can't be streamed"},
+		{name: "FireQL only", qm: FirestoreQuery{Query: "SELECT * FROM dialogs WHERE status IN ('open')"}, expected: "IN is evaluated by FireQL"},
+		{name: "tail logs", qm: FirestoreQuery{Query: "SELECT * FROM events", Format: formatLogs, Tail: true}},
+		{name: "tail logs at a read time", qm: FirestoreQuery{Query: "SELECT * FROM events", Format: formatLogs, Tail: true, ReadTime: "2024-05-01T12:00:00Z"}, expected: "readTime and explain queries can't be streamed"},
+		{name: "tail logs explained", qm: FirestoreQuery{Query: "SELECT * FROM events", Format: formatLogs, Tail: true, Explain: true}, expected: "readTime and explain queries can't be streamed"},
+		{name: "logs", qm: FirestoreQuery{Query: "SELECT * FROM events", Format: formatLogs}, expected: "the logs format can't be streamed"},
+		{name: "histogram", qm: FirestoreQuery{Query: "SELECT * FROM dialogs", Format: formatHistogram}, expected: "the histogram format can't be streamed"},
+		{name: "metadata ordering", qm: FirestoreQuery{Query: "SELECT * FROM dialogs ORDER BY __updateTime__"}, expected: "ORDER BY __updateTime__ can't be streamed"},
+		{name: "wildcard", qm: FirestoreQuery{Query: "SELECT * FROM logs_*"}, expected: "wildcard collections can't be streamed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := nativeQueryInfo(tt.qm, backend.TimeRange{})
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, streamUnsupported(tt.qm, info))
+		})
+	}
+}
+
+func TestStreamFirestoreQueryTimeFilter(t *testing.T) {
+	client := fakeFirestoreClient(t)
+	from := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	timeRange := backend.TimeRange{From: from, To: from.Add(time.Hour)}
+	structuredQuery := func(qm FirestoreQuery) *firestorepb.StructuredQuery {
+		info, err := nativeQueryInfo(qm, timeRange)
+		require.NoError(t, err)
+		info.TimeRange = timeRange
+		serialized, err := streamFirestoreQuery(client, info, qm, nil).Serialize()
+		require.NoError(t, err)
+		var req firestorepb.RunQueryRequest
+		require.NoError(t, proto.Unmarshal(serialized, &req))
+		return req.GetStructuredQuery()
+	}
+
+	// The lower bound of the time range is kept, newest first
+	query := structuredQuery(FirestoreQuery{Query: "SELECT * FROM dialogs WHERE created >= $__from AND created <= $__to", MaxRows: 100})
+	filter := query.GetWhere().GetFieldFilter()
+	require.Equal(t, "created", filter.GetField().GetFieldPath())
+	require.Equal(t, firestorepb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL, filter.GetOp())
+	require.Equal(t, from, filter.GetValue().GetTimestampValue().AsTime())
+	require.Len(t, query.GetOrderBy(), 1)
+	require.Equal(t, "created", query.GetOrderBy()[0].GetField().GetFieldPath())
+	require.Equal(t, firestorepb.StructuredQuery_DESCENDING, query.GetOrderBy()[0].GetDirection())
+
+	// The ordering of the query is kept
+	query = structuredQuery(FirestoreQuery{Query: "SELECT * FROM dialogs WHERE created >= $__from AND created <= $__to ORDER BY created ASC", MaxRows: 100})
+	require.Equal(t, firestorepb.StructuredQuery_ASCENDING, query.GetOrderBy()[0].GetDirection())
+
+	// Panels with other time ranges stream other documents
+	qm := FirestoreQuery{Query: "SELECT * FROM dialogs WHERE created >= $__from AND created <= $__to", Stream: true}
+	earlier, err := streamPath(&streamQuery{qm: qm, info: &QueryInfo{TimeRange: timeRange}})
+	require.NoError(t, err)
+	later, err := streamPath(&streamQuery{qm: qm, info: &QueryInfo{TimeRange: backend.TimeRange{From: from.Add(time.Minute), To: from.Add(time.Hour)}}})
+	require.NoError(t, err)
+	require.NotEqual(t, earlier, later)
+}
+
+func TestSubscribeStream(t *testing.T) {
+	ds := Datasource{streams: newStreamHub(newFakeListener().listen)}
+	path, err := ds.streams.register(&streamQuery{qm: FirestoreQuery{Query: "SELECT * FROM dialogs"}}, time.Now())
+	require.NoError(t, err)
+
+	resp, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: path})
+	require.NoError(t, err)
+	require.Equal(t, backend.SubscribeStreamStatusOK, resp.Status)
+
+	resp, err = ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "query/unknown"})
+	require.NoError(t, err)
+	require.Equal(t, backend.SubscribeStreamStatusNotFound, resp.Status)
+
+	publish, err := ds.PublishStream(context.Background(), &backend.PublishStreamRequest{Path: path})
+	require.NoError(t, err)
+	require.Equal(t, backend.PublishStreamStatusPermissionDenied, publish.Status)
+}
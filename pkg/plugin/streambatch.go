@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultStreamMaxUpdatesPerSecond caps the updates of a stream when the
+// streamMaxUpdatesPerSecond setting isn't set
+const defaultStreamMaxUpdatesPerSecond = 10
+
+// validateStreamSettings checks the streaming settings, zero is the default
+func validateStreamSettings(settings *FirestoreSettings) error {
+	if settings.StreamMaxUpdatesPerSecond < 0 {
+		return fmt.Errorf("streamMaxUpdatesPerSecond must be positive, got %g", settings.StreamMaxUpdatesPerSecond)
+	}
+	if settings.StreamBatchWindowMs < 0 {
+		return fmt.Errorf("streamBatchWindowMs must be positive, got %d", settings.StreamBatchWindowMs)
+	}
+	return nil
+}
+
+// streamPendingChange is a document changed since the last update of a stream
+type streamPendingChange struct {
+	path string
+	kind string
+}
+
+// streamBatcher coalesces the document changes of a stream between its updates, so a
+// collection written hundreds of times a second sends a few updates holding the latest state
+// of each document instead of one per write. Changes wait for the batch window after the
+// first of them, and updates are at least an interval apart.
+type streamBatcher struct {
+	window   time.Duration
+	interval time.Duration
+
+	pending map[string]string
+	order   []string
+	first   time.Time
+	last    time.Time
+}
+
+// newStreamBatcher creates the batcher of the streamBatchWindowMs and
+// streamMaxUpdatesPerSecond settings
+func newStreamBatcher(settings *FirestoreSettings) *streamBatcher {
+	rate := settings.StreamMaxUpdatesPerSecond
+	if rate <= 0 {
+		rate = defaultStreamMaxUpdatesPerSecond
+	}
+	return &streamBatcher{
+		window:   time.Duration(settings.StreamBatchWindowMs) * time.Millisecond,
+		interval: time.Duration(float64(time.Second) / rate),
+		pending:  make(map[string]string),
+	}
+}
+
+// mergeChange returns the change of a document changed twice since the last update, empty
+// when the changes cancel out
+func mergeChange(previous, next string) string {
+	switch {
+	case previous == "":
+		return next
+	case previous == changeAdded && next == changeRemoved:
+		return ""
+	case previous == changeAdded:
+		return changeAdded
+	case previous == changeRemoved && next == changeAdded:
+		return changeModified
+	default:
+		return next
+	}
+}
+
+// add records the change of a document
+func (b *streamBatcher) add(path, kind string, now time.Time) {
+	previous, ok := b.pending[path]
+	merged := mergeChange(previous, kind)
+	if merged == "" {
+		delete(b.pending, path)
+		if len(b.pending) == 0 {
+			b.first = time.Time{}
+		}
+		return
+	}
+	if !ok {
+		b.order = append(b.order, path)
+	}
+	if b.first.IsZero() {
+		b.first = now
+	}
+	b.pending[path] = merged
+}
+
+// due returns when the pending changes are sent, zero without pending changes
+func (b *streamBatcher) due() time.Time {
+	if len(b.pending) == 0 {
+		return time.Time{}
+	}
+	due := b.first.Add(b.window)
+	if next := b.last.Add(b.interval); next.After(due) {
+		due = next
+	}
+	return due
+}
+
+// flush returns the pending changes in the order the documents first changed and starts a
+// new batch
+func (b *streamBatcher) flush(now time.Time) []streamPendingChange {
+	var changes []streamPendingChange
+	for _, path := range b.order {
+		if kind, ok := b.pending[path]; ok {
+			changes = append(changes, streamPendingChange{path: path, kind: kind})
+			delete(b.pending, path)
+		}
+	}
+	b.order = b.order[:0]
+	b.first = time.Time{}
+	b.last = now
+	return changes
+}
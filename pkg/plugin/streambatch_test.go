@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeChange(t *testing.T) {
+	tests := []struct {
+		previous, next, expected string
+	}{
+		{previous: "", next: changeModified, expected: changeModified},
+		{previous: changeAdded, next: changeModified, expected: changeAdded},
+		{previous: changeAdded, next: changeRemoved, expected: ""},
+		{previous: changeModified, next: changeModified, expected: changeModified},
+		{previous: changeModified, next: changeRemoved, expected: changeRemoved},
+		{previous: changeRemoved, next: changeAdded, expected: changeModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.previous+" "+tt.next, func(t *testing.T) {
+			require.Equal(t, tt.expected, mergeChange(tt.previous, tt.next))
+		})
+	}
+}
+
+func TestStreamBatcher(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	batcher := newStreamBatcher(&FirestoreSettings{StreamMaxUpdatesPerSecond: 2, StreamBatchWindowMs: 100})
+	batcher.flush(now)
+	require.True(t, batcher.due().IsZero())
+
+	batcher.add("dialogs/a", changeModified, now.Add(100*time.Millisecond))
+	batcher.add("dialogs/b", changeAdded, now.Add(200*time.Millisecond))
+	batcher.add("dialogs/a", changeRemoved, now.Add(300*time.Millisecond))
+	batcher.add("dialogs/c", changeAdded, now.Add(300*time.Millisecond))
+	batcher.add("dialogs/c", changeRemoved, now.Add(400*time.Millisecond))
+
+	// Half a second after the previous update, later than the batch window
+	require.Equal(t, now.Add(500*time.Millisecond), batcher.due())
+	require.Equal(t, []streamPendingChange{
+		{path: "dialogs/a", kind: changeRemoved},
+		{path: "dialogs/b", kind: changeAdded},
+	}, batcher.flush(now.Add(500*time.Millisecond)))
+	require.True(t, batcher.due().IsZero())
+
+	// The batch window after the first change, once the interval has passed
+	batcher.add("dialogs/b", changeModified, now.Add(2*time.Second))
+	require.Equal(t, now.Add(2100*time.Millisecond), batcher.due())
+}
+
+func TestNewStreamBatcherDefaults(t *testing.T) {
+	batcher := newStreamBatcher(&FirestoreSettings{})
+	require.Equal(t, 100*time.Millisecond, batcher.interval)
+	require.Zero(t, batcher.window)
+
+	require.Error(t, validateStreamSettings(&FirestoreSettings{StreamMaxUpdatesPerSecond: -1}))
+	require.Error(t, validateStreamSettings(&FirestoreSettings{StreamBatchWindowMs: -1}))
+	require.NoError(t, validateStreamSettings(&FirestoreSettings{StreamMaxUpdatesPerSecond: 0.5}))
+}
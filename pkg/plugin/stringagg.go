@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// stringAggPattern matches a string aggregate, STRING_AGG(DISTINCT code, ', ') or its MySQL
+// form GROUP_CONCAT(code SEPARATOR ', '), the separator defaulting to a comma
+var stringAggPattern = regexp.MustCompile(`(?i)^(?:STRING_AGG|GROUP_CONCAT)\s*\(\s*(DISTINCT\s+)?([^,()]+?)(?:(?:\s*,\s*|\s+SEPARATOR\s+)('(?:[^']|'')*'))?\s*\)$`)
+
+// stringAggCallPattern matches a string aggregate call in a query
+var stringAggCallPattern = regexp.MustCompile(`(?i)\b(STRING_AGG|GROUP_CONCAT)\s*\(`)
+
+// stringAggDistinctPattern matches the DISTINCT of a string aggregate, which unlike SELECT
+// DISTINCT the native route evaluates
+var stringAggDistinctPattern = regexp.MustCompile(`(?i)\b(STRING_AGG|GROUP_CONCAT)\s*\(\s*DISTINCT\b`)
+
+// defaultStringAggSeparator joins the values of a string aggregate without a separator
+const defaultStringAggSeparator = ","
+
+// parseStringAgg parses a SELECT expression like STRING_AGG(DISTINCT code, ', ') AS codes.
+// isStringAgg is false for other expressions.
+func parseStringAgg(field string) (aggregate AggregateInfo, isStringAgg bool, err error) {
+	expr := stripAlias(field)
+	if loc := stringAggCallPattern.FindStringIndex(expr); loc == nil || loc[0] != 0 {
+		return AggregateInfo{}, false, nil
+	}
+	match := stringAggPattern.FindStringSubmatch(expr)
+	if match == nil {
+		return AggregateInfo{}, true, fmt.Errorf("invalid string aggregate %s, expected STRING_AGG([DISTINCT] field, 'separator')", expr)
+	}
+
+	aggregate = AggregateInfo{
+		Function:  "STRING_AGG",
+		Field:     cleanBackticks(match[2]),
+		Alias:     field,
+		Distinct:  match[1] != "",
+		Separator: defaultStringAggSeparator,
+	}
+	if idx := strings.LastIndex(strings.ToUpper(field), " AS "); idx != -1 {
+		aggregate.Alias = strings.TrimSpace(field[idx+4:])
+	}
+	if match[3] != "" {
+		aggregate.Separator = strings.ReplaceAll(match[3][1:len(match[3])-1], "''", "'")
+	}
+	return aggregate, true, nil
+}
+
+// stringAggValue joins the values of a field over the documents of a group in their order,
+// each element of an array field on its own. DISTINCT keeps the first of equal values. A
+// group without values is null, as in SQL.
+func stringAggValue(aggField AggregateInfo, groupDocs []map[string]interface{}) interface{} {
+	var parts []string
+	seen := map[string]bool{}
+	add := func(value interface{}) {
+		if value == nil {
+			return
+		}
+		text := stringValue(value)
+		if aggField.Distinct {
+			if seen[text] {
+				return
+			}
+			seen[text] = true
+		}
+		parts = append(parts, text)
+	}
+	for _, doc := range groupDocs {
+		value := selectFieldValue(doc, aggField.Field)
+		if elements, ok := value.([]interface{}); ok {
+			for _, element := range elements {
+				add(element)
+			}
+			continue
+		}
+		add(value)
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return strings.Join(parts, aggField.Separator)
+}
@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStringAgg(t *testing.T) {
+	tests := []struct {
+		field       string
+		aggregate   AggregateInfo
+		isStringAgg bool
+		err         bool
+	}{
+		{"STRING_AGG(code, ', ') AS codes", AggregateInfo{Function: "STRING_AGG", Field: "code", Alias: "codes", Separator: ", "}, true, false},
+		{"string_agg(DISTINCT `error.code`, ' | ')", AggregateInfo{Function: "STRING_AGG", Field: "error.code", Alias: "string_agg(DISTINCT `error.code`, ' | ')", Distinct: true, Separator: " | "}, true, false},
+		{"GROUP_CONCAT(code)", AggregateInfo{Function: "STRING_AGG", Field: "code", Alias: "GROUP_CONCAT(code)", Separator: ","}, true, false},
+		{"GROUP_CONCAT(DISTINCT code SEPARATOR 'it''s') AS codes", AggregateInfo{Function: "STRING_AGG", Field: "code", Alias: "codes", Distinct: true, Separator: "it's"}, true, false},
+		{"STRING_AGG(code, ;)", AggregateInfo{}, true, true},
+		{"STRING_AGG(LOWER(code), ',')", AggregateInfo{}, true, true},
+		{"COUNT(*)", AggregateInfo{}, false, false},
+		{"my_string_agg(code)", AggregateInfo{}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			aggregate, isStringAgg, err := parseStringAgg(tt.field)
+			require.Equal(t, tt.isStringAgg, isStringAgg)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if isStringAgg {
+				require.Equal(t, tt.aggregate, aggregate)
+			}
+		})
+	}
+}
+
+func TestStringAggValue(t *testing.T) {
+	docs := []map[string]interface{}{
+		{"code": "E42"},
+		{"code": int64(500)},
+		{"code": nil},
+		{"code": "E42"},
+		{"code": []interface{}{"E7", "E42"}},
+		{"other": "x"},
+	}
+
+	tests := []struct {
+		name      string
+		aggregate AggregateInfo
+		docs      []map[string]interface{}
+		want      interface{}
+	}{
+		{"all values", AggregateInfo{Field: "code", Separator: ", "}, docs, "E42, 500, E42, E7, E42"},
+		{"distinct", AggregateInfo{Field: "code", Separator: ",", Distinct: true}, docs, "E42,500,E7"},
+		{"no values", AggregateInfo{Field: "code", Separator: ","}, docs[2:3], nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, stringAggValue(tt.aggregate, tt.docs))
+		})
+	}
+}
+
+func TestStringAggQuery(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"customer": "acme", "code": "E42"},
+		{"customer": "acme", "code": "E7"},
+		{"customer": "acme", "code": "E42"},
+		{"customer": "globex"},
+	}
+	info, err := parseSQLQueryWithVariables("SELECT customer, STRING_AGG(DISTINCT code, ', ') AS codes, COUNT(*) AS n FROM errors GROUP BY customer ORDER BY customer")
+	require.NoError(t, err)
+
+	response := (&Datasource{}).aggregateRows(context.Background(), rows, info, FirestoreQuery{})
+	require.NoError(t, response.Error)
+	frame := response.Frames[0]
+	require.Equal(t, "codes", frame.Fields[1].Name)
+	require.Equal(t, data.FieldTypeNullableString, frame.Fields[1].Type())
+	codes, ok := frame.Fields[1].ConcreteAt(0)
+	require.True(t, ok)
+	require.Equal(t, "E42, E7", codes)
+	_, ok = frame.Fields[1].ConcreteAt(1)
+	require.False(t, ok)
+
+	_, err = parseSQLQueryWithVariables("SELECT date_trunc('hour', ts), STRING_AGG(code, ',') AS codes, MOVING_AVG(codes, 3) FROM errors GROUP BY date_trunc('hour', ts)")
+	require.ErrorContains(t, err, "codes is not a numeric aggregate")
+}
@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// defaultSubqueryAlias names a subquery without an alias in traces
+const defaultSubqueryAlias = "subquery"
+
+// subqueryPattern matches the start of a subquery in the FROM clause
+var subqueryPattern = regexp.MustCompile(`(?i)\bFROM\s*\(`)
+
+// subqueryAliasPattern matches the alias following a subquery
+var subqueryAliasPattern = regexp.MustCompile(`(?i)^\s+(AS\s+)?([\p{L}_][\p{L}\p{N}_]*)`)
+
+// subqueryClauses are the keywords that may follow a subquery without an alias
+var subqueryClauses = map[string]bool{
+	"WHERE": true,
+	"GROUP": true,
+	"ORDER": true,
+	"LIMIT": true,
+	"JOIN":  true,
+	"INNER": true,
+	"LEFT":  true,
+}
+
+// parseSubquery parses the subquery a query reads FROM and returns the outer query reading
+// FROM the subquery's alias, parsed as usual for the columns and clauses. Queries reading a
+// collection are returned as they are with a nil subquery.
+func parseSubquery(query string) (*QueryInfo, string, error) {
+	loc := subqueryPattern.FindStringIndex(query)
+	if loc == nil {
+		return nil, query, nil
+	}
+	open := loc[1] - 1
+	end := closingParen(query, open)
+	if end == -1 {
+		return nil, "", fmt.Errorf("subquery: missing closing parenthesis")
+	}
+
+	inner := strings.TrimSpace(query[open+1 : end])
+	if fields := strings.Fields(inner); len(fields) == 0 || !strings.EqualFold(fields[0], "SELECT") {
+		return nil, "", fmt.Errorf("subquery: FROM (...) must hold a SELECT query")
+	}
+	info, err := parseSQLQueryWithVariables(inner)
+	if err != nil {
+		return nil, "", fmt.Errorf("subquery: %w", err)
+	}
+
+	alias, after := defaultSubqueryAlias, query[end+1:]
+	if match := subqueryAliasPattern.FindStringSubmatch(after); match != nil && (match[1] != "" || !subqueryClauses[strings.ToUpper(match[2])]) {
+		alias, after = match[2], after[len(match[0]):]
+	}
+	return info, query[:loc[0]] + "FROM " + alias + after, nil
+}
+
+// closingParen returns the index of the parenthesis closing the one at open, skipping
+// string literals, or -1 when it isn't closed
+func closingParen(query string, open int) int {
+	depth := 0
+	var quote byte
+	for i := open; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// executeSubquery runs the subquery of a query into an in-memory table and evaluates the
+// outer query over its rows, so the outer query can aggregate aggregates or filter on them.
+// The subquery isn't capped by maxRows, the memory budget bounds the table.
+func (d *Datasource) executeSubquery(ctx context.Context, pCtx backend.PluginContext, settings *FirestoreSettings, qm FirestoreQuery, queryInfo *QueryInfo, timeRange backend.TimeRange, meta *queryMeta) backend.DataResponse {
+	switch {
+	case qm.Explain:
+		return backend.ErrDataResponse(backend.StatusBadRequest, "explain is not supported for subqueries")
+	case qm.Format == formatLogs || distributionFormat(qm.Format):
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("the %s format is not supported for subqueries", qm.Format))
+	}
+	location, err := settings.location()
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+
+	inner := qm
+	inner.Format = formatTable
+	inner.Fill = ""
+	inner.MaxRows = 0
+	inner.Lookup = nil
+	response := d.executeNativeQuery(ctx, pCtx, settings, inner, queryInfo.Subquery, timeRange, meta)
+	if response.Error != nil {
+		return response
+	}
+
+	queryInfo.TimeFormat = qm.TimeFormat
+	queryInfo.Location = location
+	queryInfo.GeoFormat = qm.GeoFormat
+	queryInfo.RefFormat = qm.RefFormat
+	queryInfo.MemoryBudget = newMemoryBudget(settings.MemoryBudgetMB)
+	for _, condition := range queryInfo.IgnoredConditions {
+		meta.addNotice(data.NoticeSeverityWarning, fmt.Sprintf("WHERE condition %q is not supported and was ignored, results may include unfiltered rows", condition))
+	}
+
+	var columns []string
+	var rows []map[string]interface{}
+	seen := make(map[string]bool)
+	for _, frame := range response.Frames {
+		frameColumns, frameData := frameRows(frame)
+		for _, column := range frameColumns {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+		for _, row := range frameData {
+			if err := queryInfo.MemoryBudget.add(row); err != nil {
+				return budgetExceededResponse(err)
+			}
+		}
+		rows = append(rows, frameData...)
+		if frame.Meta != nil {
+			for _, notice := range frame.Meta.Notices {
+				meta.addNotice(notice.Severity, notice.Text)
+			}
+		}
+	}
+
+	inMemory := []string{fmt.Sprintf("from(%s)", queryInfo.Collection)}
+	if queryInfo.TimeField != "" {
+		queryInfo.TimeRange = timeRange
+		rows = filterTimeRange(rows, queryInfo)
+		inMemory = append(inMemory,
+			fmt.Sprintf("where(%s >= %s)", queryInfo.TimeField, describeTimeValue(timeRange.From)),
+			fmt.Sprintf("where(%s <= %s)", queryInfo.TimeField, describeTimeValue(timeRange.To)))
+	}
+	inMemory = append(inMemory, rowsTrace(queryInfo)...)
+	meta.executedQuery += "\n-- outer query in memory: " + strings.Join(inMemory, ".")
+
+	d.debugLog(ctx, "Subquery complete", "alias", queryInfo.Collection, "rows", len(rows))
+	return d.evalRows(ctx, rows, func([]map[string]interface{}) []string { return columns }, false, queryInfo, qm, meta)
+}
+
+// filterTimeRange keeps the rows whose time field is within the query's time range
+func filterTimeRange(rows []map[string]interface{}, queryInfo *QueryInfo) []map[string]interface{} {
+	kept := rows[:0]
+	for _, row := range rows {
+		ts, ok := toTime(selectFieldValue(row, queryInfo.TimeField), queryInfo.timeFormatOf(queryInfo.TimeField), queryInfo.Location)
+		if ok && !ts.Before(queryInfo.TimeRange.From) && !ts.After(queryInfo.TimeRange.To) {
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSubquery(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		collection string
+		rest       string
+	}{
+		{
+			"alias",
+			"SELECT brand, AVG(total) AS avgTotal FROM (SELECT brand, SUM(total) AS total FROM orders GROUP BY brand, customerId) t GROUP BY brand",
+			"orders",
+			"SELECT brand, AVG(total) AS avgTotal FROM t GROUP BY brand",
+		},
+		{
+			"as alias",
+			"select * from (select * from orders where note = 'a)b') as paid where total > 10",
+			"orders",
+			"select * FROM paid where total > 10",
+		},
+		{
+			"no alias",
+			"SELECT COUNT(*) AS n FROM (SELECT customerId, COUNT(*) AS orders FROM orders GROUP BY customerId) WHERE orders > 5",
+			"orders",
+			"SELECT COUNT(*) AS n FROM subquery WHERE orders > 5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, rest, err := parseSubquery(tt.query)
+			require.NoError(t, err)
+			require.Equal(t, tt.collection, info.Collection)
+			require.Equal(t, tt.rest, rest)
+		})
+	}
+
+	info, rest, err := parseSubquery("SELECT * FROM orders WHERE note = '(from)'")
+	require.NoError(t, err)
+	require.Nil(t, info)
+	require.Equal(t, "SELECT * FROM orders WHERE note = '(from)'", rest)
+
+	for _, query := range []string{
+		"SELECT * FROM (SELECT * FROM orders",
+		"SELECT * FROM (orders)",
+		"SELECT * FROM (SELECT FROM)",
+	} {
+		_, _, err := parseSubquery(query)
+		require.Error(t, err, query)
+	}
+}
+
+func TestParseSubqueryQuery(t *testing.T) {
+	info, err := nativeQueryInfo(FirestoreQuery{Query: "SELECT brand, AVG(total) AS avgTotal FROM (SELECT brand, ts, total FROM orders WHERE ts >= $__from AND ts <= $__to) o WHERE ts >= $__from AND ts <= $__to GROUP BY brand"}, backend.TimeRange{})
+	require.NoError(t, err)
+	require.Equal(t, "o", info.Collection)
+	require.Equal(t, []string{"brand"}, info.GroupByFields)
+	require.Equal(t, "orders", info.Subquery.Collection)
+	require.Empty(t, info.TimeField)
+	require.Empty(t, info.Subquery.TimeField)
+
+	_, err = parseSQLQueryWithVariables("SELECT * FROM (SELECT * FROM orders) o JOIN customers c ON o.customerId = c.__name__")
+	require.ErrorContains(t, err, "can't be joined")
+}
+
+func TestFilterTimeRange(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := []map[string]interface{}{
+		{"ts": from.Add(-time.Second)},
+		{"ts": from},
+		{"ts": from.Add(time.Hour)},
+		{"ts": "not a time"},
+		{"total": 1.0},
+	}
+	info := &QueryInfo{TimeField: "ts", TimeRange: backend.TimeRange{From: from, To: from.Add(time.Minute)}}
+	require.Equal(t, []map[string]interface{}{{"ts": from}}, filterTimeRange(rows, info))
+}
@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// rowsTrace describes the clauses evalRows evaluates in memory, for ExecutedQueryString
+func rowsTrace(queryInfo *QueryInfo) []string {
+	var trace []string
+	for _, filter := range queryInfo.AdditionalFilters {
+		trace = append(trace, fmt.Sprintf("where(%s %s %v)", filter.Field, filter.Operator, filter.Value))
+	}
+	if len(queryInfo.GroupByFields) > 0 || len(queryInfo.AggregateFields) > 0 {
+		trace = append(trace, fmt.Sprintf("groupBy(%s)", strings.Join(queryInfo.GroupByFields, ", ")))
+	}
+	for _, rank := range queryInfo.Ranks {
+		trace = append(trace, rank.String())
+	}
+	for _, expression := range queryInfo.Expressions {
+		trace = append(trace, expression.String())
+	}
+	for _, key := range queryInfo.OrderBy {
+		trace = append(trace, fmt.Sprintf("orderBy(%s %s)", key.Field, key.direction()))
+	}
+	if queryInfo.Limit > 0 {
+		trace = append(trace, fmt.Sprintf("limit(%d)", queryInfo.Limit))
+	}
+	return trace
+}
+
+// evalRows evaluates the WHERE, GROUP BY, ORDER BY and LIMIT clauses of a query over an
+// in-memory table, the rows of a join or a subquery. SELECT * selects the columns returned
+// for the rows kept; expand reports that the table's maps were flattened or it held GeoPoints.
+func (d *Datasource) evalRows(ctx context.Context, rows []map[string]interface{}, columns func([]map[string]interface{}) []string, expand bool, queryInfo *QueryInfo, qm FirestoreQuery, meta *queryMeta) backend.DataResponse {
+	rows = filterRows(rows, queryInfo.AdditionalFilters)
+	if len(queryInfo.GroupByFields) > 0 || len(queryInfo.AggregateFields) > 0 {
+		setPanelTimeBucket(queryInfo, qm)
+		if len(rows) == 0 {
+			return emptyGroupByResponse(queryInfo)
+		}
+		return d.aggregateRows(ctx, rows, queryInfo, qm)
+	}
+	for _, row := range rows {
+		applyExpressions(row, queryInfo.Expressions)
+	}
+	return selectRows(rows, columns, expand, queryInfo, qm, meta)
+}
+
+// selectRows numbers, orders and limits the filtered rows of an in-memory table and builds
+// the frame of the selected columns, * standing for the columns returned for the rows kept
+// besides those selected explicitly
+func selectRows(rows []map[string]interface{}, columns func([]map[string]interface{}) []string, expand bool, queryInfo *QueryInfo, qm FirestoreQuery, meta *queryMeta) backend.DataResponse {
+	applyRanks(rows, queryInfo.Ranks)
+	sortRows(rows, queryInfo.OrderBy)
+	if queryInfo.Limit > 0 && len(rows) > queryInfo.Limit {
+		rows = rows[:queryInfo.Limit]
+	}
+	rows = rows[:meta.applyMaxRows(len(rows), qm.MaxRows)]
+
+	if slices.Contains(queryInfo.Fields, "*") {
+		queryInfo.Fields = expandStar(queryInfo.Fields, columns(rows))
+	}
+	return rowsResponse(rows, queryInfo, expand)
+}
+
+// expandStar replaces * in the selected fields with the columns not selected explicitly
+func expandStar(fields, columns []string) []string {
+	var expanded []string
+	for _, field := range fields {
+		if field != "*" {
+			expanded = append(expanded, field)
+			continue
+		}
+		for _, column := range columns {
+			if !slices.Contains(fields, column) {
+				expanded = append(expanded, column)
+			}
+		}
+	}
+	return expanded
+}
+
+// frameRows reads the rows of a frame into an in-memory table, its column names and a map
+// of the column values for each row, nulls left out
+func frameRows(frame *data.Frame) ([]string, []map[string]interface{}) {
+	columns := make([]string, len(frame.Fields))
+	for i, field := range frame.Fields {
+		columns[i] = field.Name
+	}
+	rows := make([]map[string]interface{}, frame.Rows())
+	for row := range rows {
+		rows[row] = make(map[string]interface{}, len(frame.Fields))
+		for _, field := range frame.Fields {
+			if value, ok := field.ConcreteAt(row); ok {
+				rows[row][field.Name] = value
+			}
+		}
+	}
+	return columns, rows
+}
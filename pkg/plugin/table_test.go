@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameRows(t *testing.T) {
+	frame := data.NewFrame("response",
+		data.NewField("brand", nil, []string{"acme", "globex"}),
+		data.NewField("total", nil, []*float64{ptr(12.5), nil}),
+	)
+	columns, rows := frameRows(frame)
+	require.Equal(t, []string{"brand", "total"}, columns)
+	require.Equal(t, []map[string]interface{}{
+		{"brand": "acme", "total": 12.5},
+		{"brand": "globex"},
+	}, rows)
+}
+
+func TestRowsTrace(t *testing.T) {
+	info := &QueryInfo{
+		AdditionalFilters: []FilterInfo{{Field: "total", Operator: ">", Value: 10}},
+		GroupByFields:     []string{"brand"},
+		OrderBy:           []OrderKey{{Field: "brand", Descending: true}},
+		Limit:             5,
+	}
+	require.Equal(t, []string{"where(total > 10)", "groupBy(brand)", "orderBy(brand DESC)", "limit(5)"}, rowsTrace(info))
+	require.Empty(t, rowsTrace(&QueryInfo{}))
+}
+
+func TestExpandStar(t *testing.T) {
+	require.Equal(t, []string{"brand", "total", "rn"}, expandStar([]string{"*", "rn"}, []string{"brand", "rn", "total"}))
+	require.Equal(t, []string{"total", "brand"}, expandStar([]string{"total", "*"}, []string{"brand", "total"}))
+}
@@ -0,0 +1,255 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// templatesMaxCount caps the templates listed
+	templatesMaxCount = 500
+	// templateMaxDescription caps the length of a template description
+	templateMaxDescription = 1000
+)
+
+// templateNamePattern matches template names, which are the IDs of their documents
+var templateNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,99}$`)
+
+// errTemplatesDisabled is returned by the template routes when templatesCollection isn't set
+var errTemplatesDisabled = errors.New("query templates are disabled, set the templatesCollection setting")
+
+// queryTemplate is a named query shared by the users of a datasource, stored as a document of
+// the templatesCollection named after the template
+type queryTemplate struct {
+	Name        string    `json:"name" firestore:"name"`
+	Description string    `json:"description,omitempty" firestore:"description,omitempty"`
+	Query       string    `json:"query" firestore:"query"`
+	Format      string    `json:"format,omitempty" firestore:"format,omitempty"`
+	UpdatedBy   string    `json:"updatedBy,omitempty" firestore:"updatedBy,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt" firestore:"updatedAt"`
+}
+
+// validateTemplate checks a template before it is saved. The query must be read-only, and may
+// hold dashboard variables and :name parameters, so it isn't parsed.
+func validateTemplate(template queryTemplate) error {
+	switch template.Format {
+	case "", formatTable, formatTimeSeries, formatLogs, formatHistogram, formatHeatmap:
+	default:
+		return fmt.Errorf("invalid format %q", template.Format)
+	}
+	if len(template.Description) > templateMaxDescription {
+		return fmt.Errorf("description is longer than %d characters", templateMaxDescription)
+	}
+	if strings.TrimSpace(template.Query) == "" {
+		return errors.New("query is required")
+	}
+	return validateReadOnly(template.Query)
+}
+
+// canEditTemplates reports whether a Grafana user may save and delete templates: editors and
+// admins of the organization
+func canEditTemplates(user *backend.User) bool {
+	return user != nil && (user.Role == "Editor" || user.Role == "Admin")
+}
+
+// templateName returns the template name of a request path, or writes an error
+func templateName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := r.PathValue("name")
+	if !templateNamePattern.MatchString(name) {
+		writeResourceError(w, http.StatusBadRequest, fmt.Sprintf("invalid template name %q", name))
+		return "", false
+	}
+	return name, true
+}
+
+// templatesCollection returns the collection holding the templates, or writes an error when
+// templates are disabled
+func (d *Datasource) templatesCollection(w http.ResponseWriter) (string, bool) {
+	if d.templates == "" {
+		writeResourceError(w, http.StatusNotFound, errTemplatesDisabled.Error())
+		return "", false
+	}
+	return d.templates, true
+}
+
+// handleListTemplates returns the templates sorted by name: GET /templates
+func (d *Datasource) handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	collection, ok := d.templatesCollection(w)
+	if !ok {
+		return
+	}
+	templates, err := d.listTemplates(r.Context(), collection)
+	if err != nil {
+		writeResourceError(w, httpStatusOf(err), err.Error())
+		return
+	}
+	writeResourceJSON(w, templates)
+}
+
+// handleGetTemplate returns a template: GET /templates/{name}
+func (d *Datasource) handleGetTemplate(w http.ResponseWriter, r *http.Request) {
+	collection, ok := d.templatesCollection(w)
+	if !ok {
+		return
+	}
+	name, ok := templateName(w, r)
+	if !ok {
+		return
+	}
+	template, err := d.getTemplate(r.Context(), collection, name)
+	switch {
+	case status.Code(err) == codes.NotFound:
+		writeResourceError(w, http.StatusNotFound, fmt.Sprintf("template %s not found", name))
+	case err != nil:
+		writeResourceError(w, httpStatusOf(err), err.Error())
+	default:
+		writeResourceJSON(w, template)
+	}
+}
+
+// handleSaveTemplate creates or replaces a template from a JSON body with its query,
+// description and format: PUT /templates/{name}
+func (d *Datasource) handleSaveTemplate(w http.ResponseWriter, r *http.Request) {
+	collection, ok := d.templatesCollection(w)
+	if !ok {
+		return
+	}
+	name, ok := templateName(w, r)
+	if !ok {
+		return
+	}
+	user := backend.UserFromContext(r.Context())
+	if !canEditTemplates(user) {
+		writeResourceError(w, http.StatusForbidden, "only editors and admins can save templates")
+		return
+	}
+
+	var template queryTemplate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&template); err != nil {
+		writeResourceError(w, http.StatusBadRequest, "invalid template: "+err.Error())
+		return
+	}
+	if err := validateTemplate(template); err != nil {
+		writeResourceError(w, http.StatusBadRequest, "invalid template: "+err.Error())
+		return
+	}
+	template.Name = name
+	template.UpdatedBy = user.Login
+	template.UpdatedAt = time.Now().UTC()
+
+	if err := d.saveTemplate(r.Context(), collection, template); err != nil {
+		writeResourceError(w, httpStatusOf(err), err.Error())
+		return
+	}
+	writeResourceJSON(w, template)
+}
+
+// handleDeleteTemplate deletes a template: DELETE /templates/{name}
+func (d *Datasource) handleDeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	collection, ok := d.templatesCollection(w)
+	if !ok {
+		return
+	}
+	name, ok := templateName(w, r)
+	if !ok {
+		return
+	}
+	if !canEditTemplates(backend.UserFromContext(r.Context())) {
+		writeResourceError(w, http.StatusForbidden, "only editors and admins can delete templates")
+		return
+	}
+	if err := d.deleteTemplate(r.Context(), collection, name); err != nil {
+		writeResourceError(w, httpStatusOf(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *Datasource) templatesClient(ctx context.Context) (*firestore.Client, error) {
+	return newFirestoreClient(ctx, backend.PluginContext{DataSourceInstanceSettings: &d.settings})
+}
+
+func (d *Datasource) listTemplates(ctx context.Context, collection string) ([]queryTemplate, error) {
+	client, err := d.templatesClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	query := client.Collection(collection).OrderBy(firestore.DocumentID, firestore.Asc).Limit(templatesMaxCount)
+	docs, err := d.getAllDocuments(ctx, "list templates", query)
+	if err != nil {
+		return nil, err
+	}
+	templates := make([]queryTemplate, 0, len(docs))
+	for _, doc := range docs {
+		var template queryTemplate
+		if err := doc.DataTo(&template); err != nil {
+			d.logger(ctx).Warn("Skipping invalid template", "template", doc.Ref.ID, "error", err)
+			continue
+		}
+		template.Name = doc.Ref.ID
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+func (d *Datasource) getTemplate(ctx context.Context, collection, name string) (queryTemplate, error) {
+	var template queryTemplate
+	client, err := d.templatesClient(ctx)
+	if err != nil {
+		return template, err
+	}
+	defer client.Close()
+
+	var doc *firestore.DocumentSnapshot
+	err = d.withRetries(ctx, "get template", func() (err error) {
+		doc, err = client.Collection(collection).Doc(name).Get(ctx)
+		return err
+	})
+	if err != nil {
+		return template, err
+	}
+	if err := doc.DataTo(&template); err != nil {
+		return template, err
+	}
+	template.Name = name
+	return template, nil
+}
+
+func (d *Datasource) saveTemplate(ctx context.Context, collection string, template queryTemplate) error {
+	client, err := d.templatesClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return d.withRetries(ctx, "save template", func() error {
+		_, err := client.Collection(collection).Doc(template.Name).Set(ctx, template)
+		return err
+	})
+}
+
+func (d *Datasource) deleteTemplate(ctx context.Context, collection, name string) error {
+	client, err := d.templatesClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return d.withRetries(ctx, "delete template", func() error {
+		_, err := client.Collection(collection).Doc(name).Delete(ctx)
+		return err
+	})
+}
@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template queryTemplate
+		valid    bool
+	}{
+		{"query", queryTemplate{Query: "SELECT brand FROM dialogs WHERE brand = :brand"}, true},
+		{"format", queryTemplate{Query: "SELECT count(*) FROM dialogs GROUP BY date_trunc('hour', openTS)", Format: formatTimeSeries}, true},
+		{"variables", queryTemplate{Query: "SELECT * FROM dialogs WHERE brand = '$brand'", Description: "Dialogs of a brand"}, true},
+		{"no query", queryTemplate{Query: " "}, false},
+		{"write", queryTemplate{Query: "DELETE FROM dialogs"}, false},
+		{"invalid format", queryTemplate{Query: "SELECT * FROM dialogs", Format: "csv"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTemplate(tt.template)
+			if tt.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestCanEditTemplates(t *testing.T) {
+	require.False(t, canEditTemplates(nil))
+	require.False(t, canEditTemplates(&backend.User{Login: "viewer", Role: "Viewer"}))
+	require.True(t, canEditTemplates(&backend.User{Login: "editor", Role: "Editor"}))
+	require.True(t, canEditTemplates(&backend.User{Login: "admin", Role: "Admin"}))
+}
+
+func TestTemplatesResource(t *testing.T) {
+	d := &Datasource{}
+	d.resourceHandler = newResourceHandler(d)
+
+	var response *backend.CallResourceResponse
+	sender := backend.CallResourceResponseSenderFunc(func(res *backend.CallResourceResponse) error {
+		response = res
+		return nil
+	})
+	call := func(method, path, role string, body string) int {
+		req := &backend.CallResourceRequest{Method: method, Path: path, URL: path, Body: []byte(body)}
+		if role != "" {
+			req.PluginContext.User = &backend.User{Login: "user", Role: role}
+		}
+		require.NoError(t, d.CallResource(context.Background(), req, sender))
+		return response.Status
+	}
+
+	// Disabled without a templates collection
+	require.Equal(t, http.StatusNotFound, call(http.MethodGet, "templates", "", ""))
+
+	d.templates = "templates"
+	require.Equal(t, http.StatusBadRequest, call(http.MethodGet, "templates/bad%20name", "", ""))
+	require.Equal(t, http.StatusForbidden, call(http.MethodPut, "templates/dialogs", "Viewer", `{"query": "SELECT * FROM dialogs"}`))
+	require.Equal(t, http.StatusBadRequest, call(http.MethodPut, "templates/dialogs", "Editor", `{"query": "DELETE FROM dialogs"}`))
+	require.Equal(t, http.StatusBadRequest, call(http.MethodPut, "templates/dialogs", "Editor", `{"query": `))
+	require.Equal(t, http.StatusForbidden, call(http.MethodDelete, "templates/dialogs", "", ""))
+}
@@ -0,0 +1,164 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// validateCollectionTimeFields checks the collectionTimeFields setting, which maps collection
+// paths or IDs to their time field
+func validateCollectionTimeFields(timeFields map[string]string) error {
+	for collection, field := range timeFields {
+		switch {
+		case !collectionPathPattern.MatchString(collection) || isCollectionPattern(collection):
+			return fmt.Errorf("collectionTimeFields: invalid collection %q", collection)
+		case !fieldPathPattern.MatchString(field):
+			return fmt.Errorf("collectionTimeFields: invalid time field %q of collection %s", field, collection)
+		}
+	}
+	return nil
+}
+
+// collectionTimeField returns the time field configured for a collection, matched on its path
+// and then on its ID, so "events" also applies to customers/ACME/events. Empty when none is.
+func (s *FirestoreSettings) collectionTimeField(collection string) string {
+	collection = strings.Trim(cleanBackticks(collection), "/")
+	if collection == "" {
+		return ""
+	}
+	if field, ok := s.CollectionTimeFields[collection]; ok {
+		return field
+	}
+	return s.CollectionTimeFields[collection[strings.LastIndex(collection, "/")+1:]]
+}
+
+// applyCollectionTimeField filters a query without a time field by the time field configured
+// for its collection, when the panel has a time range. It returns the applied field, empty
+// when none was.
+func applyCollectionTimeField(info *QueryInfo, settings *FirestoreSettings, timeRange backend.TimeRange) string {
+	source := timeFieldSource(info, timeRange)
+	if source == nil {
+		return ""
+	}
+	source.TimeField = settings.collectionTimeField(source.Collection)
+	return source.TimeField
+}
+
+// timeFieldSource returns the query that reads the collection of a query without a time field,
+// nil when the query has one or the panel has no time range. A subquery is filtered where it
+// reads the collection, and joins keep the time field of their ON clause.
+func timeFieldSource(info *QueryInfo, timeRange backend.TimeRange) *QueryInfo {
+	if info == nil || info.Join != nil || timeRange.From.IsZero() || timeRange.To.IsZero() {
+		return nil
+	}
+	source := info
+	for query := info; query != nil; query = query.Subquery {
+		if query.TimeField != "" || query.Join != nil {
+			return nil
+		}
+		source = query
+	}
+	return source
+}
+
+// timeFieldNames are the names of time fields in the order they are picked from a schema
+var timeFieldNames = []string{"timestamp", "ts", "time", "eventtime", "createdat", "created_at", "createtime", "date", "updatedat", "updated_at"}
+
+// timeFieldSuffixes mark the names of likely time fields, such as openTS or sentAt
+var timeFieldSuffixes = []string{"ts", "time", "timestamp", "at", "date"}
+
+// needsTimeField reports whether a query's output needs a time field: time series, logs,
+// heatmaps and counters. A GROUP BY of a time bucket has its own.
+func needsTimeField(info *QueryInfo, qm FirestoreQuery) bool {
+	if info.TimeBucketField != "" || qm.TimeField != "" {
+		return false
+	}
+	switch qm.Format {
+	case formatTimeSeries, formatLogs, formatHeatmap:
+		return true
+	}
+	return hasCounterFunctions(info)
+}
+
+// likelyTimeField picks the time field of a schema and returns how it is stored: common names
+// first, then names ending like a time field, top-level fields before nested ones. Fields
+// stored as the time format of the query come first, then date strings. Empty when the
+// schema has none.
+func likelyTimeField(schema *collectionSchema, timeFormat string) (string, string) {
+	switch timeFormat {
+	case "", timeFormatTimestamp:
+		if field := rankTimeFields(schema, kindTime); field != "" {
+			return field, timeFormat
+		}
+	case timeFormatRFC3339:
+	default:
+		// Numbers can't be told apart from epoch times
+		return "", ""
+	}
+	if field := rankTimeFields(schema, kindString); field != "" {
+		return field, timeFormatRFC3339
+	}
+	return "", ""
+}
+
+// rankTimeFields returns the most likely time field of a schema among those of a kind
+func rankTimeFields(schema *collectionSchema, kind valueKind) string {
+	rank := func(field schemaField) int {
+		name := strings.ToLower(field.Name[strings.LastIndex(field.Name, ".")+1:])
+		score := len(timeFieldNames) + 1
+		for i, common := range timeFieldNames {
+			if name == common {
+				score = i
+				break
+			}
+		}
+		if score > len(timeFieldNames) {
+			for _, suffix := range timeFieldSuffixes {
+				if strings.HasSuffix(name, suffix) {
+					score = len(timeFieldNames)
+					break
+				}
+			}
+		}
+		if field.Nested {
+			score += len(timeFieldNames) + 2
+		}
+		return score
+	}
+
+	best, bestRank := "", 0
+	for _, name := range schema.TimeFields {
+		field, ok := schema.field(name)
+		if !ok || field.kind != kind {
+			continue
+		}
+		// TimeFields are sorted, so ties keep the first name
+		if r := rank(field); best == "" || r < bestRank {
+			best, bestRank = name, r
+		}
+	}
+	return best
+}
+
+// detectTimeField filters a query that needs a time field and has none by the likely time
+// field of its collection's cached schema. It returns the detected field, empty when none was.
+func (d *Datasource) detectTimeField(ctx context.Context, info *QueryInfo, qm FirestoreQuery, timeRange backend.TimeRange) string {
+	source := timeFieldSource(info, timeRange)
+	if source == nil || d.schemas == nil || !needsTimeField(info, qm) || isCollectionPattern(source.Collection) {
+		return ""
+	}
+	schema, err := d.schemas.get(ctx, source.Collection)
+	if err != nil {
+		d.logger(ctx).Warn("Time field detection failed", "collection", source.Collection, "error", err)
+		return ""
+	}
+	field, format := likelyTimeField(schema, qm.TimeFormat)
+	source.TimeField = field
+	if format != qm.TimeFormat {
+		source.TimeFieldFormat = format
+	}
+	return field
+}
@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCollectionTimeFields(t *testing.T) {
+	require.NoError(t, validateCollectionTimeFields(nil))
+	require.NoError(t, validateCollectionTimeFields(map[string]string{"events": "ts", "customers/ACME/orders": "audit.createdAt"}))
+	require.Error(t, validateCollectionTimeFields(map[string]string{"logs_*": "ts"}))
+	require.Error(t, validateCollectionTimeFields(map[string]string{"events": "ts desc"}))
+}
+
+func TestCollectionTimeField(t *testing.T) {
+	settings := &FirestoreSettings{CollectionTimeFields: map[string]string{"events": "ts", "customers/ACME/orders": "createdAt"}}
+	require.Equal(t, "ts", settings.collectionTimeField("events"))
+	require.Equal(t, "ts", settings.collectionTimeField("`events`"))
+	require.Equal(t, "ts", settings.collectionTimeField("customers/ACME/events"))
+	require.Equal(t, "createdAt", settings.collectionTimeField("customers/ACME/orders"))
+	require.Empty(t, settings.collectionTimeField("customers/OTHER/orders"))
+	require.Empty(t, settings.collectionTimeField("users"))
+	require.Empty(t, (&FirestoreSettings{}).collectionTimeField("events"))
+}
+
+func TestPlanQueryCollectionTimeField(t *testing.T) {
+	settings := &FirestoreSettings{CollectionTimeFields: map[string]string{"events": "ts", "orders": "createdAt"}}
+	timeRange := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+	tests := []struct {
+		name      string
+		qm        FirestoreQuery
+		timeRange backend.TimeRange
+		timeField string
+	}{
+		{"applied", FirestoreQuery{Query: "SELECT * FROM events WHERE status = 'open'"}, timeRange, "ts"},
+		{"own time field", FirestoreQuery{Query: "SELECT * FROM events WHERE openTS >= $__from AND openTS <= $__to"}, timeRange, ""},
+		{"no time range", FirestoreQuery{Query: "SELECT * FROM events"}, backend.TimeRange{}, ""},
+		{"not configured", FirestoreQuery{Query: "SELECT * FROM users"}, timeRange, ""},
+		{"builder", FirestoreQuery{Builder: &BuilderQuery{Collection: "events"}}, timeRange, "ts"},
+		{"join", FirestoreQuery{Query: "SELECT o.total, c.name FROM orders o JOIN customers c ON o.customerId = c.__name__"}, timeRange, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planQuery(tt.qm, settings, tt.timeRange)
+			require.NoError(t, err)
+			require.Equal(t, tt.timeField, plan.timeField)
+			if tt.timeField != "" {
+				require.Equal(t, tt.timeField, plan.info.TimeField)
+			}
+		})
+	}
+
+	plan, err := planQuery(FirestoreQuery{Query: "SELECT brand, COUNT(*) AS n FROM (SELECT * FROM orders) o GROUP BY brand"}, settings, timeRange)
+	require.NoError(t, err)
+	require.Equal(t, "createdAt", plan.timeField)
+	require.Empty(t, plan.info.TimeField)
+	require.Equal(t, "createdAt", plan.info.Subquery.TimeField)
+}
+
+func TestLikelyTimeField(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		row        map[string]interface{}
+		timeFormat string
+		expected   string
+		format     string
+	}{
+		{"common name", map[string]interface{}{"closedAt": ts, "createdAt": ts, "ts": ts}, "", "ts", ""},
+		{"suffix", map[string]interface{}{"expiry": ts, "openTS": ts}, timeFormatTimestamp, "openTS", timeFormatTimestamp},
+		{"alphabetical", map[string]interface{}{"b": ts, "a": ts}, "", "a", ""},
+		{"top-level first", map[string]interface{}{"audit": map[string]interface{}{"timestamp": ts}, "sentAt": ts}, "", "sentAt", ""},
+		{"timestamp before date strings", map[string]interface{}{"ts": "2024-01-01T00:00:00Z", "sentAt": ts}, "", "sentAt", ""},
+		{"date strings", map[string]interface{}{"ts": ts, "day": "2024-01-01"}, timeFormatRFC3339, "day", timeFormatRFC3339},
+		{"detected date strings", map[string]interface{}{"createdAt": "2024-01-01T10:00:00Z", "n": int64(1)}, "", "createdAt", timeFormatRFC3339},
+		{"no time", map[string]interface{}{"name": "a", "n": int64(1)}, "", "", ""},
+		{"epoch", map[string]interface{}{"ts": ts}, timeFormatUnixMillis, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := inferSchema("events", []map[string]interface{}{tt.row})
+			field, format := likelyTimeField(schema, tt.timeFormat)
+			require.Equal(t, tt.expected, field)
+			require.Equal(t, tt.format, format)
+		})
+	}
+}
+
+func TestDetectTimeField(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := &Datasource{}
+	d.schemas = newSchemaCache(func(ctx context.Context, collection string) (*collectionSchema, error) {
+		return inferSchema(collection, []map[string]interface{}{{"createdAt": ts, "status": "open"}}), nil
+	}, time.Hour)
+	defer d.Dispose()
+	timeRange := backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(3600, 0)}
+
+	info := &QueryInfo{Collection: "events", Fields: []string{"*"}}
+	require.Empty(t, d.detectTimeField(context.Background(), info, FirestoreQuery{Format: formatTable}, timeRange))
+	require.Equal(t, "createdAt", d.detectTimeField(context.Background(), info, FirestoreQuery{Format: formatTimeSeries}, timeRange))
+	require.Equal(t, "createdAt", info.TimeField)
+
+	info = &QueryInfo{Collection: "events", Fields: []string{"*"}}
+	require.Empty(t, d.detectTimeField(context.Background(), info, FirestoreQuery{Format: formatLogs, TimeField: "ts"}, timeRange))
+	require.Empty(t, d.detectTimeField(context.Background(), info, FirestoreQuery{Format: formatLogs}, backend.TimeRange{}))
+
+	info = &QueryInfo{Collection: "events", AggregateFields: []AggregateInfo{{Function: "RATE", Field: "bytes"}}}
+	require.Equal(t, "createdAt", d.detectTimeField(context.Background(), info, FirestoreQuery{Format: formatTable}, timeRange))
+
+	info = &QueryInfo{Collection: "events", TimeBucketField: "ts"}
+	require.Empty(t, d.detectTimeField(context.Background(), info, FirestoreQuery{Format: formatTimeSeries}, timeRange))
+
+	d.schemas = newSchemaCache(func(ctx context.Context, collection string) (*collectionSchema, error) {
+		return inferSchema(collection, []map[string]interface{}{{"sentAt": "2024-01-01T10:00:00Z"}}), nil
+	}, time.Hour)
+	info = &QueryInfo{Collection: "messages", Fields: []string{"*"}}
+	require.Equal(t, "sentAt", d.detectTimeField(context.Background(), info, FirestoreQuery{Format: formatLogs}, timeRange))
+	require.Equal(t, timeFormatRFC3339, info.TimeFieldFormat)
+}
@@ -0,0 +1,153 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	_ "time/tzdata" // timezone settings must work without a system zoneinfo database
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Supported representations of the time field, set with the timeFormat datasource
+// setting or per query
+const (
+	timeFormatTimestamp   = "timestamp" // Firestore Timestamp (default)
+	timeFormatUnixMillis  = "unix_ms"
+	timeFormatUnixSeconds = "unix_s"
+	timeFormatUnixMicros  = "unix_us"
+	timeFormatUnixNanos   = "unix_ns"
+	timeFormatRFC3339     = "rfc3339"
+)
+
+// timeFilterValue returns the value compared against the time field in native
+// Firestore filters, matching how the field is stored
+func timeFilterValue(t time.Time, format string) interface{} {
+	switch format {
+	case timeFormatUnixMillis:
+		return t.UnixMilli()
+	case timeFormatUnixSeconds:
+		return t.Unix()
+	case timeFormatUnixMicros:
+		return t.UnixMicro()
+	case timeFormatUnixNanos:
+		return t.UnixNano()
+	case timeFormatRFC3339:
+		return t.UTC().Format(time.RFC3339Nano)
+	default:
+		return t
+	}
+}
+
+// rfc3339BoundLayout formats the bounds of a range filter on date strings to the second,
+// without a zone, so they compare as prefixes of the stored strings
+const rfc3339BoundLayout = "2006-01-02T15:04:05"
+
+// timeRangeBounds returns the values a time field is compared against to filter a time range.
+// Date strings compare as strings, so the bounds of a range on UTC ISO-8601 strings are
+// prefixes matching any fraction of a second or zone suffix: the range is widened to whole
+// seconds, and strings with another offset don't compare in time order.
+func timeRangeBounds(timeRange backend.TimeRange, format string) (interface{}, interface{}) {
+	if format != timeFormatRFC3339 {
+		return timeFilterValue(timeRange.From, format), timeFilterValue(timeRange.To, format)
+	}
+	from := timeRange.From.UTC().Format(rfc3339BoundLayout)
+	// "~" sorts after the fraction separator, zone letters and offset signs
+	to := timeRange.To.UTC().Format(rfc3339BoundLayout) + "~"
+	return from, to
+}
+
+// naiveDateLayouts are the accepted layouts for date strings stored without a UTC offset
+var naiveDateLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// toTime converts a stored time field value to time.Time according to its format.
+// Firestore Timestamps are always accepted. Date strings without an offset are
+// interpreted in loc (UTC when nil) and returned in UTC.
+func toTime(value interface{}, format string, loc *time.Location) (time.Time, bool) {
+	if ts, ok := value.(time.Time); ok {
+		return ts, true
+	}
+	// A date string is a time whatever the format of the field, so fields whose values were
+	// stored as strings for a while still convert
+	if str, ok := value.(string); ok && format != timeFormatRFC3339 {
+		if _, err := strconv.ParseFloat(str, 64); err != nil {
+			return parseDateString(str, loc)
+		}
+	}
+
+	switch format {
+	case timeFormatUnixMillis, timeFormatUnixSeconds, timeFormatUnixMicros, timeFormatUnixNanos:
+		if n, ok := value.(int64); ok && format == timeFormatUnixNanos {
+			// Nanoseconds don't fit the precision of a float64
+			return time.Unix(0, n).UTC(), true
+		}
+		n, err := convertToFloat(value)
+		if err != nil || math.IsNaN(n) {
+			return time.Time{}, false
+		}
+		switch format {
+		case timeFormatUnixSeconds:
+			sec, frac := math.Modf(n)
+			return time.Unix(int64(sec), int64(frac*1e9)).UTC(), true
+		case timeFormatUnixMicros:
+			return time.UnixMicro(int64(n)).UTC(), true
+		case timeFormatUnixNanos:
+			return time.Unix(0, int64(n)).UTC(), true
+		}
+		return time.UnixMilli(int64(n)).UTC(), true
+	case timeFormatRFC3339:
+		str, ok := value.(string)
+		if !ok {
+			return time.Time{}, false
+		}
+		return parseDateString(str, loc)
+	default:
+		return time.Time{}, false
+	}
+}
+
+// parseDateString parses RFC3339 strings, falling back to naive layouts interpreted in loc
+func parseDateString(str string, loc *time.Location) (time.Time, bool) {
+	if ts, err := time.Parse(time.RFC3339Nano, str); err == nil {
+		return ts.UTC(), true
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	for _, layout := range naiveDateLayouts {
+		if ts, err := time.ParseInLocation(layout, str, loc); err == nil {
+			return ts.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// validateTimeFormat checks the configured time format
+func validateTimeFormat(format string) error {
+	switch format {
+	case "", timeFormatTimestamp, timeFormatUnixMillis, timeFormatUnixSeconds, timeFormatUnixMicros, timeFormatUnixNanos, timeFormatRFC3339:
+		return nil
+	default:
+		return fmt.Errorf("unsupported timeFormat %q, expected one of %s, %s, %s, %s, %s, %s",
+			format, timeFormatTimestamp, timeFormatUnixMillis, timeFormatUnixSeconds, timeFormatUnixMicros, timeFormatUnixNanos, timeFormatRFC3339)
+	}
+}
+
+// timeFormatOf returns how a field of the query is stored: its type in the fieldTypes setting
+// when that is a time format, the detected format of the time field, or the query's format
+func (info *QueryInfo) timeFormatOf(field string) string {
+	if fieldType := info.FieldTypes[field]; isTimeFieldType(fieldType) {
+		return fieldType
+	}
+	if field == info.TimeField && info.TimeFieldFormat != "" {
+		return info.TimeFieldFormat
+	}
+	return info.TimeFormat
+}
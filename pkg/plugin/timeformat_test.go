@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToTime(t *testing.T) {
+	expected := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  interface{}
+		format string
+		ok     bool
+	}{
+		{"timestamp", expected, timeFormatTimestamp, true},
+		{"timestamp with other format", expected, timeFormatUnixMillis, true},
+		{"unix millis", int64(1672531200000), timeFormatUnixMillis, true},
+		{"unix seconds", int64(1672531200), timeFormatUnixSeconds, true},
+		{"unix seconds float", 1672531200.0, timeFormatUnixSeconds, true},
+		{"unix micros", int64(1672531200000000), timeFormatUnixMicros, true},
+		{"unix nanos", int64(1672531200000000000), timeFormatUnixNanos, true},
+		{"unix nanos float", 1672531200000000000.0, timeFormatUnixNanos, true},
+		{"rfc3339", "2023-01-01T00:00:00Z", timeFormatRFC3339, true},
+		{"rfc3339 with offset", "2023-01-01T01:00:00+01:00", timeFormatRFC3339, true},
+		{"number as timestamp", int64(1672531200000), timeFormatTimestamp, false},
+		{"invalid rfc3339", "yesterday", timeFormatRFC3339, false},
+		{"date string as timestamp", "2023-01-01T00:00:00Z", timeFormatTimestamp, true},
+		{"date string as unix millis", "2023-01-01", timeFormatUnixMillis, true},
+		{"numeric string as unix millis", "1672531200000", timeFormatUnixMillis, true},
+		{"invalid string as timestamp", "yesterday", timeFormatTimestamp, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, ok := toTime(tt.value, tt.format, nil)
+			require.Equal(t, tt.ok, ok)
+			if ok {
+				require.True(t, expected.Equal(ts))
+			}
+		})
+	}
+}
+
+func TestTimeFilterValue(t *testing.T) {
+	ts := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	require.Equal(t, ts, timeFilterValue(ts, ""))
+	require.Equal(t, ts, timeFilterValue(ts, timeFormatTimestamp))
+	require.Equal(t, int64(1672531200000), timeFilterValue(ts, timeFormatUnixMillis))
+	require.Equal(t, int64(1672531200), timeFilterValue(ts, timeFormatUnixSeconds))
+	require.Equal(t, int64(1672531200000000), timeFilterValue(ts, timeFormatUnixMicros))
+	require.Equal(t, int64(1672531200000000000), timeFilterValue(ts, timeFormatUnixNanos))
+	require.Equal(t, "2023-01-01T00:00:00Z", timeFilterValue(ts, timeFormatRFC3339))
+}
+
+func TestTimeRangeBounds(t *testing.T) {
+	timeRange := backend.TimeRange{From: time.Date(2023, 1, 1, 0, 0, 0, 500, time.UTC), To: time.Date(2023, 1, 2, 12, 30, 15, 0, time.UTC)}
+
+	from, to := timeRangeBounds(timeRange, timeFormatUnixMillis)
+	require.Equal(t, timeRange.From.UnixMilli(), from)
+	require.Equal(t, timeRange.To.UnixMilli(), to)
+
+	from, to = timeRangeBounds(timeRange, timeFormatRFC3339)
+	require.Equal(t, "2023-01-01T00:00:00", from)
+	require.Equal(t, "2023-01-02T12:30:15~", to)
+
+	// Stored strings within the range compare within the bounds
+	for _, stored := range []string{"2023-01-01T00:00:00Z", "2023-01-01T00:00:00.5Z", "2023-01-02T12:30:15Z", "2023-01-02T12:30:15.999+00:00"} {
+		require.GreaterOrEqual(t, stored, from.(string))
+		require.LessOrEqual(t, stored, to.(string))
+	}
+	require.Less(t, "2022-12-31T23:59:59.9Z", from.(string))
+	require.Greater(t, "2023-01-02T12:30:16Z", to.(string))
+}
+
+func TestValidateTimeFormat(t *testing.T) {
+	require.NoError(t, validateTimeFormat(""))
+	require.NoError(t, validateTimeFormat(timeFormatUnixSeconds))
+	require.NoError(t, validateTimeFormat(timeFormatUnixNanos))
+	require.Error(t, validateTimeFormat("millis"))
+}
+
+func TestTimeFormatOf(t *testing.T) {
+	info := &QueryInfo{
+		TimeField:  "ts",
+		TimeFormat: timeFormatUnixMillis,
+		FieldTypes: map[string]string{"ts": timeFormatUnixSeconds, "payload": fieldTypeJSON},
+	}
+	require.Equal(t, timeFormatUnixSeconds, info.timeFormatOf("ts"))
+	require.Equal(t, timeFormatUnixMillis, info.timeFormatOf("payload"))
+	require.Equal(t, timeFormatUnixMillis, info.timeFormatOf("createdAt"))
+
+	// A detected format applies to the time field only
+	info = &QueryInfo{TimeField: "createdAt", TimeFormat: timeFormatTimestamp, TimeFieldFormat: timeFormatRFC3339}
+	require.Equal(t, timeFormatRFC3339, info.timeFormatOf("createdAt"))
+	require.Equal(t, timeFormatTimestamp, info.timeFormatOf("updatedAt"))
+}
+
+func TestToTimeNaiveStringInLocation(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	require.NoError(t, err)
+
+	ts, ok := toTime("2023-07-01 12:30:00", timeFormatRFC3339, madrid)
+	require.True(t, ok)
+	require.Equal(t, time.Date(2023, 7, 1, 10, 30, 0, 0, time.UTC), ts)
+
+	ts, ok = toTime("2023-07-01", timeFormatRFC3339, nil)
+	require.True(t, ok)
+	require.Equal(t, time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC), ts)
+
+	// Strings with an explicit offset ignore the configured timezone
+	ts, ok = toTime("2023-07-01T12:30:00Z", timeFormatRFC3339, madrid)
+	require.True(t, ok)
+	require.Equal(t, time.Date(2023, 7, 1, 12, 30, 0, 0, time.UTC), ts)
+}
@@ -0,0 +1,249 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// buildWideTimeSeriesFrame pivots grouped results that include a time bucket into a wide
+// time series frame: one time field plus one numeric field per aggregate and label set.
+// The remaining GROUP BY fields become the labels of each series.
+// Returns false when the results don't contain a usable time bucket.
+func buildWideTimeSeriesFrame(results []AggregatedResult, queryInfo *QueryInfo) (*data.Frame, bool) {
+	timeIdx := -1
+	for i, groupField := range queryInfo.GroupByFields {
+		if groupField == queryInfo.TimeBucketField {
+			timeIdx = i
+			break
+		}
+	}
+	if timeIdx == -1 {
+		return nil, false
+	}
+
+	// Collect the distinct buckets and label sets
+	bucketSet := make(map[time.Time]bool)
+	seriesLabels := make(map[string]data.Labels)
+	for _, result := range results {
+		if timeIdx >= len(result.GroupValues) {
+			continue
+		}
+		ts, ok := result.GroupValues[timeIdx].(time.Time)
+		if !ok {
+			return nil, false
+		}
+		bucketSet[ts] = true
+
+		labels := resultLabels(result, queryInfo, timeIdx)
+		seriesLabels[labels.String()] = labels
+	}
+
+	buckets := make([]time.Time, 0, len(bucketSet))
+	for ts := range bucketSet {
+		buckets = append(buckets, ts)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Before(buckets[j]) })
+
+	bucketIndex := make(map[time.Time]int, len(buckets))
+	for i, ts := range buckets {
+		bucketIndex[ts] = i
+	}
+
+	seriesKeys := make([]string, 0, len(seriesLabels))
+	for key := range seriesLabels {
+		seriesKeys = append(seriesKeys, key)
+	}
+	sort.Strings(seriesKeys)
+
+	frame := data.NewFrame("response", data.NewField(queryInfo.TimeBucketField, nil, buckets))
+
+	for aggIdx, aggField := range queryInfo.AggregateFields {
+		// One value slice per series for this aggregate
+		seriesValues := make(map[string][]interface{}, len(seriesKeys))
+		for _, key := range seriesKeys {
+			seriesValues[key] = make([]interface{}, len(buckets))
+		}
+
+		for _, result := range results {
+			if aggIdx >= len(result.AggregateValues) {
+				continue
+			}
+			ts := result.GroupValues[timeIdx].(time.Time)
+			key := resultLabels(result, queryInfo, timeIdx).String()
+			seriesValues[key][bucketIndex[ts]] = result.AggregateValues[aggIdx]
+		}
+
+		// Integer aggregates stay int64, see computeAggregate
+		for _, key := range seriesKeys {
+			field := newTypedField(aggregateFieldName(aggField), seriesValues[key])
+			field.Labels = seriesLabels[key]
+			setAggregateUnit(field, aggField, queryInfo.FieldTypes)
+			frame.Fields = append(frame.Fields, field)
+		}
+	}
+
+	frame.SetMeta(&data.FrameMeta{Type: data.FrameTypeTimeSeriesWide})
+	return frame, true
+}
+
+// resultLabels builds the series labels from every group value except the time bucket
+func resultLabels(result AggregatedResult, queryInfo *QueryInfo, timeIdx int) data.Labels {
+	labels := data.Labels{}
+	for i, groupField := range queryInfo.GroupByFields {
+		if i == timeIdx || i >= len(result.GroupValues) {
+			continue
+		}
+		labels[groupField] = fmt.Sprintf("%v", result.GroupValues[i])
+	}
+	return labels
+}
+
+// timeBucketValues returns the time bucket group values of the results, false when a
+// bucket isn't a time because the field didn't hold one
+func timeBucketValues(results []AggregatedResult, groupIdx int) ([]*time.Time, bool) {
+	buckets := make([]*time.Time, len(results))
+	for i, result := range results {
+		if groupIdx >= len(result.GroupValues) || result.GroupValues[groupIdx] == nil {
+			continue
+		}
+		ts, ok := result.GroupValues[groupIdx].(time.Time)
+		if !ok {
+			return nil, false
+		}
+		buckets[i] = &ts
+	}
+	return buckets, true
+}
+
+// dateTruncPattern matches a date_trunc('unit', field[, 'timezone']) time bucket
+var dateTruncPattern = regexp.MustCompile(`(?i)^date_trunc\s*\(\s*'(\w+)'\s*,\s*([^,)]+?)\s*(?:,\s*'([^']+)'\s*)?\)$`)
+
+// calendarUnitPattern matches a calendar bucket function such as month(field[, 'timezone'])
+var calendarUnitPattern = regexp.MustCompile(`(?i)^(week|month|quarter|year)\s*\(\s*([^,)]+?)\s*(?:,\s*'([^']+)'\s*)?\)$`)
+
+// dateTruncUnits are the date_trunc units and their bucket sizes, nominal for the calendar
+// units whose buckets vary in length
+var dateTruncUnits = map[string]time.Duration{
+	"second":  time.Second,
+	"minute":  time.Minute,
+	"hour":    time.Hour,
+	"day":     24 * time.Hour,
+	"week":    7 * 24 * time.Hour,
+	"month":   30 * 24 * time.Hour,
+	"quarter": 91 * 24 * time.Hour,
+	"year":    365 * 24 * time.Hour,
+}
+
+// calendarUnits are the date_trunc units following the calendar. Weeks start on Monday.
+var calendarUnits = map[string]bool{"week": true, "month": true, "quarter": true, "year": true}
+
+// dateTrunc is a date_trunc time bucket. Buckets start on the wall clock of Location, so
+// days begin at local midnight; nil is UTC.
+type dateTrunc struct {
+	Field    string
+	Unit     string
+	Bucket   time.Duration
+	Location *time.Location
+}
+
+// parseDateTrunc parses a date_trunc('day', ts, 'Europe/Madrid') expression, or a calendar
+// bucket like month(ts), into the truncated field, the bucket unit and size and the timezone.
+// isBucket is false for other expressions.
+func parseDateTrunc(expr string) (trunc dateTrunc, isBucket bool, err error) {
+	expr = strings.TrimSpace(expr)
+	match := dateTruncPattern.FindStringSubmatch(expr)
+	if match == nil {
+		match = calendarUnitPattern.FindStringSubmatch(expr)
+	}
+	if match == nil {
+		return dateTrunc{}, false, nil
+	}
+	unit := strings.ToLower(match[1])
+	bucket, ok := dateTruncUnits[unit]
+	if !ok {
+		return dateTrunc{}, true, fmt.Errorf("unsupported date_trunc unit %q, expected second, minute, hour, day, week, month, quarter or year", match[1])
+	}
+	trunc = dateTrunc{Field: cleanBackticks(match[2]), Unit: unit, Bucket: bucket}
+	if match[3] != "" {
+		if trunc.Location, err = time.LoadLocation(match[3]); err != nil {
+			return dateTrunc{}, true, fmt.Errorf("invalid date_trunc timezone %q: %v", match[3], err)
+		}
+	}
+	return trunc, true, nil
+}
+
+// truncateToBucket returns the start of the time bucket of ts, in UTC
+func truncateToBucket(ts time.Time, queryInfo *QueryInfo) time.Time {
+	loc := queryInfo.TimeBucketLocation
+	if calendarUnits[queryInfo.TimeBucketUnit] {
+		if loc == nil {
+			loc = time.UTC
+		}
+		return truncateToCalendarUnit(ts.In(loc), queryInfo.TimeBucketUnit).UTC()
+	}
+	if loc == nil {
+		return ts.Truncate(queryInfo.TimeBucket).UTC()
+	}
+	// Truncate the wall clock, then read it back in the timezone
+	local := ts.In(loc)
+	wall := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+	wall = wall.Truncate(queryInfo.TimeBucket)
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), loc).UTC()
+}
+
+// truncateToCalendarUnit returns the start of the week, month, quarter or year of a local time
+func truncateToCalendarUnit(local time.Time, unit string) time.Time {
+	year, month, day := local.Date()
+	switch unit {
+	case "week":
+		daysSinceMonday := (int(local.Weekday()) + 6) % 7
+		return time.Date(year, month, day-daysSinceMonday, 0, 0, 0, 0, local.Location())
+	case "month":
+		return time.Date(year, month, 1, 0, 0, 0, 0, local.Location())
+	case "quarter":
+		return time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, local.Location())
+	default:
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, local.Location())
+	}
+}
+
+// nextBucket returns the start of the time bucket after the one starting at start. Days
+// in a timezone last 23 or 25 hours when daylight saving time changes, and calendar
+// buckets vary with the length of months and years.
+func nextBucket(start time.Time, queryInfo *QueryInfo) time.Time {
+	if calendarUnits[queryInfo.TimeBucketUnit] {
+		loc := queryInfo.TimeBucketLocation
+		if loc == nil {
+			loc = time.UTC
+		}
+		local := start.In(loc)
+		switch queryInfo.TimeBucketUnit {
+		case "week":
+			local = local.AddDate(0, 0, 7)
+		case "month":
+			local = local.AddDate(0, 1, 0)
+		case "quarter":
+			local = local.AddDate(0, 3, 0)
+		default:
+			local = local.AddDate(1, 0, 0)
+		}
+		return truncateToCalendarUnit(local, queryInfo.TimeBucketUnit).UTC()
+	}
+	if queryInfo.TimeBucketLocation == nil {
+		return start.Add(queryInfo.TimeBucket)
+	}
+	return truncateToBucket(start.Add(queryInfo.TimeBucket*3/2), queryInfo)
+}
+
+// stripAlias removes the AS alias of a SELECT expression
+func stripAlias(expr string) string {
+	if idx := strings.LastIndex(strings.ToUpper(expr), " AS "); idx != -1 {
+		return strings.TrimSpace(expr[:idx])
+	}
+	return strings.TrimSpace(expr)
+}
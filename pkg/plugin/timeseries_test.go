@@ -0,0 +1,192 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildWideTimeSeriesFrame(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+
+	queryInfo := &QueryInfo{
+		GroupByFields:   []string{"ts", "brand"},
+		AggregateFields: []AggregateInfo{{Function: "COUNT", Field: "*", Alias: "COUNT(*) as total"}},
+		TimeBucketField: "ts",
+		TimeBucket:      time.Hour,
+	}
+	results := []AggregatedResult{
+		{GroupValues: []interface{}{t1, "yoigo"}, AggregateValues: []interface{}{2.0}},
+		{GroupValues: []interface{}{t0, "yoigo"}, AggregateValues: []interface{}{1.0}},
+		{GroupValues: []interface{}{t0, "masmovil"}, AggregateValues: []interface{}{3.0}},
+	}
+
+	frame, ok := buildWideTimeSeriesFrame(results, queryInfo)
+	require.True(t, ok)
+	require.Len(t, frame.Fields, 3)
+	require.Equal(t, data.FrameTypeTimeSeriesWide, frame.Meta.Type)
+
+	require.Equal(t, 2, frame.Fields[0].Len())
+	require.Equal(t, t0, frame.Fields[0].At(0))
+
+	masmovil := frame.Fields[1]
+	require.Equal(t, "total", masmovil.Name)
+	require.Equal(t, data.Labels{"brand": "masmovil"}, masmovil.Labels)
+	require.Equal(t, 3.0, *masmovil.At(0).(*float64))
+	require.Nil(t, masmovil.At(1))
+
+	yoigo := frame.Fields[2]
+	require.Equal(t, data.Labels{"brand": "yoigo"}, yoigo.Labels)
+	require.Equal(t, 1.0, *yoigo.At(0).(*float64))
+	require.Equal(t, 2.0, *yoigo.At(1).(*float64))
+}
+
+func TestBuildWideTimeSeriesFrameWithoutTimeBucket(t *testing.T) {
+	queryInfo := &QueryInfo{GroupByFields: []string{"brand"}, TimeBucketField: "ts"}
+	_, ok := buildWideTimeSeriesFrame(nil, queryInfo)
+	require.False(t, ok)
+}
+
+func TestParseDateTruncGroupBy(t *testing.T) {
+	queryInfo, err := parseSQLQueryWithVariables("SELECT date_trunc('hour', ts) AS hour, brand, COUNT(*) AS total FROM orders WHERE ts >= $__from AND ts <= $__to GROUP BY date_trunc('hour', ts), brand ORDER BY ts")
+	require.NoError(t, err)
+	require.Equal(t, []string{"brand"}, queryInfo.Fields)
+	require.Equal(t, []string{"ts", "brand"}, queryInfo.GroupByFields)
+	require.Equal(t, "ts", queryInfo.TimeBucketField)
+	require.Equal(t, time.Hour, queryInfo.TimeBucket)
+	require.Len(t, queryInfo.AggregateFields, 1)
+
+	queryInfo, err = parseSQLQueryWithVariables("SELECT brand, COUNT(*) FROM orders GROUP BY brand, DATE_TRUNC('day', `created.at`)")
+	require.NoError(t, err)
+	require.Equal(t, []string{"brand", "created.at"}, queryInfo.GroupByFields)
+	require.Equal(t, 24*time.Hour, queryInfo.TimeBucket)
+
+	_, err = parseSQLQueryWithVariables("SELECT COUNT(*) FROM orders GROUP BY date_trunc('fortnight', ts)")
+	require.Error(t, err)
+	_, err = parseSQLQueryWithVariables("SELECT COUNT(*) FROM orders GROUP BY date_trunc('hour', ts), date_trunc('day', ts)")
+	require.Error(t, err)
+}
+
+func TestTimeBucketValues(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	buckets, ok := timeBucketValues([]AggregatedResult{
+		{GroupValues: []interface{}{t0, "yoigo"}},
+		{GroupValues: []interface{}{nil, "yoigo"}},
+	}, 0)
+	require.True(t, ok)
+	require.Equal(t, t0, *buckets[0])
+	require.Nil(t, buckets[1])
+
+	_, ok = timeBucketValues([]AggregatedResult{{GroupValues: []interface{}{"not a time"}}}, 0)
+	require.False(t, ok)
+}
+
+func TestParseDateTruncTimezone(t *testing.T) {
+	queryInfo, err := parseSQLQueryWithVariables("SELECT date_trunc('day', ts, 'Europe/Madrid') AS day, COUNT(*) FROM orders GROUP BY date_trunc('day', ts, 'Europe/Madrid')")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ts"}, queryInfo.GroupByFields)
+	require.Equal(t, "Europe/Madrid", queryInfo.TimeBucketLocation.String())
+
+	_, err = parseSQLQueryWithVariables("SELECT COUNT(*) FROM orders GROUP BY date_trunc('day', ts, 'Mars/Olympus')")
+	require.ErrorContains(t, err, "invalid date_trunc timezone")
+}
+
+func TestTruncateToBucketTimezone(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	require.NoError(t, err)
+	queryInfo := &QueryInfo{TimeBucket: 24 * time.Hour, TimeBucketLocation: madrid}
+
+	// 23:30 UTC on March 30 is already March 31 in Madrid
+	day := truncateToBucket(time.Date(2024, 3, 30, 23, 30, 0, 0, time.UTC), queryInfo)
+	require.Equal(t, time.Date(2024, 3, 31, 0, 0, 0, 0, madrid).UTC(), day)
+
+	// Summer time starts on March 31, a 23 hour day
+	next := nextBucket(day, queryInfo)
+	require.Equal(t, time.Date(2024, 4, 1, 0, 0, 0, 0, madrid).UTC(), next)
+	require.Equal(t, 23*time.Hour, next.Sub(day))
+
+	queryInfo.TimeBucketLocation = nil
+	require.Equal(t, time.Date(2024, 3, 30, 0, 0, 0, 0, time.UTC), truncateToBucket(time.Date(2024, 3, 30, 23, 30, 0, 0, time.UTC), queryInfo))
+
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	require.NoError(t, err)
+	queryInfo = &QueryInfo{TimeBucket: time.Hour, TimeBucketLocation: kolkata}
+	require.Equal(t, time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC), truncateToBucket(time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC), queryInfo))
+}
+
+func TestSetPanelTimeBucketTimezone(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	require.NoError(t, err)
+	qm := FirestoreQuery{Format: formatTimeSeries, IntervalMs: 24 * 60 * 60 * 1000}
+
+	// Daily panel buckets start at midnight of the datasource timezone
+	queryInfo := &QueryInfo{TimeField: "ts", Location: madrid}
+	setPanelTimeBucket(queryInfo, qm)
+	require.Equal(t, "ts", queryInfo.TimeBucketField)
+	require.Equal(t, madrid, queryInfo.TimeBucketLocation)
+	require.Equal(t, time.Date(2024, 3, 31, 0, 0, 0, 0, madrid).UTC(), truncateToBucket(time.Date(2024, 3, 30, 23, 30, 0, 0, time.UTC), queryInfo))
+
+	queryInfo = &QueryInfo{TimeField: "ts", Location: time.UTC}
+	setPanelTimeBucket(queryInfo, qm)
+	require.Nil(t, queryInfo.TimeBucketLocation)
+	require.Equal(t, time.Date(2024, 3, 30, 0, 0, 0, 0, time.UTC), truncateToBucket(time.Date(2024, 3, 30, 23, 30, 0, 0, time.UTC), queryInfo))
+
+	// A date_trunc keeps its own timezone
+	queryInfo = &QueryInfo{TimeBucketField: "ts", TimeBucket: time.Hour, Location: madrid}
+	setPanelTimeBucket(queryInfo, qm)
+	require.Nil(t, queryInfo.TimeBucketLocation)
+}
+
+func TestParseCalendarBuckets(t *testing.T) {
+	tests := []struct {
+		expr  string
+		field string
+		unit  string
+	}{
+		{"month(ts)", "ts", "month"},
+		{"WEEK(`created.at`)", "created.at", "week"},
+		{"quarter(ts, 'Europe/Madrid')", "ts", "quarter"},
+		{"date_trunc('year', ts)", "ts", "year"},
+	}
+	for _, tt := range tests {
+		trunc, isBucket, err := parseDateTrunc(tt.expr)
+		require.NoError(t, err, tt.expr)
+		require.True(t, isBucket, tt.expr)
+		require.Equal(t, tt.field, trunc.Field, tt.expr)
+		require.Equal(t, tt.unit, trunc.Unit, tt.expr)
+	}
+
+	queryInfo, err := parseSQLQueryWithVariables("SELECT month(ts), brand, SUM(amount) FROM orders GROUP BY month(ts), brand")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ts", "brand"}, queryInfo.GroupByFields)
+	require.Equal(t, []string{"brand"}, queryInfo.Fields)
+	require.Equal(t, "month", queryInfo.TimeBucketUnit)
+}
+
+func TestCalendarBuckets(t *testing.T) {
+	ts := time.Date(2024, 2, 29, 15, 4, 5, 0, time.UTC) // a Thursday
+	tests := []struct {
+		unit  string
+		start time.Time
+		next  time.Time
+	}{
+		{"week", time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"month", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"quarter", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"year", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		queryInfo := &QueryInfo{TimeBucketUnit: tt.unit, TimeBucket: dateTruncUnits[tt.unit]}
+		start := truncateToBucket(ts, queryInfo)
+		require.Equal(t, tt.start, start, tt.unit)
+		require.Equal(t, tt.next, nextBucket(start, queryInfo), tt.unit)
+	}
+
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	require.NoError(t, err)
+	queryInfo := &QueryInfo{TimeBucketUnit: "month", TimeBucketLocation: madrid}
+	require.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, madrid).UTC(), truncateToBucket(time.Date(2024, 2, 29, 23, 30, 0, 0, time.UTC), queryInfo))
+}
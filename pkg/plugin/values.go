@@ -0,0 +1,141 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const (
+	// valuesSampleSize is the number of documents read for the distinct values of a field
+	valuesSampleSize = 1000
+	// defaultValuesLimit is the number of distinct values returned without a limit
+	defaultValuesLimit = 100
+	// valuesMaxLimit caps the distinct values returned
+	valuesMaxLimit = 1000
+)
+
+// fieldValues are the distinct values of a field found in a sample of a collection
+type fieldValues struct {
+	Collection string        `json:"collection"`
+	Field      string        `json:"field"`
+	Values     []interface{} `json:"values"`
+
+	// Sampled is the number of documents read
+	Sampled int `json:"sampled"`
+
+	// Truncated reports that the sample held more distinct values than the limit
+	Truncated bool `json:"truncated"`
+}
+
+// handleFieldValues returns sampled distinct values of a field, for value autocompletion:
+// GET /collections/{collection}/fields/{field}/values?limit=100&prefix=<text>
+func (d *Datasource) handleFieldValues(w http.ResponseWriter, r *http.Request) {
+	collection, field := r.PathValue("collection"), r.PathValue("field")
+	query := r.URL.Query()
+	switch {
+	case !collectionPathPattern.MatchString(collection) || isCollectionPattern(collection):
+		writeResourceError(w, http.StatusBadRequest, fmt.Sprintf("invalid collection %q", collection))
+		return
+	case !fieldPathPattern.MatchString(field) && !isMetadataColumn(field):
+		writeResourceError(w, http.StatusBadRequest, fmt.Sprintf("invalid field %q", field))
+		return
+	}
+	limit := defaultValuesLimit
+	if text := query.Get("limit"); text != "" {
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 1 || n > valuesMaxLimit {
+			writeResourceError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", valuesMaxLimit))
+			return
+		}
+		limit = n
+	}
+	prefix := query.Get("prefix")
+
+	rows, err := d.sampleFieldValues(r.Context(), collection, field, prefix)
+	if err != nil {
+		writeResourceError(w, httpStatusOf(err), err.Error())
+		return
+	}
+	values := distinctFieldValues(rows, prefix, limit)
+	values.Collection, values.Field = collection, field
+	writeResourceJSON(w, values)
+}
+
+// sampleFieldValues reads the values of a field in up to valuesSampleSize documents. With a
+// prefix, the documents whose field starts with it are read, using the single-field index.
+func (d *Datasource) sampleFieldValues(ctx context.Context, collection, field, prefix string) ([]interface{}, error) {
+	client, err := newFirestoreClient(ctx, backend.PluginContext{DataSourceInstanceSettings: &d.settings})
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	query := client.Collection(collection).Query
+	if isMetadataColumn(field) {
+		query = query.Select()
+	} else {
+		query = query.Select(field)
+		if prefix != "" {
+			// \uf8ff sorts after the characters that follow the prefix in practice
+			query = query.Where(field, ">=", prefix).Where(field, "<", prefix+"\uf8ff").OrderBy(field, firestore.Asc)
+		}
+	}
+	docs, err := d.getAllDocuments(ctx, "field values", query.Limit(valuesSampleSize))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		if isMetadataColumn(field) {
+			values[i] = documentMetadata(doc, field)
+			continue
+		}
+		values[i] = convertDocumentRefs(selectFieldValue(doc.Data(), field), "")
+	}
+	return values, nil
+}
+
+// distinctFieldValues returns the sorted distinct scalar values, those starting with prefix when
+// it isn't empty, up to limit. Maps, arrays and missing values are skipped.
+func distinctFieldValues(values []interface{}, prefix string, limit int) fieldValues {
+	result := fieldValues{Values: []interface{}{}, Sampled: len(values)}
+	seen := make(map[string]bool)
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			if !strings.HasPrefix(v, prefix) {
+				continue
+			}
+		case int64, float64, bool, time.Time:
+			if prefix != "" {
+				continue
+			}
+		default:
+			continue
+		}
+		key, _ := joinKey(value, false)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result.Values = append(result.Values, value)
+	}
+
+	sort.SliceStable(result.Values, func(i, j int) bool {
+		return compareValues(result.Values[i], result.Values[j]) < 0
+	})
+	if len(result.Values) > limit {
+		result.Values = result.Values[:limit]
+		result.Truncated = true
+	}
+	return result
+}
@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDistinctFieldValues(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	values := []interface{}{"yoigo", "masmovil", nil, "yoigo", int64(3), 3.0, true, ts, map[string]interface{}{"a": 1}, []interface{}{"x"}, "orange"}
+
+	result := distinctFieldValues(values, "", 100)
+	require.Equal(t, []interface{}{true, int64(3), ts, "masmovil", "orange", "yoigo"}, result.Values)
+	require.Equal(t, 11, result.Sampled)
+	require.False(t, result.Truncated)
+
+	result = distinctFieldValues(values, "", 2)
+	require.Equal(t, []interface{}{true, int64(3)}, result.Values)
+	require.True(t, result.Truncated)
+
+	result = distinctFieldValues(values, "m", 100)
+	require.Equal(t, []interface{}{"masmovil"}, result.Values)
+
+	require.Equal(t, []interface{}{}, distinctFieldValues(nil, "", 100).Values)
+}
+
+func TestFieldValuesResource(t *testing.T) {
+	d := &Datasource{}
+	d.resourceHandler = newResourceHandler(d)
+
+	var response *backend.CallResourceResponse
+	sender := backend.CallResourceResponseSenderFunc(func(res *backend.CallResourceResponse) error {
+		response = res
+		return nil
+	})
+
+	for _, url := range []string{
+		"collections/users/fields/status/values?limit=0",
+		"collections/users/fields/status/values?limit=many",
+		"collections/users/fields/a%20b/values",
+		"collections/logs_%2A/fields/status/values",
+	} {
+		err := d.CallResource(context.Background(), &backend.CallResourceRequest{Method: http.MethodGet, Path: url, URL: url}, sender)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, response.Status, url)
+	}
+
+	err := d.CallResource(context.Background(), &backend.CallResourceRequest{Method: http.MethodPost, Path: "collections/users/fields/status/values", URL: "collections/users/fields/status/values"}, sender)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, response.Status)
+}
@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// wideFillMissing returns how the series of a long frame pivoted to wide fill the times they
+// have no row at, following the fill query option. Without one they are nulls.
+func wideFillMissing(fill string) *data.FillMissing {
+	switch fill {
+	case fillZero:
+		return &data.FillMissing{Mode: data.FillModeValue, Value: 0}
+	case fillPrevious:
+		return &data.FillMissing{Mode: data.FillModePrevious}
+	default:
+		return &data.FillMissing{Mode: data.FillModeNull}
+	}
+}
+
+// longToWide pivots a long time series frame, a row per time and label set, into a wide frame
+// with a field per value column and label set. Other frames are returned as they are.
+func longToWide(frame *data.Frame, fillMissing *data.FillMissing) (*data.Frame, error) {
+	if frame.TimeSeriesSchema().Type != data.TimeSeriesTypeLong {
+		return frame, nil
+	}
+	frame = sortFrameByTime(frame)
+	if frame.Rows() == 0 {
+		return frame, nil
+	}
+	// Labels can't be null, a missing label is empty
+	for i, field := range frame.Fields {
+		if field.Type() != data.FieldTypeNullableString && field.Type() != data.FieldTypeNullableBool {
+			continue
+		}
+		values := make([]string, field.Len())
+		for row := range values {
+			if value, ok := field.ConcreteAt(row); ok {
+				values[row] = fmt.Sprint(value)
+			}
+		}
+		frame.Fields[i] = data.NewField(field.Name, field.Labels, values)
+	}
+
+	wide, err := data.LongToWide(frame, fillMissing)
+	if err != nil {
+		return nil, err
+	}
+	// The series keep the unit and display settings of their column
+	for _, field := range wide.Fields {
+		if long, idx := frame.FieldByName(field.Name); idx != -1 && field.Config == nil {
+			field.Config = long.Config
+		}
+	}
+	return wide, nil
+}
+
+// wideResponse pivots the long frames of a query with the convertToWide option, so panels
+// show a series per label set without a transformation
+func wideResponse(response backend.DataResponse, qm FirestoreQuery) backend.DataResponse {
+	if response.Error != nil {
+		return response
+	}
+	for i, frame := range response.Frames {
+		wide, err := longToWide(frame, wideFillMissing(qm.Fill))
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("convertToWide: %s", err))
+		}
+		response.Frames[i] = wide
+	}
+	return response
+}
+
+// sortFrameByTime returns the rows of a frame sorted by its first time field, without the
+// rows whose time is null, as long to wide conversion needs
+func sortFrameByTime(frame *data.Frame) *data.Frame {
+	timeIdx := -1
+	for i, field := range frame.Fields {
+		if field.Type().Time() {
+			timeIdx = i
+			break
+		}
+	}
+	if timeIdx == -1 {
+		return frame
+	}
+
+	var rows []int
+	times := make(map[int]time.Time)
+	for row := 0; row < frame.Rows(); row++ {
+		if value, ok := frame.Fields[timeIdx].ConcreteAt(row); ok {
+			rows = append(rows, row)
+			times[row] = value.(time.Time)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return times[rows[i]].Before(times[rows[j]]) })
+
+	sorted := data.NewFrame(frame.Name)
+	sorted.RefID = frame.RefID
+	sorted.Meta = frame.Meta
+	for i, field := range frame.Fields {
+		fieldType := field.Type()
+		if i == timeIdx {
+			fieldType = data.FieldTypeTime
+		}
+		out := data.NewFieldFromFieldType(fieldType, len(rows))
+		out.Name, out.Labels, out.Config = field.Name, field.Labels, field.Config
+		for j, row := range rows {
+			if i == timeIdx {
+				out.Set(j, times[row])
+			} else {
+				out.Set(j, field.CopyAt(row))
+			}
+		}
+		sorted.Fields = append(sorted.Fields, out)
+	}
+	return sorted
+}
@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWideResponse(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newFrame := func() *data.Frame {
+		frame := data.NewFrame("response",
+			data.NewField("ts", nil, []time.Time{start.Add(time.Minute), start, start, start.Add(2 * time.Minute)}),
+			data.NewField("host", nil, []*string{ptr("a"), ptr("a"), ptr("b"), nil}),
+			data.NewField("cpu", nil, []float64{1, 2, 3, 4}),
+		)
+		frame.Fields[2].SetConfig(&data.FieldConfig{Unit: "percent"})
+		return frame
+	}
+
+	tests := []struct {
+		fill     string
+		expected []*float64
+	}{
+		{"", []*float64{ptr(3.0), nil, nil}},
+		{fillZero, []*float64{ptr(3.0), ptr(0.0), ptr(0.0)}},
+		{fillPrevious, []*float64{ptr(3.0), ptr(3.0), ptr(3.0)}},
+	}
+	for _, tt := range tests {
+		t.Run("fill "+tt.fill, func(t *testing.T) {
+			response := wideResponse(backend.DataResponse{Frames: data.Frames{newFrame()}}, FirestoreQuery{ConvertToWide: true, Fill: tt.fill})
+			require.NoError(t, response.Error)
+			wide := response.Frames[0]
+			require.Equal(t, data.FrameTypeTimeSeriesWide, wide.Meta.Type)
+			require.Equal(t, 3, wide.Rows())
+			require.Len(t, wide.Fields, 4)
+
+			// Series are sorted by their labels, the missing label is empty
+			require.Equal(t, data.Labels{"host": ""}, wide.Fields[1].Labels)
+			require.Equal(t, data.Labels{"host": "a"}, wide.Fields[2].Labels)
+			require.Equal(t, data.Labels{"host": "b"}, wide.Fields[3].Labels)
+			require.Equal(t, "percent", wide.Fields[3].Config.Unit)
+
+			for row, expected := range tt.expected {
+				value, ok := wide.Fields[3].ConcreteAt(row)
+				if expected == nil {
+					require.False(t, ok)
+				} else {
+					require.Equal(t, *expected, value)
+				}
+			}
+		})
+	}
+}
+
+func TestWideResponseKeepsWideFrames(t *testing.T) {
+	frame := data.NewFrame("response",
+		data.NewField("ts", nil, []time.Time{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}),
+		data.NewField("cpu", nil, []float64{1}),
+	)
+	response := wideResponse(backend.DataResponse{Frames: data.Frames{frame}}, FirestoreQuery{ConvertToWide: true})
+	require.Same(t, frame, response.Frames[0])
+}
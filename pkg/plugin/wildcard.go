@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// wildcardMaxCollections caps the collections a wildcard FROM expands to
+const wildcardMaxCollections = 50
+
+// isCollectionPattern reports whether a FROM collection is a glob such as logs_*
+func isCollectionPattern(collection string) bool {
+	return strings.ContainsAny(collection, "*?[")
+}
+
+// splitCollectionPattern returns the document holding the collections a pattern matches,
+// empty for top-level collections, and the glob matching their IDs
+func splitCollectionPattern(pattern string) (parent, glob string, err error) {
+	pattern = strings.Trim(cleanBackticks(pattern), "/")
+	if idx := strings.LastIndex(pattern, "/"); idx != -1 {
+		parent, glob = pattern[:idx], pattern[idx+1:]
+	} else {
+		glob = pattern
+	}
+	if isCollectionPattern(parent) {
+		return "", "", fmt.Errorf("FROM %s: only the last segment of a collection path can be a pattern", pattern)
+	}
+	if _, err := path.Match(glob, ""); err != nil {
+		return "", "", fmt.Errorf("FROM %s: invalid collection pattern", pattern)
+	}
+	return parent, glob, nil
+}
+
+// matchCollectionIDs returns the sorted collection IDs matching a glob
+func matchCollectionIDs(glob string, ids []string) []string {
+	var matched []string
+	for _, id := range ids {
+		if ok, _ := path.Match(glob, id); ok {
+			matched = append(matched, id)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+// expandCollectionPattern lists the collections that a wildcard FROM matches
+func (d *Datasource) expandCollectionPattern(ctx context.Context, client *firestore.Client, pattern string) ([]string, error) {
+	parent, glob, err := splitCollectionPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var collections []*firestore.CollectionRef
+	err = d.withRetries(ctx, "list collections", func() (err error) {
+		if parent == "" {
+			collections, err = client.Collections(ctx).GetAll()
+		} else {
+			collections, err = client.Doc(parent).Collections(ctx).GetAll()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(collections))
+	for i, collection := range collections {
+		ids[i] = collection.ID
+	}
+	matched := matchCollectionIDs(glob, ids)
+	if len(matched) > wildcardMaxCollections {
+		return nil, fmt.Errorf("FROM %s matches %d collections, more than %d", pattern, len(matched), wildcardMaxCollections)
+	}
+	for i, id := range matched {
+		if parent != "" {
+			matched[i] = parent + "/" + id
+		}
+	}
+	return matched, nil
+}
+
+// executeWildcardQuery runs a native query against every collection matching its wildcard
+// FROM with one client. The documents of the collections are merged before they are
+// grouped, ordered and limited, and their rows hold the __collection__ they come from.
+func (d *Datasource) executeWildcardQuery(ctx context.Context, pCtx backend.PluginContext, settings *FirestoreSettings, qm FirestoreQuery, queryInfo *QueryInfo, timeRange backend.TimeRange, meta *queryMeta) backend.DataResponse {
+	client, err := newFirestoreClient(ctx, pCtx)
+	if err != nil {
+		d.logger(ctx).Error("Failed to create Firestore client", "error", err)
+		return backend.ErrDataResponse(backend.StatusBadRequest, "Firestore client: "+err.Error())
+	}
+	defer client.Close()
+
+	// The collections are listed at the readTime their documents are read at
+	if err := applyReadTime(client, &qm, meta); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	collections, err := d.expandCollectionPattern(ctx, client, queryInfo.Collection)
+	if err != nil {
+		d.logger(ctx).Error("Expanding the collection pattern failed", "pattern", queryInfo.Collection, "error", err)
+		return firestoreErrorResponse("Collection pattern: ", err)
+	}
+	if len(collections) == 0 {
+		meta.executedQuery = qm.Query
+		meta.addNotice(data.NoticeSeverityInfo, fmt.Sprintf("No collection matches %s", queryInfo.Collection))
+		return backend.DataResponse{Frames: data.Frames{data.NewFrame("response", data.NewField(docCollectionColumn, nil, []string{}))}}
+	}
+
+	queryInfo.Collections = collections
+	grouped := len(queryInfo.GroupByFields) > 0 || len(queryInfo.AggregateFields) > 0
+	if !grouped && !slices.Contains(queryInfo.Fields, docCollectionColumn) {
+		// Rows are labelled with their collection, which keeps time series apart
+		queryInfo.Fields = append([]string{docCollectionColumn}, queryInfo.Fields...)
+	}
+	return d.executeNativeSDKQuery(ctx, client, settings, qm, queryInfo, timeRange, meta)
+}
+
+// mergeTrace describes the documents of a wildcard FROM merged in memory, for
+// ExecutedQueryString. The filters were already applied to each collection.
+func mergeTrace(queryInfo *QueryInfo, collections []string) []string {
+	trace := []string{fmt.Sprintf("union(%s)", strings.Join(collections, ", "))}
+	return append(trace, rowsTrace(queryInfo)[len(queryInfo.AdditionalFilters):]...)
+}
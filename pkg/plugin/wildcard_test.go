@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCollectionPattern(t *testing.T) {
+	parent, glob, err := splitCollectionPattern("logs_*")
+	require.NoError(t, err)
+	require.Empty(t, parent)
+	require.Equal(t, "logs_*", glob)
+
+	parent, glob, err = splitCollectionPattern("`tenants/acme/events_202?`")
+	require.NoError(t, err)
+	require.Equal(t, "tenants/acme", parent)
+	require.Equal(t, "events_202?", glob)
+
+	_, _, err = splitCollectionPattern("tenants/*/events")
+	require.Error(t, err)
+	_, _, err = splitCollectionPattern("logs_[")
+	require.Error(t, err)
+}
+
+func TestMatchCollectionIDs(t *testing.T) {
+	ids := []string{"users", "logs_2024_02", "logs_2024_01", "logs", "audit_logs_2024"}
+	require.Equal(t, []string{"logs_2024_01", "logs_2024_02"}, matchCollectionIDs("logs_*", ids))
+	require.Equal(t, []string{"logs_2024_01"}, matchCollectionIDs("logs_*_01", ids))
+	require.Empty(t, matchCollectionIDs("metrics_*", ids))
+}
+
+func TestWildcardMergesCollections(t *testing.T) {
+	countDocument := func(collection, id string, count int64) *firestorepb.Document {
+		return collectionDocument(collection, id, map[string]*firestorepb.Value{"count": {ValueType: &firestorepb.Value_IntegerValue{IntegerValue: count}}})
+	}
+	client := fakeFirestoreClient(t,
+		countDocument("logs_a", "a1", 3), countDocument("logs_a", "a2", 1), countDocument("logs_a", "a3", 4),
+		countDocument("logs_b", "b1", 5), countDocument("logs_b", "b2", 2),
+		countDocument("users", "u1", 9),
+	)
+	run := func(query string) (backend.DataResponse, *queryMeta) {
+		info, err := parseSQLQueryWithVariables(query)
+		require.NoError(t, err)
+		info.Collections = []string{"logs_a", "logs_b"}
+		meta := &queryMeta{}
+		qm := FirestoreQuery{Query: query, Format: formatTable, MaxRows: 100}
+		return (&Datasource{}).executeNativeSDKQuery(context.Background(), client, &FirestoreSettings{}, qm, info, backend.TimeRange{}, meta), meta
+	}
+
+	// The top rows of all the collections, not of each one
+	response, meta := run("SELECT __collection__, __name__, count FROM logs_* ORDER BY count DESC LIMIT 3")
+	require.NoError(t, response.Error)
+	frame := response.Frames[0]
+	require.Equal(t, 3, frame.Rows())
+	for i, want := range [][]string{{"logs_b", "b1"}, {"logs_a", "a3"}, {"logs_a", "a1"}} {
+		require.Equal(t, want[0], *frame.Fields[0].At(i).(*string))
+		require.Equal(t, want[1], *frame.Fields[1].At(i).(*string))
+	}
+	require.Contains(t, meta.executedQuery, "collection(logs_a)")
+	require.Contains(t, meta.executedQuery, "collection(logs_b)")
+	require.Contains(t, meta.executedQuery, "-- merged in memory: union(logs_a, logs_b).orderBy(count DESC).limit(3)")
+
+	// One group for all the collections, or one per collection grouping by __collection__
+	response, _ = run("SELECT COUNT(*) AS n, SUM(count) AS total FROM logs_*")
+	require.NoError(t, response.Error)
+	require.Equal(t, 1, response.Frames[0].Rows())
+	require.Equal(t, int64(5), response.Frames[0].Fields[0].At(0))
+
+	response, _ = run("SELECT __collection__, COUNT(*) AS n FROM logs_* GROUP BY __collection__ ORDER BY n DESC")
+	require.NoError(t, response.Error)
+	frame = response.Frames[0]
+	require.Equal(t, 2, frame.Rows())
+	require.Equal(t, "logs_a", frame.Fields[0].At(0))
+	require.Equal(t, int64(3), frame.Fields[1].At(0))
+}
+
+func TestCollectionMetadata(t *testing.T) {
+	docs := readSnapshots(t, validDocument("a"))
+	require.Equal(t, "events", documentMetadata(docs[0], docCollectionColumn))
+
+	// Subcollections are named by their path, as FROM names them
+	ref := fakeFirestoreClient(t).Doc("tenants/acme/events_2024/a")
+	require.Equal(t, "tenants/acme/events_2024", documentMetadata(&firestore.DocumentSnapshot{Ref: ref}, docCollectionColumn))
+}
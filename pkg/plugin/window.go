@@ -0,0 +1,203 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// windowPattern matches a window function over another aggregate of the query, such as
+// MOVING_AVG(total, 5) or MOVING_AVG(total, '30m')
+var windowPattern = regexp.MustCompile(`(?i)^(MOVING_AVG)\s*\(\s*([^,()]+?)\s*,\s*([^,()]+?)\s*\)$`)
+
+// windowFrame is the window of a window function: the current row and the Rows-1 rows
+// before it, or the rows whose time bucket is within Span of the current one
+type windowFrame struct {
+	Rows int
+	Span time.Duration
+}
+
+// parseWindowFunction parses a SELECT expression like MOVING_AVG(total, 5) AS smooth.
+// isWindow is false for other expressions.
+func parseWindowFunction(field string) (aggregate AggregateInfo, isWindow bool, err error) {
+	expr := stripAlias(field)
+	match := windowPattern.FindStringSubmatch(expr)
+	if match == nil {
+		return AggregateInfo{}, false, nil
+	}
+
+	aggregate = AggregateInfo{
+		Function: strings.ToUpper(match[1]),
+		Field:    cleanBackticks(match[2]),
+		Alias:    field,
+		Window:   &windowFrame{},
+	}
+	if idx := strings.LastIndex(strings.ToUpper(field), " AS "); idx != -1 {
+		aggregate.Alias = strings.TrimSpace(field[idx+4:])
+	}
+
+	size := strings.TrimSpace(match[3])
+	if rows, err := strconv.Atoi(size); err == nil {
+		if rows < 1 {
+			return aggregate, true, fmt.Errorf("%s: the window must be at least 1 row, got %d", aggregate.Function, rows)
+		}
+		aggregate.Window.Rows = rows
+		return aggregate, true, nil
+	}
+	span, err := time.ParseDuration(strings.Trim(size, `'"`))
+	if err != nil || span <= 0 {
+		return aggregate, true, fmt.Errorf("%s: invalid window %s, expected a number of rows or a duration like '30m'", aggregate.Function, size)
+	}
+	aggregate.Window.Span = span
+	return aggregate, true, nil
+}
+
+// validateWindowFunctions checks that every window function reads another aggregate of the query
+func validateWindowFunctions(info *QueryInfo) error {
+	for _, aggField := range info.AggregateFields {
+		if aggField.Window == nil {
+			continue
+		}
+		source := windowSourceIndex(info, aggField.Field)
+		if source == -1 {
+			return fmt.Errorf("%s(%s): %s is not an aggregate of the query", aggField.Function, aggField.Field, aggField.Field)
+		}
+		if info.AggregateFields[source].Function == "STRING_AGG" {
+			return fmt.Errorf("%s(%s): %s is not a numeric aggregate", aggField.Function, aggField.Field, aggField.Field)
+		}
+	}
+	return nil
+}
+
+// windowSourceIndex returns the index of the aggregate named by alias or function, -1 when
+// there is none. Window functions can't read other window functions.
+func windowSourceIndex(info *QueryInfo, name string) int {
+	for i, aggField := range info.AggregateFields {
+		if aggField.Window != nil {
+			continue
+		}
+		if strings.EqualFold(name, aggField.Alias) || name == aggregateFieldName(aggField) {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasWindowFunctions reports whether the query selects a window function
+func hasWindowFunctions(info *QueryInfo) bool {
+	for _, aggField := range info.AggregateFields {
+		if aggField.Window != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// applyWindowFunctions computes the window functions of the GROUP BY results over their series
+func applyWindowFunctions(results []AggregatedResult, queryInfo *QueryInfo) {
+	if !hasWindowFunctions(queryInfo) {
+		return
+	}
+
+	series, timeIdx := resultSeries(results, queryInfo)
+	for aggIdx, aggField := range queryInfo.AggregateFields {
+		if aggField.Window == nil {
+			continue
+		}
+		sourceIdx := windowSourceIndex(queryInfo, aggField.Field)
+		for _, rows := range series {
+			for pos, row := range rows {
+				window := windowRows(results, rows, pos, timeIdx, *aggField.Window)
+				results[row].AggregateValues[aggIdx] = movingAverage(results, window, sourceIdx)
+			}
+		}
+	}
+}
+
+// resultSeries splits the GROUP BY results into series, the rows of each in time bucket order.
+// A series is a combination of the group values besides the time bucket; without a time
+// bucket the results form one series in group value order. timeIdx is the group index of
+// the time bucket, -1 without one.
+func resultSeries(results []AggregatedResult, queryInfo *QueryInfo) (series [][]int, timeIdx int) {
+	timeIdx = -1
+	for i, groupField := range queryInfo.GroupByFields {
+		if groupField == queryInfo.TimeBucketField {
+			timeIdx = i
+		}
+	}
+
+	seriesRows := make(map[string][]int)
+	var keys []string
+	for i, result := range results {
+		key := ""
+		if timeIdx != -1 {
+			key = resultLabels(result, queryInfo, timeIdx).String()
+		}
+		if _, ok := seriesRows[key]; !ok {
+			keys = append(keys, key)
+		}
+		seriesRows[key] = append(seriesRows[key], i)
+	}
+	for _, key := range keys {
+		rows := seriesRows[key]
+		sort.Slice(rows, func(a, b int) bool {
+			if timeIdx != -1 {
+				return compareValues(results[rows[a]].GroupValues[timeIdx], results[rows[b]].GroupValues[timeIdx]) < 0
+			}
+			return compareGroupValues(results[rows[a]], results[rows[b]]) < 0
+		})
+		series = append(series, rows)
+	}
+	return series, timeIdx
+}
+
+// windowRows returns the rows of a series in the window ending at position pos
+func windowRows(results []AggregatedResult, rows []int, pos, timeIdx int, frame windowFrame) []int {
+	if frame.Span == 0 {
+		return rows[max(0, pos-frame.Rows+1) : pos+1]
+	}
+	end, ok := resultTime(results[rows[pos]], timeIdx)
+	if !ok {
+		return rows[pos : pos+1]
+	}
+	start := pos
+	for start > 0 {
+		ts, ok := resultTime(results[rows[start-1]], timeIdx)
+		if !ok || !ts.After(end.Add(-frame.Span)) {
+			break
+		}
+		start--
+	}
+	return rows[start : pos+1]
+}
+
+// resultTime returns the time bucket of a result
+func resultTime(result AggregatedResult, timeIdx int) (time.Time, bool) {
+	if timeIdx == -1 || timeIdx >= len(result.GroupValues) {
+		return time.Time{}, false
+	}
+	ts, ok := result.GroupValues[timeIdx].(time.Time)
+	return ts, ok
+}
+
+// movingAverage averages the source aggregate over the rows of a window, nil when none
+// of them has a value
+func movingAverage(results []AggregatedResult, window []int, sourceIdx int) interface{} {
+	sum, count := 0.0, 0
+	for _, row := range window {
+		if sourceIdx >= len(results[row].AggregateValues) {
+			continue
+		}
+		if numVal, err := convertToFloat(results[row].AggregateValues[sourceIdx]); err == nil {
+			sum += numVal
+			count++
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	return sum / float64(count)
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWindowFunction(t *testing.T) {
+	tests := []struct {
+		field     string
+		aggregate AggregateInfo
+		isWindow  bool
+		err       bool
+	}{
+		{"MOVING_AVG(total, 3) AS smooth", AggregateInfo{Function: "MOVING_AVG", Field: "total", Alias: "smooth", Window: &windowFrame{Rows: 3}}, true, false},
+		{"moving_avg(`total`, '30m')", AggregateInfo{Function: "MOVING_AVG", Field: "total", Alias: "moving_avg(`total`, '30m')", Window: &windowFrame{Span: 30 * time.Minute}}, true, false},
+		{"MOVING_AVG(total, 0)", AggregateInfo{}, true, true},
+		{"MOVING_AVG(total, 'soon')", AggregateInfo{}, true, true},
+		{"AVG(amount)", AggregateInfo{}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			aggregate, isWindow, err := parseWindowFunction(tt.field)
+			require.Equal(t, tt.isWindow, isWindow)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if isWindow {
+				require.Equal(t, tt.aggregate, aggregate)
+			}
+		})
+	}
+}
+
+func TestParseWindowFunctionQuery(t *testing.T) {
+	queryInfo, err := parseSQLQueryWithVariables("SELECT date_trunc('hour', ts), SUM(amount) AS total, MOVING_AVG(total, 3) AS smooth FROM orders GROUP BY date_trunc('hour', ts)")
+	require.NoError(t, err)
+	require.Len(t, queryInfo.AggregateFields, 2)
+	require.Equal(t, "MOVING_AVG", queryInfo.AggregateFields[1].Function)
+	require.Equal(t, []string{"ts", "amount"}, projectionFields(queryInfo, FirestoreQuery{}))
+
+	_, err = parseSQLQueryWithVariables("SELECT SUM(amount) AS total, MOVING_AVG(sales, 3) FROM orders")
+	require.ErrorContains(t, err, "sales is not an aggregate")
+}
+
+func TestApplyWindowFunctions(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	hour := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Hour) }
+	queryInfo := &QueryInfo{
+		GroupByFields: []string{"ts", "brand"},
+		AggregateFields: []AggregateInfo{
+			{Function: "SUM", Field: "amount", Alias: "total"},
+			{Function: "MOVING_AVG", Field: "total", Alias: "rows", Window: &windowFrame{Rows: 2}},
+			{Function: "MOVING_AVG", Field: "total", Alias: "span", Window: &windowFrame{Span: 2 * time.Hour}},
+		},
+		TimeBucketField: "ts",
+		TimeBucket:      time.Hour,
+	}
+	// Unordered, hour 2 of yoigo is missing
+	results := []AggregatedResult{
+		{GroupValues: []interface{}{hour(3), "yoigo"}, AggregateValues: []interface{}{int64(8), nil, nil}},
+		{GroupValues: []interface{}{hour(0), "yoigo"}, AggregateValues: []interface{}{int64(2), nil, nil}},
+		{GroupValues: []interface{}{hour(1), "yoigo"}, AggregateValues: []interface{}{int64(4), nil, nil}},
+		{GroupValues: []interface{}{hour(0), "masmovil"}, AggregateValues: []interface{}{int64(100), nil, nil}},
+	}
+
+	applyWindowFunctions(results, queryInfo)
+
+	require.Equal(t, []interface{}{int64(8), 6.0, 8.0}, results[0].AggregateValues)
+	require.Equal(t, []interface{}{int64(2), 2.0, 2.0}, results[1].AggregateValues)
+	require.Equal(t, []interface{}{int64(4), 3.0, 3.0}, results[2].AggregateValues)
+	require.Equal(t, []interface{}{int64(100), 100.0, 100.0}, results[3].AggregateValues)
+}
+
+func TestApplyWindowFunctionsWithoutTimeBucket(t *testing.T) {
+	queryInfo := &QueryInfo{
+		GroupByFields: []string{"day"},
+		AggregateFields: []AggregateInfo{
+			{Function: "COUNT", Field: "*", Alias: "COUNT(*)"},
+			{Function: "MOVING_AVG", Field: "count", Alias: "smooth", Window: &windowFrame{Rows: 3}},
+		},
+	}
+	results := []AggregatedResult{
+		{GroupValues: []interface{}{int64(2)}, AggregateValues: []interface{}{int64(6), nil}},
+		{GroupValues: []interface{}{int64(1)}, AggregateValues: []interface{}{int64(3), nil}},
+	}
+
+	applyWindowFunctions(results, queryInfo)
+
+	require.Equal(t, 4.5, results[0].AggregateValues[1])
+	require.Equal(t, 3.0, results[1].AggregateValues[1])
+}
@@ -1,7 +1,52 @@
 import React, { ChangeEvent, PureComponent } from 'react';
-import { InlineField, Input, SecretTextArea } from '@grafana/ui';
-import { DataSourcePluginOptionsEditorProps } from '@grafana/data';
-import { FirestoreSecureJsonData, MyDataSourceOptions } from '../types';
+import { InlineField, InlineSwitch, Input, SecretTextArea, Select } from '@grafana/ui';
+import { DataSourcePluginOptionsEditorProps, SelectableValue } from '@grafana/data';
+import { AuthType, Downsample, FirestoreSecureJsonData, LogLevel, MyDataSourceOptions, TimeFormat } from '../types';
+
+const authTypeOptions: Array<SelectableValue<AuthType>> = [
+  { label: 'Service account key', value: 'serviceAccount' },
+  { label: 'Application Default Credentials', value: 'adc' },
+  { label: 'Forward OAuth identity', value: 'oauthPassThru' },
+];
+
+const timeFormatOptions: Array<SelectableValue<TimeFormat>> = [
+  { label: 'Firestore Timestamp', value: 'timestamp' },
+  { label: 'Unix milliseconds', value: 'unix_ms' },
+  { label: 'Unix seconds', value: 'unix_s' },
+  { label: 'Unix microseconds', value: 'unix_us' },
+  { label: 'Unix nanoseconds', value: 'unix_ns' },
+  { label: 'RFC3339 string', value: 'rfc3339' },
+];
+
+const downsampleOptions: Array<SelectableValue<Downsample>> = [
+  { label: 'Average', value: 'avg' },
+  { label: 'Last', value: 'last' },
+  { label: 'None', value: 'none' },
+];
+
+const logLevelOptions: Array<SelectableValue<LogLevel>> = [
+  { label: 'Debug', value: 'debug' },
+  { label: 'Info', value: 'info' },
+  { label: 'Warn', value: 'warn' },
+  { label: 'Error', value: 'error' },
+];
+
+// Mappings such as the collection time fields are edited as "events=ts, orders=createdAt"
+const parseMapping = (text: string): Record<string, string> | undefined => {
+  const mapping: Record<string, string> = {};
+  for (const pair of text.split(',')) {
+    const [key, value] = pair.split('=').map((part) => part.trim());
+    if (key && value) {
+      mapping[key] = value;
+    }
+  }
+  return Object.keys(mapping).length > 0 ? mapping : undefined;
+};
+
+const formatMapping = (mapping?: Record<string, string>): string =>
+  Object.entries(mapping || {})
+    .map(([key, value]) => `${key}=${value}`)
+    .join(', ');
 
 interface Props extends DataSourcePluginOptionsEditorProps<MyDataSourceOptions> { }
 
@@ -19,6 +64,281 @@ export class ConfigEditor extends PureComponent<Props, State> {
     });
   };
 
+  onAuthTypeChange = (option: SelectableValue<AuthType>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        authType: option.value,
+        // Grafana only forwards the user's token to datasources with oauthPassThru set
+        oauthPassThru: option.value === 'oauthPassThru',
+      },
+    });
+  };
+
+  onEndpointChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        endpoint: event.target.value.trim(),
+      },
+    });
+  };
+
+  onEmulatorHostChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        emulatorHost: event.target.value.trim(),
+      },
+    });
+  };
+
+  onHealthCheckCollectionChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        healthCheckCollection: event.target.value.trim(),
+      },
+    });
+  };
+
+  onCollectionTimeFieldsChange = (text: string) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        collectionTimeFields: parseMapping(text),
+      },
+    });
+  };
+
+  onFieldTypesChange = (text: string) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        fieldTypes: parseMapping(text),
+      },
+    });
+  };
+
+  onTemplatesCollectionChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        templatesCollection: event.target.value.trim(),
+      },
+    });
+  };
+
+  onTimeFormatChange = (option: SelectableValue<TimeFormat>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        timeFormat: option.value,
+      },
+    });
+  };
+
+  onTimezoneChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        timezone: event.target.value.trim(),
+      },
+    });
+  };
+
+  onMaxRowsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const value = parseInt(event.target.value, 10);
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        maxRows: isNaN(value) ? undefined : value,
+      },
+    });
+  };
+
+  onDefaultLimitChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const value = parseInt(event.target.value, 10);
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        defaultLimit: isNaN(value) ? undefined : value,
+      },
+    });
+  };
+
+  onDownsampleChange = (option: SelectableValue<Downsample>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        downsample: option.value,
+      },
+    });
+  };
+
+  onTimeoutSecondsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const value = parseInt(event.target.value, 10);
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        timeoutSeconds: isNaN(value) ? undefined : value,
+      },
+    });
+  };
+
+  onMaxRetriesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const value = parseInt(event.target.value, 10);
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        maxRetries: isNaN(value) ? undefined : value,
+      },
+    });
+  };
+
+  onMemoryBudgetChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const value = parseInt(event.target.value, 10);
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        memoryBudgetMB: isNaN(value) ? undefined : value,
+      },
+    });
+  };
+
+  onStreamMaxUpdatesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const value = parseFloat(event.target.value);
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        streamMaxUpdatesPerSecond: isNaN(value) ? undefined : value,
+      },
+    });
+  };
+
+  onStreamBatchWindowChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const value = parseInt(event.target.value, 10);
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        streamBatchWindowMs: isNaN(value) ? undefined : value,
+      },
+    });
+  };
+
+  onMaxConcurrentQueriesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const value = parseInt(event.target.value, 10);
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        maxConcurrentQueries: isNaN(value) ? undefined : value,
+      },
+    });
+  };
+
+  onMaxActiveQueriesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const value = parseInt(event.target.value, 10);
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        maxActiveQueries: isNaN(value) ? undefined : value,
+      },
+    });
+  };
+
+  onLogLevelChange = (option: SelectableValue<LogLevel>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        logLevel: option.value,
+      },
+    });
+  };
+
+  onAuditLogChange = (event: React.FormEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        auditLog: event.currentTarget.checked,
+      },
+    });
+  };
+
+  onAuditLogPathChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        auditLogPath: event.target.value.trim(),
+      },
+    });
+  };
+
+  onDataplaneLogsChange = (event: React.FormEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        dataplaneLogs: event.currentTarget.checked,
+      },
+    });
+  };
+
+  onDebugChange = (event: React.FormEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      jsonData: {
+        ...options.jsonData,
+        debug: event.currentTarget.checked,
+      },
+    });
+  };
+
   // Secure field (only sent to the backend)
   onServiceAccountChange = (event: ChangeEvent<HTMLTextAreaElement>) => {
     const { onOptionsChange, options } = this.props;
@@ -61,7 +381,16 @@ export class ConfigEditor extends PureComponent<Props, State> {
               placeholder="Unique identifier for the GCP Project"
               width={40}></Input>
           </InlineField>
-          <InlineField required label="Service Account" labelWidth={20}
+          <InlineField label="Authentication" labelWidth={20}
+            tooltip="Application Default Credentials use the identity of the Grafana server, e.g. Workload Identity on GKE or the GCE metadata server, instead of a service account key. Forward OAuth identity runs queries as the signed-in user and requires Google OAuth login in Grafana.">
+            <Select
+              options={authTypeOptions}
+              value={jsonData.authType || 'serviceAccount'}
+              onChange={this.onAuthTypeChange}
+              width={40}
+            />
+          </InlineField>
+          {(jsonData.authType || 'serviceAccount') === 'serviceAccount' && <InlineField required label="Service Account" labelWidth={20}
             tooltip="Service Account having previliges to read all firestore resources. Least role expected is 'roles/datastore.viewer'">
             <SecretTextArea
               label="Service Account"
@@ -82,6 +411,200 @@ export class ConfigEditor extends PureComponent<Props, State> {
               cols={80}
               rows={10}
             />
+          </InlineField>}
+          <InlineField label="Endpoint" labelWidth={20}
+            tooltip="Firestore API endpoint (host:port) to use instead of firestore.googleapis.com:443, e.g. a Private Service Connect or restricted VIP endpoint. Queries then always use the native Firestore SDK.">
+            <Input
+              onChange={this.onEndpointChange}
+              value={jsonData.endpoint || ''}
+              placeholder="firestore.googleapis.com:443"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Emulator host" labelWidth={20}
+            tooltip="Firestore emulator (host:port) to query instead of Google Cloud, for local development and staging. Credentials are not used. Queries then always use the native Firestore SDK.">
+            <Input
+              onChange={this.onEmulatorHostChange}
+              value={jsonData.emulatorHost || ''}
+              placeholder="localhost:8080"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Health check collection" labelWidth={20}
+            tooltip="Collection read by Save & test to verify the credentials can read the documents dashboards use, not only list collections. The read latency is reported.">
+            <Input
+              onChange={this.onHealthCheckCollectionChange}
+              value={jsonData.healthCheckCollection || ''}
+              placeholder="Optional"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Time fields" labelWidth={20}
+            tooltip="Time field of each collection, as collection=field pairs. Queries of these collections that don't filter a time field with $__from and $__to are filtered by the panel time range on it, and the health check reports the latest time of the health check collection. A collection ID also applies to the subcollections with that ID.">
+            <Input
+              defaultValue={formatMapping(jsonData.collectionTimeFields)}
+              onBlur={(e) => this.onCollectionTimeFieldsChange(e.currentTarget.value)}
+              placeholder="events=ts, orders=createdAt"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Field types" labelWidth={20}
+            tooltip="Type the values of a field are read as, for fields whose type changed over time, as field=type pairs. A field written as collection:field applies to that collection ID only, other fields are field paths of every collection. Types: string, number, boolean, json, seconds, milliseconds, timestamp, unix_ms, unix_s, unix_us, unix_ns and rfc3339. A time type sets the epoch unit of a time field.">
+            <Input
+              defaultValue={formatMapping(jsonData.fieldTypes)}
+              onBlur={(e) => this.onFieldTypesChange(e.currentTarget.value)}
+              placeholder="sessions:duration=seconds, payload=json"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Templates collection" labelWidth={20}
+            tooltip="Collection storing the query templates shared by the users of this data source. Editors and admins can save templates, which needs write permission on this collection only. Templates are disabled when empty.">
+            <Input
+              onChange={this.onTemplatesCollectionChange}
+              value={jsonData.templatesCollection || ''}
+              placeholder="Optional"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Time format" labelWidth={20}
+            tooltip="How time fields are stored in Firestore. Used to build time filters and to convert values to time columns. Can be overridden per query.">
+            <Select
+              options={timeFormatOptions}
+              value={jsonData.timeFormat || 'timestamp'}
+              onChange={this.onTimeFormatChange}
+              width={40}
+            />
+          </InlineField>
+          <InlineField label="Timezone" labelWidth={20}
+            tooltip="IANA timezone (e.g. Europe/Madrid) used to interpret date strings stored without an offset. Defaults to UTC.">
+            <Input
+              onChange={this.onTimezoneChange}
+              value={jsonData.timezone || ''}
+              placeholder="UTC"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Max rows" labelWidth={20}
+            tooltip="Maximum rows returned by a query. Larger results are truncated with a warning. Can be overridden per query. Defaults to 10000.">
+            <Input
+              type="number"
+              min={1}
+              onChange={this.onMaxRowsChange}
+              value={jsonData.maxRows ?? ''}
+              placeholder="10000"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Default limit" labelWidth={20}
+            tooltip="LIMIT of queries without one, so a query can't read a whole collection by accident. Aggregates and histograms still read every matching document. Empty leaves queries capped by max rows only.">
+            <Input
+              type="number"
+              min={1}
+              onChange={this.onDefaultLimitChange}
+              value={jsonData.defaultLimit ?? ''}
+              placeholder="Optional"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Downsample" labelWidth={20}
+            tooltip="How time series with more points than the panel shows are bucketed into intervals: the average or the last value of each interval, or not at all. Can be overridden per query.">
+            <Select
+              options={downsampleOptions}
+              value={jsonData.downsample || 'avg'}
+              onChange={this.onDownsampleChange}
+              width={40}
+            />
+          </InlineField>
+          <InlineField label="Query timeout" labelWidth={20}
+            tooltip="Seconds a query may run before it fails with a timeout error. Can be overridden per query. Defaults to 30.">
+            <Input
+              type="number"
+              min={1}
+              onChange={this.onTimeoutSecondsChange}
+              value={jsonData.timeoutSeconds ?? ''}
+              placeholder="30"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Max retries" labelWidth={20}
+            tooltip="How often queries failing with a transient Firestore error (unavailable, deadline exceeded, quota) are retried with backoff. Set 0 to disable retries. Defaults to 3.">
+            <Input
+              type="number"
+              min={0}
+              onChange={this.onMaxRetriesChange}
+              value={jsonData.maxRetries ?? ''}
+              placeholder="3"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Memory budget (MB)" labelWidth={20}
+            tooltip="Approximate memory a single query may use while its results are built. Larger results fail with an error asking for a LIMIT or a narrower time range. Defaults to 256.">
+            <Input
+              type="number"
+              min={1}
+              onChange={this.onMemoryBudgetChange}
+              value={jsonData.memoryBudgetMB ?? ''}
+              placeholder="256"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Stream updates/s" labelWidth={20}
+            tooltip="Maximum updates per second sent to the panels of a streaming query, changes in between are sent together. Defaults to 10.">
+            <Input
+              type="number"
+              min={0}
+              step={0.1}
+              onChange={this.onStreamMaxUpdatesChange}
+              value={jsonData.streamMaxUpdatesPerSecond ?? ''}
+              placeholder="10"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Stream batch (ms)" labelWidth={20}
+            tooltip="How long a change of a streaming query waits for more changes before they are sent together. Defaults to 0.">
+            <Input
+              type="number"
+              min={0}
+              onChange={this.onStreamBatchWindowChange}
+              value={jsonData.streamBatchWindowMs ?? ''}
+              placeholder="0"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Max concurrent queries" labelWidth={20}
+            tooltip="Number of queries of a dashboard refresh executed at the same time. Defaults to 10.">
+            <Input
+              type="number"
+              min={1}
+              onChange={this.onMaxConcurrentQueriesChange}
+              value={jsonData.maxConcurrentQueries ?? ''}
+              placeholder="10"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Max active queries" labelWidth={20}
+            tooltip="Number of queries of every dashboard and user executed at the same time on this datasource, the others wait for a free slot within their timeout. Defaults to 50.">
+            <Input
+              type="number"
+              min={1}
+              onChange={this.onMaxActiveQueriesChange}
+              value={jsonData.maxActiveQueries ?? ''}
+              placeholder="50"
+              width={40}></Input>
+          </InlineField>
+          <InlineField label="Log level" labelWidth={20}
+            tooltip="Minimum level of the plugin logs written for this datasource. Defaults to Info.">
+            <Select
+              options={logLevelOptions}
+              value={jsonData.logLevel || 'info'}
+              onChange={this.onLogLevelChange}
+              width={40}
+            />
+          </InlineField>
+          <InlineField label="Audit log" labelWidth={20}
+            tooltip="Record every executed query with the Grafana user and org, the collection and the documents read.">
+            <InlineSwitch value={jsonData.auditLog || false} onChange={this.onAuditLogChange} />
+          </InlineField>
+          {jsonData.auditLog && <InlineField label="Audit log file" labelWidth={20}
+            tooltip="File on the Grafana server the audit records are appended to as JSON lines. Defaults to the plugin logs.">
+            <Input
+              onChange={this.onAuditLogPathChange}
+              value={jsonData.auditLogPath || ''}
+              placeholder="Plugin logs"
+              width={40}></Input>
+          </InlineField>}
+          <InlineField label="Dataplane logs" labelWidth={20}
+            tooltip="Name the fields of log frames timestamp, body and severity, as dataplane log lines, instead of time, body and level. Panels transforming the time or level fields need updating.">
+            <InlineSwitch value={jsonData.dataplaneLogs || false} onChange={this.onDataplaneLogsChange} />
+          </InlineField>
+          <InlineField label="Debug" labelWidth={20}
+            tooltip="Log query diagnostics and show which engine executed each query. Leave disabled in production.">
+            <InlineSwitch value={jsonData.debug || false} onChange={this.onDebugChange} />
           </InlineField>
         </div>
 
@@ -1,14 +1,77 @@
 import React, { ChangeEvent, PureComponent } from 'react';
 import {
-  QueryField, Button
+  QueryField, Button, InlineField, InlineSwitch, Input, RadioButtonGroup
 } from '@grafana/ui';
 // import { FieldValues } from "react-hook-form"
 import { QueryEditorProps } from '@grafana/data';
 import { DataSource } from '../datasource';
-import { MyDataSourceOptions, FirestoreQuery } from '../types';
+import { MyDataSourceOptions, FirestoreQuery, QueryFormat, FillPolicy, Downsample, FieldOptions, LookupOptions, QueryParam } from '../types';
+
+const formatOptions = [
+  { label: 'Table', value: 'table' as QueryFormat },
+  { label: 'Time series', value: 'time_series' as QueryFormat },
+  { label: 'Logs', value: 'logs' as QueryFormat },
+  { label: 'Histogram', value: 'histogram' as QueryFormat },
+  { label: 'Heatmap', value: 'heatmap' as QueryFormat },
+];
+
+const fillOptions = [
+  { label: 'None', value: '' as FillPolicy | '' },
+  { label: 'Null', value: 'null' as FillPolicy | '' },
+  { label: 'Zero', value: 'zero' as FillPolicy | '' },
+  { label: 'Previous', value: 'previous' as FillPolicy | '' },
+];
+
+const downsampleOptions = [
+  { label: 'Default', value: '' as Downsample | '' },
+  { label: 'Average', value: 'avg' as Downsample | '' },
+  { label: 'Last', value: 'last' as Downsample | '' },
+  { label: 'None', value: 'none' as Downsample | '' },
+];
 
 type Props = QueryEditorProps<DataSource, FirestoreQuery, MyDataSourceOptions>;
 
+// Params are edited as name=value pairs: quoted values are strings, true, false, null and
+// numbers keep their type, anything else like $msisdn is a string
+const parseParams = (text: string): Record<string, QueryParam> | undefined => {
+  const params: Record<string, QueryParam> = {};
+  for (const pair of text.split(',')) {
+    const idx = pair.indexOf('=');
+    const name = pair.slice(0, idx).trim();
+    if (idx === -1 || !name) {
+      continue;
+    }
+    const raw = pair.slice(idx + 1).trim();
+    if (/^(['"]).*\1$/.test(raw)) {
+      params[name] = raw.slice(1, -1);
+    } else if (raw === 'true' || raw === 'false') {
+      params[name] = raw === 'true';
+    } else if (raw === 'null') {
+      params[name] = null;
+    } else if (raw !== '' && !isNaN(Number(raw))) {
+      params[name] = Number(raw);
+    } else {
+      params[name] = raw;
+    }
+  }
+  return Object.keys(params).length > 0 ? params : undefined;
+};
+
+const formatParams = (params?: Record<string, QueryParam>): string =>
+  Object.entries(params || {})
+    .map(([name, value]) => `${name}=${typeof value === 'string' && /^(true|false|null|-?[\d.]+)$/.test(value) ? `'${value}'` : value}`)
+    .join(', ');
+
+// Field config is edited as JSON keyed by column, text that isn't a JSON object clears it
+const parseFieldConfig = (text: string): Record<string, FieldOptions> | undefined => {
+  try {
+    const parsed = JSON.parse(text);
+    return parsed && typeof parsed === 'object' && !Array.isArray(parsed) && Object.keys(parsed).length ? parsed : undefined;
+  } catch {
+    return undefined;
+  }
+};
+
 export class QueryEditor extends PureComponent<Props> {
   timeoutId: NodeJS.Timeout | undefined
   onCollectionChange = (event: ChangeEvent<HTMLInputElement>) => {
@@ -23,6 +86,123 @@ export class QueryEditor extends PureComponent<Props> {
     // this.runQuery(onRunQuery)
   };
 
+  onFormatChange = (format: QueryFormat) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, format });
+    this.runQuery(onRunQuery)
+  };
+
+  onFillChange = (fill: FillPolicy | '') => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, fill: fill || undefined });
+    this.runQuery(onRunQuery)
+  };
+
+  onDownsampleChange = (downsample: Downsample | '') => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, downsample: downsample || undefined });
+    this.runQuery(onRunQuery)
+  };
+
+  onLogMessageFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, logMessageField: event.target.value.trim() });
+  };
+
+  onLogLevelFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, logLevelField: event.target.value.trim() });
+  };
+
+  onHistogramFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, histogramField: event.target.value.trim() });
+  };
+
+  onHistogramBucketWidthChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    const width = parseFloat(event.target.value);
+    onChange({ ...query, histogramBucketWidth: width > 0 ? width : undefined });
+  };
+
+  onHistogramBucketsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    const buckets = parseInt(event.target.value, 10);
+    onChange({ ...query, histogramBuckets: buckets > 0 ? buckets : undefined });
+  };
+
+  onLookupChange = (change: Partial<LookupOptions>) => {
+    const { onChange, query } = this.props;
+    const lookup = { collection: '', key: '', fields: [], ...query.lookup, ...change };
+    // Without a collection the lookup is off
+    onChange({ ...query, lookup: lookup.collection ? lookup : undefined });
+  };
+
+  onParamsChange = (text: string) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, params: parseParams(text) });
+  };
+
+  onFieldConfigChange = (text: string) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, fieldConfig: parseFieldConfig(text) });
+  };
+
+  onDecimalsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    const decimals = parseInt(event.target.value, 10);
+    onChange({ ...query, decimals: decimals >= 0 ? decimals : undefined });
+  };
+
+  onExplainChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, explain: event.currentTarget.checked });
+    this.runQuery(onRunQuery)
+  };
+
+  onConvertToWideChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, convertToWide: event.currentTarget.checked });
+    this.runQuery(onRunQuery)
+  };
+
+  onAlertingChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, alerting: event.currentTarget.checked });
+    this.runQuery(onRunQuery)
+  };
+
+  onStreamChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, stream: event.currentTarget.checked });
+    this.runQuery(onRunQuery)
+  };
+
+  onTailChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, tail: event.currentTarget.checked });
+    this.runQuery(onRunQuery)
+  };
+
+  onTotalCountChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, totalCount: event.currentTarget.checked });
+    this.runQuery(onRunQuery)
+  };
+
+  onPageSizeChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    const pageSize = parseInt(event.target.value, 10);
+    // Another page size starts over from the first page
+    onChange({ ...query, pageSize: pageSize > 0 ? pageSize : undefined, pageToken: undefined });
+  };
+
+  onPageChange = (pageToken?: string) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, pageToken });
+    this.runQuery(onRunQuery)
+  };
+
   // Time field removed - users should use $__from and $__to variables in queries
 
   onRunQuery = () => {
@@ -45,7 +225,10 @@ export class QueryEditor extends PureComponent<Props> {
   }
 
   render() {
-    const { query } = this.props.query;
+    const { query, format, logMessageField, logLevelField, explain, alerting, stream, tail, pageSize, pageToken, totalCount, convertToWide, fill, downsample, histogramField, histogramBucketWidth, histogramBuckets, lookup, params, fieldConfig, decimals } = this.props.query;
+
+    // The cursor of the next page is returned in the meta of the current one
+    const nextPageToken = this.props.data?.series[0]?.meta?.custom?.nextPageToken;
 
     return (
       <div>
@@ -53,6 +236,100 @@ export class QueryEditor extends PureComponent<Props> {
          <QueryField query={query} placeholder="FireQL query (use $__from and $__to for time filtering)" portalOrigin="" onChange={this.onQueryChange}></QueryField>
          <Button style={{marginLeft: "10px"}} onClick={this.onRunQuery}>Run query</Button>
         </div>
+        <div className="gf-form">
+          <InlineField label="Format" labelWidth={14}>
+            <RadioButtonGroup options={formatOptions} value={format || 'table'} onChange={this.onFormatChange} />
+          </InlineField>
+          <InlineField label="Fill" labelWidth={8} tooltip="Rows for the empty time buckets of a GROUP BY">
+            <RadioButtonGroup options={fillOptions} value={fill || ''} onChange={this.onFillChange} />
+          </InlineField>
+          {format === 'time_series' && (
+            <InlineField label="Downsample" labelWidth={14} tooltip="How points beyond the panel's max data points are bucketed into intervals, defaults to the datasource setting">
+              <RadioButtonGroup options={downsampleOptions} value={downsample || ''} onChange={this.onDownsampleChange} />
+            </InlineField>
+          )}
+          {format === 'time_series' && (
+            <InlineField label="Wide" labelWidth={8} tooltip="Pivot rows of time, string columns and values into a series per label set, filling missing points with the Fill policy">
+              <InlineSwitch value={convertToWide || false} onChange={this.onConvertToWideChange} />
+            </InlineField>
+          )}
+          <InlineField label="Explain" labelWidth={10} tooltip="Profile the query and show its plan, indexes used and documents scanned instead of the results">
+            <InlineSwitch value={explain || false} onChange={this.onExplainChange} />
+          </InlineField>
+          <InlineField label="Alerting" labelWidth={10} tooltip="Return numeric series labelled by the string columns, as alert rules and expressions evaluate them, and fail on columns they can't evaluate">
+            <InlineSwitch value={alerting || false} onChange={this.onAlertingChange} />
+          </InlineField>
+          <InlineField label="Stream" labelWidth={10} tooltip="Push changes of the matching documents to the panel as they happen, over Grafana Live">
+            <InlineSwitch value={stream || false} onChange={this.onStreamChange} />
+          </InlineField>
+          <InlineField label="Tail" labelWidth={8} tooltip="Stream the documents written after the end of the time range, newest first by the time field, to watch incoming events">
+            <InlineSwitch value={tail || false} onChange={this.onTailChange} />
+          </InlineField>
+          <InlineField label="Total count" labelWidth={12} tooltip="Count the documents matching the filters server-side when a LIMIT, a page or maxRows cuts the results, reported as totalCount in the frame meta">
+            <InlineSwitch value={totalCount || false} onChange={this.onTotalCountChange} />
+          </InlineField>
+          <InlineField label="Page size" labelWidth={12} tooltip="Read the results a page at a time instead of truncating them at maxRows">
+            <Input type="number" value={pageSize ?? ''} width={10} onChange={this.onPageSizeChange} onBlur={this.onRunQuery} />
+          </InlineField>
+          {!!pageSize && (
+            <>
+              <Button variant="secondary" disabled={!pageToken} onClick={() => this.onPageChange(undefined)}>First page</Button>
+              <Button variant="secondary" style={{marginLeft: "4px"}} disabled={!nextPageToken} onClick={() => this.onPageChange(nextPageToken)}>Next page</Button>
+            </>
+          )}
+          {format === 'logs' && (
+            <>
+              <InlineField label="Message field" labelWidth={16} tooltip="Field used as the log line body (defaults to 'message')">
+                <Input value={logMessageField || ''} placeholder="message" width={20} onChange={this.onLogMessageFieldChange} onBlur={this.onRunQuery} />
+              </InlineField>
+              <InlineField label="Level field" labelWidth={14} tooltip="Field mapped to the log level">
+                <Input value={logLevelField || ''} placeholder="level" width={20} onChange={this.onLogLevelFieldChange} onBlur={this.onRunQuery} />
+              </InlineField>
+            </>
+          )}
+          {(format === 'histogram' || format === 'heatmap') && (
+            <>
+              <InlineField label="Field" labelWidth={10} tooltip="Numeric field counted into the histogram buckets">
+                <Input value={histogramField || ''} placeholder="latencyMs" width={20} onChange={this.onHistogramFieldChange} onBlur={this.onRunQuery} />
+              </InlineField>
+              <InlineField label="Bucket width" labelWidth={14} tooltip="Width of each bucket, takes precedence over the bucket count">
+                <Input type="number" value={histogramBucketWidth ?? ''} width={12} onChange={this.onHistogramBucketWidthChange} onBlur={this.onRunQuery} />
+              </InlineField>
+              <InlineField label="Buckets" labelWidth={10} tooltip="Number of buckets spanning the values (defaults to 10)">
+                <Input type="number" value={histogramBuckets ?? ''} placeholder="10" width={10} onChange={this.onHistogramBucketsChange} onBlur={this.onRunQuery} />
+              </InlineField>
+            </>
+          )}
+        </div>
+        <div className="gf-form">
+          <InlineField label="Lookup" labelWidth={14} tooltip="Collection whose documents enrich the results, read once and cached">
+            <Input value={lookup?.collection || ''} placeholder="customers" width={20} onChange={(e: ChangeEvent<HTMLInputElement>) => this.onLookupChange({ collection: e.target.value.trim() })} onBlur={this.onRunQuery} />
+          </InlineField>
+          {lookup && (
+            <>
+              <InlineField label="Key" labelWidth={8} tooltip="Result column holding the looked up value">
+                <Input value={lookup.key} placeholder="customerId" width={16} onChange={(e: ChangeEvent<HTMLInputElement>) => this.onLookupChange({ key: e.target.value.trim() })} onBlur={this.onRunQuery} />
+              </InlineField>
+              <InlineField label="By" labelWidth={8} tooltip="Field of the lookup documents matching the key, the document ID when empty">
+                <Input value={lookup.by || ''} placeholder="__name__" width={16} onChange={(e: ChangeEvent<HTMLInputElement>) => this.onLookupChange({ by: e.target.value.trim() || undefined })} onBlur={this.onRunQuery} />
+              </InlineField>
+              <InlineField label="Fields" labelWidth={10} tooltip="Fields of the lookup documents added as columns, comma separated">
+                <Input value={lookup.fields.join(', ')} placeholder="name, segment" width={24} onChange={(e: ChangeEvent<HTMLInputElement>) => this.onLookupChange({ fields: e.target.value.split(',').map((f) => f.trim()).filter((f) => f) })} onBlur={this.onRunQuery} />
+              </InlineField>
+            </>
+          )}
+        </div>
+        <div className="gf-form">
+          <InlineField label="Params" labelWidth={14} tooltip="Values of the :name parameters of the query as name=value pairs, comma separated. Quote numbers to bind them as strings">
+            <Input defaultValue={formatParams(params)} placeholder="msisdn=$msisdn, minTotal=10" width={60} onBlur={(e) => { this.onParamsChange(e.currentTarget.value); this.onRunQuery(); }} />
+          </InlineField>
+          <InlineField label="Field config" labelWidth={14} tooltip="Display name, unit and decimals of result columns as JSON keyed by column. Display names may use ${__field.labels.name}">
+            <Input defaultValue={fieldConfig ? JSON.stringify(fieldConfig) : ''} placeholder='{"total": {"displayName": "Total", "unit": "currencyEUR", "decimals": 2}}' width={60} onBlur={(e) => { this.onFieldConfigChange(e.currentTarget.value); this.onRunQuery(); }} />
+          </InlineField>
+          <InlineField label="Decimals" labelWidth={10} tooltip="Rounds the float columns the query computes, like averages and ratios, to this many decimals. The decimals of a column in Field config win">
+            <Input type="number" value={decimals ?? ''} width={8} onChange={this.onDecimalsChange} onBlur={this.onRunQuery} />
+          </InlineField>
+        </div>
       </div>
     );
   }
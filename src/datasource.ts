@@ -1,7 +1,13 @@
-import { DataSourceInstanceSettings, CoreApp } from '@grafana/data';
-import { DataSourceWithBackend } from '@grafana/runtime';
+import { DataSourceInstanceSettings, CoreApp, ScopedVars } from '@grafana/data';
+import {
+  DataSourceWithBackend,
+  StreamingFrameAction,
+  StreamOptionsProvider,
+  getBackendSrv,
+  getTemplateSrv,
+} from '@grafana/runtime';
 
-import { FirestoreQuery, MyDataSourceOptions, DEFAULT_QUERY } from './types';
+import { CollectionSchema, FieldValues, FirestoreQuery, MyDataSourceOptions, QueryCatalog, QueryParam, QueryTemplate, DEFAULT_QUERY } from './types';
 
 export class DataSource extends DataSourceWithBackend<FirestoreQuery, MyDataSourceOptions> {
   constructor(instanceSettings: DataSourceInstanceSettings<MyDataSourceOptions>) {
@@ -11,4 +17,81 @@ export class DataSource extends DataSourceWithBackend<FirestoreQuery, MyDataSour
   getDefaultQuery(_: CoreApp): Partial<FirestoreQuery> {
     return DEFAULT_QUERY
   }
+
+  // Each frame of a stream is the whole result, replacing the panel's, while a tail appends
+  // the documents written since its last frame, keeping the time range of a recent range
+  streamOptionsProvider: StreamOptionsProvider<FirestoreQuery> = (request, frame) => {
+    const query = request.targets.find((target) => target.refId === frame.refId);
+    const maxLength = request.maxDataPoints ?? 500;
+    if (!query?.tail) {
+      return { maxLength: Math.max(maxLength, frame.length, query?.maxRows ?? 0), action: StreamingFrameAction.Replace };
+    }
+    return {
+      maxLength,
+      action: StreamingFrameAction.Append,
+      ...(request.rangeRaw?.to === 'now' ? { maxDelta: request.range.to.valueOf() - request.range.from.valueOf() } : {}),
+    };
+  };
+
+  // Hidden queries aren't sent, so they don't read documents
+  filterQuery(query: FirestoreQuery): boolean {
+    return !query.hide;
+  }
+
+  // The group values usually come from a multi-value variable, interpolated as a comma-separated list.
+  // String parameters are interpolated as they are, the backend binds them without quoting.
+  applyTemplateVariables(query: FirestoreQuery, scopedVars: ScopedVars): FirestoreQuery {
+    const templateSrv = getTemplateSrv();
+    let applied = query;
+    if (query.groupValues) {
+      applied = { ...applied, groupValues: templateSrv.replace(query.groupValues, scopedVars, 'csv') };
+    }
+    if (query.params) {
+      const params: Record<string, QueryParam> = {};
+      for (const [name, value] of Object.entries(query.params)) {
+        params[name] = typeof value === 'string' ? templateSrv.replace(value, scopedVars) : value;
+      }
+      applied = { ...applied, params };
+    }
+    return applied;
+  }
+
+  // Fields, types and time field candidates inferred from a sample of the collection, cached by the backend
+  getSchema(collection: string): Promise<CollectionSchema> {
+    return this.getResource('schema', { collection });
+  }
+
+  // Distinct values of a field found in a sample of the collection, for value autocompletion
+  getFieldValues(collection: string, field: string, prefix?: string, limit?: number): Promise<FieldValues> {
+    const path = `collections/${encodeURIComponent(collection)}/fields/${encodeURIComponent(field)}/values`;
+    return this.getResource(path, { ...(prefix ? { prefix } : {}), ...(limit ? { limit } : {}) });
+  }
+
+  // Macros, functions, operators and pseudo-columns supported by the backend, for autocompletion
+  getMacros(): Promise<QueryCatalog> {
+    return this.getResource('metadata/macros');
+  }
+
+  // Query templates shared by the users of the datasource, sorted by name
+  getTemplates(): Promise<QueryTemplate[]> {
+    return this.getResource('templates');
+  }
+
+  getTemplate(name: string): Promise<QueryTemplate> {
+    return this.getResource(`templates/${encodeURIComponent(name)}`);
+  }
+
+  // Creates or replaces a template, editors and admins only
+  saveTemplate(template: QueryTemplate): Promise<QueryTemplate> {
+    const { name, query, description, format } = template;
+    return getBackendSrv().put<QueryTemplate>(this.templateUrl(name), { query, description, format });
+  }
+
+  deleteTemplate(name: string): Promise<void> {
+    return getBackendSrv().delete(this.templateUrl(name));
+  }
+
+  private templateUrl(name: string): string {
+    return `/api/datasources/uid/${this.uid}/resources/templates/${encodeURIComponent(name)}`;
+  }
 }
@@ -1,8 +1,124 @@
 import { DataQuery, DataSourceJsonData } from '@grafana/data';
 
+export type QueryFormat = 'table' | 'time_series' | 'logs' | 'histogram' | 'heatmap';
+
+/**
+ * How the time field is stored in Firestore
+ */
+export type TimeFormat = 'timestamp' | 'unix_ms' | 'unix_s' | 'unix_us' | 'unix_ns' | 'rfc3339';
+
+/**
+ * How the datasource authenticates: a service account key, Application Default Credentials
+ * or the Google OAuth identity Grafana forwards for the signed-in user
+ */
+export type AuthType = 'serviceAccount' | 'adc' | 'oauthPassThru';
+
+/**
+ * Minimum level of the datasource's plugin logs
+ */
+export type LogLevel = 'debug' | 'info' | 'warn' | 'error';
+
+/**
+ * How GeoPoint fields are returned: lat/lng columns or a GeoJSON Point
+ */
+export type GeoFormat = 'latlng' | 'geojson';
+
+/**
+ * How DocumentRef fields are returned: the document path or only its ID
+ */
+export type RefFormat = 'path' | 'id';
+
+/**
+ * How empty time buckets of a GROUP BY are filled: null, zero or the previous bucket's values
+ */
+export type FillPolicy = 'null' | 'zero' | 'previous';
+
+/**
+ * How time series with more points than the panel shows are downsampled: the average or the
+ * latest value of each interval, or not at all
+ */
+export type Downsample = 'avg' | 'last' | 'none';
+
+/**
+ * Structured query of the visual query builder, executed without SQL parsing
+ */
+export interface BuilderQuery {
+  collection: string;
+  fields?: string[];
+  filters?: Array<{
+    field: string;
+    operator: '==' | '!=' | '<' | '<=' | '>' | '>=' | 'in' | 'not-in' | 'array-contains' | 'array-contains-any';
+    value: unknown;
+  }>;
+  orderBy?: Array<{ field: string; descending?: boolean }>;
+  limit?: number;
+  groupBy?: string[];
+  aggregations?: Array<{ function: 'COUNT' | 'SUM' | 'AVG' | 'MIN' | 'MAX'; field?: string; alias?: string }>;
+}
+
+/**
+ * Enriches the results with fields of the documents of another collection, matched on a
+ * result column by document ID or by a field of the lookup documents
+ */
+export interface LookupOptions {
+  collection: string;
+  key: string;
+  by?: string;
+  fields: string[];
+}
+
+/**
+ * Value of a :name parameter of the query, bound as a typed value instead of being
+ * concatenated into the SQL
+ */
+export type QueryParam = string | number | boolean | null;
+
+/**
+ * Display settings of a result column, written by the backend into the config of its fields
+ */
+export interface FieldOptions {
+  displayName?: string;
+  unit?: string;
+  decimals?: number;
+}
+
 export interface FirestoreQuery extends DataQuery {
   query: string;
   timeField?: string;
+  timeFormat?: TimeFormat;
+  format?: QueryFormat;
+  flatten?: boolean;
+  geoFormat?: GeoFormat;
+  refFormat?: RefFormat;
+  maxRows?: number;
+  timeoutSeconds?: number;
+  readTime?: string;
+  explain?: boolean;
+  alerting?: boolean;
+  stream?: boolean;
+  tail?: boolean;
+  pageSize?: number;
+  pageToken?: string;
+  totalCount?: boolean;
+  convertToWide?: boolean;
+  groupValues?: string;
+  fill?: FillPolicy;
+  downsample?: Downsample;
+  builder?: BuilderQuery;
+  lookup?: LookupOptions;
+  params?: Record<string, QueryParam>;
+  fieldConfig?: Record<string, FieldOptions>;
+  decimals?: number;
+
+  // Logs format options
+  logMessageField?: string;
+  logLevelField?: string;
+  logLabelFields?: string[];
+
+  // Histogram and heatmap format options, the bucket width or else the bucket count
+  histogramField?: string;
+  histogramBucketWidth?: number;
+  histogramBuckets?: number;
 }
 
 export const DEFAULT_QUERY: Partial<FirestoreQuery> = {
@@ -14,6 +130,80 @@ export const DEFAULT_QUERY: Partial<FirestoreQuery> = {
 export interface MyDataSourceOptions extends DataSourceJsonData {
   projectId: string;
   serviceAccount: string;
+  authType?: AuthType;
+  endpoint?: string;
+  emulatorHost?: string;
+  healthCheckCollection?: string;
+  collectionTimeFields?: Record<string, string>;
+  fieldTypes?: Record<string, string>;
+  auditLog?: boolean;
+  auditLogPath?: string;
+  dataplaneLogs?: boolean;
+  timeFormat?: TimeFormat;
+  timezone?: string;
+  debug?: boolean;
+  maxConcurrentQueries?: number;
+  maxActiveQueries?: number;
+  maxRows?: number;
+  defaultLimit?: number;
+  downsample?: Downsample;
+  timeoutSeconds?: number;
+  maxRetries?: number;
+  memoryBudgetMB?: number;
+  streamMaxUpdatesPerSecond?: number;
+  streamBatchWindowMs?: number;
+  logLevel?: LogLevel;
+  templatesCollection?: string;
+}
+
+/**
+ * Schema inferred from sampled documents, returned by the schema resource
+ */
+export interface CollectionSchema {
+  collection: string;
+  fields: Array<{ name: string; type: string; nested?: boolean; values?: unknown[] }>;
+  timeFields: string[];
+  updatedAt: string;
+}
+
+/**
+ * Distinct values of a field found in sampled documents, returned by the field values resource
+ */
+export interface FieldValues {
+  collection: string;
+  field: string;
+  values: unknown[];
+  sampled: number;
+  truncated: boolean;
+}
+
+export interface CatalogEntry {
+  name: string;
+  signature: string;
+  description: string;
+}
+
+/**
+ * Macros, functions, operators and pseudo-columns supported by the backend, returned by the macros resource
+ */
+export interface QueryCatalog {
+  macros: CatalogEntry[];
+  aggregates: CatalogEntry[];
+  functions: CatalogEntry[];
+  operators: CatalogEntry[];
+  columns: CatalogEntry[];
+}
+
+/**
+ * Named query shared by the users of a datasource, returned by the templates resource
+ */
+export interface QueryTemplate {
+  name: string;
+  query: string;
+  description?: string;
+  format?: QueryFormat;
+  updatedBy?: string;
+  updatedAt?: string;
 }
 
 /**